@@ -6,9 +6,14 @@ import (
 	"liuproxy_nexus/internal/app"
 	"liuproxy_nexus/internal/shared/config"
 	"liuproxy_nexus/internal/shared/logger"
+	"liuproxy_nexus/internal/shared/outbound"
 	"liuproxy_nexus/internal/shared/types"
+	"liuproxy_nexus/internal/tunnel/goremote"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
+	"time"
 )
 
 func main() {
@@ -32,6 +37,12 @@ func main() {
 		os.Exit(1)
 	}
 
+	// 1.2 remote 模式只运行 goremote 服务器，不需要 servers.json
+	if cfg.CommonConf.Mode == "remote" {
+		runRemote(cfg)
+		return
+	}
+
 	// 2. 加载 servers.json 数据配置
 
 	_, err := config.LoadServers(serversPath)
@@ -44,3 +55,23 @@ func main() {
 	appServer.Run()
 
 }
+
+// runRemote 运行 goremote 服务器，直到收到退出信号。
+// 到目标的连接使用 liuproxy.ini 中的出站 socket 选项 (fwmark、网卡、源地址)。
+func runRemote(cfg *types.Config) {
+	if err := app.ConfigureOutbound(&cfg.LocalConf); err != nil {
+		logger.Fatal().Err(err).Msg("Invalid outbound socket options")
+	}
+	server, err := goremote.NewServer(cfg, outbound.NewDialer(10*time.Second))
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to create goremote server")
+	}
+	if err := server.Start(); err != nil {
+		logger.Fatal().Err(err).Msg("Failed to start goremote server")
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+	server.Close()
+}
@@ -12,12 +12,14 @@ require (
 	github.com/refraction-networking/utls v1.8.1
 	github.com/rs/zerolog v1.33.0
 	github.com/sagernet/sing v0.7.12
+	github.com/sagernet/sing-shadowsocks v0.2.9
 	github.com/xtaci/smux v1.5.28
 	github.com/xtls/reality v0.0.0-20250904214705-431b6ff8c67c
 	golang.org/x/crypto v0.43.0
 	golang.org/x/mobile v0.0.0-20251009145931-8baca8bf4eeb
 	golang.org/x/net v0.46.0
 	golang.org/x/sys v0.37.0
+	golang.zx2c4.com/wireguard v0.0.0-20260522210424-ecfc5a8d5446
 	google.golang.org/grpc v1.75.1
 	google.golang.org/protobuf v1.36.9
 	gopkg.in/ini.v1 v1.67.0
@@ -41,9 +43,11 @@ require (
 	github.com/gobwas/glob v0.2.3 // indirect
 	github.com/golang/groupcache v0.0.0-20241129210726-2c02b8208cf8 // indirect
 	github.com/golang/protobuf v1.5.4 // indirect
+	github.com/google/btree v1.1.2 // indirect
 	github.com/juju/ratelimit v1.0.2 // indirect
 	github.com/kennygrant/sanitize v1.2.4 // indirect
 	github.com/klauspost/compress v1.17.6 // indirect
+	github.com/klauspost/cpuid/v2 v2.0.12 // indirect
 	github.com/kr/pretty v0.3.1 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.19 // indirect
@@ -57,8 +61,12 @@ require (
 	golang.org/x/mod v0.29.0 // indirect
 	golang.org/x/sync v0.17.0 // indirect
 	golang.org/x/text v0.30.0 // indirect
+	golang.org/x/time v0.7.0 // indirect
 	golang.org/x/tools v0.38.0 // indirect
+	golang.zx2c4.com/wintun v0.0.0-20230126152724-0fa3db229ce2 // indirect
 	google.golang.org/appengine v1.6.8 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20250707201910-8d1bb00bc6a7 // indirect
+	gvisor.dev/gvisor v0.0.0-20250503011706-39ed1f5ac29c // indirect
 	h12.io/socks v1.0.3 // indirect
+	lukechampine.com/blake3 v1.4.1 // indirect
 )
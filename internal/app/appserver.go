@@ -10,7 +10,9 @@ import (
 	"liuproxy_nexus/internal/service/web"
 	"liuproxy_nexus/internal/shared/config"
 	"liuproxy_nexus/internal/shared/logger"
+	"liuproxy_nexus/internal/shared/outbound"
 	"liuproxy_nexus/internal/shared/settings"
+	"liuproxy_nexus/internal/sys/netfilter"
 	"liuproxy_nexus/internal/tunnel"
 	manager "liuproxy_nexus/proxypool"
 	"liuproxy_nexus/proxypool/model"
@@ -56,6 +58,8 @@ type AppServer struct {
 	dispatcher         types.Dispatcher
 	gateway            *gateway.Gateway
 	transparentGateway *gateway.TransparentGateway // <-- 新增
+	inboundManager     *gateway.InboundManager     // settings.json 中定义的额外监听器
+	netfilter          *netfilter.Manager          // 透明代理的 nftables/iptables 规则
 	firewall           firewall.Firewall           // <-- 新增
 	healthChecker      *health.Checker
 	healthCheckTicker  *time.Ticker
@@ -69,12 +73,6 @@ type AppServer struct {
 
 // AppServer must implement StateManager 接口
 var _ types.StateManager = (*AppServer)(nil)
-var _ UDPListenerProvider = (*AppServer)(nil)
-
-// UDPListenerProvider 是一个内部接口，用于解耦
-type UDPListenerProvider interface {
-	GetUDPListener() net.PacketConn
-}
 
 // --- START OF REPLACEMENT for NewForPC function in liuproxy_nexus/internal/app/appserver.go ---
 // NewForPC creates a new AppServer instance for PC/file-based mode.
@@ -92,6 +90,11 @@ func NewForPC(cfg *types.Config, iniPath, serversPath string) *AppServer {
 		healthCheckTicker: time.NewTicker(30 * time.Second),
 	}
 
+	if err := ConfigureOutbound(&cfg.LocalConf); err != nil {
+		fmt.Fprintf(os.Stderr, "FATAL: Invalid outbound socket options: %v\n", err)
+		os.Exit(1)
+	}
+
 	settingsPath := filepath.Join(configDir, "settings.json")
 	sm, err := settings.NewSettingsManager(settingsPath)
 	if err != nil {
@@ -131,10 +134,29 @@ func NewForPC(cfg *types.Config, iniPath, serversPath string) *AppServer {
 	s.dispatcher = disp
 	s.gateway = gateway.New(cfg.LocalConf.UnifiedPort, disp, s.hub)
 	s.transparentGateway = gateway.NewTransparent(cfg.LocalConf.TProxyPort, fw, disp, s.hub)
+	if cfg.LocalConf.TProxySniff {
+		s.transparentGateway.EnableSniffing(0)
+	}
+	s.inboundManager = gateway.NewInboundManager(fw, disp, s.hub)
+	sm.Register("inbounds", s.inboundManager)
+	s.netfilter = netfilter.NewManager(cfg.LocalConf.TProxyPort, cfg.LocalConf.OutboundMark)
+	sm.Register("netfilter", s.netfilter)
 
 	return s
 }
 
+// ConfigureOutbound 把 liuproxy.ini 中的出站 socket 选项应用到全局出站拨号器。
+func ConfigureOutbound(conf *types.LocalConf) error {
+	opts := outbound.Options{Mark: conf.OutboundMark, Interface: conf.OutboundInterface}
+	if conf.OutboundSourceIP != "" {
+		opts.SourceIP = net.ParseIP(conf.OutboundSourceIP)
+		if opts.SourceIP == nil {
+			return fmt.Errorf("invalid outbound_source_ip '%s'", conf.OutboundSourceIP)
+		}
+	}
+	return outbound.Configure(opts)
+}
+
 // NewForMobile creates a new AppServer instance for mobile/in-memory mode.
 func NewForMobile(cfg *types.Config) *AppServer {
 	// For mobile, settings manager runs in-memory without a file path.
@@ -270,6 +292,18 @@ func (s *AppServer) Run() {
 		logger.Warn().Msg("Transparent Gateway is disabled.")
 	}
 
+	if s.inboundManager != nil {
+		if err := s.inboundManager.Apply(s.settingsManager.Get().Inbounds); err != nil {
+			logger.Error().Err(err).Msg("Some inbound listeners failed to start")
+		}
+	}
+
+	if s.netfilter != nil {
+		if err := s.netfilter.Apply(s.settingsManager.Get().Netfilter); err != nil {
+			logger.Error().Err(err).Msg("Failed to install transparent proxy rules")
+		}
+	}
+
 	go s.hub.Run() // 启动 Hub
 	web.StartServer(&s.waitGroup, s.cfg, s.serversPath, s.settingsManager, s, s.hub)
 	s.Wait()
@@ -300,25 +334,25 @@ func (s *AppServer) Stop() {
 			}
 		}
 
+		// 先撤销引流规则，避免监听器关闭后被重定向的流量无处可去
+		if s.netfilter != nil {
+			if err := s.netfilter.Close(); err != nil {
+				logger.Error().Err(err).Msg("Failed to remove transparent proxy rules")
+			}
+		}
 		if s.gateway != nil {
 			s.gateway.Close()
 		}
 		if s.transparentGateway != nil {
 			s.transparentGateway.Close()
 		}
+		if s.inboundManager != nil {
+			s.inboundManager.Close()
+		}
 		//logger.Info().Msg("All strategies stopped.")
 	})
 }
 
-// GetUDPListener 实现了 UDPListenerProvider 接口
-// 它允许策略（如goremote）获取对主UDP监听器的引用，以便将返回的UDP包写回给正确的客户端
-func (s *AppServer) GetUDPListener() net.PacketConn {
-	if s.transparentGateway != nil {
-		return s.transparentGateway.GetListener()
-	}
-	return nil
-}
-
 //	is the core instance lifecycle manager.
 //
 // It creates, starts, stops, and cleans up strategy instances based on the desired state in configState.
@@ -346,10 +380,13 @@ func (s *AppServer) manageInstances() {
 	}(instancesToClose)
 
 	// Start instances that are new or activated
+	// 其余服务器排在当前服务器之后传给工厂，用于解析 via 链
+	allProfiles := s.sortedProfilesLocked()
 	for _, state := range s.configState.Servers {
 		if state.Profile.Active && state.Instance == nil {
 			//logger.Info().Str("remarks", state.Profile.Remarks).Msg("Activating and creating new strategy instance.")
-			newInstance, err := tunnel.NewStrategy(s.cfg, []*types.ServerProfile{state.Profile}, s)
+			profiles := append([]*types.ServerProfile{state.Profile}, allProfiles...)
+			newInstance, err := tunnel.NewStrategy(s.cfg, profiles, s)
 			if err != nil {
 				logger.Error().Err(err).Str("remarks", state.Profile.Remarks).Msg("Failed to create strategy")
 				state.Profile.Active = false // Mark as inactive on creation failure
@@ -153,7 +153,12 @@ func (s *AppServer) DuplicateServerProfile(id string) (*types.ServerProfile, err
 func (s *AppServer) GetAllServerProfilesSorted() []*types.ServerProfile {
 	s.configLock.RLock()
 	defer s.configLock.RUnlock()
+	return s.sortedProfilesLocked()
+}
 
+// sortedProfilesLocked returns all server profiles sorted by remarks.
+// This must be called under a lock on configLock.
+func (s *AppServer) sortedProfilesLocked() []*types.ServerProfile {
 	profiles := make([]*types.ServerProfile, 0, len(s.configState.Servers))
 	for _, state := range s.configState.Servers {
 		profiles = append(profiles, state.Profile)
@@ -5,6 +5,7 @@ import (
 	"liuproxy_nexus/internal/shared/logger"
 	"liuproxy_nexus/internal/shared/settings"
 	"liuproxy_nexus/internal/shared/types"
+	"liuproxy_nexus/internal/sys/netfilter"
 )
 
 // deepCopyAppState performs a deep copy of the AppState, suitable for creating a read-only snapshot.
@@ -147,3 +148,11 @@ func (s *AppServer) GetRecentTargets() []string {
 	}
 	return []string{}
 }
+
+// GetNetfilterStatus implements the ServerController interface.
+func (s *AppServer) GetNetfilterStatus() *netfilter.Status {
+	if s.netfilter == nil {
+		return &netfilter.Status{}
+	}
+	return s.netfilter.Status()
+}
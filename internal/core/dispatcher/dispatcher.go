@@ -259,6 +259,26 @@ func (d *Dispatcher) Dispatch(ctx context.Context, source net.Addr, target strin
 					break
 				}
 			}
+		case string(settings.RuleTypeInboundTag):
+			inboundTag := types.InboundTagFromContext(ctx)
+			for _, tag := range rule.Value {
+				if inboundTag != "" && tag == inboundTag {
+					matched = true
+					matchedValue = tag
+					break
+				}
+			}
+		case string(settings.RuleTypeALPN):
+			// 仅匹配客户端首选的协议，这样 "h2" 与 "http/1.1" 规则可以区分支持 h2 的客户端
+			if info := types.SniffInfoFromContext(ctx); info != nil && len(info.ALPN) > 0 {
+				for _, alpn := range rule.Value {
+					if strings.EqualFold(alpn, info.ALPN[0]) {
+						matched = true
+						matchedValue = alpn
+						break
+					}
+				}
+			}
 		case string(settings.RuleTypeDestIP):
 			var targetIP netip.Addr
 			var parseErr error
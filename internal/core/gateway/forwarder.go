@@ -124,7 +124,7 @@ func (g *Gateway) handleHttpProxy(ctx context.Context, inboundConn net.Conn, inb
 }
 
 // forwardTCP 将原始 TCP (来自 TLS ClientHello) 流量转发到后端。
-func (g *Gateway) forwardTCP(inboundConn net.Conn, inboundReader *bufio.Reader, serverID string, builder types.TunnelBuilder) {
+func (g *Gateway) forwardTCP(inboundConn net.Conn, inboundReader *bufio.Reader, targetDest, serverID string, builder types.TunnelBuilder) {
 	outboundConn, err := builder.GetSocksConnection()
 	if err != nil {
 		logger.Error().Err(err).Str("server_id", serverID).Msg("Gateway: Failed to get SOCKS connection for TLS forwarding")
@@ -132,6 +132,12 @@ func (g *Gateway) forwardTCP(inboundConn net.Conn, inboundReader *bufio.Reader,
 	}
 	defer outboundConn.Close()
 
+	// 裸 TLS 流量没有 SOCKS 握手，需要代替客户端向后端发起到嗅探目标的 CONNECT
+	if err := dialSocksProxyHandshake(outboundConn, targetDest, serverID); err != nil {
+		logger.Error().Err(err).Str("server_id", serverID).Str("target", targetDest).Msg("Gateway: SOCKS handshake for TLS forwarding failed")
+		return
+	}
+
 	var wg sync.WaitGroup
 	wg.Add(2)
 	clientAddr := inboundConn.RemoteAddr().String()
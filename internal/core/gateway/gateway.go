@@ -8,8 +8,10 @@ import (
 	"github.com/rs/zerolog/log"
 	"liuproxy_nexus/internal/service/web"
 	"liuproxy_nexus/internal/shared/logger"
+	"liuproxy_nexus/internal/shared/settings"
 	"liuproxy_nexus/internal/shared/types"
 	"net"
+	"net/http"
 	"strings"
 	"sync"
 	"time"
@@ -31,39 +33,75 @@ type Gateway struct {
 	hub          *web.Hub
 	closeOnce    sync.Once
 	waitGroup    sync.WaitGroup
-	listenPort   int
+	network      string // "tcp" 或 "unix"
+	listenAddr   string
+	tag          string                // 监听器标签，供 inbound_tag 路由规则匹配
+	sniffOpts    sniffOptions          // 由监听器类型和认证配置决定
+	auth         *settings.InboundAuth // 为 nil 表示不需要认证
 	directConn   VirtualStrategy
 	rejectConn   VirtualStrategy
+	httpPool     *httpConnPool // 普通 HTTP 转发代理的上游连接池
+	httpIdle     idleClientSet // 等待下一个请求的 keep-alive 客户端连接
 }
 
+// New 创建 liuproxy.ini 中 unified_port 对应的默认混合端口网关。
 func New(listenPort int, dispatcher types.Dispatcher, hub *web.Hub) *Gateway {
 	return &Gateway{
-		listenPort: listenPort,
+		network:    "tcp",
+		listenAddr: fmt.Sprintf("0.0.0.0:%d", listenPort), // 如果 listenPort 为 0, net.Listen 会选择一个可用的动态端口
+		tag:        DefaultUnifiedTag,
 		dispatcher: dispatcher,
 		hub:        hub,
 		directConn: NewDirectStrategy(),
 		rejectConn: NewRejectStrategy(),
+		httpPool:   newHTTPConnPool(),
 	}
 }
 
+// NewInbound 根据 settings 中的监听器定义创建网关，支持 mixed / socks5 / http 三种类型。
+func NewInbound(cfg *settings.Inbound, dispatcher types.Dispatcher, hub *web.Hub) (*Gateway, error) {
+	network, address, err := parseListenAddr(cfg.Listen)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := newSniffOptions(cfg.Type, cfg.Auth)
+	if err != nil {
+		return nil, err
+	}
+	opts.timeout = time.Duration(cfg.SniffTimeout) * time.Millisecond
+	return &Gateway{
+		network:    network,
+		listenAddr: address,
+		tag:        cfg.Tag,
+		sniffOpts:  opts,
+		auth:       cfg.Auth,
+		dispatcher: dispatcher,
+		hub:        hub,
+		directConn: NewDirectStrategy(),
+		rejectConn: NewRejectStrategy(),
+		httpPool:   newHTTPConnPool(),
+	}, nil
+}
+
 // InitializeListener 负责监听端口并准备服务，但不阻塞。
 // 它返回实际监听的端口号。
 func (g *Gateway) InitializeListener() (int, error) {
-	// 如果 listenPort 为 0, net.Listen 会选择一个可用的动态端口
-	listenAddr := fmt.Sprintf("0.0.0.0:%d", g.listenPort)
-	listener, err := net.Listen("tcp", listenAddr)
+	listener, err := listenStream(g.network, g.listenAddr)
 	if err != nil {
-		return 0, fmt.Errorf("gateway failed to listen on %s: %w", listenAddr, err)
+		return 0, fmt.Errorf("gateway failed to listen on %s: %w", g.listenAddr, err)
 	}
 	g.listener = listener
 
 	// 存储监听器信息
-	tcpAddr := g.listener.Addr().(*net.TCPAddr)
-	g.listenerInfo = &types.ListenerInfo{
-		Address: tcpAddr.IP.String(),
-		Port:    tcpAddr.Port,
+	if tcpAddr, ok := g.listener.Addr().(*net.TCPAddr); ok {
+		g.listenerInfo = &types.ListenerInfo{
+			Address: tcpAddr.IP.String(),
+			Port:    tcpAddr.Port,
+		}
+	} else {
+		g.listenerInfo = &types.ListenerInfo{Address: g.listener.Addr().String()}
 	}
-	logger.Info().Str("listen_addr", g.listener.Addr().String()).Msg(">>> Gateway is listening on unified port.")
+	logger.Info().Str("listen_addr", g.listener.Addr().String()).Str("tag", g.tag).Msg(">>> Gateway is listening on unified port.")
 
 	return g.listenerInfo.Port, nil
 }
@@ -114,18 +152,33 @@ func (g *Gateway) handleConnection(inboundConn net.Conn) {
 	defer inboundConn.Close()
 
 	traceID := uuid.NewString()
-	l := log.With().Str("trace_id", traceID).Logger()
-	ctx := l.WithContext(context.Background())
+	l := log.With().Str("trace_id", traceID).Str("inbound", g.tag).Logger()
+	ctx := types.WithInboundTag(l.WithContext(context.Background()), g.tag)
 	clientIP := inboundConn.RemoteAddr().String()
-	inboundReader := bufio.NewReader(inboundConn)
+	inboundReader := bufio.NewReaderSize(inboundConn, sniffReaderSize)
 
-	targetDest, proto, req, err := sniffTargetForRouting(inboundConn, inboundReader)
+	sniffed, err := sniffTargetForRouting(inboundConn, inboundReader, g.sniffOpts)
 	if err != nil {
 		l.Warn().Err(err).Str("client_ip", clientIP).Msg("Could not determine target")
 		return
 	}
+	targetDest, proto, req := sniffed.target, sniffed.proto, sniffed.req
+	if sniffed.info != nil {
+		ctx = types.WithSniffInfo(ctx, sniffed.info)
+	}
+
+	if proto == types.ProtoHTTP && !checkProxyAuth(req, g.auth) {
+		writeProxyAuthRequired(inboundConn)
+		return
+	}
 	//l.Debug().Str("proto", string(proto)).Str("client_ip", clientIP).Str("target", targetDest).Msg("Gateway: Sniffed target for routing")
 
+	// 普通 HTTP 请求 (非 CONNECT) 走转发代理模式：同一连接上的每个请求都独立分流。
+	if proto == types.ProtoHTTP && req != nil && req.Method != http.MethodConnect {
+		g.serveHTTPForward(ctx, inboundConn, inboundReader)
+		return
+	}
+
 	// 【流量日志】记录拦截
 	g.hub.BroadcastTrafficLog(&web.TrafficLogEntry{
 		Timestamp:   time.Now(),
@@ -135,7 +188,7 @@ func (g *Gateway) handleConnection(inboundConn net.Conn) {
 		Action:      "Intercepted",
 	})
 
-	strategy, serverID, err := g.dispatcher.Dispatch(ctx, inboundConn.RemoteAddr(), targetDest)
+	strategy, serverID, err := g.dispatcher.Dispatch(ctx, dispatchSource(inboundConn), targetDest)
 	if err != nil {
 		l.Warn().Err(err).Str("client_ip", clientIP).Str("target", targetDest).Msg("Gateway: Dispatcher returned error")
 		return
@@ -156,6 +209,8 @@ func (g *Gateway) handleConnection(inboundConn net.Conn) {
 		// 如果协议是 SOCKS5，必须在这里发送成功响应！
 		switch proto {
 		case types.ProtoSOCKS5:
+			// 丢弃已预读的 SOCKS5 请求，之后 reader 中只剩客户端的应用数据
+			inboundReader.Discard(inboundReader.Buffered())
 			// 发送 SOCKS5 CONNECT 成功响应
 			if _, err := inboundConn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0}); err != nil {
 				l.Warn().Err(err).Msg("Gateway: Failed to write SOCKS5 success reply for DIRECT connection")
@@ -164,6 +219,7 @@ func (g *Gateway) handleConnection(inboundConn net.Conn) {
 		case types.ProtoHTTP:
 			// 如果是 HTTP CONNECT 请求，发送 200 OK
 			if req != nil && req.Method == "CONNECT" {
+				inboundReader.Discard(inboundReader.Buffered())
 				if _, err := inboundConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
 					l.Warn().Err(err).Msg("Gateway: Failed to write HTTP CONNECT success reply for DIRECT connection")
 					return
@@ -203,18 +259,25 @@ func (g *Gateway) handleConnection(inboundConn net.Conn) {
 	case types.ProtoHTTP:
 		g.handleHttpProxy(ctx, inboundConn, inboundReader, targetDest, serverID, tunnelBuilder)
 	case types.ProtoTLS:
-		g.forwardTCP(inboundConn, inboundReader, serverID, tunnelBuilder)
+		g.forwardTCP(inboundConn, inboundReader, targetDest, serverID, tunnelBuilder)
 	default:
 		l.Warn().Str("client_ip", clientIP).Msg("Unsupported protocol")
 	}
 }
 
+// stopListening 关闭监听器但不等待已有连接结束，用于热移除监听器。
+func (g *Gateway) stopListening() {
+	if g.listener != nil {
+		g.listener.Close()
+	}
+}
+
 func (g *Gateway) Close() {
 	g.closeOnce.Do(func() {
-		if g.listener != nil {
-			g.listener.Close()
-		}
+		g.stopListening()
+		g.httpIdle.wakeAll()
 		g.waitGroup.Wait()
+		g.httpPool.closeAll()
 		log.Info().Msg("Gateway has been shut down")
 	})
 }
@@ -0,0 +1,420 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"io"
+	"liuproxy_nexus/internal/service/web"
+	"liuproxy_nexus/internal/shared/outbound"
+	"liuproxy_nexus/internal/shared/types"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	httpClientIdleTimeout   = 120 * time.Second // 客户端两次请求之间的最大空闲时间
+	httpUpstreamIdleTimeout = 90 * time.Second
+	httpUpstreamMaxIdle     = 4 // 每个 (server, host) 最多保留的空闲连接数
+)
+
+// hopByHopHeaders 是 RFC 7230 6.1 定义的逐跳头部，代理转发时必须移除。
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// upstreamConn 是一条可复用的上游连接 (已完成 SOCKS 握手或直连)。
+type upstreamConn struct {
+	net.Conn
+	reader   *bufio.Reader
+	idleFrom time.Time
+}
+
+// httpConnPool 按 (serverID, host:port) 缓存空闲的上游连接。
+type httpConnPool struct {
+	mu     sync.Mutex
+	idle   map[string][]*upstreamConn
+	closed bool
+}
+
+func newHTTPConnPool() *httpConnPool {
+	return &httpConnPool{idle: make(map[string][]*upstreamConn)}
+}
+
+func poolKey(serverID, target string) string {
+	return serverID + "|" + target
+}
+
+// get 取出一条未过期的空闲连接，没有则返回 nil。
+func (p *httpConnPool) get(key string) *upstreamConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	conns := p.idle[key]
+	for len(conns) > 0 {
+		uc := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+		if time.Since(uc.idleFrom) < httpUpstreamIdleTimeout {
+			p.idle[key] = conns
+			return uc
+		}
+		uc.Close()
+	}
+	delete(p.idle, key)
+	return nil
+}
+
+// put 将连接归还到池中；池已关闭或已满时直接关闭连接。
+func (p *httpConnPool) put(key string, uc *upstreamConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || len(p.idle[key]) >= httpUpstreamMaxIdle {
+		uc.Close()
+		return
+	}
+	uc.idleFrom = time.Now()
+	p.idle[key] = append(p.idle[key], uc)
+}
+
+// closeAll 关闭所有空闲连接，之后归还的连接也会被直接关闭。
+func (p *httpConnPool) closeAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for key, conns := range p.idle {
+		for _, uc := range conns {
+			uc.Close()
+		}
+		delete(p.idle, key)
+	}
+}
+
+// idleClientSet 记录正在等待下一个请求的客户端连接，以便网关关闭时立即唤醒它们，
+// 而不是等到空闲超时。
+type idleClientSet struct {
+	mu      sync.Mutex
+	conns   map[net.Conn]struct{}
+	closing bool
+}
+
+// enter 将连接标记为空闲；网关已关闭时返回 false。
+func (s *idleClientSet) enter(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]struct{})
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *idleClientSet) leave(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+}
+
+// wakeAll 使所有空闲连接上阻塞的读取立即返回。
+func (s *idleClientSet) wakeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closing = true
+	for conn := range s.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+}
+
+// serveHTTPForward 实现 HTTP/1.1 转发代理：逐个解析客户端请求，按每个请求的 Host 重新分流，
+// 并在 (server, host) 维度复用上游连接。
+func (g *Gateway) serveHTTPForward(ctx context.Context, inboundConn net.Conn, inboundReader *bufio.Reader) {
+	l := log.Ctx(ctx)
+	clientIP := inboundConn.RemoteAddr().String()
+
+	for {
+		// 先设置空闲超时再登记，保证 wakeAll 设置的截止时间不会被覆盖
+		inboundConn.SetReadDeadline(time.Now().Add(httpClientIdleTimeout))
+		if !g.httpIdle.enter(inboundConn) {
+			return
+		}
+		req, err := http.ReadRequest(inboundReader)
+		inboundConn.SetReadDeadline(time.Time{})
+		g.httpIdle.leave(inboundConn)
+		if err != nil {
+			if err != io.EOF {
+				l.Debug().Err(err).Str("client_ip", clientIP).Msg("Gateway: HTTP forward loop finished reading requests.")
+			}
+			return
+		}
+
+		if !checkProxyAuth(req, g.auth) {
+			writeProxyAuthRequired(inboundConn)
+			return
+		}
+
+		if req.Method == http.MethodConnect {
+			// 同一连接上出现 CONNECT 不属于转发代理语义，直接拒绝。
+			writeHTTPError(inboundConn, http.StatusBadRequest)
+			return
+		}
+
+		keepAlive, err := g.forwardHTTPRequest(ctx, inboundConn, inboundReader, req)
+		if err != nil {
+			l.Warn().Err(err).Str("client_ip", clientIP).Str("host", req.Host).Msg("Gateway: HTTP forward request failed.")
+			return
+		}
+		if !keepAlive {
+			return
+		}
+	}
+}
+
+// forwardHTTPRequest 处理单个请求，返回客户端连接是否可以继续复用。
+func (g *Gateway) forwardHTTPRequest(ctx context.Context, inboundConn net.Conn, inboundReader *bufio.Reader, req *http.Request) (bool, error) {
+	clientIP := inboundConn.RemoteAddr().String()
+
+	targetDest, err := httpRequestTarget(req)
+	if err != nil {
+		writeHTTPError(inboundConn, http.StatusBadRequest)
+		return false, err
+	}
+
+	g.hub.BroadcastTrafficLog(&web.TrafficLogEntry{
+		Timestamp:   time.Now(),
+		ClientIP:    clientIP,
+		Protocol:    string(types.ProtoHTTP),
+		Destination: targetDest,
+		Action:      "Intercepted",
+	})
+
+	strategy, serverID, err := g.dispatcher.Dispatch(ctx, dispatchSource(inboundConn), targetDest)
+	if err != nil {
+		writeHTTPError(inboundConn, http.StatusBadGateway)
+		return false, fmt.Errorf("dispatch failed: %w", err)
+	}
+
+	decided := serverID
+	if strategy != nil {
+		decided = strategy.GetType() + " (" + serverID[:8] + ")"
+	}
+	g.hub.BroadcastTrafficLog(&web.TrafficLogEntry{
+		Timestamp:   time.Now(),
+		ClientIP:    clientIP,
+		Protocol:    string(types.ProtoHTTP),
+		Destination: targetDest,
+		Action:      "Decided",
+		Target:      decided,
+	})
+
+	if strategy == nil && serverID == "REJECT" {
+		writeHTTPError(inboundConn, http.StatusForbidden)
+		return false, nil
+	}
+
+	upgrade := isUpgradeRequest(req)
+	prepareOutboundRequest(req, upgrade)
+
+	key := poolKey(serverID, targetDest)
+	upstream, reused := g.httpPool.get(key), true
+	if upstream == nil {
+		reused = false
+		if upstream, err = g.dialHTTPUpstream(strategy, serverID, targetDest); err != nil {
+			writeHTTPError(inboundConn, http.StatusBadGateway)
+			return false, err
+		}
+	}
+
+	resp, err := roundTripUpstream(upstream, req)
+	if err != nil && reused && isReplayable(req) {
+		// 空闲连接可能已被对端关闭，对于无请求体的请求换一条新连接重试一次。
+		upstream.Close()
+		if upstream, err = g.dialHTTPUpstream(strategy, serverID, targetDest); err != nil {
+			writeHTTPError(inboundConn, http.StatusBadGateway)
+			return false, err
+		}
+		resp, err = roundTripUpstream(upstream, req)
+	}
+	if err != nil {
+		upstream.Close()
+		writeHTTPError(inboundConn, http.StatusBadGateway)
+		return false, err
+	}
+
+	if upgrade && resp.StatusCode == http.StatusSwitchingProtocols {
+		// 协议升级 (如 WebSocket) 之后不再是 HTTP 语义，转为纯字节转发。
+		defer upstream.Close()
+		if err := resp.Write(inboundConn); err != nil {
+			return false, err
+		}
+		pipeUpgraded(inboundConn, inboundReader, upstream)
+		return false, nil
+	}
+
+	removeHopByHopHeaders(resp.Header)
+	writeErr := resp.Write(inboundConn)
+	resp.Body.Close()
+	if writeErr != nil {
+		upstream.Close()
+		return false, writeErr
+	}
+
+	if resp.Close || req.Close {
+		upstream.Close()
+	} else {
+		g.httpPool.put(key, upstream)
+	}
+	return !req.Close && !resp.Close, nil
+}
+
+// dialHTTPUpstream 为指定后端建立一条到 targetDest 的上游连接。
+func (g *Gateway) dialHTTPUpstream(strategy types.TunnelStrategy, serverID, targetDest string) (*upstreamConn, error) {
+	var conn net.Conn
+	var err error
+	if strategy == nil {
+		conn, err = outbound.DialTimeout("tcp", targetDest, 10*time.Second)
+		if err != nil {
+			return nil, fmt.Errorf("direct dial to %s failed: %w", targetDest, err)
+		}
+	} else {
+		conn, err = strategy.GetSocksConnection()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get socks connection: %w", err)
+		}
+		if err := dialSocksProxyHandshake(conn, targetDest, serverID); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return &upstreamConn{Conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// roundTripUpstream 在上游连接上写出请求并读取响应头。
+func roundTripUpstream(upstream *upstreamConn, req *http.Request) (*http.Response, error) {
+	if err := req.Write(upstream); err != nil {
+		return nil, fmt.Errorf("failed to write request upstream: %w", err)
+	}
+	resp, err := http.ReadResponse(upstream.reader, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upstream response: %w", err)
+	}
+	return resp, nil
+}
+
+// httpRequestTarget 从绝对 URI 或 Host 头中解析出 host:port。
+func httpRequestTarget(req *http.Request) (string, error) {
+	host := req.URL.Host
+	if host == "" {
+		host = req.Host
+	}
+	if host == "" {
+		return "", errors.New("HTTP request host is empty")
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		port := "80"
+		if req.URL.Scheme == "https" {
+			port = "443"
+		}
+		host = net.JoinHostPort(strings.Trim(host, "[]"), port)
+	}
+	return host, nil
+}
+
+// prepareOutboundRequest 将代理请求改写为发往源站的请求：
+// 绝对 URI 改为 origin-form，并移除逐跳头部。
+func prepareOutboundRequest(req *http.Request, upgrade bool) {
+	if req.URL.Host != "" {
+		req.Host = req.URL.Host
+	}
+	req.URL.Scheme = ""
+	req.URL.Host = ""
+	req.RequestURI = ""
+
+	upgradeProto := req.Header.Get("Upgrade")
+	removeHopByHopHeaders(req.Header)
+	if upgrade {
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", upgradeProto)
+	}
+}
+
+// removeHopByHopHeaders 删除固定的逐跳头部以及 Connection 中列出的头部。
+func removeHopByHopHeaders(header http.Header) {
+	for _, v := range header.Values("Connection") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				header.Del(name)
+			}
+		}
+	}
+	for _, h := range hopByHopHeaders {
+		header.Del(h)
+	}
+}
+
+func isUpgradeRequest(req *http.Request) bool {
+	if req.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, v := range req.Header.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isReplayable 判断请求能否在新连接上安全重发。
+func isReplayable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return req.Body == nil || req.Body == http.NoBody || req.ContentLength == 0
+	}
+	return false
+}
+
+// pipeUpgraded 在协议升级后双向转发剩余字节。
+func pipeUpgraded(inboundConn net.Conn, inboundReader *bufio.Reader, upstream *upstreamConn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(upstream, inboundReader)
+		closeWrite(upstream.Conn)
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(inboundConn, upstream.reader)
+		closeWrite(inboundConn)
+	}()
+	wg.Wait()
+}
+
+// closeWrite 关闭连接的写方向。策略返回的 SOCKS 管道等连接不支持半关闭，只能整体关闭，
+// 否则另一个方向的拷贝永远等不到 EOF。
+func closeWrite(conn net.Conn) {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		c.CloseWrite()
+	} else {
+		conn.Close()
+	}
+}
+
+func writeHTTPError(conn net.Conn, status int) {
+	fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\nContent-Length: 0\r\nConnection: close\r\n\r\n", status, http.StatusText(status))
+}
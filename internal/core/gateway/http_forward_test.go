@@ -0,0 +1,392 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"liuproxy_nexus/internal/service/web"
+	"liuproxy_nexus/internal/shared/types"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// socksTestStrategy 是一个最小的后端策略：GetSocksConnection 返回内存管道，管道另一端的 SOCKS5 服务
+// 记录请求的目标后，把连接转发到 origin (不论目标是什么)。
+type socksTestStrategy struct {
+	origin string
+	dials  atomic.Int32
+
+	mu      sync.Mutex
+	targets []string
+}
+
+func (s *socksTestStrategy) GetSocksConnection() (net.Conn, error) {
+	s.dials.Add(1)
+	client, server := net.Pipe()
+	go s.serve(server)
+	return client, nil
+}
+
+func (s *socksTestStrategy) serve(conn net.Conn) {
+	defer conn.Close()
+	greeting := make([]byte, 3)
+	if _, err := io.ReadFull(conn, greeting); err != nil {
+		return
+	}
+	conn.Write([]byte{0x05, 0x00})
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	var host string
+	switch header[3] {
+	case 0x01, 0x04:
+		ip := make([]byte, 4)
+		if header[3] == 0x04 {
+			ip = make([]byte, 16)
+		}
+		io.ReadFull(conn, ip)
+		host = net.IP(ip).String()
+	case 0x03:
+		n := make([]byte, 1)
+		io.ReadFull(conn, n)
+		name := make([]byte, n[0])
+		io.ReadFull(conn, name)
+		host = string(name)
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.targets = append(s.targets, net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+	s.mu.Unlock()
+
+	upstream, err := net.Dial("tcp", s.origin)
+	if err != nil {
+		conn.Write([]byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+	// 任一方向结束都关闭两端，使对端关闭连接能传递到网关的连接池
+	done := make(chan struct{}, 2)
+	go func() { io.Copy(upstream, conn); done <- struct{}{} }()
+	go func() { io.Copy(conn, upstream); done <- struct{}{} }()
+	<-done
+}
+
+func (s *socksTestStrategy) requestedTargets() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.targets...)
+}
+
+func (s *socksTestStrategy) HandleRawTCP(inboundConn net.Conn, targetDest string) {
+	inboundConn.Close()
+}
+func (s *socksTestStrategy) HandleUDPPacket(*types.UDPPacket, string) error { return nil }
+func (s *socksTestStrategy) GetTrafficStats() types.TrafficStats            { return types.TrafficStats{} }
+func (s *socksTestStrategy) Initialize() error                              { return nil }
+func (s *socksTestStrategy) InitializeForGateway() error                    { return nil }
+func (s *socksTestStrategy) GetType() string                                { return "socks-test" }
+func (s *socksTestStrategy) CloseTunnel()                                   {}
+func (s *socksTestStrategy) GetListenerInfo() *types.ListenerInfo           { return nil }
+func (s *socksTestStrategy) GetMetrics() *types.Metrics                     { return &types.Metrics{} }
+func (s *socksTestStrategy) UpdateServer(*types.ServerProfile) error        { return nil }
+func (s *socksTestStrategy) CheckHealth() error                             { return nil }
+
+// testRoute 是 hostDispatcher 对一个主机名的决策。
+type testRoute struct {
+	strategy types.TunnelStrategy
+	serverID string
+}
+
+// hostDispatcher 按目标主机名分流，并记录每次调度时 context 中的监听器标签。
+type hostDispatcher struct {
+	routes map[string]testRoute
+
+	mu   sync.Mutex
+	tags []string
+}
+
+func (d *hostDispatcher) Dispatch(ctx context.Context, _ net.Addr, target string) (types.TunnelStrategy, string, error) {
+	d.mu.Lock()
+	d.tags = append(d.tags, types.InboundTagFromContext(ctx))
+	d.mu.Unlock()
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, "", err
+	}
+	route, ok := d.routes[host]
+	if !ok {
+		return nil, "REJECT", nil
+	}
+	return route.strategy, route.serverID, nil
+}
+
+func (d *hostDispatcher) dispatchedTags() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.tags...)
+}
+
+// startOrigin 启动一个源站，响应中写回收到的请求行、Host 和请求头，并统计建立的连接数。
+func startOrigin(t *testing.T, name string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	conns := new(atomic.Int32)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") == "echo" {
+			serveEchoUpgrade(w)
+			return
+		}
+		w.Header().Set("Keep-Alive", "timeout=5")
+		fmt.Fprintf(w, "origin=%s uri=%s host=%s\n", name, r.RequestURI, r.Host)
+		r.Header.Write(w)
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	server.Start()
+	t.Cleanup(server.Close)
+	return server, conns
+}
+
+// serveEchoUpgrade 接受 "Upgrade: echo" 请求，之后把收到的字节原样写回。
+func serveEchoUpgrade(w http.ResponseWriter) {
+	conn, rw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	rw.Flush()
+	io.Copy(conn, rw)
+}
+
+// startTestGateway 启动一个默认混合端口网关，返回其地址。
+func startTestGateway(t *testing.T, dispatcher types.Dispatcher) string {
+	t.Helper()
+	g := New(0, dispatcher, web.NewHub())
+	g.listenAddr = "127.0.0.1:0"
+	port, err := g.InitializeListener()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go g.Serve()
+	t.Cleanup(g.Close)
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+}
+
+// proxyClient 是一条到网关的 keep-alive 客户端连接。
+type proxyClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialProxy(t *testing.T, addr string) *proxyClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &proxyClient{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// do 发送原始请求文本并返回响应和响应体。
+func (c *proxyClient) do(t *testing.T, raw string) (*http.Response, string) {
+	t.Helper()
+	if _, err := io.WriteString(c.conn, raw); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(c.reader, nil)
+	if err != nil {
+		t.Fatalf("reading response failed: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+func newTwoOriginDispatcher(t *testing.T) (*hostDispatcher, *socksTestStrategy, *socksTestStrategy) {
+	t.Helper()
+	originA, _ := startOrigin(t, "a")
+	originB, _ := startOrigin(t, "b")
+	strategyA := &socksTestStrategy{origin: originA.Listener.Addr().String()}
+	strategyB := &socksTestStrategy{origin: originB.Listener.Addr().String()}
+	return &hostDispatcher{routes: map[string]testRoute{
+		"a.test": {strategy: strategyA, serverID: "server-a-id"},
+		"b.test": {strategy: strategyB, serverID: "server-b-id"},
+	}}, strategyA, strategyB
+}
+
+func TestHTTPForwardRoutesEachRequest(t *testing.T) {
+	disp, strategyA, strategyB := newTwoOriginDispatcher(t)
+	client := dialProxy(t, startTestGateway(t, disp))
+
+	// 同一条 keep-alive 连接上发往不同 Host 的请求分别分流
+	_, body := client.do(t, "GET http://a.test/one?x=1 HTTP/1.1\r\nHost: a.test\r\n\r\n")
+	if !strings.HasPrefix(body, "origin=a uri=/one?x=1 host=a.test\n") {
+		t.Errorf("first response = %q", body)
+	}
+	_, body = client.do(t, "GET http://b.test:8080/two HTTP/1.1\r\nHost: b.test:8080\r\n\r\n")
+	if !strings.HasPrefix(body, "origin=b uri=/two host=b.test:8080\n") {
+		t.Errorf("second response = %q", body)
+	}
+
+	if got := strategyA.requestedTargets(); len(got) != 1 || got[0] != "a.test:80" {
+		t.Errorf("strategy a targets = %v, want [a.test:80]", got)
+	}
+	if got := strategyB.requestedTargets(); len(got) != 1 || got[0] != "b.test:8080" {
+		t.Errorf("strategy b targets = %v, want [b.test:8080]", got)
+	}
+	if got := disp.dispatchedTags(); len(got) != 2 || got[0] != DefaultUnifiedTag {
+		t.Errorf("dispatched with tags %v, want two requests from %q", got, DefaultUnifiedTag)
+	}
+}
+
+func TestHTTPForwardStripsHopByHopHeaders(t *testing.T) {
+	disp, _, _ := newTwoOriginDispatcher(t)
+	client := dialProxy(t, startTestGateway(t, disp))
+
+	resp, body := client.do(t, "GET http://a.test/ HTTP/1.1\r\nHost: a.test\r\n"+
+		"Connection: keep-alive, X-Hop\r\nX-Hop: secret\r\nProxy-Connection: keep-alive\r\n"+
+		"Proxy-Authorization: Basic dXNlcjpwYXNz\r\nKeep-Alive: timeout=5\r\nTe: trailers\r\n"+
+		"X-End-To-End: kept\r\n\r\n")
+	for _, name := range []string{"X-Hop", "Proxy-Connection", "Proxy-Authorization", "Keep-Alive", "Te", "Connection"} {
+		if strings.Contains(body, name+":") {
+			t.Errorf("origin received hop-by-hop header %s:\n%s", name, body)
+		}
+	}
+	if !strings.Contains(body, "X-End-To-End: kept") {
+		t.Errorf("end-to-end header was dropped:\n%s", body)
+	}
+	if resp.Header.Get("Keep-Alive") != "" {
+		t.Errorf("client received hop-by-hop response header Keep-Alive: %q", resp.Header.Get("Keep-Alive"))
+	}
+}
+
+func TestPrepareOutboundRequest(t *testing.T) {
+	req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(
+		"POST http://a.test:8080/p?q=1 HTTP/1.1\r\nHost: ignored.test\r\nConnection: close\r\nContent-Length: 0\r\n\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prepareOutboundRequest(req, false)
+	var out bytes.Buffer
+	if err := req.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "POST /p?q=1 HTTP/1.1\r\nHost: a.test:8080\r\n") {
+		t.Errorf("outbound request = %q, want origin-form with the absolute URI host", out.String())
+	}
+}
+
+func TestHTTPForwardReusesUpstreamConnections(t *testing.T) {
+	origin, originConns := startOrigin(t, "a")
+	strategy := &socksTestStrategy{origin: origin.Listener.Addr().String()}
+	other := &socksTestStrategy{origin: origin.Listener.Addr().String()}
+	disp := &hostDispatcher{routes: map[string]testRoute{
+		"a.test":     {strategy: strategy, serverID: "server-a-id"},
+		"alias.test": {strategy: other, serverID: "server-b-id"},
+	}}
+	addr := startTestGateway(t, disp)
+
+	// 两条客户端连接上发往同一 (server, host) 的请求共用一条上游连接
+	for i := 0; i < 2; i++ {
+		client := dialProxy(t, addr)
+		client.do(t, "GET http://a.test/ HTTP/1.1\r\nHost: a.test\r\n\r\n")
+		client.conn.Close()
+	}
+	if n := strategy.dials.Load(); n != 1 {
+		t.Fatalf("strategy dialed %d upstream connections, want 1", n)
+	}
+	// 不同的 (server, host) 不共用连接
+	dialProxy(t, addr).do(t, "GET http://alias.test/ HTTP/1.1\r\nHost: alias.test\r\n\r\n")
+	if n := other.dials.Load(); n != 1 {
+		t.Fatalf("second server dialed %d upstream connections, want 1", n)
+	}
+
+	// 源站关闭空闲连接后，可重放的请求在新连接上重试
+	origin.CloseClientConnections()
+	if n := originConns.Load(); n != 2 {
+		t.Fatalf("origin accepted %d connections, want 2", n)
+	}
+	time.Sleep(50 * time.Millisecond)
+	client := dialProxy(t, addr)
+	if resp, body := client.do(t, "GET http://a.test/again HTTP/1.1\r\nHost: a.test\r\n\r\n"); resp.StatusCode != http.StatusOK ||
+		!strings.HasPrefix(body, "origin=a uri=/again") {
+		t.Fatalf("retried request = %d %q", resp.StatusCode, body)
+	}
+	if n := strategy.dials.Load(); n != 2 {
+		t.Errorf("strategy dialed %d upstream connections, want 2 after the stale one failed", n)
+	}
+
+	// 带请求体的请求不能重放，在失效的连接上失败时返回 502
+	origin.CloseClientConnections()
+	time.Sleep(50 * time.Millisecond)
+	client = dialProxy(t, addr)
+	if resp, _ := client.do(t, "POST http://a.test/ HTTP/1.1\r\nHost: a.test\r\nContent-Length: 4\r\n\r\nbody"); resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("POST on a stale connection = %d, want 502", resp.StatusCode)
+	}
+}
+
+func TestIsReplayable(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want bool
+	}{
+		{"GET / HTTP/1.1\r\nHost: a\r\n\r\n", true},
+		{"HEAD / HTTP/1.1\r\nHost: a\r\n\r\n", true},
+		{"GET / HTTP/1.1\r\nHost: a\r\nContent-Length: 3\r\n\r\nabc", false},
+		{"POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 0\r\n\r\n", false},
+	}
+	for _, tt := range tests {
+		req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(tt.raw)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := isReplayable(req); got != tt.want {
+			t.Errorf("isReplayable(%q) = %v, want %v", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestHTTPForwardUpgrade(t *testing.T) {
+	disp, _, _ := newTwoOriginDispatcher(t)
+	client := dialProxy(t, startTestGateway(t, disp))
+
+	io.WriteString(client.conn, "GET http://a.test/ws HTTP/1.1\r\nHost: a.test\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	resp, err := http.ReadResponse(client.reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Upgrade") != "echo" {
+		t.Fatalf("upgrade response = %d %v", resp.StatusCode, resp.Header)
+	}
+	// 升级之后是纯字节转发
+	io.WriteString(client.conn, "ping")
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(client.reader, got); err != nil || string(got) != "ping" {
+		t.Fatalf("echo after upgrade = %q, %v", got, err)
+	}
+	// 客户端关闭后网关也结束两个方向的转发，Close 不会被阻塞
+	client.conn.Close()
+}
@@ -0,0 +1,235 @@
+package gateway
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"liuproxy_nexus/internal/firewall"
+	"liuproxy_nexus/internal/service/web"
+	"liuproxy_nexus/internal/shared/settings"
+	"liuproxy_nexus/internal/shared/types"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// liuproxy.ini 中定义的默认监听器使用的标签
+const (
+	DefaultUnifiedTag = "unified"
+	DefaultTProxyTag  = "tproxy"
+)
+
+// parseListenAddr 解析监听地址。"unix:" 前缀表示 unix socket，其余按 TCP host:port 处理 (支持 IPv6)。
+func parseListenAddr(listen string) (network, address string, err error) {
+	if strings.HasPrefix(listen, "unix:") {
+		path := strings.TrimPrefix(listen, "unix:")
+		if path == "" {
+			return "", "", errors.New("unix socket path is empty")
+		}
+		return "unix", path, nil
+	}
+	if _, _, err := net.SplitHostPort(listen); err != nil {
+		return "", "", fmt.Errorf("invalid listen address '%s': %w", listen, err)
+	}
+	return "tcp", listen, nil
+}
+
+// listenStream 监听 TCP 或 unix socket。对于 unix socket，会先清理上次异常退出遗留的 socket 文件。
+func listenStream(network, address string) (net.Listener, error) {
+	if network == "unix" {
+		if fi, err := os.Lstat(address); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(address)
+		}
+	}
+	return net.Listen(network, address)
+}
+
+// dispatchSource 返回用于路由的来源地址。unix socket 连接没有 IP，视为本机回环。
+func dispatchSource(conn net.Conn) net.Addr {
+	if _, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return conn.RemoteAddr()
+	}
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+}
+
+func credentialsMatch(auth *settings.InboundAuth, username, password string) bool {
+	userOK := subtle.ConstantTimeCompare([]byte(username), []byte(auth.Username)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(password), []byte(auth.Password)) == 1
+	return userOK && passOK
+}
+
+// checkProxyAuth 校验 HTTP 请求的 Proxy-Authorization (Basic) 头。auth 为 nil 时总是通过。
+func checkProxyAuth(req *http.Request, auth *settings.InboundAuth) bool {
+	if auth == nil {
+		return true
+	}
+	if req == nil {
+		return false
+	}
+	const prefix = "Basic "
+	header := req.Header.Get("Proxy-Authorization")
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(header[len(prefix):])
+	if err != nil {
+		return false
+	}
+	username, password, ok := strings.Cut(string(decoded), ":")
+	return ok && credentialsMatch(auth, username, password)
+}
+
+func writeProxyAuthRequired(conn net.Conn) {
+	conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n" +
+		"Proxy-Authenticate: Basic realm=\"liuproxy\"\r\n" +
+		"Content-Length: 0\r\nConnection: close\r\n\r\n"))
+}
+
+// inboundListener 是 InboundManager 管理的监听器 (Gateway 或 TransparentGateway)。
+type inboundListener interface {
+	stopListening()
+	Close()
+}
+
+type runningInbound struct {
+	cfg      settings.Inbound
+	listener inboundListener
+}
+
+// InboundManager 管理 settings.json 中 "inbounds" 模块定义的监听器，支持热添加和热移除。
+// 它实现了 settings.ConfigurableModule 接口。
+type InboundManager struct {
+	mu         sync.Mutex
+	running    map[string]*runningInbound // key: tag
+	firewall   firewall.Firewall
+	dispatcher types.Dispatcher
+	hub        *web.Hub
+	closed     bool
+}
+
+// NewInboundManager 创建一个新的 InboundManager 实例。
+func NewInboundManager(fw firewall.Firewall, disp types.Dispatcher, hub *web.Hub) *InboundManager {
+	return &InboundManager{
+		running:    make(map[string]*runningInbound),
+		firewall:   fw,
+		dispatcher: disp,
+		hub:        hub,
+	}
+}
+
+// OnSettingsUpdate 实现了 settings.ConfigurableModule 接口。
+func (m *InboundManager) OnSettingsUpdate(moduleKey string, newSettings interface{}) error {
+	if moduleKey != "inbounds" {
+		return nil
+	}
+	cfg, ok := newSettings.(*settings.InboundSettings)
+	if !ok {
+		return fmt.Errorf("inbound manager: received incorrect settings type for inbounds module")
+	}
+	return m.Apply(cfg)
+}
+
+// Apply 将运行中的监听器与期望配置对齐：停止被移除或被修改的监听器，启动新增的监听器。
+// 单个监听器启动失败不会影响其他监听器，所有错误会合并返回。
+func (m *InboundManager) Apply(cfg *settings.InboundSettings) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil
+	}
+
+	desired := make(map[string]settings.Inbound)
+	var errs []error
+	for _, in := range cfg.Listeners {
+		if in == nil || !in.Enabled {
+			continue
+		}
+		if in.Tag == "" || in.Tag == DefaultUnifiedTag || in.Tag == DefaultTProxyTag {
+			errs = append(errs, fmt.Errorf("inbound '%s': tag is empty or reserved", in.Listen))
+			continue
+		}
+		if _, dup := desired[in.Tag]; dup {
+			errs = append(errs, fmt.Errorf("inbound '%s': duplicate tag", in.Tag))
+			continue
+		}
+		desired[in.Tag] = copyInbound(in)
+	}
+
+	// 1. 先停止被移除或被修改的监听器，释放地址以便重新绑定
+	for tag, ri := range m.running {
+		if want, ok := desired[tag]; ok && reflect.DeepEqual(want, ri.cfg) {
+			continue
+		}
+		ri.listener.stopListening()
+		go ri.listener.Close() // 等待存量连接结束，不阻塞配置更新
+		delete(m.running, tag)
+		log.Info().Str("tag", tag).Msg("Inbound listener removed.")
+	}
+
+	// 2. 启动新增的监听器
+	for tag, in := range desired {
+		if _, ok := m.running[tag]; ok {
+			continue
+		}
+		listener, err := m.start(&in)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("inbound '%s': %w", tag, err))
+			continue
+		}
+		m.running[tag] = &runningInbound{cfg: in, listener: listener}
+		log.Info().Str("tag", tag).Str("type", string(in.Type)).Str("listen", in.Listen).Msg("Inbound listener started.")
+	}
+
+	return errors.Join(errs...)
+}
+
+func (m *InboundManager) start(in *settings.Inbound) (inboundListener, error) {
+	switch in.Type {
+	case settings.InboundTProxy, settings.InboundRedirect:
+		tg, err := NewTransparentInbound(in, m.firewall, m.dispatcher, m.hub)
+		if err != nil {
+			return nil, err
+		}
+		if err := tg.Start(); err != nil {
+			return nil, err
+		}
+		return tg, nil
+	default:
+		g, err := NewInbound(in, m.dispatcher, m.hub)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := g.InitializeListener(); err != nil {
+			return nil, err
+		}
+		go g.Serve()
+		return g, nil
+	}
+}
+
+// Close 关闭所有由 InboundManager 管理的监听器。
+func (m *InboundManager) Close() {
+	m.mu.Lock()
+	running := m.running
+	m.running = make(map[string]*runningInbound)
+	m.closed = true
+	m.mu.Unlock()
+
+	for _, ri := range running {
+		ri.listener.Close()
+	}
+}
+
+func copyInbound(in *settings.Inbound) settings.Inbound {
+	c := *in
+	if in.Auth != nil {
+		authCopy := *in.Auth
+		c.Auth = &authCopy
+	}
+	return c
+}
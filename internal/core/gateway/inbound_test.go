@@ -0,0 +1,256 @@
+package gateway
+
+import (
+	"bufio"
+	"liuproxy_nexus/internal/core/dispatcher"
+	"liuproxy_nexus/internal/firewall"
+	"liuproxy_nexus/internal/service/web"
+	"liuproxy_nexus/internal/shared/settings"
+	"liuproxy_nexus/internal/shared/types"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+type staticStateProvider map[string]*types.ServerState
+
+func (p staticStateProvider) GetServerStates() map[string]*types.ServerState { return p }
+
+// freeTCPAddr 返回一个当前未被占用的回环地址。
+func freeTCPAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func newTestInboundManager(t *testing.T, disp types.Dispatcher) *InboundManager {
+	t.Helper()
+	m := NewInboundManager(firewall.NewEngine(), disp, web.NewHub())
+	t.Cleanup(m.Close)
+	return m
+}
+
+// dialInbound 连接 "unix:" 前缀的 unix socket 或 TCP 地址。
+func dialInbound(listen string) (net.Conn, error) {
+	if path, ok := strings.CutPrefix(listen, "unix:"); ok {
+		return net.DialTimeout("unix", path, time.Second)
+	}
+	return net.DialTimeout("tcp", listen, time.Second)
+}
+
+// getThrough 通过 listen 上的监听器发送一个 HTTP 转发请求，返回响应体。
+func getThrough(t *testing.T, listen, rawRequest string) string {
+	t.Helper()
+	conn, err := dialInbound(listen)
+	if err != nil {
+		t.Fatalf("dial %s failed: %v", listen, err)
+	}
+	client := &proxyClient{conn: conn, reader: bufio.NewReader(conn)}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, body := client.do(t, rawRequest)
+	return body
+}
+
+func TestInboundManagerApply(t *testing.T) {
+	disp, _, _ := newTwoOriginDispatcher(t)
+	m := newTestInboundManager(t, disp)
+	unixListen := "unix:" + filepath.Join(t.TempDir(), "inbound.sock")
+	tcpListen := freeTCPAddr(t)
+	const request = "GET http://a.test/ HTTP/1.1\r\nHost: a.test\r\nConnection: close\r\n\r\n"
+
+	// 热添加：unix socket 和 TCP 监听器
+	cfg := &settings.InboundSettings{Listeners: []*settings.Inbound{
+		{Tag: "local", Type: settings.InboundHTTP, Listen: unixListen, Enabled: true},
+		{Tag: "lan", Type: settings.InboundMixed, Listen: tcpListen, Enabled: true},
+		{Tag: "disabled", Type: settings.InboundMixed, Listen: freeTCPAddr(t)},
+	}}
+	if err := m.Apply(cfg); err != nil {
+		t.Fatal(err)
+	}
+	for _, listen := range []string{unixListen, tcpListen} {
+		if body := getThrough(t, listen, request); !strings.HasPrefix(body, "origin=a") {
+			t.Errorf("request through %s = %q", listen, body)
+		}
+	}
+	if tags := disp.dispatchedTags(); len(tags) != 2 || tags[0] != "local" || tags[1] != "lan" {
+		t.Errorf("dispatched tags = %v, want [local lan]", tags)
+	}
+	if _, ok := m.running["disabled"]; ok {
+		t.Error("disabled inbound was started")
+	}
+
+	// 热修改：只有被修改的监听器重启，未变化的监听器保持不动
+	local := m.running["local"].listener
+	movedListen := freeTCPAddr(t)
+	cfg.Listeners[1] = &settings.Inbound{Tag: "lan", Type: settings.InboundMixed, Listen: movedListen, Enabled: true}
+	if err := m.Apply(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if m.running["local"].listener != local {
+		t.Error("unchanged inbound was restarted")
+	}
+	if conn, err := dialInbound(tcpListen); err == nil {
+		conn.Close()
+		t.Errorf("old address %s still accepts connections", tcpListen)
+	}
+	if body := getThrough(t, movedListen, request); !strings.HasPrefix(body, "origin=a") {
+		t.Errorf("request through the modified inbound = %q", body)
+	}
+
+	// 热移除
+	cfg.Listeners = cfg.Listeners[1:]
+	if err := m.Apply(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if conn, err := dialInbound(unixListen); err == nil {
+		conn.Close()
+		t.Error("removed unix inbound still accepts connections")
+	}
+	if len(m.running) != 1 {
+		t.Errorf("%d inbounds running, want 1", len(m.running))
+	}
+}
+
+func TestInboundManagerRejectsInvalidTags(t *testing.T) {
+	m := newTestInboundManager(t, &hostDispatcher{})
+	err := m.Apply(&settings.InboundSettings{Listeners: []*settings.Inbound{
+		{Tag: "", Type: settings.InboundMixed, Listen: freeTCPAddr(t), Enabled: true},
+		{Tag: DefaultUnifiedTag, Type: settings.InboundMixed, Listen: freeTCPAddr(t), Enabled: true},
+		{Tag: DefaultTProxyTag, Type: settings.InboundMixed, Listen: freeTCPAddr(t), Enabled: true},
+		{Tag: "dup", Type: settings.InboundMixed, Listen: freeTCPAddr(t), Enabled: true},
+		{Tag: "dup", Type: settings.InboundMixed, Listen: freeTCPAddr(t), Enabled: true},
+	}})
+	if err == nil {
+		t.Fatal("Apply() accepted empty, reserved and duplicate tags")
+	}
+	for _, want := range []string{"reserved", "duplicate tag"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Apply() error %q does not mention %q", err, want)
+		}
+	}
+	// 合法的监听器仍然启动，重复标签只保留第一个
+	if len(m.running) != 1 || m.running["dup"] == nil {
+		t.Errorf("running inbounds = %v, want only the first 'dup'", m.running)
+	}
+}
+
+func TestInboundAuth(t *testing.T) {
+	origin, _ := startOrigin(t, "direct")
+	disp := &hostDispatcher{routes: map[string]testRoute{"127.0.0.1": {serverID: "DIRECT"}}}
+	m := newTestInboundManager(t, disp)
+	auth := &settings.InboundAuth{Username: "user", Password: "secret"}
+	socksListen, httpListen := freeTCPAddr(t), freeTCPAddr(t)
+	if err := m.Apply(&settings.InboundSettings{Listeners: []*settings.Inbound{
+		{Tag: "socks", Type: settings.InboundSocks5, Listen: socksListen, Enabled: true, Auth: auth},
+		{Tag: "http", Type: settings.InboundHTTP, Listen: httpListen, Enabled: true, Auth: auth},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	target := origin.Listener.Addr().String()
+
+	t.Run("socks5", func(t *testing.T) {
+		for _, tt := range []struct {
+			name   string
+			auth   *proxy.Auth
+			wantOK bool
+		}{
+			{name: "valid", auth: &proxy.Auth{User: "user", Password: "secret"}, wantOK: true},
+			{name: "wrong password", auth: &proxy.Auth{User: "user", Password: "wrong"}},
+			{name: "no auth"},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				dialer, err := proxy.SOCKS5("tcp", socksListen, tt.auth, &net.Dialer{Timeout: time.Second})
+				if err != nil {
+					t.Fatal(err)
+				}
+				conn, err := dialer.Dial("tcp", target)
+				if (err == nil) != tt.wantOK {
+					t.Fatalf("Dial() error = %v, want success %v", err, tt.wantOK)
+				}
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				client := &proxyClient{conn: conn, reader: bufio.NewReader(conn)}
+				conn.SetDeadline(time.Now().Add(5 * time.Second))
+				if _, body := client.do(t, "GET / HTTP/1.1\r\nHost: origin\r\n\r\n"); !strings.HasPrefix(body, "origin=direct") {
+					t.Errorf("response through SOCKS5 = %q", body)
+				}
+			})
+		}
+	})
+
+	t.Run("http", func(t *testing.T) {
+		request := "GET http://" + target + "/ HTTP/1.1\r\nHost: " + target + "\r\n"
+		for _, tt := range []struct {
+			name       string
+			header     string
+			wantStatus int
+		}{
+			{name: "valid", header: "Proxy-Authorization: Basic dXNlcjpzZWNyZXQ=\r\n", wantStatus: http.StatusOK},
+			{name: "wrong password", header: "Proxy-Authorization: Basic dXNlcjp3cm9uZw==\r\n", wantStatus: http.StatusProxyAuthRequired},
+			{name: "no auth", wantStatus: http.StatusProxyAuthRequired},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				client := dialProxy(t, httpListen)
+				if resp, _ := client.do(t, request+tt.header+"\r\n"); resp.StatusCode != tt.wantStatus {
+					t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+				}
+			})
+		}
+	})
+}
+
+func TestInboundTagRouting(t *testing.T) {
+	originA, _ := startOrigin(t, "a")
+	originB, _ := startOrigin(t, "b")
+	states := staticStateProvider{
+		"server-a-id": {
+			Profile:  &types.ServerProfile{ID: "server-a-id", Remarks: "a", Active: true},
+			Instance: &socksTestStrategy{origin: originA.Listener.Addr().String()},
+			Health:   types.StatusUp,
+		},
+		"server-b-id": {
+			Profile:  &types.ServerProfile{ID: "server-b-id", Remarks: "b", Active: true},
+			Instance: &socksTestStrategy{origin: originB.Listener.Addr().String()},
+			Health:   types.StatusUp,
+		},
+	}
+	disp := dispatcher.New(&settings.GatewaySettings{}, states)
+	if err := disp.OnSettingsUpdate("routing", &settings.RoutingSettings{
+		Rules: []*settings.Rule{
+			{Priority: 1, Type: string(settings.RuleTypeInboundTag), Value: []string{"office"}, Target: "a"},
+			{Priority: 2, Type: string(settings.RuleTypeInboundTag), Value: []string{"home", "guest"}, Target: "b"},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newTestInboundManager(t, disp)
+	office, home := freeTCPAddr(t), freeTCPAddr(t)
+	if err := m.Apply(&settings.InboundSettings{Listeners: []*settings.Inbound{
+		{Tag: "office", Type: settings.InboundMixed, Listen: office, Enabled: true},
+		{Tag: "home", Type: settings.InboundMixed, Listen: home, Enabled: true},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 发往同一目标的请求按进入的监听器分流
+	const request = "GET http://same.test/ HTTP/1.1\r\nHost: same.test\r\nConnection: close\r\n\r\n"
+	if body := getThrough(t, office, request); !strings.HasPrefix(body, "origin=a") {
+		t.Errorf("request through 'office' = %q, want origin a", body)
+	}
+	if body := getThrough(t, home, request); !strings.HasPrefix(body, "origin=b") {
+		t.Errorf("request through 'home' = %q, want origin b", body)
+	}
+}
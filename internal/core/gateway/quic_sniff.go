@@ -0,0 +1,345 @@
+package gateway
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// QUIC 版本号 (RFC 9000 / RFC 9369)
+const (
+	quicVersion1 uint32 = 0x00000001
+	quicVersion2 uint32 = 0x6b3343cf
+)
+
+var (
+	quicSaltV1 = []byte{0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17, 0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a}
+	quicSaltV2 = []byte{0x0d, 0xed, 0xe3, 0xde, 0xf7, 0x00, 0xa6, 0xdb, 0x81, 0x93, 0x81, 0xbe, 0x6e, 0x26, 0x9d, 0xcb, 0xf9, 0xbd, 0x2e, 0xd9}
+
+	// errQUICNeedMore 表示 ClientHello 跨越了多个 Initial 包，需要继续喂入后续数据报。
+	errQUICNeedMore = errors.New("quic: ClientHello is incomplete, more Initial packets needed")
+	errNotQUIC      = errors.New("quic: not a QUIC Initial packet")
+)
+
+// quicInitialKeys 是客户端 Initial 包的解密材料 (RFC 9001 5.2)。
+type quicInitialKeys struct {
+	aead cipher.AEAD
+	iv   []byte
+	hp   cipher.Block
+}
+
+// deriveQUICInitialKeys 从客户端选择的 Destination Connection ID 派生客户端 Initial 密钥。
+func deriveQUICInitialKeys(version uint32, dcid []byte) (*quicInitialKeys, error) {
+	salt, keyLabel, ivLabel, hpLabel := quicSaltV1, "quic key", "quic iv", "quic hp"
+	if version == quicVersion2 {
+		salt, keyLabel, ivLabel, hpLabel = quicSaltV2, "quicv2 key", "quicv2 iv", "quicv2 hp"
+	}
+
+	initialSecret, err := hkdf.Extract(sha256.New, dcid, salt)
+	if err != nil {
+		return nil, err
+	}
+	clientSecret, err := hkdfExpandLabel(initialSecret, "client in", 32)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hkdfExpandLabel(clientSecret, keyLabel, 16)
+	if err != nil {
+		return nil, err
+	}
+	iv, err := hkdfExpandLabel(clientSecret, ivLabel, 12)
+	if err != nil {
+		return nil, err
+	}
+	hpKey, err := hkdfExpandLabel(clientSecret, hpLabel, 16)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	hp, err := aes.NewCipher(hpKey)
+	if err != nil {
+		return nil, err
+	}
+	return &quicInitialKeys{aead: aead, iv: iv, hp: hp}, nil
+}
+
+// hkdfExpandLabel 实现 TLS 1.3 的 HKDF-Expand-Label (RFC 8446 7.1)，context 为空。
+func hkdfExpandLabel(secret []byte, label string, length int) ([]byte, error) {
+	fullLabel := "tls13 " + label
+	info := make([]byte, 0, 4+len(fullLabel))
+	info = binary.BigEndian.AppendUint16(info, uint16(length))
+	info = append(info, byte(len(fullLabel)))
+	info = append(info, fullLabel...)
+	info = append(info, 0) // context length
+	return hkdf.Expand(sha256.New, secret, string(info), length)
+}
+
+// readQUICVarint 读取 QUIC 变长整数 (RFC 9000 16)，返回值和占用的字节数。
+func readQUICVarint(b []byte) (uint64, int, error) {
+	if len(b) == 0 {
+		return 0, 0, errors.New("quic: truncated varint")
+	}
+	n := 1 << (b[0] >> 6)
+	if len(b) < n {
+		return 0, 0, errors.New("quic: truncated varint")
+	}
+	v := uint64(b[0] & 0x3f)
+	for i := 1; i < n; i++ {
+		v = v<<8 | uint64(b[i])
+	}
+	return v, n, nil
+}
+
+// quicSniffer 累积同一 UDP 流上客户端 Initial 包中的 CRYPTO 数据，直到重组出完整的 ClientHello。
+// 现代客户端的 ClientHello (例如带后量子 key share) 经常跨越两个 Initial 包，
+// 且 CRYPTO 帧可能乱序，因此按 offset 存储再拼接。
+type quicSniffer struct {
+	fragments map[uint64][]byte
+}
+
+func newQUICSniffer() *quicSniffer {
+	return &quicSniffer{fragments: make(map[uint64][]byte)}
+}
+
+// Feed 解析一个 UDP 数据报 (可能包含多个合并的 QUIC 包)，并尝试重组 ClientHello。
+// ClientHello 不完整时返回 errQUICNeedMore。
+func (s *quicSniffer) Feed(datagram []byte) (*clientHelloInfo, error) {
+	foundInitial := false
+	for len(datagram) > 0 {
+		payload, rest, err := decryptQUICInitial(datagram)
+		if err != nil {
+			if foundInitial && errors.Is(err, errNotQUIC) {
+				break // 合并在后面的非 Initial 包 (例如 0-RTT) 直接忽略
+			}
+			return nil, err
+		}
+		foundInitial = true
+		if err := s.collectCryptoFrames(payload); err != nil {
+			return nil, err
+		}
+		datagram = rest
+	}
+
+	msg, ok := s.assemble()
+	if !ok {
+		return nil, errQUICNeedMore
+	}
+	return parseClientHello(msg)
+}
+
+// decryptQUICInitial 去除头部保护并解密数据报中的第一个 Initial 包，返回明文帧和剩余数据。
+func decryptQUICInitial(packet []byte) (payload, rest []byte, err error) {
+	// 长包头: 1 字节 flags + 4 字节 version + DCID + SCID
+	if len(packet) < 7 || packet[0]&0x80 == 0 || packet[0]&0x40 == 0 {
+		return nil, nil, errNotQUIC
+	}
+	version := binary.BigEndian.Uint32(packet[1:5])
+	packetType := (packet[0] & 0x30) >> 4
+	switch {
+	case version == quicVersion1 && packetType == 0x00:
+	case version == quicVersion2 && packetType == 0x01:
+	default:
+		return nil, nil, errNotQUIC
+	}
+
+	offset := 5
+	dcidLen := int(packet[offset])
+	offset++
+	if dcidLen > 20 || offset+dcidLen >= len(packet) {
+		return nil, nil, errors.New("quic: invalid destination connection ID")
+	}
+	dcid := packet[offset : offset+dcidLen]
+	offset += dcidLen
+
+	scidLen := int(packet[offset])
+	offset++
+	if scidLen > 20 || offset+scidLen > len(packet) {
+		return nil, nil, errors.New("quic: invalid source connection ID")
+	}
+	offset += scidLen
+
+	tokenLen, n, err := readQUICVarint(packet[offset:])
+	if err != nil {
+		return nil, nil, err
+	}
+	offset += n
+	if uint64(len(packet)-offset) < tokenLen {
+		return nil, nil, errors.New("quic: invalid token length")
+	}
+	offset += int(tokenLen)
+
+	length, n, err := readQUICVarint(packet[offset:])
+	if err != nil {
+		return nil, nil, err
+	}
+	offset += n
+	pnOffset := offset
+	if uint64(len(packet)-pnOffset) < length {
+		return nil, nil, errors.New("quic: packet length exceeds datagram")
+	}
+	end := pnOffset + int(length)
+
+	// 去除头部保护 (RFC 9001 5.4)：采样位置假定包号为 4 字节
+	if pnOffset+4+aes.BlockSize > end {
+		return nil, nil, errors.New("quic: packet too short for header protection sample")
+	}
+	keys, err := deriveQUICInitialKeys(version, dcid)
+	if err != nil {
+		return nil, nil, err
+	}
+	mask := make([]byte, aes.BlockSize)
+	keys.hp.Encrypt(mask, packet[pnOffset+4:pnOffset+4+aes.BlockSize])
+
+	header := make([]byte, pnOffset+4)
+	copy(header, packet[:pnOffset+4])
+	header[0] ^= mask[0] & 0x0f
+	pnLen := int(header[0]&0x03) + 1
+	var pn uint64
+	for i := 0; i < pnLen; i++ {
+		header[pnOffset+i] ^= mask[1+i]
+		pn = pn<<8 | uint64(header[pnOffset+i])
+	}
+	header = header[:pnOffset+pnLen]
+
+	nonce := make([]byte, len(keys.iv))
+	copy(nonce, keys.iv)
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-1-i] ^= byte(pn >> (8 * i))
+	}
+
+	plaintext, err := keys.aead.Open(nil, nonce, packet[pnOffset+pnLen:end], header)
+	if err != nil {
+		return nil, nil, fmt.Errorf("quic: failed to decrypt Initial packet: %w", err)
+	}
+	return plaintext, packet[end:], nil
+}
+
+// collectCryptoFrames 遍历 Initial 包中的帧，保存 CRYPTO 帧的数据。
+// Initial 包中只允许出现 PADDING、PING、ACK、CRYPTO 和 CONNECTION_CLOSE 帧。
+func (s *quicSniffer) collectCryptoFrames(payload []byte) error {
+	for len(payload) > 0 {
+		frameType := payload[0]
+		switch frameType {
+		case 0x00, 0x01: // PADDING, PING
+			payload = payload[1:]
+		case 0x02, 0x03: // ACK
+			rest, err := skipQUICAckFrame(payload[1:], frameType == 0x03)
+			if err != nil {
+				return err
+			}
+			payload = rest
+		case 0x06: // CRYPTO
+			cryptoOffset, n, err := readQUICVarint(payload[1:])
+			if err != nil {
+				return err
+			}
+			pos := 1 + n
+			dataLen, n, err := readQUICVarint(payload[pos:])
+			if err != nil {
+				return err
+			}
+			pos += n
+			if uint64(len(payload)-pos) < dataLen {
+				return errors.New("quic: truncated CRYPTO frame")
+			}
+			if cryptoOffset+dataLen > sniffReaderSize {
+				return fmt.Errorf("quic: ClientHello exceeds %d bytes", sniffReaderSize)
+			}
+			s.fragments[cryptoOffset] = append([]byte(nil), payload[pos:pos+int(dataLen)]...)
+			payload = payload[pos+int(dataLen):]
+		case 0x1c: // CONNECTION_CLOSE
+			return errors.New("quic: connection closed by client")
+		default:
+			return fmt.Errorf("quic: unexpected frame type 0x%02x in Initial packet", frameType)
+		}
+	}
+	return nil
+}
+
+func skipQUICAckFrame(b []byte, withECN bool) ([]byte, error) {
+	// Largest Acknowledged, ACK Delay, ACK Range Count, First ACK Range
+	fields := 4
+	var rangeCount uint64
+	for i := 0; i < fields; i++ {
+		v, n, err := readQUICVarint(b)
+		if err != nil {
+			return nil, err
+		}
+		if i == 2 {
+			rangeCount = v
+		}
+		b = b[n:]
+	}
+	// 每个 ACK Range 包含 Gap 和 ACK Range Length
+	extra := rangeCount * 2
+	if withECN {
+		extra += 3
+	}
+	for i := uint64(0); i < extra; i++ {
+		_, n, err := readQUICVarint(b)
+		if err != nil {
+			return nil, err
+		}
+		b = b[n:]
+	}
+	return b, nil
+}
+
+// assemble 将已收到的 CRYPTO 数据从 offset 0 开始拼接，返回完整的 ClientHello 握手消息。
+func (s *quicSniffer) assemble() ([]byte, bool) {
+	offsets := make([]uint64, 0, len(s.fragments))
+	for off := range s.fragments {
+		offsets = append(offsets, off)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+	var stream []byte
+	for _, off := range offsets {
+		if off > uint64(len(stream)) {
+			break // 中间缺少数据
+		}
+		frag := s.fragments[off]
+		if end := off + uint64(len(frag)); end > uint64(len(stream)) {
+			stream = append(stream, frag[uint64(len(stream))-off:]...)
+		}
+	}
+
+	if len(stream) < 4 {
+		return nil, false
+	}
+	msgLen := 4 + (int(stream[1])<<16 | int(stream[2])<<8 | int(stream[3]))
+	if len(stream) < msgLen {
+		return nil, false
+	}
+	return stream[:msgLen], true
+}
+
+// sniffQUICClientHello 从一个或多个客户端 Initial 数据报中提取 ClientHello 信息。
+func sniffQUICClientHello(datagrams ...[]byte) (*clientHelloInfo, error) {
+	s := newQUICSniffer()
+	var info *clientHelloInfo
+	var err error
+	for _, d := range datagrams {
+		info, err = s.Feed(d)
+		if err == nil {
+			return info, nil
+		}
+		if !errors.Is(err, errQUICNeedMore) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
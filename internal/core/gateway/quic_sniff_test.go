@@ -0,0 +1,86 @@
+package gateway
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadHexFixture 读取 testdata 中以十六进制保存的抓包数据报。
+func loadHexFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	raw, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("failed to read fixture %s: %v", name, err)
+	}
+	data, err := hex.DecodeString(strings.Join(strings.Fields(string(raw)), ""))
+	if err != nil {
+		t.Fatalf("failed to decode fixture %s: %v", name, err)
+	}
+	return data
+}
+
+func TestSniffQUICClientHello(t *testing.T) {
+	tests := []struct {
+		name       string
+		fixtures   []string
+		serverName string
+		alpn       []string
+	}{
+		// RFC 9001 附录 A.2 的客户端 Initial 包
+		{name: "QUIC v1 RFC 9001", fixtures: []string{"quic_v1_initial.hex"}, serverName: "example.com", alpn: []string{"alpn"}},
+		// RFC 9369 附录 A.2 的客户端 Initial 包
+		{name: "QUIC v2 RFC 9369", fixtures: []string{"quic_v2_initial.hex"}, serverName: "example.com", alpn: []string{"alpn"}},
+		// 带 X25519MLKEM768 key share 的 ClientHello，跨越两个 Initial 数据报
+		{
+			name:       "QUIC v1 ClientHello split across datagrams",
+			fixtures:   []string{"quic_v1_split_initial_1.hex", "quic_v1_split_initial_2.hex"},
+			serverName: "www.example.org",
+			alpn:       []string{"h3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			datagrams := make([][]byte, 0, len(tt.fixtures))
+			for _, f := range tt.fixtures {
+				datagrams = append(datagrams, loadHexFixture(t, f))
+			}
+
+			info, err := sniffQUICClientHello(datagrams...)
+			if err != nil {
+				t.Fatalf("sniffQUICClientHello() error = %v", err)
+			}
+			if info.ServerName != tt.serverName {
+				t.Errorf("ServerName = %q, want %q", info.ServerName, tt.serverName)
+			}
+			if strings.Join(info.ALPN, ",") != strings.Join(tt.alpn, ",") {
+				t.Errorf("ALPN = %v, want %v", info.ALPN, tt.alpn)
+			}
+		})
+	}
+}
+
+func TestSniffQUICClientHelloNeedsMoreData(t *testing.T) {
+	first := loadHexFixture(t, "quic_v1_split_initial_1.hex")
+	if _, err := sniffQUICClientHello(first); !errors.Is(err, errQUICNeedMore) {
+		t.Fatalf("expected errQUICNeedMore for a partial ClientHello, got %v", err)
+	}
+}
+
+func TestSniffQUICClientHelloRejectsTampering(t *testing.T) {
+	packet := loadHexFixture(t, "quic_v1_initial.hex")
+	packet[len(packet)-1] ^= 0xff // 破坏 AEAD 认证标签
+	if _, err := sniffQUICClientHello(packet); err == nil {
+		t.Fatal("expected decryption failure for a tampered packet")
+	}
+}
+
+func TestSniffQUICClientHelloRejectsNonQUIC(t *testing.T) {
+	if _, err := sniffQUICClientHello([]byte{0x12, 0x34, 0x01, 0x00, 0x00, 0x01}); !errors.Is(err, errNotQUIC) {
+		t.Fatalf("expected errNotQUIC for a DNS-like payload, got %v", err)
+	}
+}
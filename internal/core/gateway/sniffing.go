@@ -4,9 +4,12 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"liuproxy_nexus/internal/shared/settings"
 	"liuproxy_nexus/internal/shared/types"
+	"liuproxy_nexus/internal/sys/tproxy"
 	"net"
 	"net/http"
 	"strconv"
@@ -14,124 +17,119 @@ import (
 	"time"
 )
 
-// sniffTargetForRouting 检查连接的第一个字节，以确定协议并嗅探目标地址。
-func sniffTargetForRouting(conn net.Conn, reader *bufio.Reader) (target string, ptl types.Protocol, req *http.Request, err error) {
-	if err := fillBuffer(conn, reader, 1); err != nil {
-		return "", types.ProtoUnknown, nil, fmt.Errorf("failed to read initial byte: %w", err)
-	}
-	firstByte, _ := reader.Peek(1)
+const defaultSniffTimeout = 2 * time.Second
 
-	switch {
-	case firstByte[0] == 0x05: // SOCKS5
-		target, err := sniffTargetSocks5(conn, reader)
-		return target, types.ProtoSOCKS5, nil, err
-	case firstByte[0] == 0x16: // TLS ClientHello
-		host, tlsErr := sniffTargetTLS(conn, reader)
-		if tlsErr == nil && host != "" {
-			return host, types.ProtoTLS, nil, nil
-		}
-		return "", types.ProtoUnknown, nil, fmt.Errorf("TLS SNI sniff failed: %w", tlsErr)
-	case firstByte[0] >= 'A' && firstByte[0] <= 'Z': // HTTP Methods (GET, POST, CONNECT, etc.)
-		host, request, httpErr := sniffTargetHTTP(conn, reader)
-		if httpErr == nil && host != "" {
-			return host, types.ProtoHTTP, request, nil
-		}
-		return "", types.ProtoUnknown, nil, fmt.Errorf("HTTP sniff failed: %w", httpErr)
-	default:
-		return "", types.ProtoUnknown, nil, fmt.Errorf("could not determine target protocol, initial byte: 0x%02x", firstByte[0])
-	}
+// sniffOptions 控制嗅探阶段的行为，由监听器的类型和认证配置决定。
+type sniffOptions struct {
+	auth    *settings.InboundAuth
+	allowed map[types.Protocol]bool // 为 nil 表示允许所有协议
+	timeout time.Duration           // 为 0 表示使用 defaultSniffTimeout
 }
 
-// sniffTargetTLS 被动嗅探 TLS ClientHello 中的 SNI (Server Name Indication)
-func sniffTargetTLS(conn net.Conn, reader *bufio.Reader) (string, error) {
-	// 确保缓冲区至少有5个字节 (TLS Record Header)
-	if err := fillBuffer(conn, reader, 5); err != nil {
-		return "", err
-	}
-	header, _ := reader.Peek(5)
-
-	if header[0] != 0x16 {
-		return "", fmt.Errorf("not a TLS handshake record")
+func (o sniffOptions) sniffTimeout() time.Duration {
+	if o.timeout > 0 {
+		return o.timeout
 	}
+	return defaultSniffTimeout
+}
 
-	if header[1] != 0x03 {
-		return "", fmt.Errorf("unexpected TLS major version: %d", header[1])
-	}
-
-	recordLen := int(binary.BigEndian.Uint16(header[3:5]))
-	totalHelloLen := 5 + recordLen
-
-	if err := fillBuffer(conn, reader, totalHelloLen); err != nil {
-		return "", fmt.Errorf("buffer does not contain full TLS ClientHello")
-	}
-
-	data, _ := reader.Peek(totalHelloLen)
-	data = data[5:]
-
-	if len(data) < 42 {
-		return "", fmt.Errorf("invalid ClientHello: too short")
+// newSniffOptions 根据监听器类型计算允许的入站协议。
+// 需要认证时不允许裸 TLS，因为它没有携带凭据的途径。
+func newSniffOptions(inboundType settings.InboundType, auth *settings.InboundAuth) (sniffOptions, error) {
+	opts := sniffOptions{auth: auth}
+	switch inboundType {
+	case settings.InboundMixed, "":
+		if auth != nil {
+			opts.allowed = map[types.Protocol]bool{types.ProtoSOCKS5: true, types.ProtoHTTP: true}
+		}
+	case settings.InboundSocks5:
+		opts.allowed = map[types.Protocol]bool{types.ProtoSOCKS5: true}
+	case settings.InboundHTTP:
+		opts.allowed = map[types.Protocol]bool{types.ProtoHTTP: true}
+	default:
+		return opts, fmt.Errorf("inbound type '%s' is not a proxy listener type", inboundType)
 	}
+	return opts, nil
+}
 
-	if data[0] != 0x01 {
-		return "", fmt.Errorf("not a ClientHello message")
-	}
+func (o sniffOptions) allows(proto types.Protocol) bool {
+	return o.allowed == nil || o.allowed[proto]
+}
 
-	offset := 38
+// sniffResult 是入站连接的嗅探结果。
+type sniffResult struct {
+	target string
+	proto  types.Protocol
+	req    *http.Request    // 仅 HTTP 协议时有效
+	info   *types.SniffInfo // 仅 TLS 协议时有效，用于 alpn 等路由规则
+}
 
-	sessionIDLen := int(data[offset])
-	offset += 1 + sessionIDLen
-	if offset+2 > len(data) {
-		return "", fmt.Errorf("invalid ClientHello: session ID parsing error")
+// sniffTargetForRouting 检查连接的第一个字节，以确定协议并嗅探目标地址。
+func sniffTargetForRouting(conn net.Conn, reader *bufio.Reader, opts sniffOptions) (*sniffResult, error) {
+	if err := fillBufferUntil(conn, reader, 1, time.Now().Add(opts.sniffTimeout())); err != nil {
+		return nil, fmt.Errorf("failed to read initial byte: %w", err)
 	}
+	firstByte, _ := reader.Peek(1)
 
-	cipherSuitesLen := int(binary.BigEndian.Uint16(data[offset : offset+2]))
-	offset += 2 + cipherSuitesLen
-	if offset+1 > len(data) {
-		return "", fmt.Errorf("invalid ClientHello: cipher suites parsing error")
+	proto := types.ProtoUnknown
+	switch {
+	case firstByte[0] == 0x05:
+		proto = types.ProtoSOCKS5
+	case firstByte[0] == 0x16:
+		proto = types.ProtoTLS
+	case firstByte[0] >= 'A' && firstByte[0] <= 'Z':
+		proto = types.ProtoHTTP
 	}
-
-	compressionMethodsLen := int(data[offset])
-	offset += 1 + compressionMethodsLen
-	if offset+2 > len(data) {
-		return "", fmt.Errorf("no extensions found")
+	if proto != types.ProtoUnknown && !opts.allows(proto) {
+		return nil, fmt.Errorf("protocol %s is not accepted by this listener", proto)
 	}
 
-	extensionsLen := int(binary.BigEndian.Uint16(data[offset : offset+2]))
-	offset += 2
-	if offset+extensionsLen > len(data) {
-		return "", fmt.Errorf("invalid ClientHello: extensions length mismatch")
+	switch proto {
+	case types.ProtoSOCKS5:
+		target, err := sniffTargetSocks5(conn, reader, opts.auth)
+		return &sniffResult{target: target, proto: proto}, err
+	case types.ProtoTLS: // TLS ClientHello
+		hello, tlsErr := sniffTLSClientHello(conn, reader, opts.sniffTimeout())
+		if tlsErr != nil {
+			return nil, fmt.Errorf("TLS ClientHello sniff failed: %w", tlsErr)
+		}
+		target, err := tlsRoutingTarget(conn, hello)
+		if err != nil {
+			return nil, err
+		}
+		return &sniffResult{target: target, proto: proto, info: hello.sniffInfo()}, nil
+	case types.ProtoHTTP: // HTTP Methods (GET, POST, CONNECT, etc.)
+		host, request, httpErr := sniffTargetHTTP(conn, reader)
+		if httpErr == nil && host != "" {
+			return &sniffResult{target: host, proto: proto, req: request}, nil
+		}
+		return nil, fmt.Errorf("HTTP sniff failed: %w", httpErr)
+	default:
+		return nil, fmt.Errorf("could not determine target protocol, initial byte: 0x%02x", firstByte[0])
 	}
-	extensionsData := data[offset : offset+extensionsLen]
-
-	for len(extensionsData) >= 4 {
-		extType := binary.BigEndian.Uint16(extensionsData[0:2])
-		extLen := int(binary.BigEndian.Uint16(extensionsData[2:4]))
-		extensionsData = extensionsData[4:]
+}
 
-		if len(extensionsData) < extLen {
-			return "", fmt.Errorf("invalid extension length")
+// tlsRoutingTarget 根据 ClientHello 计算路由目标。客户端实际连接的地址是经 DNAT/REDIRECT
+// 到达本端口前的原始目标，没有重定向时就是本端地址。有 SNI 时使用 SNI 加上该地址的端口
+// (例如 DNS 劫持到网关的 8443 端口仍然访问 8443)；没有 SNI (例如直接访问 IP) 时回退到原始 IP 目标。
+func tlsRoutingTarget(conn net.Conn, hello *clientHelloInfo) (string, error) {
+	dst, err := tproxy.GetOriginalDst(conn)
+	redirected := err == nil && dst.String() != conn.LocalAddr().String()
+	if hello.ServerName != "" {
+		port := "443"
+		connected := conn.LocalAddr()
+		if redirected {
+			connected = dst
 		}
-
-		if extType == 0x0000 { // SNI
-			sniData := extensionsData[:extLen]
-			if len(sniData) < 5 {
-				return "", fmt.Errorf("invalid SNI data")
-			}
-			sniData = sniData[2:]
-			if sniData[0] != 0x00 {
-				return "", fmt.Errorf("unsupported SNI name type: %d", sniData[0])
-			}
-			nameLen := int(binary.BigEndian.Uint16(sniData[1:3]))
-			sniData = sniData[3:]
-			if len(sniData) < nameLen {
-				return "", fmt.Errorf("invalid SNI name length")
-			}
-			return string(sniData[:nameLen]), nil
+		if _, p, err := net.SplitHostPort(connected.String()); err == nil && p != "0" {
+			port = p
 		}
-		extensionsData = extensionsData[extLen:]
+		return net.JoinHostPort(hello.ServerName, port), nil
 	}
-
-	return "", fmt.Errorf("SNI not found")
+	if redirected {
+		return dst.String(), nil
+	}
+	return "", errors.New("TLS ClientHello has no SNI and the original IP destination is unknown")
 }
 
 // sniffTargetHTTP 嗅探 Host 并确保始终返回 host:port 格式。
@@ -169,8 +167,8 @@ func sniffTargetHTTP(conn net.Conn, reader *bufio.Reader) (string, *http.Request
 }
 
 // sniffTargetSocks5 嗅探 SOCKS5 请求中的目标地址。
-func sniffTargetSocks5(conn net.Conn, reader *bufio.Reader) (string, error) {
-	err := handleSocks5ClientHandshake(conn, reader)
+func sniffTargetSocks5(conn net.Conn, reader *bufio.Reader, auth *settings.InboundAuth) (string, error) {
+	err := handleSocks5ClientHandshake(conn, reader, auth)
 	if err != nil {
 		return "handleSocks5ClientHandshake ", err
 	}
@@ -225,7 +223,8 @@ func sniffTargetSocks5(conn net.Conn, reader *bufio.Reader) (string, error) {
 }
 
 // handleSocks5ClientHandshake 处理 SOCKS5 的客户端握手阶段。
-func handleSocks5ClientHandshake(conn net.Conn, reader *bufio.Reader) error {
+// 如果监听器配置了认证，则要求客户端使用 RFC 1929 用户名/密码认证。
+func handleSocks5ClientHandshake(conn net.Conn, reader *bufio.Reader, auth *settings.InboundAuth) error {
 	header := make([]byte, 2)
 	if _, err := io.ReadFull(reader, header); err != nil {
 		return err
@@ -235,19 +234,147 @@ func handleSocks5ClientHandshake(conn net.Conn, reader *bufio.Reader) error {
 	if _, err := io.ReadFull(reader, methods); err != nil {
 		return err
 	}
-	_, err := conn.Write([]byte{0x05, 0x00})
+	if auth == nil {
+		_, err := conn.Write([]byte{0x05, 0x00})
+		return err
+	}
+
+	if !bytes.Contains(methods, []byte{0x02}) {
+		conn.Write([]byte{0x05, 0xFF})
+		return fmt.Errorf("client does not support username/password authentication")
+	}
+	if _, err := conn.Write([]byte{0x05, 0x02}); err != nil {
+		return err
+	}
+
+	// +----+------+----------+------+----------+
+	// |VER | ULEN |  UNAME   | PLEN |  PASSWD  |
+	// +----+------+----------+------+----------+
+	verAndLen := make([]byte, 2)
+	if _, err := io.ReadFull(reader, verAndLen); err != nil {
+		return err
+	}
+	if verAndLen[0] != 0x01 {
+		return fmt.Errorf("unsupported SOCKS5 auth version: %d", verAndLen[0])
+	}
+	username := make([]byte, int(verAndLen[1]))
+	if _, err := io.ReadFull(reader, username); err != nil {
+		return err
+	}
+	passLen, err := reader.ReadByte()
+	if err != nil {
+		return err
+	}
+	password := make([]byte, int(passLen))
+	if _, err := io.ReadFull(reader, password); err != nil {
+		return err
+	}
+
+	if !credentialsMatch(auth, string(username), string(password)) {
+		conn.Write([]byte{0x01, 0x01})
+		return fmt.Errorf("SOCKS5 authentication failed for user '%s'", username)
+	}
+	_, err = conn.Write([]byte{0x01, 0x00})
 	return err
 }
 
-// fillBuffer 确保 reader 的缓冲区至少有 n 个字节，带超时。
+// fillBuffer 确保 reader 的缓冲区至少有 n 个字节，带默认超时。
 func fillBuffer(conn net.Conn, reader *bufio.Reader, n int) error {
-	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	return fillBufferUntil(conn, reader, n, time.Now().Add(defaultSniffTimeout))
+}
+
+// fillBufferUntil 确保 reader 的缓冲区至少有 n 个字节，直到 deadline 为止。
+// n 不能超过 reader 的缓冲区大小。
+func fillBufferUntil(conn net.Conn, reader *bufio.Reader, n int, deadline time.Time) error {
+	if reader.Buffered() >= n {
+		return nil
+	}
+	conn.SetReadDeadline(deadline)
 	defer conn.SetReadDeadline(time.Time{})
-	for reader.Buffered() < n {
-		_, err := reader.Peek(1)
+	_, err := reader.Peek(n)
+	return err
+}
+
+// defaultTransparentSniffTimeout 是透明代理路径上嗅探首包的默认超时。
+// 必须足够短：SMTP/SSH 等服务器先发言的协议在嗅探期间收不到任何数据，会被拖慢整段超时。
+const defaultTransparentSniffTimeout = 300 * time.Millisecond
+
+// sniffOverrideDomain 在透明代理路径上窥视首包，从 TLS SNI 或 HTTP Host 中提取域名，不消费任何数据。
+// 未能识别协议、首包中没有域名或域名本身是 IP 时返回空字符串。
+func sniffOverrideDomain(conn net.Conn, reader *bufio.Reader, timeout time.Duration) (string, *types.SniffInfo) {
+	deadline := time.Now().Add(timeout)
+	if err := fillBufferUntil(conn, reader, 1, deadline); err != nil {
+		return "", nil
+	}
+	firstByte, _ := reader.Peek(1)
+
+	var domain string
+	var info *types.SniffInfo
+	switch {
+	case firstByte[0] == tlsRecordTypeHandshake:
+		msg, err := readClientHello(conn, reader, deadline)
+		if err != nil {
+			return "", nil
+		}
+		hello, err := parseClientHello(msg)
+		if err != nil {
+			return "", nil
+		}
+		domain, info = hello.ServerName, hello.sniffInfo()
+	case firstByte[0] >= 'A' && firstByte[0] <= 'Z':
+		host, err := peekHTTPHost(conn, reader, deadline)
 		if err != nil {
-			return err
+			return "", nil
+		}
+		domain, info = host, &types.SniffInfo{}
+	}
+
+	if domain == "" || net.ParseIP(domain) != nil {
+		return "", nil
+	}
+	info.ServerName = domain
+	return domain, info
+}
+
+// peekHTTPHost 窥视完整的 HTTP 请求头并返回不带端口的 Host。
+func peekHTTPHost(conn net.Conn, reader *bufio.Reader, deadline time.Time) (string, error) {
+	for n := 1; ; {
+		if err := fillBufferUntil(conn, reader, n, deadline); err != nil {
+			return "", err
+		}
+		data, _ := reader.Peek(reader.Buffered())
+		if idx := bytes.Index(data, []byte("\r\n\r\n")); idx >= 0 {
+			req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(data[:idx+4])))
+			if err != nil {
+				return "", fmt.Errorf("could not parse HTTP request: %w", err)
+			}
+			host := req.Host
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			return strings.Trim(host, "[]"), nil
+		}
+		if len(data) >= sniffReaderSize {
+			return "", fmt.Errorf("HTTP request header exceeds %d bytes", sniffReaderSize)
 		}
+		n = len(data) + 1
+	}
+}
+
+// prefetchedConn 让嗅探时预读到 reader 中的数据在后续读取中仍然可见，
+// 使只接受 net.Conn 的策略 (HandleRawTCP) 也不会丢失首包。
+type prefetchedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *prefetchedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c *prefetchedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
 	}
 	return nil
 }
@@ -0,0 +1,231 @@
+package gateway
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"liuproxy_nexus/internal/shared/types"
+	"net"
+	"time"
+)
+
+const (
+	tlsRecordHeaderLen      = 5
+	tlsRecordTypeHandshake  = 0x16
+	tlsHandshakeClientHello = 0x01
+
+	// sniffReaderSize 是入站 bufio.Reader 的大小，需要容纳跨多个 record 的 ClientHello
+	// (例如带 X25519MLKEM768 key share 的后量子 ClientHello)。
+	sniffReaderSize = 16 * 1024
+
+	extServerName        = 0x0000
+	extALPN              = 0x0010
+	extSupportedVersions = 0x002b
+)
+
+// clientHelloInfo 是从 TLS ClientHello 中提取的路由相关信息。
+type clientHelloInfo struct {
+	ServerName        string   // 为空表示客户端没有发送 SNI (例如直接访问 IP)
+	ALPN              []string // 按客户端偏好排序
+	LegacyVersion     uint16
+	SupportedVersions []uint16 // 来自 supported_versions 扩展，TLS 1.3 客户端必带
+}
+
+func (h *clientHelloInfo) sniffInfo() *types.SniffInfo {
+	return &types.SniffInfo{
+		ServerName:  h.ServerName,
+		ALPN:        h.ALPN,
+		TLSVersions: h.SupportedVersions,
+	}
+}
+
+// readClientHello 在不消费数据的前提下，从 reader 中窥视并重组完整的 ClientHello 握手消息。
+// ClientHello 可能被拆分到多个 TLS record 中，这里逐个 record 拼接其 payload，
+// 直到得到完整的握手消息。所有读取共享同一个截止时间。
+func readClientHello(conn net.Conn, reader *bufio.Reader, deadline time.Time) ([]byte, error) {
+	var msg []byte
+	msgLen := -1
+	offset := 0
+
+	for msgLen < 0 || len(msg) < msgLen {
+		if err := fillBufferUntil(conn, reader, offset+tlsRecordHeaderLen, deadline); err != nil {
+			return nil, fmt.Errorf("failed to read TLS record header: %w", err)
+		}
+		header, _ := reader.Peek(offset + tlsRecordHeaderLen)
+		header = header[offset:]
+		if header[0] != tlsRecordTypeHandshake {
+			return nil, fmt.Errorf("not a TLS handshake record (type 0x%02x)", header[0])
+		}
+		if header[1] != 0x03 {
+			return nil, fmt.Errorf("unexpected TLS major version: %d", header[1])
+		}
+		recordLen := int(binary.BigEndian.Uint16(header[3:5]))
+		if recordLen == 0 {
+			return nil, errors.New("empty TLS handshake record")
+		}
+
+		end := offset + tlsRecordHeaderLen + recordLen
+		if end > sniffReaderSize {
+			return nil, fmt.Errorf("TLS ClientHello exceeds %d bytes", sniffReaderSize)
+		}
+		if err := fillBufferUntil(conn, reader, end, deadline); err != nil {
+			return nil, fmt.Errorf("buffer does not contain full TLS record: %w", err)
+		}
+		data, _ := reader.Peek(end)
+		msg = append(msg, data[offset+tlsRecordHeaderLen:end]...)
+		offset = end
+
+		if msgLen < 0 && len(msg) >= 4 {
+			if msg[0] != tlsHandshakeClientHello {
+				return nil, errors.New("not a ClientHello message")
+			}
+			msgLen = 4 + (int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3]))
+			if msgLen > sniffReaderSize {
+				return nil, fmt.Errorf("TLS ClientHello exceeds %d bytes", sniffReaderSize)
+			}
+		}
+	}
+	return msg[:msgLen], nil
+}
+
+// parseClientHello 解析一个完整的 ClientHello 握手消息 (含 4 字节握手头)。
+// 缺少 SNI 不视为错误。
+func parseClientHello(msg []byte) (*clientHelloInfo, error) {
+	if len(msg) < 4 || msg[0] != tlsHandshakeClientHello {
+		return nil, errors.New("not a ClientHello message")
+	}
+	data := msg[4:]
+	// legacy_version(2) + random(32) + session_id_len(1)
+	if len(data) < 35 {
+		return nil, errors.New("invalid ClientHello: too short")
+	}
+	info := &clientHelloInfo{LegacyVersion: binary.BigEndian.Uint16(data[0:2])}
+
+	offset := 34
+	sessionIDLen := int(data[offset])
+	offset += 1 + sessionIDLen
+	if offset+2 > len(data) {
+		return nil, errors.New("invalid ClientHello: session ID parsing error")
+	}
+
+	cipherSuitesLen := int(binary.BigEndian.Uint16(data[offset : offset+2]))
+	offset += 2 + cipherSuitesLen
+	if offset+1 > len(data) {
+		return nil, errors.New("invalid ClientHello: cipher suites parsing error")
+	}
+
+	compressionMethodsLen := int(data[offset])
+	offset += 1 + compressionMethodsLen
+	if offset > len(data) {
+		return nil, errors.New("invalid ClientHello: compression methods parsing error")
+	}
+	if offset+2 > len(data) {
+		// 没有扩展的 ClientHello (SSLv3/TLS 1.0 时代的客户端) 是合法的
+		return info, nil
+	}
+
+	extensionsLen := int(binary.BigEndian.Uint16(data[offset : offset+2]))
+	offset += 2
+	if offset+extensionsLen > len(data) {
+		return nil, errors.New("invalid ClientHello: extensions length mismatch")
+	}
+	extensionsData := data[offset : offset+extensionsLen]
+
+	for len(extensionsData) >= 4 {
+		extType := binary.BigEndian.Uint16(extensionsData[0:2])
+		extLen := int(binary.BigEndian.Uint16(extensionsData[2:4]))
+		extensionsData = extensionsData[4:]
+		if len(extensionsData) < extLen {
+			return nil, errors.New("invalid extension length")
+		}
+		extData := extensionsData[:extLen]
+		extensionsData = extensionsData[extLen:]
+
+		var err error
+		switch extType {
+		case extServerName:
+			info.ServerName, err = parseSNIExtension(extData)
+		case extALPN:
+			info.ALPN, err = parseALPNExtension(extData)
+		case extSupportedVersions:
+			info.SupportedVersions, err = parseSupportedVersionsExtension(extData)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return info, nil
+}
+
+func parseSNIExtension(data []byte) (string, error) {
+	if len(data) < 2 {
+		return "", errors.New("invalid SNI data")
+	}
+	listLen := int(binary.BigEndian.Uint16(data[0:2]))
+	data = data[2:]
+	if len(data) < listLen {
+		return "", errors.New("invalid SNI list length")
+	}
+	data = data[:listLen]
+	for len(data) >= 3 {
+		nameType := data[0]
+		nameLen := int(binary.BigEndian.Uint16(data[1:3]))
+		data = data[3:]
+		if len(data) < nameLen {
+			return "", errors.New("invalid SNI name length")
+		}
+		if nameType == 0x00 { // host_name
+			return string(data[:nameLen]), nil
+		}
+		data = data[nameLen:]
+	}
+	return "", nil
+}
+
+func parseALPNExtension(data []byte) ([]string, error) {
+	if len(data) < 2 {
+		return nil, errors.New("invalid ALPN data")
+	}
+	listLen := int(binary.BigEndian.Uint16(data[0:2]))
+	data = data[2:]
+	if len(data) != listLen {
+		return nil, errors.New("invalid ALPN list length")
+	}
+	var protocols []string
+	for len(data) > 0 {
+		protoLen := int(data[0])
+		data = data[1:]
+		if protoLen == 0 || len(data) < protoLen {
+			return nil, errors.New("invalid ALPN protocol length")
+		}
+		protocols = append(protocols, string(data[:protoLen]))
+		data = data[protoLen:]
+	}
+	return protocols, nil
+}
+
+func parseSupportedVersionsExtension(data []byte) ([]uint16, error) {
+	if len(data) < 1 {
+		return nil, errors.New("invalid supported_versions data")
+	}
+	listLen := int(data[0])
+	data = data[1:]
+	if len(data) != listLen || listLen%2 != 0 {
+		return nil, errors.New("invalid supported_versions list length")
+	}
+	versions := make([]uint16, 0, listLen/2)
+	for i := 0; i < listLen; i += 2 {
+		versions = append(versions, binary.BigEndian.Uint16(data[i:i+2]))
+	}
+	return versions, nil
+}
+
+// sniffTLSClientHello 窥视并解析入站连接上的 ClientHello，不消费任何数据。
+func sniffTLSClientHello(conn net.Conn, reader *bufio.Reader, timeout time.Duration) (*clientHelloInfo, error) {
+	msg, err := readClientHello(conn, reader, time.Now().Add(timeout))
+	if err != nil {
+		return nil, err
+	}
+	return parseClientHello(msg)
+}
@@ -0,0 +1,248 @@
+package gateway
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"liuproxy_nexus/internal/core/dispatcher"
+	"liuproxy_nexus/internal/shared/settings"
+	"liuproxy_nexus/internal/shared/types"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureClientHello 让 crypto/tls 客户端按 cfg 发起握手，返回它发出的第一个 TLS record。
+func captureClientHello(t *testing.T, cfg *tls.Config) []byte {
+	t.Helper()
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		defer client.Close()
+		tls.Client(client, cfg).Handshake()
+	}()
+	server.SetDeadline(time.Now().Add(5 * time.Second))
+	header := make([]byte, tlsRecordHeaderLen)
+	if _, err := io.ReadFull(server, header); err != nil {
+		t.Fatal(err)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header[3:5]))
+	if _, err := io.ReadFull(server, body); err != nil {
+		t.Fatal(err)
+	}
+	return append(header, body...)
+}
+
+// splitRecords 把握手消息重新封装为每个 payload 不超过 size 字节的多个 handshake record。
+func splitRecords(msg []byte, size int) []byte {
+	var out []byte
+	for len(msg) > 0 {
+		n := min(size, len(msg))
+		out = append(out, tlsRecordTypeHandshake, 0x03, 0x01, byte(n>>8), byte(n))
+		out = append(out, msg[:n]...)
+		msg = msg[n:]
+	}
+	return out
+}
+
+// sniffFromPipe 逐字节写入 data，模拟 ClientHello 分多个 TCP 段到达，返回嗅探结果和 reader 中缓冲的字节数。
+func sniffFromPipe(t *testing.T, data []byte) (*clientHelloInfo, int, error) {
+	t.Helper()
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		defer client.Close()
+		for i := range data {
+			if _, err := client.Write(data[i : i+1]); err != nil {
+				return
+			}
+		}
+	}()
+	reader := bufio.NewReaderSize(server, sniffReaderSize)
+	hello, err := sniffTLSClientHello(server, reader, 5*time.Second)
+	return hello, reader.Buffered(), err
+}
+
+func TestSniffTLSClientHello(t *testing.T) {
+	record := captureClientHello(t, &tls.Config{
+		ServerName: "www.example.com",
+		NextProtos: []string{"h2", "http/1.1"},
+		MinVersion: tls.VersionTLS12,
+	})
+	msg := record[tlsRecordHeaderLen:]
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "single record", data: record},
+		// 握手消息被拆分到多个 record 中
+		{name: "multiple records", data: splitRecords(msg, 100)},
+		// 握手头本身也被拆开
+		{name: "one byte records", data: append(splitRecords(msg[:64], 1), splitRecords(msg[64:], 512)...)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hello, buffered, err := sniffFromPipe(t, tt.data)
+			if err != nil {
+				t.Fatalf("sniffTLSClientHello() error = %v", err)
+			}
+			if hello.ServerName != "www.example.com" {
+				t.Errorf("ServerName = %q", hello.ServerName)
+			}
+			if !slices.Equal(hello.ALPN, []string{"h2", "http/1.1"}) {
+				t.Errorf("ALPN = %v, want [h2 http/1.1]", hello.ALPN)
+			}
+			if !slices.Equal(hello.SupportedVersions, []uint16{tls.VersionTLS13, tls.VersionTLS12}) {
+				t.Errorf("SupportedVersions = %x, want [0304 0303]", hello.SupportedVersions)
+			}
+			if hello.LegacyVersion != tls.VersionTLS12 {
+				t.Errorf("LegacyVersion = %x, want 0303", hello.LegacyVersion)
+			}
+			// 嗅探只窥视，不消费任何数据
+			if buffered != len(tt.data) {
+				t.Errorf("reader buffered %d bytes, want the whole %d byte ClientHello", buffered, len(tt.data))
+			}
+		})
+	}
+
+	t.Run("no SNI", func(t *testing.T) {
+		hello, _, err := sniffFromPipe(t, captureClientHello(t, &tls.Config{InsecureSkipVerify: true}))
+		if err != nil {
+			t.Fatalf("sniffTLSClientHello() error = %v", err)
+		}
+		if hello.ServerName != "" || hello.ALPN != nil {
+			t.Errorf("ServerName = %q, ALPN = %v, want both empty", hello.ServerName, hello.ALPN)
+		}
+	})
+}
+
+func TestSniffTLSClientHelloSizeLimit(t *testing.T) {
+	// 握手头声明的长度超过 sniffReaderSize，第一个 record 之后就应放弃
+	declared := []byte{tlsHandshakeClientHello, 0x00, 0x50, 0x00}
+	declared = append(declared, make([]byte, 100)...)
+
+	// 每个 record 都合法，但累计长度超过 sniffReaderSize
+	huge := make([]byte, sniffReaderSize)
+	huge[0] = tlsHandshakeClientHello
+	n := len(huge) - 4
+	huge[1], huge[2], huge[3] = byte(n>>16), byte(n>>8), byte(n)
+
+	for name, data := range map[string][]byte{
+		"declared length": splitRecords(declared, 512),
+		"records":         splitRecords(huge, 4096),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := sniffFromPipe(t, data)
+			if err == nil || !strings.Contains(err.Error(), "exceeds") {
+				t.Errorf("sniffTLSClientHello() error = %v, want size limit error", err)
+			}
+		})
+	}
+}
+
+func TestTLSRoutingTarget(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	port := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+
+	// 保留客户端实际连接的端口，而不是假定 443
+	target, err := tlsRoutingTarget(conn, &clientHelloInfo{ServerName: "example.com"})
+	if err != nil || target != "example.com:"+port {
+		t.Errorf("tlsRoutingTarget() = %q, %v, want example.com:%s", target, err, port)
+	}
+	// 没有 SNI 且连接没有经过重定向时，无法得知原始目标
+	if target, err := tlsRoutingTarget(conn, &clientHelloInfo{}); err == nil {
+		t.Errorf("tlsRoutingTarget() without SNI = %q, want error", target)
+	}
+	// 无法得知端口的连接 (例如 unix socket) 回退到 443
+	pipe, _ := net.Pipe()
+	defer pipe.Close()
+	if target, err := tlsRoutingTarget(pipe, &clientHelloInfo{ServerName: "example.com"}); err != nil || target != "example.com:443" {
+		t.Errorf("tlsRoutingTarget() over a pipe = %q, %v, want example.com:443", target, err)
+	}
+}
+
+// waitForTarget 等待策略收到一个 SOCKS5 目标并返回它。
+func waitForTarget(t *testing.T, s *socksTestStrategy) string {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if targets := s.requestedTargets(); len(targets) > 0 {
+			return targets[0]
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return ""
+}
+
+func TestGatewayALPNRouting(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		alpn []string
+		want string // 应收到连接的策略
+	}{
+		{name: "h2 preferred", alpn: []string{"h2", "http/1.1"}, want: "a"},
+		// alpn 规则只看客户端首选的协议，同时提供 h2 的客户端仍匹配 http/1.1 规则
+		{name: "http/1.1 preferred", alpn: []string{"http/1.1", "h2"}, want: "b"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			strategies := map[string]*socksTestStrategy{"a": {origin: "127.0.0.1:1"}, "b": {origin: "127.0.0.1:1"}}
+			states := staticStateProvider{}
+			for remarks, s := range strategies {
+				id := "server-" + remarks + "-id"
+				states[id] = &types.ServerState{
+					Profile:  &types.ServerProfile{ID: id, Remarks: remarks, Active: true},
+					Instance: s,
+					Health:   types.StatusUp,
+				}
+			}
+			disp := dispatcher.New(&settings.GatewaySettings{}, states)
+			if err := disp.OnSettingsUpdate("routing", &settings.RoutingSettings{
+				Rules: []*settings.Rule{
+					{Priority: 1, Type: string(settings.RuleTypeALPN), Value: []string{"h2"}, Target: "a"},
+					{Priority: 2, Type: string(settings.RuleTypeALPN), Value: []string{"http/1.1"}, Target: "b"},
+				},
+			}); err != nil {
+				t.Fatal(err)
+			}
+			addr := startTestGateway(t, disp)
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if _, err := conn.Write(captureClientHello(t, &tls.Config{ServerName: "alpn.test", NextProtos: tt.alpn})); err != nil {
+				t.Fatal(err)
+			}
+
+			_, port, _ := net.SplitHostPort(addr)
+			if got := waitForTarget(t, strategies[tt.want]); got != "alpn.test:"+port {
+				t.Errorf("strategy %q got target %q, want alpn.test:%s", tt.want, got, port)
+			}
+			for remarks, s := range strategies {
+				if remarks != tt.want && len(s.requestedTargets()) > 0 {
+					t.Errorf("strategy %q also received %v", remarks, s.requestedTargets())
+				}
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@
 package gateway
 
 import (
+	"bufio"
 	"context"
 	"fmt"
 	"github.com/google/uuid"
@@ -13,6 +14,7 @@ import (
 	"liuproxy_nexus/internal/shared/types"
 	"liuproxy_nexus/internal/sys/tproxy"
 	"net"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -20,7 +22,11 @@ import (
 
 // TransparentGateway 负责处理被iptables等工具重定向的透明流量。
 type TransparentGateway struct {
-	listenPort  int
+	listenAddr  string
+	tag         string // 监听器标签，供 inbound_tag 路由规则匹配
+	tproxyMode  bool   // true: TPROXY (IP_TRANSPARENT)，false: REDIRECT (SO_ORIGINAL_DST)
+	sniff       bool   // 是否用首包中的 TLS SNI / HTTP Host 覆盖 IP 目标
+	sniffWait   time.Duration
 	tcpListener net.Listener
 	udpListener net.PacketConn
 	firewall    firewall.Firewall
@@ -28,40 +34,90 @@ type TransparentGateway struct {
 	hub         *web.Hub
 	direct      VirtualStrategy
 	reject      VirtualStrategy
+	udpFlows    *udpFlowTable
+	udpReplies  *udpReplyTable // 仅 TPROXY 模式：以原始目标为源地址回复客户端
 	closeOnce   sync.Once
 	waitGroup   sync.WaitGroup
 }
 
-// NewTransparent 创建一个新的 TransparentGateway 实例。
+// NewTransparent 创建 liuproxy.ini 中 tproxy_port 对应的默认透明网关 (REDIRECT 模式)。
 func NewTransparent(port int, fw firewall.Firewall, disp types.Dispatcher, hub *web.Hub) *TransparentGateway {
 	return &TransparentGateway{
-		listenPort: port,
+		listenAddr: fmt.Sprintf("0.0.0.0:%d", port),
+		tag:        DefaultTProxyTag,
 		firewall:   fw,
 		dispatcher: disp,
 		hub:        hub,
 		direct:     NewDirectStrategy(), // 复用现有的 direct 策略
 		reject:     NewRejectStrategy(), // 复用现有的 reject 策略
+		udpFlows:   newUDPFlowTable(),
 	}
 }
 
+// NewTransparentInbound 根据 settings 中的监听器定义创建透明网关，支持 tproxy 和 redirect 两种类型。
+func NewTransparentInbound(cfg *settings.Inbound, fw firewall.Firewall, disp types.Dispatcher, hub *web.Hub) (*TransparentGateway, error) {
+	network, address, err := parseListenAddr(cfg.Listen)
+	if err != nil {
+		return nil, err
+	}
+	if network != "tcp" {
+		return nil, fmt.Errorf("transparent inbound must listen on an IP address, got '%s'", cfg.Listen)
+	}
+	if cfg.Auth != nil {
+		return nil, fmt.Errorf("transparent inbound does not support authentication")
+	}
+	g := NewTransparent(0, fw, disp, hub)
+	g.listenAddr = address
+	g.tag = cfg.Tag
+	g.tproxyMode = cfg.Type == settings.InboundTProxy
+	if cfg.Sniff {
+		g.EnableSniffing(time.Duration(cfg.SniffTimeout) * time.Millisecond)
+	}
+	return g, nil
+}
+
+// EnableSniffing 开启 TCP 嗅探覆盖：在 timeout 内从首包中提取域名，用 domain:port 代替原始 IP 目标
+// 重新参与路由并交给策略。timeout 为 0 时使用 defaultTransparentSniffTimeout。
+func (g *TransparentGateway) EnableSniffing(timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = defaultTransparentSniffTimeout
+	}
+	g.sniff = true
+	g.sniffWait = timeout
+}
+
 func (g *TransparentGateway) Start() error {
-	addr := fmt.Sprintf("0.0.0.0:%d", g.listenPort)
+	addr := g.listenAddr
 
 	// 启动 TCP 监听
-	tcpListener, err := net.Listen("tcp", addr)
+	var tcpListener net.Listener
+	var err error
+	if g.tproxyMode {
+		tcpListener, _, err = tproxy.ListenTransparent("tcp", addr)
+	} else {
+		tcpListener, err = net.Listen("tcp", addr)
+	}
 	if err != nil {
 		return fmt.Errorf("transparent gateway failed to listen TCP on %s: %w", addr, err)
 	}
 	g.tcpListener = tcpListener
-	logger.Info().Str("listen_addr", tcpListener.Addr().String()).Msg(">>> Transparent Gateway is listening for TCP.")
+	logger.Info().Str("listen_addr", tcpListener.Addr().String()).Str("tag", g.tag).Msg(">>> Transparent Gateway is listening for TCP.")
 
 	// 启动 UDP 监听
-	udpListener, err := net.ListenPacket("udp", addr)
+	var udpListener net.PacketConn
+	if g.tproxyMode {
+		_, udpListener, err = tproxy.ListenTransparent("udp", addr)
+	} else {
+		udpListener, err = net.ListenPacket("udp", addr)
+	}
 	if err != nil {
 		tcpListener.Close()
 		return fmt.Errorf("transparent gateway failed to listen UDP on %s: %w", addr, err)
 	}
 	g.udpListener = udpListener
+	if g.tproxyMode {
+		g.udpReplies = newUDPReplyTable(g.handleUDPPacket)
+	}
 	logger.Info().Str("listen_addr", udpListener.LocalAddr().String()).Msg(">>> Transparent Gateway is listening for UDP.")
 
 	g.waitGroup.Add(2) // 只为 TCP 和 UDP 循环
@@ -95,10 +151,10 @@ func (g *TransparentGateway) handleTCPConnection(inboundConn net.Conn) {
 	defer inboundConn.Close()
 
 	traceID := uuid.NewString()
-	l := log.With().Str("trace_id", traceID).Logger()
-	ctx := l.WithContext(context.Background())
+	l := log.With().Str("trace_id", traceID).Str("inbound", g.tag).Logger()
+	ctx := types.WithInboundTag(l.WithContext(context.Background()), g.tag)
 
-	originalDst, err := tproxy.GetOriginalDst(inboundConn)
+	originalDst, err := g.originalDst(inboundConn)
 	if err != nil {
 		l.Error().Err(err).Str("client_ip", inboundConn.RemoteAddr().String()).Msg("TPROXY: Failed to get original destination")
 		return
@@ -135,15 +191,30 @@ func (g *TransparentGateway) handleTCPConnection(inboundConn net.Conn) {
 		return
 	}
 
-	// 2. 调度器分流
+	// 2. 嗅探覆盖：首包中带有域名时，用 domain:port 代替 IP 目标参与路由，
+	// 预读的数据保留在 reader 中，随后原样交给 DIRECT 或策略。
 	targetDestStr := originalDst.String()
+	var conn net.Conn = inboundConn
+	var reader *bufio.Reader
+	if g.sniff {
+		reader = bufio.NewReaderSize(inboundConn, sniffReaderSize)
+		conn = &prefetchedConn{Conn: inboundConn, reader: reader}
+		if domain, info := sniffOverrideDomain(inboundConn, reader, g.sniffWait); domain != "" {
+			_, port, _ := net.SplitHostPort(targetDestStr)
+			targetDestStr = net.JoinHostPort(domain, port)
+			ctx = types.WithSniffInfo(ctx, info)
+			l.Debug().Str("original_dest", originalDst.String()).Str("sniffed_dest", targetDestStr).Msg("TPROXY: Destination overridden by sniffed domain")
+		}
+	}
+
+	// 3. 调度器分流
 	strategy, serverID, err := g.dispatcher.Dispatch(ctx, inboundConn.RemoteAddr(), targetDestStr)
 	if err != nil {
 		l.Warn().Err(err).Str("target", targetDestStr).Msg("TPROXY: Dispatcher returned error")
 		return
 	}
 
-	// 3. 执行决策
+	// 4. 执行决策
 	if strategy == nil {
 		l.Debug().Str("decision", serverID).Msg("TPROXY: Dispatching to virtual strategy")
 		// 【流量日志】更新决策
@@ -157,8 +228,8 @@ func (g *TransparentGateway) handleTCPConnection(inboundConn net.Conn) {
 		})
 		switch serverID {
 		case "DIRECT":
-			// 对于透明代理的直连，reader是nil，因为我们没有预读任何数据
-			g.direct.Handle(inboundConn, nil, originalDst)
+			// 直连仍然连接原始 IP；未开启嗅探时 reader 为 nil
+			g.direct.Handle(inboundConn, reader, originalDst)
 		case "REJECT":
 			g.reject.Handle(inboundConn, nil, originalDst)
 		}
@@ -176,7 +247,16 @@ func (g *TransparentGateway) handleTCPConnection(inboundConn net.Conn) {
 
 	l.Debug().Str("strategy", strategy.GetType()).Str("server_id", serverID).Msg("TPROXY: Dispatching to strategy")
 	// 将连接的控制权完全移交给策略实例
-	strategy.HandleRawTCP(inboundConn, targetDestStr)
+	strategy.HandleRawTCP(conn, targetDestStr)
+}
+
+// originalDst 返回被拦截连接的原始目标地址。
+// TPROXY 不改写目标地址，LocalAddr 即为原始目标；REDIRECT 需要通过 SO_ORIGINAL_DST 查询。
+func (g *TransparentGateway) originalDst(conn net.Conn) (net.Addr, error) {
+	if g.tproxyMode {
+		return conn.LocalAddr(), nil
+	}
+	return tproxy.GetOriginalDst(conn)
 }
 
 // 【新增】UDP 循环
@@ -184,7 +264,7 @@ func (g *TransparentGateway) acceptUDPLoop() {
 	defer g.waitGroup.Done()
 	buf := make([]byte, 4096)
 	for {
-		n, clientAddr, err := g.udpListener.ReadFrom(buf)
+		n, clientAddr, originalDst, err := g.readUDP(buf)
 		if err != nil {
 			if opErr, ok := err.(*net.OpError); ok && strings.Contains(opErr.Err.Error(), "use of closed network connection") {
 				logger.Info().Msg("Transparent Gateway UDP listener is closing.")
@@ -197,59 +277,113 @@ func (g *TransparentGateway) acceptUDPLoop() {
 		payload := make([]byte, n)
 		copy(payload, buf[:n])
 
-		go g.handleUDPPacket(payload, clientAddr)
+		go g.handleUDPPacket(payload, clientAddr, originalDst)
 	}
 }
 
-// 【修改】无状态的 UDP 包处理器
-func (g *TransparentGateway) handleUDPPacket(payload []byte, clientAddr net.Addr) {
-	// 由于无法可靠获取原始目标地址，我们必须依赖 Dispatcher 的规则
-	// 我们将使用一个“虚拟目标”来触发规则匹配。对于DNS，这通常是 8.8.8.8:53
-	// 这要求用户必须配置一条匹配此虚拟目标的路由规则。
-	const virtualTarget = "8.8.8.8:53"
+// readUDP 读取一个数据报。TPROXY 模式下同时返回原始目标；REDIRECT 模式下无法取得 UDP 的原始目标，返回 nil。
+func (g *TransparentGateway) readUDP(buf []byte) (int, net.Addr, *net.UDPAddr, error) {
+	if !g.tproxyMode {
+		n, clientAddr, err := g.udpListener.ReadFrom(buf)
+		return n, clientAddr, nil, err
+	}
+	n, clientAddr, originalDst, err := tproxy.ReadFromUDP(g.udpListener, buf)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	return n, clientAddr, originalDst, nil
+}
+
+// virtualUDPTarget 是无法取得原始目标时 (REDIRECT 模式) 用于路由和转发的目标。
+// 这要求用户配置一条匹配此虚拟目标的路由规则。
+var virtualUDPTarget = &net.UDPAddr{IP: net.IPv4(8, 8, 8, 8), Port: 53}
+
+// handleUDPPacket 处理一个数据报。同一个流 (客户端 + 原始目标) 共用一个 QUIC 嗅探器，
+// ClientHello 跨越多个 Initial 数据报时也能用 SNI 代替 IP 参与路由，使 HTTP/3 流量匹配 domain 规则。
+func (g *TransparentGateway) handleUDPPacket(payload []byte, clientAddr net.Addr, originalDst *net.UDPAddr) {
+	dest := originalDst
+	if dest == nil {
+		dest = virtualUDPTarget
+	}
+	sniffWait := g.sniffWait
+	if sniffWait <= 0 {
+		sniffWait = defaultTransparentSniffTimeout
+	}
+
+	flow := g.udpFlows.get(udpFlowKey{client: clientAddr.String(), dest: dest.String()})
+	datagrams, domain, info := flow.feed(payload, sniffWait, func(pending [][]byte) {
+		g.dispatchUDP(clientAddr, dest, "", nil, pending)
+	})
+	if len(datagrams) > 0 {
+		g.dispatchUDP(clientAddr, dest, domain, info, datagrams)
+	}
+}
 
-	l := log.With().Str("client_ip", clientAddr.String()).Logger()
-	ctx := l.WithContext(context.Background())
+// dispatchUDP 按目标分流并把数据报交给策略。domain 不为空时用 domain:端口 代替 IP 目标参与路由，端口为真实的目标端口。
+func (g *TransparentGateway) dispatchUDP(clientAddr net.Addr, dest *net.UDPAddr, domain string, info *types.SniffInfo, datagrams [][]byte) {
+	l := log.With().Str("client_ip", clientAddr.String()).Str("inbound", g.tag).Logger()
+	ctx := types.WithInboundTag(l.WithContext(context.Background()), g.tag)
+
+	routingTarget := dest.String()
+	if domain != "" {
+		routingTarget = net.JoinHostPort(domain, strconv.Itoa(dest.Port))
+		ctx = types.WithSniffInfo(ctx, info)
+	}
 
 	// Dispatcher 分流
-	strategy, _, err := g.dispatcher.Dispatch(ctx, clientAddr, virtualTarget)
+	strategy, _, err := g.dispatcher.Dispatch(ctx, clientAddr, routingTarget)
 	if err != nil || strategy == nil {
-		l.Debug().Msg("TPROXY-UDP: No rule matched for virtual target. Packet dropped.")
+		l.Debug().Str("target", routingTarget).Msg("TPROXY-UDP: No strategy for target. Packet dropped.")
 		return
 	}
 
-	// 从路由规则的目标中获取真实的 UDP 目标地址
-	// 这是个 hack：我们假设用户为 UDP 流量配置的规则，其 Value 字段就是真实的目标
-	// 例如，规则: { "type": "dest_ip", "value": ["8.8.8.8/32"], "target": "goremote" }
-	// 我们需要一种方法来知道该把包发到哪里。
-	// 修正：我们直接把虚拟目标地址传递给策略。策略知道如何处理它。
-
-	destUDPAddr, _ := net.ResolveUDPAddr("udp", virtualTarget)
-
-	packet := &types.UDPPacket{
-		Source:      clientAddr,
-		Destination: destUDPAddr,
-		Payload:     payload,
+	for _, payload := range datagrams {
+		packet := &types.UDPPacket{
+			Source:      clientAddr,
+			Destination: dest,
+			Payload:     payload,
+			Reply:       g.replyUDP(clientAddr, dest),
+		}
+		if err := strategy.HandleUDPPacket(packet, clientAddr.String()); err != nil {
+			l.Warn().Err(err).Msg("TPROXY-UDP: Strategy failed to handle UDP packet.")
+		}
 	}
+}
 
-	if err := strategy.HandleUDPPacket(packet, clientAddr.String()); err != nil {
-		l.Warn().Err(err).Msg("TPROXY-UDP: Strategy failed to handle UDP packet.")
+// replyUDP 返回把回复发回 clientAddr 的函数。TPROXY 模式下回复以其来源 (缺省为原始目标 dest) 为源地址，
+// 从透明 socket 发出；REDIRECT 模式下无法得知原始目标，只能经监听器发出。
+func (g *TransparentGateway) replyUDP(clientAddr net.Addr, dest *net.UDPAddr) types.UDPReplyFunc {
+	return func(payload []byte, from net.Addr) error {
+		client, ok := clientAddr.(*net.UDPAddr)
+		if !g.tproxyMode || !ok {
+			_, err := g.udpListener.WriteTo(payload, clientAddr)
+			return err
+		}
+		src := udpAddrOf(from)
+		// 地址族与客户端不同的来源 (例如 IPv4 客户端收到 IPv6 回复) 无法作为源地址
+		if src == nil || (src.IP.To4() == nil) != (client.IP.To4() == nil) {
+			src = dest
+		}
+		return g.udpReplies.write(payload, src, client)
 	}
 }
 
-// GetListener 返回网关的 UDP PacketConn 监听器
-func (g *TransparentGateway) GetListener() net.PacketConn {
-	return g.udpListener
+// stopListening 关闭监听器但不等待已有连接结束，用于热移除监听器。
+func (g *TransparentGateway) stopListening() {
+	if g.tcpListener != nil {
+		g.tcpListener.Close()
+	}
+	if g.udpListener != nil {
+		g.udpListener.Close()
+	}
+	if g.udpReplies != nil {
+		g.udpReplies.close()
+	}
 }
 
 func (g *TransparentGateway) Close() {
 	g.closeOnce.Do(func() {
-		if g.tcpListener != nil {
-			g.tcpListener.Close()
-		}
-		if g.udpListener != nil {
-			g.udpListener.Close()
-		}
+		g.stopListening()
 		g.waitGroup.Wait()
 		log.Info().Msg("Transparent Gateway has been shut down")
 	})
@@ -0,0 +1,448 @@
+//go:build linux
+
+package gateway
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"liuproxy_nexus/internal/core/dispatcher"
+	"liuproxy_nexus/internal/firewall"
+	"liuproxy_nexus/internal/service/web"
+	"liuproxy_nexus/internal/shared/settings"
+	"liuproxy_nexus/internal/shared/types"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// netnsChildEnv 标记当前进程是在独立网络命名空间中重新执行的测试子进程。
+const netnsChildEnv = "LIUPROXY_NETNS_TEST"
+
+// runInNetns 让测试在新的网络命名空间中运行：父进程通过 unshare 重新执行当前测试并返回 false，
+// 子进程执行 setup 中的命令后返回 true。需要 root 权限以及 unshare 和 ip 命令。
+func runInNetns(t *testing.T, setup ...[]string) bool {
+	t.Helper()
+	if os.Getenv(netnsChildEnv) == t.Name() {
+		for _, args := range setup {
+			if out, err := exec.Command(args[0], args[1:]...).CombinedOutput(); err != nil {
+				t.Fatalf("netns setup %v failed: %v\n%s", args, err, out)
+			}
+		}
+		return true
+	}
+
+	if os.Geteuid() != 0 {
+		t.Skip("creating a network namespace requires root")
+	}
+	for _, bin := range []string{"unshare", "ip"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s is not available", bin)
+		}
+	}
+	for _, args := range setup {
+		if _, err := exec.LookPath(args[0]); err != nil {
+			t.Skipf("%s is not available", args[0])
+		}
+	}
+
+	cmd := exec.Command("unshare", "--net", os.Args[0], "-test.run=^"+t.Name()+"$", "-test.v")
+	cmd.Env = append(os.Environ(), netnsChildEnv+"="+t.Name())
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("test in network namespace failed: %v\n%s", err, out)
+	}
+	if strings.Contains(string(out), "--- SKIP") {
+		t.Skipf("skipped in network namespace:\n%s", out)
+	}
+	return false
+}
+
+// echoTargetStrategy 把策略收到的目标地址写回 TCP 客户端，用来观察网关最终交给策略的目标；
+// UDP 数据报经回复路径原样返回。
+type echoTargetStrategy struct{}
+
+func (s *echoTargetStrategy) GetSocksConnection() (net.Conn, error) {
+	return nil, io.ErrUnexpectedEOF
+}
+func (s *echoTargetStrategy) HandleRawTCP(inboundConn net.Conn, targetDest string) {
+	defer inboundConn.Close()
+	inboundConn.Write([]byte(targetDest + "\n"))
+}
+func (s *echoTargetStrategy) HandleUDPPacket(packet *types.UDPPacket, _ string) error {
+	return packet.Reply(packet.Payload, nil)
+}
+func (s *echoTargetStrategy) GetTrafficStats() types.TrafficStats     { return types.TrafficStats{} }
+func (s *echoTargetStrategy) Initialize() error                       { return nil }
+func (s *echoTargetStrategy) InitializeForGateway() error             { return nil }
+func (s *echoTargetStrategy) GetType() string                         { return "echo" }
+func (s *echoTargetStrategy) CloseTunnel()                            {}
+func (s *echoTargetStrategy) GetListenerInfo() *types.ListenerInfo    { return nil }
+func (s *echoTargetStrategy) GetMetrics() *types.Metrics              { return &types.Metrics{} }
+func (s *echoTargetStrategy) UpdateServer(*types.ServerProfile) error { return nil }
+func (s *echoTargetStrategy) CheckHealth() error                      { return nil }
+
+// newTestGateway 组装真实的防火墙和调度器：发往 2001:db8::dead 和 198.51.100.222 的 TCP 连接被防火墙拒绝，
+// 2001:db8::/64 和 198.51.100.0/24 内的其他目标经 dest_ip 规则交给 echoTargetStrategy。
+func newTestGateway(t *testing.T, cfg *settings.Inbound) *TransparentGateway {
+	t.Helper()
+	fw := firewall.NewEngine()
+	if err := fw.OnSettingsUpdate("firewall", &settings.FirewallSettings{
+		Enabled: true,
+		Rules: []*settings.FirewallRule{
+			{Priority: 1, Protocol: "tcp", DestCIDR: []string{"2001:db8::dead/128", "198.51.100.222/32"}, Action: settings.ActionDeny},
+			{Priority: 2, DestCIDR: []string{"::/0", "0.0.0.0/0"}, Action: settings.ActionAllow},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	states := staticStateProvider{
+		"echo-server-id": {
+			Profile:  &types.ServerProfile{ID: "echo-server-id", Remarks: "echo", Active: true},
+			Instance: &echoTargetStrategy{},
+			Health:   types.StatusUp,
+		},
+	}
+	disp := dispatcher.New(&settings.GatewaySettings{}, states)
+	if err := disp.OnSettingsUpdate("routing", &settings.RoutingSettings{
+		Rules: []*settings.Rule{
+			{Priority: 1, Type: string(settings.RuleTypeDestIP), Value: []string{"2001:db8::/64", "198.51.100.0/24"}, Target: "echo"},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := NewTransparentInbound(cfg, fw, disp, web.NewHub())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(g.Close)
+	return g
+}
+
+// dialAndReadTarget 连接 addr 并读取策略写回的目标地址；连接被直接关闭时返回空字符串。
+func dialAndReadTarget(t *testing.T, addr string) string {
+	t.Helper()
+	target, err := readTarget(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return target
+}
+
+// readTarget 是 dialAndReadTarget 不依赖 t 的版本，可以在测试 goroutine 之外调用。
+func readTarget(addr string) (string, error) {
+	conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+	if err != nil {
+		return "", fmt.Errorf("dial %s failed: %w", addr, err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("read from %s failed: %w", addr, err)
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// expectUDPEcho 经 conn 发送 payload 并检查回显。conn 是已连接的 UDP socket，
+// 内核只把源地址等于其对端地址的回复交给它，收到回显即说明回复来自原始目标。
+func expectUDPEcho(conn net.Conn, payload string) error {
+	if _, err := conn.Write([]byte(payload)); err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reply := make([]byte, 1500)
+	n, err := conn.Read(reply)
+	if err != nil {
+		return fmt.Errorf("no reply to %q from %s: %w", payload, conn.RemoteAddr(), err)
+	}
+	if string(reply[:n]) != payload {
+		return fmt.Errorf("reply from %s = %q, want %q", conn.RemoteAddr(), reply[:n], payload)
+	}
+	return nil
+}
+
+func TestTransparentGatewayTProxyUDPReplySource(t *testing.T) {
+	// 把测试网段路由为本机地址 (AnyIP)，与 TPROXY 一样不改写目标地址
+	if !runInNetns(t,
+		[]string{"ip", "link", "set", "lo", "up"},
+		[]string{"ip", "route", "add", "local", "198.51.100.0/24", "dev", "lo"},
+		[]string{"ip", "-6", "route", "add", "local", "2001:db8::/64", "dev", "lo"},
+	) {
+		return
+	}
+
+	newTestGateway(t, &settings.Inbound{Tag: "tproxy-udp", Type: settings.InboundTProxy, Listen: "[::]:18443"})
+
+	for _, addr := range []string{"198.51.100.1:18443", "[2001:db8::1]:18443"} {
+		conn, err := net.Dial("udp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		// 第二个数据报会被内核交给已连接的回复 socket，网关仍需把它交给策略
+		for _, payload := range []string{"first", "second"} {
+			if err := expectUDPEcho(conn, payload); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+}
+
+// inClientNetns 在一个新的网络命名空间中执行 fn，该命名空间通过 veth 对 (veth0 - veth1) 与当前命名空间相连：
+// veth1 被移入新命名空间，地址为 10.0.0.2/24 和 fd00::2/64，默认路由指向 veth0 上的 10.0.0.1 和 fd00::1。
+// fn 运行在锁定的系统线程上，其中创建的 socket 都属于新命名空间；fn 不能调用 t.Fatal。
+func inClientNetns(t *testing.T, fn func()) {
+	t.Helper()
+	tid := make(chan int)
+	moved := make(chan error)
+	done := make(chan error)
+	go func() {
+		// 不解锁：goroutine 结束时线程随之退出，不会带着新命名空间回到调度器
+		runtime.LockOSThread()
+		if err := syscall.Unshare(syscall.CLONE_NEWNET); err != nil {
+			close(tid)
+			done <- err
+			return
+		}
+		tid <- syscall.Gettid()
+		if err := <-moved; err != nil {
+			done <- err
+			return
+		}
+		for _, args := range [][]string{
+			{"ip", "link", "set", "lo", "up"},
+			{"ip", "addr", "add", "10.0.0.2/24", "dev", "veth1"},
+			{"ip", "-6", "addr", "add", "fd00::2/64", "dev", "veth1", "nodad"},
+			{"ip", "link", "set", "veth1", "up"},
+			{"ip", "route", "add", "default", "via", "10.0.0.1"},
+			{"ip", "-6", "route", "add", "default", "via", "fd00::1"},
+		} {
+			if out, err := exec.Command(args[0], args[1:]...).CombinedOutput(); err != nil {
+				done <- fmt.Errorf("client netns setup %v failed: %v\n%s", args, err, out)
+				return
+			}
+		}
+		fn()
+		done <- nil
+	}()
+
+	if id, ok := <-tid; ok {
+		out, err := exec.Command("ip", "link", "set", "veth1", "netns", strconv.Itoa(id)).CombinedOutput()
+		if err != nil {
+			err = fmt.Errorf("moving veth1 failed: %v\n%s", err, out)
+		}
+		moved <- err
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransparentGatewayTProxyRules(t *testing.T) {
+	// 客户端位于另一个命名空间，经 veth 到达网关；nftables 的 TPROXY 规则把发往测试网段的流量
+	// 交给 18443 端口的监听器，打上标记的数据包经策略路由作为本机流量接收
+	if !runInNetns(t,
+		[]string{"ip", "link", "set", "lo", "up"},
+		[]string{"ip", "link", "add", "veth0", "type", "veth", "peer", "name", "veth1"},
+		[]string{"ip", "addr", "add", "10.0.0.1/24", "dev", "veth0"},
+		[]string{"ip", "-6", "addr", "add", "fd00::1/64", "dev", "veth0", "nodad"},
+		[]string{"ip", "link", "set", "veth0", "up"},
+		[]string{"ip", "rule", "add", "fwmark", "1", "lookup", "100"},
+		[]string{"ip", "route", "add", "local", "0.0.0.0/0", "dev", "lo", "table", "100"},
+		[]string{"ip", "-6", "rule", "add", "fwmark", "1", "lookup", "100"},
+		[]string{"ip", "-6", "route", "add", "local", "::/0", "dev", "lo", "table", "100"},
+		[]string{"nft", "add", "table", "inet", "liuproxy_test"},
+		[]string{"nft", "add", "chain", "inet", "liuproxy_test", "prerouting",
+			"{", "type", "filter", "hook", "prerouting", "priority", "mangle", ";", "}"},
+		[]string{"nft", "add", "rule", "inet", "liuproxy_test", "prerouting", "ip", "daddr", "198.51.100.0/24",
+			"meta", "l4proto", "{", "tcp,", "udp", "}", "tproxy", "ip", "to", ":18443", "meta", "mark", "set", "1", "accept"},
+		[]string{"nft", "add", "rule", "inet", "liuproxy_test", "prerouting", "ip6", "daddr", "2001:db8::/64",
+			"meta", "l4proto", "{", "tcp,", "udp", "}", "tproxy", "ip6", "to", ":18443", "meta", "mark", "set", "1", "accept"},
+	) {
+		return
+	}
+
+	newTestGateway(t, &settings.Inbound{Tag: "tproxy", Type: settings.InboundTProxy, Listen: "[::]:18443"})
+
+	inClientNetns(t, func() {
+		for _, tt := range []struct {
+			name           string
+			target, denied string
+		}{
+			{name: "ipv4", target: "198.51.100.7:80", denied: "198.51.100.222:80"},
+			{name: "ipv6", target: "[2001:db8::7]:80", denied: "[2001:db8::dead]:80"},
+		} {
+			if got, err := readTarget(tt.target); err != nil || got != tt.target {
+				t.Errorf("%s: strategy target = %q, %v, want %q", tt.name, got, err, tt.target)
+			}
+			if got, err := readTarget(tt.denied); err != nil || got != "" {
+				t.Errorf("%s: connection denied by firewall reached strategy with target %q, %v", tt.name, got, err)
+			}
+
+			host, _, _ := net.SplitHostPort(tt.target)
+			conn, err := net.Dial("udp", net.JoinHostPort(host, "53"))
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+				continue
+			}
+			for _, payload := range []string{"first", "second"} {
+				if err := expectUDPEcho(conn, payload); err != nil {
+					t.Errorf("%s: %v", tt.name, err)
+				}
+			}
+			conn.Close()
+		}
+	})
+}
+
+func TestTransparentGatewayRedirectIPv6(t *testing.T) {
+	// ip6tables REDIRECT 会把目标改写为本机地址，原始目标需要通过 IP6T_SO_ORIGINAL_DST 查询。
+	if !runInNetns(t,
+		[]string{"ip", "link", "set", "lo", "up"},
+		[]string{"ip", "-6", "addr", "add", "2001:db8::1/64", "dev", "lo"},
+		[]string{"ip6tables", "-t", "nat", "-A", "OUTPUT", "-p", "tcp", "-d", "2001:db8::100/120",
+			"-j", "REDIRECT", "--to-ports", "18080"},
+	) {
+		return
+	}
+
+	newTestGateway(t, &settings.Inbound{Tag: "redirect-v6", Type: settings.InboundRedirect, Listen: "[::]:18080"})
+
+	if got, want := dialAndReadTarget(t, "[2001:db8::123]:80"), "[2001:db8::123]:80"; got != want {
+		t.Errorf("strategy target = %q, want %q", got, want)
+	}
+}
+
+func TestGatewayTLSRedirectOriginalDst(t *testing.T) {
+	// 混合端口收到经 REDIRECT 的裸 TLS：没有 SNI 时回退到原始 IP 目标，有 SNI 时保留原始端口
+	if !runInNetns(t,
+		[]string{"ip", "link", "set", "lo", "up"},
+		[]string{"ip", "-6", "addr", "add", "2001:db8::1/64", "dev", "lo"},
+		[]string{"ip6tables", "-t", "nat", "-A", "OUTPUT", "-p", "tcp", "-d", "2001:db8::100/120",
+			"-j", "REDIRECT", "--to-ports", "18081"},
+	) {
+		return
+	}
+
+	strategy := &socksTestStrategy{origin: "127.0.0.1:1"}
+	route := testRoute{strategy: strategy, serverID: "tls-test-id"}
+	disp := &hostDispatcher{routes: map[string]testRoute{"2001:db8::123": route, "sni.test": route}}
+	g := New(0, disp, web.NewHub())
+	g.listenAddr = "[::]:18081"
+	if _, err := g.InitializeListener(); err != nil {
+		t.Fatal(err)
+	}
+	go g.Serve()
+	t.Cleanup(g.Close)
+
+	for i, tt := range []struct {
+		serverName string
+		want       string
+	}{
+		{want: "[2001:db8::123]:443"},
+		{serverName: "sni.test", want: "sni.test:8443"},
+	} {
+		_, port, _ := net.SplitHostPort(tt.want)
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort("2001:db8::123", port), 2*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		hello := captureClientHello(t, &tls.Config{ServerName: tt.serverName, InsecureSkipVerify: true})
+		if _, err := conn.Write(hello); err != nil {
+			t.Fatal(err)
+		}
+		deadline := time.Now().Add(2 * time.Second)
+		for len(strategy.requestedTargets()) <= i && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if targets := strategy.requestedTargets(); len(targets) <= i || targets[i] != tt.want {
+			t.Errorf("strategy targets = %v, want %s at index %d", targets, tt.want, i)
+		}
+	}
+}
+
+// udpRecordStrategy 记录策略收到的 UDP 数据报。
+type udpRecordStrategy struct {
+	echoTargetStrategy
+	packets chan *types.UDPPacket
+}
+
+func (s *udpRecordStrategy) HandleUDPPacket(packet *types.UDPPacket, _ string) error {
+	s.packets <- packet
+	return nil
+}
+
+func TestTransparentGatewayTProxyUDPSniffQUIC(t *testing.T) {
+	if !runInNetns(t,
+		[]string{"ip", "link", "set", "lo", "up"},
+		[]string{"ip", "-6", "route", "add", "local", "2001:db8::/64", "dev", "lo"},
+	) {
+		return
+	}
+
+	// 只有嗅探到 www.example.org 的流量交给 recorder，按 IP 目标路由的数据报被拒绝
+	recorder := &udpRecordStrategy{packets: make(chan *types.UDPPacket, 8)}
+	states := staticStateProvider{
+		"udp-recorder-id": {
+			Profile:  &types.ServerProfile{ID: "udp-recorder-id", Remarks: "recorder", Active: true},
+			Instance: recorder,
+			Health:   types.StatusUp,
+		},
+	}
+	disp := dispatcher.New(&settings.GatewaySettings{}, states)
+	if err := disp.OnSettingsUpdate("routing", &settings.RoutingSettings{
+		Rules: []*settings.Rule{
+			{Priority: 1, Type: string(settings.RuleTypeDomain), Value: []string{"www.example.org"}, Target: "recorder"},
+			{Priority: 2, Type: string(settings.RuleTypeDestIP), Value: []string{"2001:db8::/64"}, Target: "REJECT"},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	g, err := NewTransparentInbound(&settings.Inbound{Tag: "tproxy-udp", Type: settings.InboundTProxy, Listen: "[::]:18443"},
+		firewall.NewEngine(), disp, web.NewHub())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(g.Close)
+
+	conn, err := net.Dial("udp", "[2001:db8::1]:18443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// ClientHello 跨越两个 Initial 数据报，两个数据报都应按嗅探到的域名路由
+	fixtures := []string{"quic_v1_split_initial_1.hex", "quic_v1_split_initial_2.hex"}
+	for _, f := range fixtures {
+		if _, err := conn.Write(loadHexFixture(t, f)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for range fixtures {
+		select {
+		case packet := <-recorder.packets:
+			if got, want := packet.Destination.String(), "[2001:db8::1]:18443"; got != want {
+				t.Errorf("packet destination = %s, want %s", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("datagram was not routed by the sniffed QUIC server name")
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package gateway
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"liuproxy_nexus/internal/shared/logger"
+	"liuproxy_nexus/internal/sys/tproxy"
+)
+
+// udpReplyIdleTimeout 回复 socket 的空闲超时，与策略的 UDP 会话超时一致。
+const udpReplyIdleTimeout = 60 * time.Second
+
+// udpReplySocket 以一个远端地址的名义回复一个客户端。
+type udpReplySocket struct {
+	conn     *net.UDPConn
+	lastUsed atomic.Int64
+}
+
+// udpReplyTable 管理 TPROXY 模式下回复客户端的透明 UDP socket，按 (客户端, 回复来源) 复用。
+// 回复必须以客户端原本访问的地址为源地址发出，客户端 (尤其是 connect 过的 UDP socket) 才会接受。
+type udpReplyTable struct {
+	mu      sync.Mutex
+	sockets map[udpFlowKey]*udpReplySocket
+	closed  bool
+	wg      sync.WaitGroup
+
+	// receive 处理客户端随后发往同一地址的数据报：内核优先把它们交给已连接的回复 socket，而不是 TPROXY 监听器
+	receive func(payload []byte, clientAddr net.Addr, originalDst *net.UDPAddr)
+}
+
+func newUDPReplyTable(receive func(payload []byte, clientAddr net.Addr, originalDst *net.UDPAddr)) *udpReplyTable {
+	return &udpReplyTable{sockets: make(map[udpFlowKey]*udpReplySocket), receive: receive}
+}
+
+// write 以 from 为源地址把 payload 发给 client。
+func (t *udpReplyTable) write(payload []byte, from, client *net.UDPAddr) error {
+	socket, err := t.get(from, client)
+	if err != nil {
+		return err
+	}
+	socket.lastUsed.Store(time.Now().UnixNano())
+	_, err = socket.conn.Write(payload)
+	return err
+}
+
+func (t *udpReplyTable) get(from, client *net.UDPAddr) (*udpReplySocket, error) {
+	key := udpFlowKey{client: client.String(), dest: from.String()}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil, net.ErrClosed
+	}
+	if socket, ok := t.sockets[key]; ok {
+		return socket, nil
+	}
+	conn, err := tproxy.DialUDP(from, client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open reply socket from %s: %w", from, err)
+	}
+	socket := &udpReplySocket{conn: conn}
+	socket.lastUsed.Store(time.Now().UnixNano())
+	t.sockets[key] = socket
+	t.wg.Add(1)
+	go t.readLoop(key, socket, client, from)
+	return socket, nil
+}
+
+// readLoop 把客户端发到回复 socket 上的数据报交给 receive，空闲超时后关闭 socket。
+func (t *udpReplyTable) readLoop(key udpFlowKey, socket *udpReplySocket, client, from *net.UDPAddr) {
+	defer t.wg.Done()
+	defer func() {
+		t.mu.Lock()
+		if t.sockets[key] == socket {
+			delete(t.sockets, key)
+		}
+		t.mu.Unlock()
+		socket.conn.Close()
+	}()
+
+	buf := make([]byte, 65535)
+	for {
+		socket.conn.SetReadDeadline(time.Unix(0, socket.lastUsed.Load()).Add(udpReplyIdleTimeout))
+		n, err := socket.conn.Read(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() &&
+				time.Since(time.Unix(0, socket.lastUsed.Load())) < udpReplyIdleTimeout {
+				continue // 期间有新的回复，延长期限
+			}
+			return
+		}
+		socket.lastUsed.Store(time.Now().UnixNano())
+		payload := make([]byte, n)
+		copy(payload, buf[:n])
+		go t.receive(payload, client, from)
+	}
+}
+
+func (t *udpReplyTable) close() {
+	t.mu.Lock()
+	t.closed = true
+	for _, socket := range t.sockets {
+		socket.conn.Close()
+	}
+	t.mu.Unlock()
+	t.wg.Wait()
+}
+
+// udpAddrOf 把策略给出的回复来源转换为 IP 地址，无法转换 (例如域名) 时返回 nil。
+func udpAddrOf(addr net.Addr) *net.UDPAddr {
+	if addr == nil {
+		return nil
+	}
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		return udpAddr
+	}
+	addrPort, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		logger.Debug().Str("from", addr.String()).Msg("TPROXY-UDP: Reply source is not an IP address, using the original destination.")
+		return nil
+	}
+	return net.UDPAddrFromAddrPort(netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port()))
+}
@@ -0,0 +1,114 @@
+package gateway
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"liuproxy_nexus/internal/shared/types"
+)
+
+const (
+	// udpFlowIdleTimeout 之后没有新数据报的流被清除，之后的数据报重新嗅探。
+	udpFlowIdleTimeout = 2 * time.Minute
+	// maxPendingQUICDatagrams 是等待 ClientHello 重组时一个流最多暂存的数据报数。
+	maxPendingQUICDatagrams = 8
+)
+
+// udpFlowKey 标识一个 UDP 流：客户端地址 + 原始目标地址。
+type udpFlowKey struct {
+	client string
+	dest   string
+}
+
+// udpFlow 是一个 UDP 流的嗅探状态。QUIC ClientHello 跨越多个 Initial 数据报时，
+// 前面的数据报先暂存，重组完成 (或超时) 后与之后的数据报一起按同一个路由目标分流。
+type udpFlow struct {
+	mu       sync.Mutex
+	sniffer  *quicSniffer
+	pending  [][]byte
+	timer    *time.Timer
+	done     bool // 嗅探已结束，之后的数据报直接使用 domain
+	domain   string
+	info     *types.SniffInfo
+	lastSeen time.Time
+}
+
+// feed 把数据报交给流的嗅探器，返回现在可以分流的数据报以及路由使用的域名 (没有嗅探到时为空)。
+// ClientHello 尚不完整时数据报被暂存并返回 nil；wait 之后仍不完整则把暂存的数据报交给 expire，按 IP 目标分流。
+func (f *udpFlow) feed(payload []byte, wait time.Duration, expire func(datagrams [][]byte)) ([][]byte, string, *types.SniffInfo) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lastSeen = time.Now()
+	if f.done {
+		return [][]byte{payload}, f.domain, f.info
+	}
+
+	hello, err := f.sniffer.Feed(payload)
+	if errors.Is(err, errQUICNeedMore) && len(f.pending) < maxPendingQUICDatagrams {
+		f.pending = append(f.pending, payload)
+		if f.timer == nil {
+			f.timer = time.AfterFunc(wait, func() {
+				f.mu.Lock()
+				if f.done {
+					f.mu.Unlock()
+					return
+				}
+				pending := f.finishLocked()
+				f.mu.Unlock()
+				expire(pending)
+			})
+		}
+		return nil, "", nil
+	}
+	if err == nil && hello.ServerName != "" {
+		f.domain, f.info = hello.ServerName, hello.sniffInfo()
+	}
+	return append(f.finishLocked(), payload), f.domain, f.info
+}
+
+func (f *udpFlow) finishLocked() [][]byte {
+	f.done = true
+	f.sniffer = nil
+	if f.timer != nil {
+		f.timer.Stop()
+	}
+	pending := f.pending
+	f.pending = nil
+	return pending
+}
+
+// udpFlowTable 保存透明网关上所有 UDP 流的嗅探状态。
+type udpFlowTable struct {
+	mu        sync.Mutex
+	flows     map[udpFlowKey]*udpFlow
+	lastSweep time.Time
+}
+
+func newUDPFlowTable() *udpFlowTable {
+	return &udpFlowTable{flows: make(map[udpFlowKey]*udpFlow), lastSweep: time.Now()}
+}
+
+// get 返回 key 对应的流，不存在时创建。顺便清除空闲超过 udpFlowIdleTimeout 的流。
+func (t *udpFlowTable) get(key udpFlowKey) *udpFlow {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if now.Sub(t.lastSweep) > udpFlowIdleTimeout/2 {
+		t.lastSweep = now
+		for k, f := range t.flows {
+			f.mu.Lock()
+			idle := now.Sub(f.lastSeen) > udpFlowIdleTimeout
+			f.mu.Unlock()
+			if idle {
+				delete(t.flows, k)
+			}
+		}
+	}
+	f, ok := t.flows[key]
+	if !ok {
+		f = &udpFlow{sniffer: newQUICSniffer(), lastSeen: now}
+		t.flows[key] = f
+	}
+	return f
+}
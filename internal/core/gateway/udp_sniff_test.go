@@ -0,0 +1,51 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUDPFlowReassemblesQUICClientHello(t *testing.T) {
+	first := loadHexFixture(t, "quic_v1_split_initial_1.hex")
+	second := loadHexFixture(t, "quic_v1_split_initial_2.hex")
+	expire := func([][]byte) { t.Error("flow expired before the ClientHello was complete") }
+
+	flow := newUDPFlowTable().get(udpFlowKey{client: "192.0.2.1:50000", dest: "198.51.100.1:443"})
+	if datagrams, _, _ := flow.feed(first, time.Minute, expire); datagrams != nil {
+		t.Fatalf("partial ClientHello was released: %d datagrams", len(datagrams))
+	}
+	datagrams, domain, info := flow.feed(second, time.Minute, expire)
+	if len(datagrams) != 2 || domain != "www.example.org" || info == nil {
+		t.Fatalf("feed() = %d datagrams, %q, %v; want 2, www.example.org", len(datagrams), domain, info)
+	}
+	// 嗅探结束后的数据报直接使用同一个域名
+	if datagrams, domain, _ := flow.feed([]byte{0x40, 0x01}, time.Minute, expire); len(datagrams) != 1 || domain != "www.example.org" {
+		t.Errorf("feed() after sniffing = %d datagrams, %q", len(datagrams), domain)
+	}
+}
+
+func TestUDPFlowExpiresIncompleteClientHello(t *testing.T) {
+	expired := make(chan [][]byte, 1)
+	flow := newUDPFlowTable().get(udpFlowKey{client: "192.0.2.1:50000", dest: "198.51.100.1:443"})
+	flow.feed(loadHexFixture(t, "quic_v1_split_initial_1.hex"), 10*time.Millisecond, func(d [][]byte) { expired <- d })
+
+	select {
+	case datagrams := <-expired:
+		if len(datagrams) != 1 {
+			t.Fatalf("expired %d datagrams, want 1", len(datagrams))
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("pending datagrams were never released")
+	}
+	if datagrams, domain, _ := flow.feed([]byte{0x40, 0x01}, time.Minute, nil); len(datagrams) != 1 || domain != "" {
+		t.Errorf("feed() after expiry = %d datagrams, %q; want 1 datagram routed by IP", len(datagrams), domain)
+	}
+}
+
+func TestUDPFlowNonQUIC(t *testing.T) {
+	flow := newUDPFlowTable().get(udpFlowKey{client: "192.0.2.1:50000", dest: "198.51.100.1:53"})
+	datagrams, domain, _ := flow.feed([]byte{0x12, 0x34, 0x01, 0x00, 0x00, 0x01}, time.Minute, nil)
+	if len(datagrams) != 1 || domain != "" {
+		t.Errorf("feed() = %d datagrams, %q; want the DNS datagram released immediately", len(datagrams), domain)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"io"
 	"liuproxy_nexus/internal/shared/logger"
+	"liuproxy_nexus/internal/shared/outbound"
 	"net"
 	"sync"
 	"time"
@@ -37,7 +38,7 @@ func (s *DirectStrategy) Handle(inboundConn net.Conn, initialReader *bufio.Reade
 		Msg("Gateway: [DIRECT] Handling direct connection.")
 
 	// 1. 连接到原始目标地址
-	outboundConn, err := net.DialTimeout(target.Network(), targetAddr, 10*time.Second)
+	outboundConn, err := outbound.DialTimeout(target.Network(), targetAddr, 10*time.Second)
 	if err != nil {
 		logger.Error().
 			Err(err).
@@ -53,8 +54,12 @@ func (s *DirectStrategy) Handle(inboundConn net.Conn, initialReader *bufio.Reade
 
 	go func() {
 		defer wg.Done()
-		// 如果 initialReader 为 nil (例如在透明代理模式下)，则直接使用 inboundConn。
+		// 如果 initialReader 为 nil (例如在透明代理模式下)，则直接使用 inboundConn；
+		// 否则必须从 initialReader 读取，以免丢失已预读的数据 (如 TLS ClientHello)。
 		var reader io.Reader = inboundConn
+		if initialReader != nil {
+			reader = initialReader
+		}
 
 		// 从正确的 reader 拷贝到目标连接
 		io.Copy(outboundConn, reader)
@@ -6,6 +6,7 @@ import (
 	"io"
 	"liuproxy_nexus/internal/shared/globalstate"
 	"liuproxy_nexus/internal/shared/logger"
+	"liuproxy_nexus/internal/sys/netfilter"
 	"liuproxy_nexus/proxypool/model"
 	"net/http"
 	"os"
@@ -39,6 +40,7 @@ type ServerController interface {
 	GetAllProxiesFromPool() []*model.ProxyInfo
 	TriggerProxyValidation(ids []string) error
 	DeleteProxiesFromPool(ids []string) error
+	GetNetfilterStatus() *netfilter.Status
 }
 
 // --- 新的系统环境 API ---
@@ -91,6 +93,16 @@ func (h *Handler) HandleGetSystemEnv(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(settings)
 }
 
+// HandleGetNetfilterStatus 处理 GET /api/system/netfilter 请求，返回进程安装的透明代理规则及其实时内容
+func (h *Handler) HandleGetNetfilterStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.controller.GetNetfilterStatus())
+}
+
 type Handler struct {
 	serversPath     string
 	settingsManager *settings.SettingsManager // 新增
@@ -228,10 +240,11 @@ func (h *Handler) HandleApplyChanges(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) HandleStatus(w http.ResponseWriter, r *http.Request) {
 	// 1. 扩展匿名 StatusResponse 结构体，以包含流量统计
 	type MetricsWithTraffic struct {
-		ActiveConnections int64  `json:"activeConnections"`
-		Latency           int64  `json:"latency"`
-		Uplink            uint64 `json:"uplink"`
-		Downlink          uint64 `json:"downlink"`
+		ActiveConnections int64              `json:"activeConnections"`
+		Latency           int64              `json:"latency"`
+		Uplink            uint64             `json:"uplink"`
+		Downlink          uint64             `json:"downlink"`
+		EdgeIPs           []types.EdgeIPStat `json:"edgeIPs,omitempty"`
 	}
 	type StatusResponse struct {
 		GlobalStatus string                         `json:"globalStatus"`
@@ -270,6 +283,7 @@ func (h *Handler) HandleStatus(w http.ResponseWriter, r *http.Request) {
 			Latency:           state.Metrics.Latency,
 			Uplink:            traffic.Uplink,
 			Downlink:          traffic.Downlink,
+			EdgeIPs:           state.Metrics.EdgeIPs,
 		}
 	}
 
@@ -416,7 +430,22 @@ func (h *Handler) updateServer(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Missing server ID", http.StatusBadRequest)
 		return
 	}
+	stored := h.findServerProfile(id)
+	if stored == nil {
+		http.Error(w, "Server not found", http.StatusNotFound)
+		return
+	}
+	// 以已保存的配置为基础解码请求，表单中没有的字段 (例如 via、localPort 或其他类型的参数) 保持不变
 	var updatedProfile types.ServerProfile
+	base, err := json.Marshal(stored)
+	if err != nil {
+		http.Error(w, "Failed to read server: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := json.Unmarshal(base, &updatedProfile); err != nil {
+		http.Error(w, "Failed to read server: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 	if err := json.NewDecoder(r.Body).Decode(&updatedProfile); err != nil {
 		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
 		return
@@ -431,6 +460,16 @@ func (h *Handler) updateServer(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// findServerProfile 返回 A-Zone 中 ID 为 id 的服务器配置，不存在时返回 nil。
+func (h *Handler) findServerProfile(id string) *types.ServerProfile {
+	for _, profile := range h.controller.GetAllServerProfilesSorted() {
+		if profile.ID == id {
+			return profile
+		}
+	}
+	return nil
+}
+
 func (h *Handler) deleteServer(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Query().Get("id")
 	if id == "" {
@@ -0,0 +1,75 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"liuproxy_nexus/internal/shared/types"
+)
+
+// profileController 只实现服务器配置的读取和更新。
+type profileController struct {
+	ServerController
+	profiles map[string]*types.ServerProfile
+}
+
+func (c *profileController) GetAllServerProfilesSorted() []*types.ServerProfile {
+	var profiles []*types.ServerProfile
+	for _, p := range c.profiles {
+		profiles = append(profiles, p)
+	}
+	return profiles
+}
+
+func (c *profileController) UpdateServerProfile(id string, updated *types.ServerProfile) error {
+	c.profiles[id] = updated
+	return nil
+}
+
+func TestUpdateServerKeepsOmittedFields(t *testing.T) {
+	stored := &types.ServerProfile{
+		ID:           "wg-id",
+		Remarks:      "wg",
+		Type:         "wireguard",
+		Address:      "198.51.100.1",
+		Port:         51820,
+		PrivateKey:   "private",
+		PublicKey:    "peer",
+		LocalAddress: []string{"10.0.0.2/32"},
+		AllowedIPs:   []string{"10.0.0.0/8"},
+		LocalPort:    1080,
+	}
+	controller := &profileController{profiles: map[string]*types.ServerProfile{stored.ID: stored}}
+	h := NewHandler(&types.Config{}, "", nil, controller)
+
+	// 请求只包含表单中的字段：修改地址、清空 AllowedIPs
+	body := `{"remarks":"wg","type":"wireguard","address":"198.51.100.2","port":51820,"allowedIPs":[]}`
+	rec := httptest.NewRecorder()
+	h.HandleServers(rec, httptest.NewRequest(http.MethodPut, "/api/servers?id=wg-id", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	got := controller.profiles["wg-id"]
+	if got.Address != "198.51.100.2" {
+		t.Errorf("Address = %q, want the updated value", got.Address)
+	}
+	if got.PrivateKey != "private" || got.PublicKey != "peer" || got.LocalPort != 1080 || !slices.Equal(got.LocalAddress, []string{"10.0.0.2/32"}) {
+		t.Errorf("omitted fields were not preserved: %+v", got)
+	}
+	if len(got.AllowedIPs) != 0 {
+		t.Errorf("AllowedIPs = %v, want it cleared", got.AllowedIPs)
+	}
+	if len(stored.AllowedIPs) != 1 {
+		t.Errorf("stored profile was modified: AllowedIPs = %v", stored.AllowedIPs)
+	}
+
+	rec = httptest.NewRecorder()
+	h.HandleServers(rec, httptest.NewRequest(http.MethodPut, "/api/servers?id=missing", strings.NewReader(body)))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status for an unknown server = %d, want 404", rec.Code)
+	}
+}
@@ -89,6 +89,7 @@ func StartServer(
 	mux.Handle("/api/proxypool/validate", basicAuthMiddleware(http.HandlerFunc(handler.HandleValidateProxies), webUser, webPassword))
 	mux.Handle("/api/proxypool/delete", basicAuthMiddleware(http.HandlerFunc(handler.HandleDeleteProxies), webUser, webPassword))
 	mux.Handle("/api/system/env", basicAuthMiddleware(http.HandlerFunc(handler.HandleGetSystemEnv), webUser, webPassword))
+	mux.Handle("/api/system/netfilter", basicAuthMiddleware(http.HandlerFunc(handler.HandleGetNetfilterStatus), webUser, webPassword))
 	mux.Handle("/api/recent_targets", basicAuthMiddleware(http.HandlerFunc(handler.HandleGetRecentTargets), webUser, webPassword))
 
 	// --- WebSocket Endpoint (公开，无需认证) ---
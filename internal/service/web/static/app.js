@@ -196,6 +196,8 @@ function showPage(pageName) {
     serverTypeSelect.addEventListener('change', updateFormVisibility);
     networkSelect.addEventListener('change', updateFormVisibility);
     vlessSecuritySelect.addEventListener('change', updateFormVisibility);
+    document.getElementById('scheme').addEventListener('change', updateFormVisibility);
+    document.getElementById('transport').addEventListener('change', updateFormVisibility);
 
     // Auto-fill SNI from Host
     hostInput.addEventListener('blur', () => {
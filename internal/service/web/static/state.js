@@ -73,6 +73,7 @@ export function mergeRuntimeData(healthData) {
             server.latency = serverMetrics.latency;
             server.uplink = serverMetrics.uplink;
             server.downlink = serverMetrics.downlink;
+            server.edgeIPs = serverMetrics.edgeIPs || [];
             server.updateTime = Date.now();
         } else {
             // Reset metrics if server is not in the new data
@@ -80,6 +81,7 @@ export function mergeRuntimeData(healthData) {
             server.latency = -1;
             server.uplink = undefined;
             server.downlink = undefined;
+            server.edgeIPs = [];
         }
 
         const serverRuntimeInfo = runtimeInfo[server.id];
@@ -11,6 +11,7 @@ const serverTypeSelect = document.getElementById('type');
 const networkSelect = document.getElementById('network');
 const vlessSecuritySelect = document.getElementById('security');
 const transportSelect = document.getElementById('transport');
+const schemeSelect = document.getElementById('scheme');
 const multiplexCheckbox = document.getElementById('multiplex');
 const logContent = document.getElementById('log-content');
 const applyBanner = document.getElementById('apply-changes-banner');
@@ -110,6 +111,15 @@ export function renderServers(filterText = '', sortBy = 'remarks', sortDir = 'as
         }
         const remarksHTML = `${escapeHTML(server.remarks)} <span class="type-icon">${typeIcon}</span>`;
 
+        // --- Column 3: Address, with the preferred edge IPs of a worker ---
+        let addressHTML = `${escapeHTML(server.address)}:${server.port}`;
+        let addressTitle = addressHTML;
+        if (server.active && server.edgeIPs && server.edgeIPs.length > 0) {
+            const formatEdge = e => `${e.ip} (${e.latency >= 0 ? e.latency + 'ms' : 'FAIL'})`;
+            addressHTML += `<span class="edge-ip">⚡ ${escapeHTML(formatEdge(server.edgeIPs[0]))}</span>`;
+            addressTitle += '\n' + server.edgeIPs.map(e => escapeHTML(formatEdge(e))).join('\n');
+        }
+
         // --- Column 4: Latency ---
         let latencyHTML = '-';
         if (server.active) {
@@ -160,7 +170,7 @@ export function renderServers(filterText = '', sortBy = 'remarks', sortDir = 'as
         row.innerHTML = `
             <td>${statusIndicator}</td>
             <td title="${escapeHTML(server.remarks)}">${remarksHTML}</td>
-            <td title="${escapeHTML(server.address)}:${server.port}">${escapeHTML(server.address)}:${server.port}</td>
+            <td title="${addressTitle}">${addressHTML}</td>
             <td>${latencyHTML}</td>
             <td>${activityHTML}</td>
             <td>${exitIpHTML}</td>
@@ -212,6 +222,8 @@ export function showDialog(server = null) {
             if (input) {
                 if (input.type === 'checkbox') {
                     input.checked = !!server[key];
+                } else if (Array.isArray(server[key])) {
+                    input.value = server[key].join(', ');
                 } else {
                     input.value = server[key] || '';
                 }
@@ -220,6 +232,16 @@ export function showDialog(server = null) {
         if (server.type === 'goremote' && !form.elements.transport.value) {
             form.elements.transport.value = 'tcp';
         }
+        if (server.type === 'trojan' && !server.network) {
+            form.elements.network.value = 'tcp';
+        }
+        if (server.network === 'splithttp') {
+            form.elements.network.value = 'xhttp'; // 旧名称，两者等价
+        }
+        if (server.type === 'wireguard') {
+            form.elements.wgPublicKey.value = server.publicKey || '';
+            form.elements.publicKey.value = '';
+        }
     } else {
         serverTypeSelect.value = 'goremote';
         transportSelect.value = 'tcp';
@@ -273,33 +295,80 @@ export function updateFormVisibility() {
     const isWorker = type === 'worker';
     const isVless = type === 'vless';
     const isHttp = type === 'http';
+    const isShadowsocks = type === 'shadowsocks';
+    const isTrojan = type === 'trojan';
+    const isWireGuard = type === 'wireguard';
+
+    // 只有 vless 支持的选项 (grpc、h2、reality 等) 在其他类型下禁用，reality 还只能用于 tcp
+    [networkSelect, vlessSecuritySelect].forEach(select => {
+        Array.from(select.options).forEach(option => {
+            const { types, networks } = option.dataset;
+            option.disabled = (!!types && !types.split(' ').includes(type)) ||
+                (!!networks && !networks.split(' ').includes(networkSelect.value));
+        });
+        if (select.selectedOptions[0]?.disabled) {
+            select.value = select === networkSelect ? 'tcp' : 'tls';
+        }
+    });
 
     document.getElementById('goremote-fields').style.display = isGoRemote ? '' : 'none';
+    document.getElementById('mux-fields').style.display = isGoRemote || isWorker ? '' : 'none';
     document.getElementById('worker-fields').style.display = isWorker ? '' : 'none';
+    document.getElementById('crypt-fields').style.display = isGoRemote || isWorker ? '' : 'none';
     document.getElementById('vless-fields').style.display = isVless ? '' : 'none';
     document.getElementById('http-fields').style.display = isHttp ? '' : 'none';
+    document.getElementById('shadowsocks-fields').style.display = isShadowsocks ? '' : 'none';
+    document.getElementById('wireguard-fields').style.display = isWireGuard ? '' : 'none';
+    document.getElementById('network-fields').style.display = isVless || isTrojan ? '' : 'none';
+    document.getElementById('password-fields').style.display = isHttp || isShadowsocks || isTrojan ? '' : 'none';
+    document.getElementById('password-label').textContent = isHttp ? 'Password (Optional)' : 'Password';
+    document.getElementById('password-hint').textContent = isShadowsocks ? 'For 2022 methods: base64 key; separate multiple keys with ":" for relays.' : '';
+    // WireGuard 运行在 UDP 上，不能经由 via 服务器
+    document.getElementById('via-row').style.display = isWireGuard ? 'none' : '';
 
     // WebSocket fields visibility
     const goremoteTransport = transportSelect.value;
     const vlessNetwork = networkSelect.value;
-    document.getElementById('common-ws-fields').style.display = (isGoRemote && goremoteTransport === 'ws') || isWorker || (isVless && vlessNetwork === 'ws') ? '' : 'none';
+    const overWs = (isVless || isTrojan) && vlessNetwork === 'ws';
+    const overHttp = isVless && ['h2', 'httpupgrade', 'xhttp'].includes(vlessNetwork);
+    document.getElementById('common-ws-fields').style.display = (isGoRemote && goremoteTransport === 'ws') || isWorker || overWs || overHttp ? '' : 'none';
+    document.getElementById('ws-fields-title').textContent = overHttp ? 'HTTP Settings' : 'WebSocket Settings';
+    document.getElementById('scheme-wrapper').style.display = overHttp ? 'none' : '';
+    document.getElementById('earlyDataDelay-wrapper').style.display = overHttp ? 'none' : '';
+
+    // TLS fields: vless 的 tls / reality，trojan 的 tls，以及 wss 的 worker / goremote
+    const isWss = schemeSelect.value === 'wss' && (isWorker || (isGoRemote && goremoteTransport === 'ws'));
+    const vlessSecurity = vlessSecuritySelect.value;
+    const vlessTls = isVless && (vlessSecurity === 'tls' || vlessSecurity === 'reality');
+    const trojanTls = isTrojan && vlessSecurity !== 'none';
+    document.getElementById('tls-fields').style.display = isWss || vlessTls || trojanTls ? '' : 'none';
+    // ECH / ALPN 只作用于共用的 uTLS 客户端；vless 和 trojan 的 ws / grpc 由 xray 处理 TLS，不支持它们
+    const customTls = isWss || (isVless && vlessSecurity === 'tls' && vlessNetwork !== 'ws' && vlessNetwork !== 'grpc') || (trojanTls && vlessNetwork === 'tcp');
+    document.getElementById('alpn-wrapper').style.display = customTls ? '' : 'none';
+    document.getElementById('echConfig-wrapper').style.display = customTls ? '' : 'none';
+    document.getElementById('allowInsecure-wrapper').style.display = trojanTls || (isVless && vlessSecurity === 'tls' && vlessNetwork === 'tcp') ? '' : 'none';
 
     // Multiplexing logic for GoRemote
     if (isGoRemote) {
         const isWebSocket = goremoteTransport === 'ws';
         multiplexCheckbox.checked = isWebSocket || multiplexCheckbox.checked;
         multiplexCheckbox.disabled = isWebSocket;
+        document.getElementById('multiplex-hint').textContent = 'Enabled automatically for WebSocket.';
+    } else if (isWorker) {
+        multiplexCheckbox.disabled = false;
+        document.getElementById('multiplex-hint').textContent = 'Requires a worker script that supports multiple streams.';
     }
 
     // VLESS specific fields
     if (isVless) {
         const securityType = vlessSecuritySelect.value;
-        const isTls = securityType === 'tls';
         const isReality = securityType === 'reality';
 
         document.getElementById('vless-grpc-fields').style.display = (vlessNetwork === 'grpc') ? '' : 'none';
-        document.getElementById('sni-wrapper').style.display = (isTls || isReality) ? '' : 'none';
-        document.getElementById('fingerprint-wrapper').style.display = (isTls || isReality) ? '' : 'none';
+        // Vision 流控只用于 raw TCP 上的 tls / reality
+        const flowAllowed = vlessNetwork === 'tcp' && (securityType === 'tls' || isReality);
+        document.getElementById('flow-wrapper').style.display = flowAllowed ? '' : 'none';
+        if (!flowAllowed) form.elements.flow.value = '';
         document.getElementById('publicKey-wrapper').style.display = isReality ? '' : 'none';
         document.getElementById('shortId-wrapper').style.display = isReality ? '' : 'none';
     }
@@ -309,6 +378,8 @@ export function getFormServerData() {
     const formData = new FormData(form);
     const serverData = Object.fromEntries(formData.entries());
     serverData.multiplex = form.elements.multiplex.checked;
+    serverData.legacyCrypt = form.elements.legacyCrypt.checked;
+    serverData.allowInsecure = form.elements.allowInsecure.checked;
 
     if (serverData.type === 'goremote' && serverData.transport === 'ws') {
         serverData.multiplex = true;
@@ -318,8 +389,22 @@ export function getFormServerData() {
         delete serverData.host;
         delete serverData.scheme;
     }
+    if (serverData.type === 'vless' && ['h2', 'httpupgrade', 'xhttp'].includes(serverData.network)) {
+        delete serverData.scheme;
+    }
 
     serverData.port = parseInt(serverData.port, 10) || 0;
+    serverData.muxSessions = parseInt(serverData.muxSessions, 10) || 0;
+    serverData.earlyDataDelay = parseInt(serverData.earlyDataDelay, 10) || 0;
+    serverData.mtu = parseInt(serverData.mtu, 10) || 0;
+    // 列表字段总是发送 (可能为空)，服务端以已保存的配置为基础合并，省略的字段不会被清空
+    ['alpn', 'localAddress', 'allowedIPs', 'dns'].forEach(key => {
+        serverData[key] = (serverData[key] || '').split(',').map(p => p.trim()).filter(Boolean);
+    });
+    if (serverData.type === 'wireguard') {
+        serverData.publicKey = serverData.wgPublicKey;
+    }
+    delete serverData.wgPublicKey;
     delete serverData.active;
     return serverData;
 }
@@ -0,0 +1,279 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// WebSocket 早期数据 (0-RTT): 客户端把第一次写入的数据以 base64 (RawURLEncoding) 放在升级请求的
+// Sec-WebSocket-Protocol 头中，服务端升级时取出并当作连接上最先收到的数据，省去等待升级响应的一个往返。
+// 路径中的 ed=N 参数 (如 "/?ed=2560") 表示最多放入 N 字节，与 Xray / V2Ray 的约定相同。
+const EarlyDataHeader = "Sec-WebSocket-Protocol"
+
+// ParseEarlyData 读取 WebSocket URL 或路径中的 ed 参数，返回去掉该参数后的 URL 和早期数据的最大长度。
+// 没有 ed 参数或参数无效时原样返回，长度为 0。
+func ParseEarlyData(rawURL string) (string, int) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL, 0
+	}
+	query := u.Query()
+	if !query.Has("ed") {
+		return rawURL, 0
+	}
+	maxSize, err := strconv.Atoi(query.Get("ed"))
+	if err != nil || maxSize < 0 {
+		return rawURL, 0
+	}
+	query.Del("ed")
+	u.RawQuery = query.Encode()
+	return u.String(), maxSize
+}
+
+// EncodeEarlyData 返回早期数据在 Sec-WebSocket-Protocol 头中的值。
+func EncodeEarlyData(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// AcceptEarlyData 取出升级请求中的早期数据，以及需要传给 Upgrader.Upgrade 的响应头 (原样回显该头)。
+// 头的值不是合法的 base64 时视为普通的子协议，返回 nil。
+func AcceptEarlyData(r *http.Request) ([]byte, http.Header) {
+	value := r.Header.Get(EarlyDataHeader)
+	if value == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) == 0 {
+		return nil, nil
+	}
+	return data, http.Header{EarlyDataHeader: {value}}
+}
+
+// NewPrefixedConn 返回先读出 prefix 再读 conn 的连接，服务端用它把早期数据放回连接的开头。
+func NewPrefixedConn(conn net.Conn, prefix []byte) net.Conn {
+	if len(prefix) == 0 {
+		return conn
+	}
+	return &prefixedConn{Conn: conn, reader: io.MultiReader(bytes.NewReader(prefix), conn)}
+}
+
+type prefixedConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c *prefixedConn) Read(b []byte) (int, error) { return c.reader.Read(b) }
+
+// EarlyDataDialFunc 建立 WebSocket 连接，earlyData 非空时放入升级请求。
+type EarlyDataDialFunc func(earlyData []byte) (net.Conn, error)
+
+// EarlyDataConn 推迟 WebSocket 拨号，直到第一次写入的数据可以随升级请求发出。
+// 写入的数据先缓存，达到 maxSize 或距第一次写入超过 delay 时拨号；delay 为 0 时第一次写入立即拨号。
+// 超出 maxSize 的部分在连接建立后正常发送。拨号失败由之后的 Read / Write 返回。
+// Read 等待拨号完成，因此在第一次写入之前调用的 Read 会一直阻塞到写入或 Close。
+type EarlyDataConn struct {
+	maxSize int
+	delay   time.Duration
+	dial    EarlyDataDialFunc
+
+	mu            sync.Mutex
+	pending       []byte
+	timer         *time.Timer
+	started       bool // 拨号已开始，之后的写入直接发往连接
+	closed        bool
+	readDeadline  time.Time
+	writeDeadline time.Time
+	conn          net.Conn
+
+	done chan struct{} // 拨号完成后关闭，之后 conn / err 不再改变
+	err  error
+}
+
+// NewEarlyDataConn 返回一个在第一次写入时才拨号的连接。
+func NewEarlyDataConn(maxSize int, delay time.Duration, dial EarlyDataDialFunc) *EarlyDataConn {
+	return &EarlyDataConn{
+		maxSize: maxSize,
+		delay:   delay,
+		dial:    dial,
+		done:    make(chan struct{}),
+	}
+}
+
+func (c *EarlyDataConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	if c.started {
+		c.mu.Unlock()
+		conn, err := c.Conn()
+		if err != nil {
+			return 0, err
+		}
+		return conn.Write(b)
+	}
+	if c.closed {
+		c.mu.Unlock()
+		return 0, net.ErrClosed
+	}
+
+	n := min(c.maxSize-len(c.pending), len(b))
+	c.pending = append(c.pending, b[:n]...)
+	if n == len(b) && len(c.pending) < c.maxSize && c.delay > 0 {
+		// 继续等待后续写入
+		if c.timer == nil {
+			c.timer = time.AfterFunc(c.delay, c.flush)
+		}
+		c.mu.Unlock()
+		return len(b), nil
+	}
+	data := c.startLocked()
+	c.mu.Unlock()
+
+	if err := c.connect(data); err != nil {
+		return 0, err
+	}
+	if n == len(b) {
+		return n, nil
+	}
+	m, err := c.conn.Write(b[n:])
+	return n + m, err
+}
+
+// flush 在等待超时后用已缓存的数据拨号。
+func (c *EarlyDataConn) flush() {
+	c.Handshake()
+}
+
+// Handshake 不再等待写入，立即用已缓存的数据拨号并等待完成。健康检查用它确认服务器可用。
+func (c *EarlyDataConn) Handshake() error {
+	c.mu.Lock()
+	if c.started {
+		c.mu.Unlock()
+		_, err := c.Conn()
+		return err
+	}
+	data := c.startLocked()
+	c.mu.Unlock()
+	return c.connect(data)
+}
+
+func (c *EarlyDataConn) startLocked() []byte {
+	c.started = true
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	data := c.pending
+	c.pending = nil
+	return data
+}
+
+func (c *EarlyDataConn) connect(data []byte) error {
+	conn, err := c.dial(data)
+	c.mu.Lock()
+	if err == nil && c.closed {
+		conn.Close()
+		conn, err = nil, net.ErrClosed
+	}
+	if err == nil {
+		if !c.readDeadline.IsZero() {
+			conn.SetReadDeadline(c.readDeadline)
+		}
+		if !c.writeDeadline.IsZero() {
+			conn.SetWriteDeadline(c.writeDeadline)
+		}
+	}
+	c.conn, c.err = conn, err
+	c.mu.Unlock()
+	close(c.done)
+	return err
+}
+
+// Conn 等待拨号完成，返回建立的连接。
+func (c *EarlyDataConn) Conn() (net.Conn, error) {
+	<-c.done
+	return c.conn, c.err
+}
+
+func (c *EarlyDataConn) Read(b []byte) (int, error) {
+	conn, err := c.Conn()
+	if err != nil {
+		return 0, err
+	}
+	return conn.Read(b)
+}
+
+// Close 关闭连接。尚未拨号时丢弃缓存的数据，正在拨号时在拨号完成后关闭。
+func (c *EarlyDataConn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	if !c.started {
+		c.startLocked()
+		c.err = net.ErrClosed
+		c.mu.Unlock()
+		close(c.done)
+		return nil
+	}
+	conn := c.conn
+	c.mu.Unlock()
+	if conn != nil {
+		return conn.Close()
+	}
+	return nil
+}
+
+func (c *EarlyDataConn) connected() net.Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn
+}
+
+func (c *EarlyDataConn) LocalAddr() net.Addr {
+	if conn := c.connected(); conn != nil {
+		return conn.LocalAddr()
+	}
+	return &net.TCPAddr{}
+}
+
+func (c *EarlyDataConn) RemoteAddr() net.Addr {
+	if conn := c.connected(); conn != nil {
+		return conn.RemoteAddr()
+	}
+	return &net.TCPAddr{}
+}
+
+func (c *EarlyDataConn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline 在拨号之前调用时，截止时间在连接建立后生效。
+func (c *EarlyDataConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	conn := c.conn
+	c.mu.Unlock()
+	if conn != nil {
+		return conn.SetReadDeadline(t)
+	}
+	return nil
+}
+
+func (c *EarlyDataConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.writeDeadline = t
+	conn := c.conn
+	c.mu.Unlock()
+	if conn != nil {
+		return conn.SetWriteDeadline(t)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package shared
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestParseEarlyData(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		maxSize int
+	}{
+		{"/?ed=2560", "/", 2560},
+		{"/tunnel?ed=2048&key=v", "/tunnel?key=v", 2048},
+		{"wss://example.com:443/ws?ed=1024", "wss://example.com:443/ws", 1024},
+		{"/tunnel", "/tunnel", 0},
+		{"/tunnel?ed=abc", "/tunnel?ed=abc", 0},
+	}
+	for _, tt := range tests {
+		got, maxSize := ParseEarlyData(tt.in)
+		if got != tt.want || maxSize != tt.maxSize {
+			t.Errorf("ParseEarlyData(%q) = %q, %d, want %q, %d", tt.in, got, maxSize, tt.want, tt.maxSize)
+		}
+	}
+}
+
+// startEarlyDataServer 启动一个回显 WebSocket 服务，记录每个升级请求中的早期数据。
+func startEarlyDataServer(t *testing.T) (string, chan []byte) {
+	t.Helper()
+	received := make(chan []byte, 16)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		earlyData, responseHeader := AcceptEarlyData(r)
+		ws, err := upgrader.Upgrade(w, r, responseHeader)
+		if err != nil {
+			return
+		}
+		received <- earlyData
+		conn := NewPrefixedConn(NewWebSocketConnAdapter(ws), earlyData)
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http"), received
+}
+
+func newTestEarlyDataConn(t *testing.T, url string, maxSize int, delay time.Duration) (*EarlyDataConn, *atomic.Int32) {
+	t.Helper()
+	var dials atomic.Int32
+	conn := NewEarlyDataConn(maxSize, delay, func(earlyData []byte) (net.Conn, error) {
+		dials.Add(1)
+		header := http.Header{}
+		if len(earlyData) > 0 {
+			header.Set(EarlyDataHeader, EncodeEarlyData(earlyData))
+		}
+		ws, _, err := websocket.DefaultDialer.Dial(url, header)
+		if err != nil {
+			return nil, err
+		}
+		return NewWebSocketConnAdapter(ws), nil
+	})
+	t.Cleanup(func() { conn.Close() })
+	return conn, &dials
+}
+
+func expectEarlyData(t *testing.T, received chan []byte, want string) {
+	t.Helper()
+	select {
+	case got := <-received:
+		if string(got) != want {
+			t.Fatalf("early data = %q, want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not receive an upgrade request")
+	}
+}
+
+func readEcho(t *testing.T, conn net.Conn, want string) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Fatalf("echo = %q, want %q", got, want)
+	}
+}
+
+func TestEarlyDataConn(t *testing.T) {
+	url, received := startEarlyDataServer(t)
+
+	t.Run("first write", func(t *testing.T) {
+		conn, _ := newTestEarlyDataConn(t, url, 64, 0)
+		if _, err := conn.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		expectEarlyData(t, received, "hello")
+		conn.Write([]byte(" world"))
+		readEcho(t, conn, "hello world")
+	})
+
+	t.Run("larger than maxSize", func(t *testing.T) {
+		conn, _ := newTestEarlyDataConn(t, url, 4, 0)
+		if n, err := conn.Write([]byte("0123456789")); n != 10 || err != nil {
+			t.Fatalf("Write() = %d, %v", n, err)
+		}
+		expectEarlyData(t, received, "0123")
+		readEcho(t, conn, "0123456789")
+	})
+
+	t.Run("delay coalesces writes", func(t *testing.T) {
+		conn, _ := newTestEarlyDataConn(t, url, 64, 50*time.Millisecond)
+		conn.Write([]byte("ab"))
+		conn.Write([]byte("cd"))
+		expectEarlyData(t, received, "abcd")
+		readEcho(t, conn, "abcd")
+	})
+
+	t.Run("maxSize reached before delay", func(t *testing.T) {
+		conn, _ := newTestEarlyDataConn(t, url, 4, time.Hour)
+		conn.Write([]byte("ab"))
+		conn.Write([]byte("cdef"))
+		expectEarlyData(t, received, "abcd")
+		readEcho(t, conn, "abcdef")
+	})
+
+	t.Run("handshake without data", func(t *testing.T) {
+		conn, _ := newTestEarlyDataConn(t, url, 64, 0)
+		if err := conn.Handshake(); err != nil {
+			t.Fatal(err)
+		}
+		expectEarlyData(t, received, "")
+		conn.Write([]byte("ping"))
+		readEcho(t, conn, "ping")
+	})
+
+	t.Run("close before write", func(t *testing.T) {
+		conn, dials := newTestEarlyDataConn(t, url, 64, 0)
+		conn.Close()
+		if _, err := conn.Read(make([]byte, 1)); err == nil {
+			t.Error("Read() succeeded on a closed connection")
+		}
+		if _, err := conn.Write([]byte("x")); err == nil {
+			t.Error("Write() succeeded on a closed connection")
+		}
+		if dials.Load() != 0 {
+			t.Error("closed connection was dialed")
+		}
+	})
+}
+
+func TestEarlyDataConnDialError(t *testing.T) {
+	conn, _ := newTestEarlyDataConn(t, "ws://127.0.0.1:1/", 64, 10*time.Millisecond)
+	// 等待期间的写入成功，拨号失败由之后的读取返回
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("Read() succeeded after a failed dial")
+	}
+}
@@ -0,0 +1,77 @@
+package shared
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// TraceURL 是健康检查请求的 Cloudflare trace 地址，响应中的 ip= 行是出口 IP。
+const TraceURL = "https://www.cloudflare.com/cdn-cgi/trace"
+
+// PipeDialer 实现了 proxy.Dialer 接口，但它的 Dial 方法总是返回预设的 net.Conn
+type PipeDialer struct {
+	Conn net.Conn
+}
+
+func (d *PipeDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.Conn, nil
+}
+
+// CheckTraceHealth 通过 getConn 返回的 SOCKS5 管道请求 url (通常是 TraceURL)，返回延迟 (毫秒) 和出口 IP。
+// 这会完整走一遍隧道握手和目标连接，比单纯的 TCP 拨号更能反映真实可用性。name 是错误信息的前缀。
+func CheckTraceHealth(name, url string, getConn func() (net.Conn, error)) (latency int64, exitIP string, err error) {
+	pipeConn, err := getConn()
+	if err != nil {
+		return -1, "", fmt.Errorf("%s CheckHealth: failed to get pipe connection: %w", name, err)
+	}
+	defer pipeConn.Close()
+
+	dialer, err := proxy.SOCKS5("tcp", "placeholder-unused:1080", nil, &PipeDialer{Conn: pipeConn})
+	if err != nil {
+		return -1, "", fmt.Errorf("%s CheckHealth: failed to create SOCKS5 dialer: %w", name, err)
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			// DialContext 忽略传入的 addr，强制使用 SOCKS5 管道
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return dialer.Dial(network, addr)
+			},
+		},
+		Timeout: 10 * time.Second,
+	}
+
+	start := time.Now()
+	resp, err := client.Get(url)
+	if err != nil {
+		return -1, "", fmt.Errorf("%s CheckHealth: http get failed: %w", name, err)
+	}
+	defer resp.Body.Close()
+
+	latencyMs := time.Since(start).Milliseconds()
+	if resp.StatusCode != http.StatusOK {
+		return latencyMs, "", fmt.Errorf("%s CheckHealth: unexpected status code: %d", name, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return latencyMs, "", fmt.Errorf("%s CheckHealth: failed to read response body: %w", name, err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "ip=") {
+			exitIP = strings.TrimPrefix(line, "ip=")
+			break
+		}
+	}
+	return latencyMs, exitIP, nil
+}
@@ -0,0 +1,338 @@
+// Package muxpool 维护到同一服务器的多个多路复用会话，供 goremote (smux) 和 worker 隧道共用。
+package muxpool
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"liuproxy_nexus/internal/shared/types"
+)
+
+// DefaultSize 是 Config.Size 未设置时维持的会话数。
+const DefaultSize = 2
+
+const maintainInterval = 10 * time.Second
+
+// Session 是池中的一个多路复用会话。*smux.Session 直接满足此接口。
+type Session interface {
+	NumStreams() int
+	IsClosed() bool
+	// CloseChan 在会话关闭后可读
+	CloseChan() <-chan struct{}
+	Close() error
+}
+
+// Config 是池的参数。
+type Config struct {
+	Size       int           // 维持的会话数，<= 0 时为 DefaultSize
+	MaxAge     time.Duration // 会话的最大存活时间，0 表示不限制
+	MaxStreams int64         // 会话累计打开的流数上限，0 表示不限制
+	MaxActive  int           // 单个会话的并发流上限，0 表示不限制。所有会话都满时临时建立额外的会话
+}
+
+// entry 是池中的会话及其统计。
+type entry[S Session] struct {
+	session S
+	created time.Time
+	opened  atomic.Int64 // 累计打开的流数
+}
+
+// Pool 维护多个多路复用会话。新流分配给当前流数最少的会话；会话意外关闭时立即补充，
+// 达到最大存活时间或累计流数的会话不再接收新流，其上的流全部结束后关闭。
+// 池在第一次打开流之后才开始维持 Size 个会话。
+type Pool[S Session] struct {
+	dial   func() (S, error)
+	logger zerolog.Logger
+	config Config
+
+	mu       sync.Mutex
+	sessions []*entry[S] // 接收新流的会话
+	draining []*entry[S] // 已退役的会话
+	used     bool
+	closed   bool
+
+	refill chan struct{}
+	done   chan struct{}
+	wg     sync.WaitGroup
+
+	totalStreams    atomic.Int64
+	sessionsCreated atomic.Int64
+	sessionsRetired atomic.Int64
+	sessionsFailed  atomic.Int64
+}
+
+// New 创建会话池，dial 建立一个新会话。
+func New[S Session](config Config, dial func() (S, error), logger zerolog.Logger) *Pool[S] {
+	if config.Size <= 0 {
+		config.Size = DefaultSize
+	}
+	p := &Pool[S]{
+		dial:   dial,
+		logger: logger,
+		config: config,
+		refill: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	p.wg.Add(1)
+	go p.maintainLoop(maintainInterval)
+	return p
+}
+
+// Open 在流数最少的会话上调用 open 打开一个流。open 失败的会话被丢弃，并换一个会话重试一次。
+func (p *Pool[S]) Open(open func(session S) error) error {
+	var lastErr error
+	for attempt := 0; attempt < 2; attempt++ {
+		e, err := p.pick()
+		if err != nil {
+			return err
+		}
+		if err = open(e.session); err == nil {
+			e.opened.Add(1)
+			p.totalStreams.Add(1)
+			return nil
+		}
+		lastErr = err
+		p.logger.Warn().Err(err).Msg("[Mux] Failed to open stream, discarding session.")
+		e.session.Close()
+	}
+	return lastErr
+}
+
+// pick 选择流数最少且未满的会话。没有可用会话时同步建立一个，池未满时在后台补充。
+func (p *Pool[S]) pick() (*entry[S], error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, net.ErrClosed
+	}
+	p.used = true
+	p.pruneLocked(time.Now())
+	best := p.leastLoadedLocked()
+	if best != nil && len(p.sessions) < p.config.Size {
+		p.signalRefill()
+	}
+	p.mu.Unlock()
+	if best != nil {
+		return best, nil
+	}
+
+	p.logger.Info().Msg("[Mux] No available session, creating a new one...")
+	e, err := p.dialSession()
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		e.session.Close()
+		return nil, net.ErrClosed
+	}
+	// 并发的调用者可能已经填满了池；所有会话都满时允许超过 Size，空闲后由 pruneLocked 关闭
+	if len(p.sessions) >= p.config.Size {
+		if best := p.leastLoadedLocked(); best != nil {
+			e.session.Close()
+			return best, nil
+		}
+	}
+	p.sessions = append(p.sessions, e)
+	// 同步建立之后再补充其余会话，避免后台重复拨号
+	if len(p.sessions) < p.config.Size {
+		p.signalRefill()
+	}
+	return e, nil
+}
+
+func (p *Pool[S]) leastLoadedLocked() *entry[S] {
+	var best *entry[S]
+	bestStreams := 0
+	for _, e := range p.sessions {
+		n := e.session.NumStreams()
+		if p.config.MaxActive > 0 && n >= p.config.MaxActive {
+			continue
+		}
+		if best == nil || n < bestStreams {
+			best, bestStreams = e, n
+		}
+	}
+	return best
+}
+
+// pruneLocked 移除已关闭的会话，退役到期的会话，关闭没有流的退役会话和超出 Size 的空闲会话。
+func (p *Pool[S]) pruneLocked(now time.Time) {
+	live := p.sessions[:0]
+	for _, e := range p.sessions {
+		switch {
+		case e.session.IsClosed():
+			// 关闭监视协程尚未处理，在这里计数，它将找不到该会话
+			p.sessionsFailed.Add(1)
+		case p.expiredLocked(e, now):
+			p.sessionsRetired.Add(1)
+			p.draining = append(p.draining, e)
+		case len(live) >= p.config.Size && e.session.NumStreams() == 0:
+			e.session.Close()
+		default:
+			live = append(live, e)
+		}
+	}
+	clear(p.sessions[len(live):])
+	p.sessions = live
+
+	draining := p.draining[:0]
+	for _, e := range p.draining {
+		if e.session.IsClosed() || e.session.NumStreams() == 0 {
+			e.session.Close()
+		} else {
+			draining = append(draining, e)
+		}
+	}
+	clear(p.draining[len(draining):])
+	p.draining = draining
+}
+
+// expiredLocked 报告会话是否达到最大存活时间或累计流数。
+func (p *Pool[S]) expiredLocked(e *entry[S], now time.Time) bool {
+	return (p.config.MaxAge > 0 && now.Sub(e.created) >= p.config.MaxAge) ||
+		(p.config.MaxStreams > 0 && e.opened.Load() >= p.config.MaxStreams)
+}
+
+func (p *Pool[S]) signalRefill() {
+	select {
+	case p.refill <- struct{}{}:
+	default:
+	}
+}
+
+// dialSession 建立一个新会话，并在它意外关闭时通知池补充。
+func (p *Pool[S]) dialSession() (*entry[S], error) {
+	session, err := p.dial()
+	if err != nil {
+		return nil, err
+	}
+	p.sessionsCreated.Add(1)
+	e := &entry[S]{session: session, created: time.Now()}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		select {
+		case <-session.CloseChan():
+		case <-p.done:
+			return
+		}
+		p.mu.Lock()
+		failed := false
+		for i, s := range p.sessions {
+			if s == e {
+				p.sessions = append(p.sessions[:i], p.sessions[i+1:]...)
+				failed = true
+				break
+			}
+		}
+		p.mu.Unlock()
+		// 仍在池中的会话被关闭说明连接断开或 keepalive 超时 (pruneLocked 可能已先处理)
+		if failed {
+			p.sessionsFailed.Add(1)
+			p.logger.Warn().Msg("[Mux] Session closed unexpectedly, re-establishing.")
+			p.signalRefill()
+		}
+	}()
+	return e, nil
+}
+
+func (p *Pool[S]) maintainLoop(interval time.Duration) {
+	defer p.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.refill:
+		case <-p.done:
+			return
+		}
+		p.fill()
+	}
+}
+
+// fill 清理会话并补足到 Size 个。拨号不持有锁。
+func (p *Pool[S]) fill() {
+	p.mu.Lock()
+	p.pruneLocked(time.Now())
+	missing := 0
+	if p.used && !p.closed {
+		missing = p.config.Size - len(p.sessions)
+	}
+	p.mu.Unlock()
+
+	for i := 0; i < missing; i++ {
+		e, err := p.dialSession()
+		if err != nil {
+			p.logger.Warn().Err(err).Msg("[Mux] Failed to establish session.")
+			return
+		}
+		p.mu.Lock()
+		if p.closed || len(p.sessions) >= p.config.Size {
+			p.mu.Unlock()
+			e.session.Close()
+			return
+		}
+		p.sessions = append(p.sessions, e)
+		p.mu.Unlock()
+	}
+}
+
+// Reset 关闭所有会话，之后按新的 size 重新建立。
+func (p *Pool[S]) Reset(size int) {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	p.mu.Lock()
+	entries := append(p.sessions, p.draining...)
+	p.sessions, p.draining = nil, nil
+	p.config.Size = size
+	p.used = false
+	p.mu.Unlock()
+	for _, e := range entries {
+		e.session.Close()
+	}
+}
+
+// Close 关闭池和所有会话。
+func (p *Pool[S]) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.done)
+	size := p.config.Size
+	p.mu.Unlock()
+	p.Reset(size)
+	p.wg.Wait()
+}
+
+// Metrics 返回池的当前状态。
+func (p *Pool[S]) Metrics() *types.MuxMetrics {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	m := &types.MuxMetrics{
+		Sessions:         len(p.sessions),
+		DrainingSessions: len(p.draining),
+		TotalStreams:     p.totalStreams.Load(),
+		SessionsCreated:  p.sessionsCreated.Load(),
+		SessionsRetired:  p.sessionsRetired.Load(),
+		SessionsFailed:   p.sessionsFailed.Load(),
+	}
+	for _, e := range p.sessions {
+		m.ActiveStreams += e.session.NumStreams()
+	}
+	for _, e := range p.draining {
+		m.ActiveStreams += e.session.NumStreams()
+	}
+	return m
+}
@@ -0,0 +1,220 @@
+package muxpool
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// fakeSession 只记录流数，Close 模拟连接断开。
+type fakeSession struct {
+	streams atomic.Int32
+	die     chan struct{}
+	once    sync.Once
+}
+
+func (s *fakeSession) NumStreams() int            { return int(s.streams.Load()) }
+func (s *fakeSession) CloseChan() <-chan struct{} { return s.die }
+
+func (s *fakeSession) IsClosed() bool {
+	select {
+	case <-s.die:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *fakeSession) Close() error {
+	s.once.Do(func() { close(s.die) })
+	return nil
+}
+
+// fakeDialer 记录建立的所有会话，按拨号顺序。
+type fakeDialer struct {
+	mu       sync.Mutex
+	sessions []*fakeSession
+}
+
+func (d *fakeDialer) dial() (*fakeSession, error) {
+	s := &fakeSession{die: make(chan struct{})}
+	d.mu.Lock()
+	d.sessions = append(d.sessions, s)
+	d.mu.Unlock()
+	return s, nil
+}
+
+func (d *fakeDialer) session(i int) *fakeSession {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.sessions[i]
+}
+
+func newTestPool(t *testing.T, config Config) (*Pool[*fakeSession], *fakeDialer) {
+	t.Helper()
+	d := &fakeDialer{}
+	pool := New(config, d.dial, zerolog.Nop())
+	t.Cleanup(pool.Close)
+	return pool, d
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// openStreams 打开 n 个流，返回每个流所在的会话。
+func openStreams(t *testing.T, pool *Pool[*fakeSession], n int) []*fakeSession {
+	t.Helper()
+	sessions := make([]*fakeSession, n)
+	for i := range sessions {
+		err := pool.Open(func(session *fakeSession) error {
+			if session.IsClosed() {
+				return errors.New("session closed")
+			}
+			session.streams.Add(1)
+			sessions[i] = session
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+	}
+	return sessions
+}
+
+func closeStreams(sessions ...*fakeSession) {
+	for _, s := range sessions {
+		s.streams.Add(-1)
+	}
+}
+
+func TestPoolBalancesStreams(t *testing.T) {
+	pool, d := newTestPool(t, Config{Size: 3})
+
+	// 第一个流同步建立会话，其余会话在后台补充
+	closeStreams(openStreams(t, pool, 1)...)
+	waitFor(t, "the pool to fill", func() bool { return pool.Metrics().Sessions == 3 })
+
+	streams := openStreams(t, pool, 6)
+	defer closeStreams(streams...)
+	for i := 0; i < 3; i++ {
+		if n := d.session(i).NumStreams(); n != 2 {
+			t.Errorf("session %d has %d streams, want 2", i, n)
+		}
+	}
+	m := pool.Metrics()
+	if m.ActiveStreams != 6 || m.TotalStreams != 7 || m.SessionsCreated != 3 {
+		t.Errorf("unexpected metrics: %+v", m)
+	}
+}
+
+func TestPoolMaxActive(t *testing.T) {
+	pool, d := newTestPool(t, Config{Size: 1, MaxActive: 2})
+
+	// 唯一的会话满了之后临时建立额外的会话
+	streams := openStreams(t, pool, 3)
+	if streams[2] == streams[0] {
+		t.Fatal("a full session received a new stream")
+	}
+	if m := pool.Metrics(); m.Sessions != 2 {
+		t.Fatalf("unexpected metrics: %+v", m)
+	}
+
+	// 额外的会话空闲后被关闭
+	closeStreams(streams[2])
+	pool.fill()
+	if m := pool.Metrics(); m.Sessions != 1 || !d.session(1).IsClosed() {
+		t.Fatalf("idle extra session still open: %+v", m)
+	}
+	closeStreams(streams[:2]...)
+}
+
+func TestPoolReplacesFailedSession(t *testing.T) {
+	pool, d := newTestPool(t, Config{Size: 2})
+	closeStreams(openStreams(t, pool, 1)...)
+	waitFor(t, "the pool to fill", func() bool { return pool.Metrics().Sessions == 2 })
+
+	// 第一个会话断开，池应立即补充一个新会话
+	d.session(0).Close()
+	waitFor(t, "the session to be replaced", func() bool {
+		m := pool.Metrics()
+		return m.SessionsFailed == 1 && m.Sessions == 2 && m.SessionsCreated == 3
+	})
+	if s := openStreams(t, pool, 1); s[0] == d.session(0) {
+		t.Fatal("stream opened on the failed session")
+	}
+}
+
+func TestPoolDiscardsSessionOnOpenError(t *testing.T) {
+	pool, d := newTestPool(t, Config{Size: 1})
+	openStreams(t, pool, 1)
+
+	attempts := 0
+	err := pool.Open(func(session *fakeSession) error {
+		attempts++
+		if session == d.session(0) {
+			return errors.New("broken session")
+		}
+		return nil
+	})
+	if err != nil || attempts != 2 {
+		t.Fatalf("Open() = %v after %d attempts, want success on the second", err, attempts)
+	}
+	if !d.session(0).IsClosed() {
+		t.Error("the session that failed to open a stream was not closed")
+	}
+}
+
+func TestPoolRetiresSessions(t *testing.T) {
+	t.Run("max streams", func(t *testing.T) {
+		pool, d := newTestPool(t, Config{Size: 1, MaxStreams: 2})
+		streams := openStreams(t, pool, 3)
+		if streams[2] == streams[0] {
+			t.Fatal("retired session received a new stream")
+		}
+		if m := pool.Metrics(); m.Sessions != 1 || m.DrainingSessions != 1 || m.SessionsRetired != 1 {
+			t.Fatalf("unexpected metrics after retirement: %+v", m)
+		}
+
+		// 退役会话的流结束后会话被关闭
+		closeStreams(streams[:2]...)
+		pool.fill()
+		if m := pool.Metrics(); m.DrainingSessions != 0 || !d.session(0).IsClosed() {
+			t.Fatalf("retired session still open: %+v", m)
+		}
+		closeStreams(streams[2])
+	})
+
+	t.Run("max age", func(t *testing.T) {
+		pool, _ := newTestPool(t, Config{Size: 1, MaxAge: 50 * time.Millisecond})
+		openStreams(t, pool, 1)
+		time.Sleep(60 * time.Millisecond)
+		openStreams(t, pool, 1)
+		if m := pool.Metrics(); m.SessionsRetired != 1 || m.SessionsCreated != 2 {
+			t.Fatalf("unexpected metrics: %+v", m)
+		}
+	})
+}
+
+func TestPoolClose(t *testing.T) {
+	pool, d := newTestPool(t, Config{Size: 2})
+	openStreams(t, pool, 1)
+	pool.Close()
+	if err := pool.Open(func(*fakeSession) error { return nil }); err == nil {
+		t.Fatal("Open() succeeded on a closed pool")
+	}
+	if !d.session(0).IsClosed() {
+		t.Fatal("session still open after the pool was closed")
+	}
+}
@@ -0,0 +1,113 @@
+// FILE: internal/shared/outbound/dialer.go
+package outbound
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"liuproxy_nexus/internal/shared/types"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Options 是所有出站 socket 共用的属性。透明代理与网关运行在同一台主机上时，
+// 通过 fwmark 让 netfilter 规则跳过本程序自己发出的连接，避免流量被重新捕获形成环路。
+type Options struct {
+	Mark      int    // SO_MARK，0 表示不设置 (需要 CAP_NET_ADMIN)
+	Interface string // SO_BINDTODEVICE，为空表示不绑定网卡
+	SourceIP  net.IP // 出站源地址，只对同一地址族的目标生效
+}
+
+var current atomic.Pointer[Options]
+
+func init() {
+	current.Store(&Options{})
+}
+
+// Configure 设置全局出站选项，应在启动策略之前调用。
+func Configure(opts Options) error {
+	if opts.Mark < 0 {
+		return fmt.Errorf("outbound: invalid fwmark %d", opts.Mark)
+	}
+	if opts.SourceIP != nil && opts.SourceIP.IsUnspecified() {
+		opts.SourceIP = nil
+	}
+	current.Store(&opts)
+	return nil
+}
+
+// Current 返回当前的出站选项。
+func Current() Options {
+	return *current.Load()
+}
+
+// Control 可直接用作 net.Dialer 的 Control 回调，为 socket 应用出站选项。
+// 源地址在这里通过 bind 设置，因此不能与 net.Dialer.LocalAddr 或监听 socket 同时使用。
+func Control(network, address string, c syscall.RawConn) error {
+	opts := current.Load()
+	if opts.Mark == 0 && opts.Interface == "" && opts.SourceIP == nil {
+		return nil
+	}
+	var sockErr error
+	if err := c.Control(func(fd uintptr) {
+		sockErr = applySocketOptions(int(fd), network, opts)
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// NewDialer 返回一个应用了出站选项的 net.Dialer。
+func NewDialer(timeout time.Duration) *net.Dialer {
+	return &net.Dialer{Timeout: timeout, Control: Control}
+}
+
+// DialContext 是 net.Dialer.DialContext 的出站版本，可直接赋给 http.Transport 或 websocket.Dialer。
+func DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	var d net.Dialer
+	d.Control = Control
+	return d.DialContext(ctx, network, address)
+}
+
+// Dial 是 net.Dial 的出站版本。
+func Dial(network, address string) (net.Conn, error) {
+	return DialContext(context.Background(), network, address)
+}
+
+// DialTimeout 是 net.DialTimeout 的出站版本。
+func DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	return NewDialer(timeout).Dial(network, address)
+}
+
+// System 是直接连接网络的 types.Dialer 实现，应用全局出站选项。
+var System types.Dialer = systemDialer{}
+
+type systemDialer struct{}
+
+func (systemDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return DialContext(ctx, network, address)
+}
+
+// DialWithTimeout 使用 d 建立连接，timeout 为整个拨号过程的超时。
+func DialWithTimeout(d types.Dialer, network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return d.DialContext(ctx, network, address)
+}
+
+// Or 在 d 为 nil 时返回 System。
+func Or(d types.Dialer) types.Dialer {
+	if d == nil {
+		return System
+	}
+	return d
+}
+
+// CloseDialer 释放拨号器持有的资源 (例如 via 链中的前置策略实例)，应在策略关闭时调用。
+func CloseDialer(d types.Dialer) {
+	if c, ok := d.(io.Closer); ok {
+		c.Close()
+	}
+}
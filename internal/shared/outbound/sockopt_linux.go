@@ -0,0 +1,53 @@
+//go:build linux
+
+// FILE: internal/shared/outbound/sockopt_linux.go
+package outbound
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+func applySocketOptions(fd int, network string, opts *Options) error {
+	if opts.Mark != 0 {
+		if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_MARK, opts.Mark); err != nil {
+			return fmt.Errorf("outbound: setsockopt(SO_MARK) failed: %w", err)
+		}
+	}
+	if opts.Interface != "" {
+		if err := syscall.BindToDevice(fd, opts.Interface); err != nil {
+			return fmt.Errorf("outbound: setsockopt(SO_BINDTODEVICE, %s) failed: %w", opts.Interface, err)
+		}
+	}
+	if opts.SourceIP != nil {
+		return bindSourceIP(fd, network, opts)
+	}
+	return nil
+}
+
+// bindSourceIP 在 connect 之前绑定源地址 (端口由内核分配)。
+// 目标地址族与源地址不一致时 (例如 IPv4 源地址拨号 IPv6 目标) 跳过绑定，使用系统默认源地址。
+func bindSourceIP(fd int, network string, opts *Options) error {
+	ipv6Socket := strings.HasSuffix(network, "6")
+	if ip4 := opts.SourceIP.To4(); ip4 != nil {
+		if ipv6Socket {
+			return nil
+		}
+		sa := &syscall.SockaddrInet4{}
+		copy(sa.Addr[:], ip4)
+		if err := syscall.Bind(fd, sa); err != nil {
+			return fmt.Errorf("outbound: bind(%s) failed: %w", opts.SourceIP, err)
+		}
+		return nil
+	}
+	if !ipv6Socket {
+		return nil
+	}
+	sa := &syscall.SockaddrInet6{}
+	copy(sa.Addr[:], opts.SourceIP.To16())
+	if err := syscall.Bind(fd, sa); err != nil {
+		return fmt.Errorf("outbound: bind(%s) failed: %w", opts.SourceIP, err)
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+// FILE: internal/shared/outbound/sockopt_other.go
+package outbound
+
+import "fmt"
+
+func applySocketOptions(fd int, network string, opts *Options) error {
+	if opts.Mark != 0 || opts.Interface != "" {
+		return fmt.Errorf("outbound: fwmark and interface binding are only supported on Linux")
+	}
+	// 非 Linux 平台不支持在 Control 中绑定源地址，忽略该选项
+	return nil
+}
@@ -14,6 +14,9 @@ const (
 	FlagTCPData                    byte = 0x03
 	FlagUDPData                    byte = 0x04
 	FlagControlCloseStream         byte = 0x05
+	// FlagControlWindowUpdate 归还流的接收窗口，负载为 4 字节大端序的增量 (字节数)。
+	// 目前只用于 Worker 多路复用的下行方向
+	FlagControlWindowUpdate byte = 0x06
 )
 
 // Packet 代表一个 v2.2 协议的数据包
@@ -22,28 +22,34 @@ type Cipher struct {
 }
 
 // NewCipher 创建一个默认的 (chacha20) 加密器，以保持向后兼容性。
+//
+// Deprecated: 整数密钥可以被轻易穷举，只用于旧版服务器，新代码应使用 KeySource。
 func NewCipher(key int) (*Cipher, error) {
 	return NewCipherWithAlgo(key, CHACHA20_POLY1305)
 }
 
-// NewCipherWithAlgo 根据指定的算法创建一个新的加密器。
-// 这是新的总工厂函数。
+// NewCipherWithAlgo 根据指定的算法和整数密钥创建一个新的加密器。
+//
+// Deprecated: 整数密钥可以被轻易穷举，只用于旧版服务器，新代码应使用 KeySource。
 func NewCipherWithAlgo(key int, algo Algorithm) (*Cipher, error) {
 	// 密钥派生逻辑保持不变，确保两种算法使用相同的根密钥
 	keyBytes := []byte(fmt.Sprintf("liuproxy-secure-v2-key-%d", key))
 	hash := sha256.Sum256(keyBytes)
-	finalKey := hash[:]
+	return newCipherWithKey(hash[:], algo)
+}
 
+// newCipherWithKey 用 32 字节密钥创建指定算法的加密器。
+func newCipherWithKey(key []byte, algo Algorithm) (*Cipher, error) {
 	var aead cipher.AEAD
 	var err error
 
 	switch algo {
 	case AES_256_GCM:
-		aead, err = newAESGCMAEAD(finalKey)
+		aead, err = newAESGCMAEAD(key)
 	case CHACHA20_POLY1305:
 		fallthrough // 设为默认值
 	default:
-		aead, err = newChaCha20AEAD(finalKey)
+		aead, err = newChaCha20AEAD(key)
 	}
 
 	if err != nil {
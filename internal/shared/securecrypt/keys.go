@@ -0,0 +1,184 @@
+package securecrypt
+
+import (
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+)
+
+const (
+	// PSKSize 是预共享密钥的长度 (base64 解码后)。
+	PSKSize = 32
+	// SaltSize 是每条连接 (或每个 UDP 数据报) 开头明文发送的随机 salt 长度。
+	SaltSize = 32
+)
+
+// hkdfInfo 区分本协议派生出的密钥，修改它会使新旧版本互不兼容。
+const hkdfInfo = "liuproxy-secure-v3-session"
+
+// KeySource 决定 goremote/worker 连接使用的密钥。
+//
+// PSK 模式下，客户端为每条连接生成随机 salt 并以明文写在最前面，
+// 双方用 HKDF-SHA256(psk, salt) 派生出该连接的 AEAD 密钥；UDP 数据报则各自携带 salt。
+// 需要防重放的协议 (goremote v3) 改用 DeriveCounterCipher 自行完成握手和密钥派生。
+// 旧版模式 (legacy) 所有连接共用由 liuproxy.ini 中整数 crypt 派生的固定密钥，不发送 salt，
+// 该密钥可以被轻易穷举，只为兼容尚未升级的服务器而保留。
+type KeySource struct {
+	psk    []byte
+	algo   Algorithm
+	legacy *Cipher // 非 nil 表示旧版模式
+}
+
+// NewKeySource 根据服务器配置创建 KeySource。psk 为 base64 编码的 32 字节密钥；
+// 未配置 psk 时必须显式设置 legacy 才会退回到整数密钥 legacyKey。
+func NewKeySource(psk string, legacy bool, legacyKey int, algo Algorithm) (*KeySource, error) {
+	if psk != "" {
+		key, err := DecodePSK(psk)
+		if err != nil {
+			return nil, err
+		}
+		return &KeySource{psk: key, algo: algo}, nil
+	}
+	if !legacy {
+		return nil, fmt.Errorf("a psk is required (set legacyCrypt to use the integer crypt key with old servers)")
+	}
+	cipher, err := NewCipherWithAlgo(legacyKey, algo)
+	if err != nil {
+		return nil, err
+	}
+	return &KeySource{algo: algo, legacy: cipher}, nil
+}
+
+// DecodePSK 解码 base64 (标准或 URL 编码，可省略填充) 格式的预共享密钥。
+func DecodePSK(psk string) ([]byte, error) {
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if key, err := encoding.DecodeString(psk); err == nil {
+			if len(key) != PSKSize {
+				return nil, fmt.Errorf("psk must be %d bytes, got %d", PSKSize, len(key))
+			}
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("psk is not valid base64")
+}
+
+// IsLegacy 报告是否处于旧版整数密钥模式。
+func (k *KeySource) IsLegacy() bool { return k.legacy != nil }
+
+// NewClientCipher 为一条新连接生成 salt 和对应的加密器。调用方必须先把 salt 原样写给服务器；
+// 旧版模式下 salt 为 nil。
+func (k *KeySource) NewClientCipher() (*Cipher, []byte, error) {
+	if k.legacy != nil {
+		return k.legacy, nil, nil
+	}
+	salt := make([]byte, SaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	cipher, err := k.sessionCipher(salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cipher, salt, nil
+}
+
+// ReadServerCipher 在服务器端读取连接开头的 salt 并返回对应的加密器。
+func (k *KeySource) ReadServerCipher(r io.Reader) (*Cipher, error) {
+	if k.legacy != nil {
+		return k.legacy, nil
+	}
+	salt := make([]byte, SaltSize)
+	if _, err := io.ReadFull(r, salt); err != nil {
+		return nil, fmt.Errorf("failed to read salt: %w", err)
+	}
+	return k.sessionCipher(salt)
+}
+
+// SealPacket 加密一个独立的数据报 (UDP)，PSK 模式下结果为 salt || 密文。
+func (k *KeySource) SealPacket(plaintext []byte) ([]byte, error) {
+	cipher, salt, err := k.NewClientCipher()
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := cipher.Encrypt(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return append(salt, ciphertext...), nil
+}
+
+// OpenPacket 解密 SealPacket 生成的数据报。
+func (k *KeySource) OpenPacket(packet []byte) ([]byte, error) {
+	if k.legacy != nil {
+		return k.legacy.Decrypt(packet)
+	}
+	if len(packet) < SaltSize {
+		return nil, fmt.Errorf("packet is too short")
+	}
+	cipher, err := k.sessionCipher(packet[:SaltSize])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.Decrypt(packet[SaltSize:])
+}
+
+func (k *KeySource) sessionCipher(salt []byte) (*Cipher, error) {
+	key, err := hkdf.Key(sha256.New, k.psk, salt, hkdfInfo, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive session key: %w", err)
+	}
+	return newCipherWithKey(key, k.algo)
+}
+
+// DeriveCounterCipher 用 HKDF-SHA256(psk, salt, info) 派生一个以计数器为 nonce 的加密器，
+// 每个方向使用一个独立的实例。旧版模式没有 psk，无法派生。
+func (k *KeySource) DeriveCounterCipher(salt, info []byte) (*CounterCipher, error) {
+	if k.legacy != nil {
+		return nil, fmt.Errorf("counter ciphers require a psk")
+	}
+	key, err := hkdf.Key(sha256.New, k.psk, salt, string(info), 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive session key: %w", err)
+	}
+	c, err := newCipherWithKey(key, k.algo)
+	if err != nil {
+		return nil, err
+	}
+	return &CounterCipher{aead: c.aead, nonce: make([]byte, c.aead.NonceSize())}, nil
+}
+
+// CounterCipher 以从 0 开始递增的计数器 (小端序) 作为 nonce，nonce 不随密文发送。
+// 接收方必须按发送顺序解密，重放、丢弃或重排的帧都会解密失败。它不能并发使用。
+type CounterCipher struct {
+	aead  cipher.AEAD
+	nonce []byte
+}
+
+func (c *CounterCipher) Encrypt(plaintext []byte) ([]byte, error) {
+	ciphertext := c.aead.Seal(nil, c.nonce, plaintext, nil)
+	c.increment()
+	return ciphertext, nil
+}
+
+// Decrypt 解密下一帧。失败时计数器不变，连接应当被关闭。
+func (c *CounterCipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	plaintext, err := c.aead.Open(nil, c.nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed: %w", err)
+	}
+	c.increment()
+	return plaintext, nil
+}
+
+func (c *CounterCipher) increment() {
+	for i := range c.nonce {
+		c.nonce[i]++
+		if c.nonce[i] != 0 {
+			return
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package securecrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+)
+
+func newTestPSK(t *testing.T) string {
+	t.Helper()
+	key := make([]byte, PSKSize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func TestKeySourceSession(t *testing.T) {
+	for _, algo := range []Algorithm{CHACHA20_POLY1305, AES_256_GCM} {
+		t.Run(string(algo), func(t *testing.T) {
+			psk := newTestPSK(t)
+			client, err := NewKeySource(psk, false, 0, algo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			server, err := NewKeySource(psk, false, 0, algo)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			clientCipher, salt, err := client.NewClientCipher()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(salt) != SaltSize {
+				t.Fatalf("salt length = %d, want %d", len(salt), SaltSize)
+			}
+			serverCipher, err := server.ReadServerCipher(bytes.NewReader(salt))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sealed, err := clientCipher.Encrypt([]byte("hello"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opened, err := serverCipher.Decrypt(sealed); err != nil || string(opened) != "hello" {
+				t.Fatalf("server Decrypt() = %q, %v", opened, err)
+			}
+
+			// 每条连接的 salt 不同，派生出的密钥也不同
+			otherCipher, otherSalt, err := client.NewClientCipher()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Equal(salt, otherSalt) {
+				t.Fatal("two connections got the same salt")
+			}
+			if _, err := otherCipher.Decrypt(sealed); err == nil {
+				t.Fatal("a ciphertext from one connection decrypted with another connection's key")
+			}
+
+			// 不同 PSK 无法解密
+			wrong, err := NewKeySource(newTestPSK(t), false, 0, algo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wrongCipher, err := wrong.ReadServerCipher(bytes.NewReader(salt))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := wrongCipher.Decrypt(sealed); err == nil {
+				t.Fatal("decrypted with the wrong psk")
+			}
+		})
+	}
+}
+
+func TestKeySourcePacket(t *testing.T) {
+	keys, err := NewKeySource(newTestPSK(t), false, 0, CHACHA20_POLY1305)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet, err := keys.SealPacket([]byte("datagram"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened, err := keys.OpenPacket(packet); err != nil || string(opened) != "datagram" {
+		t.Fatalf("OpenPacket() = %q, %v", opened, err)
+	}
+	packet[len(packet)-1] ^= 0xff
+	if _, err := keys.OpenPacket(packet); err == nil {
+		t.Fatal("expected a tampered packet to fail")
+	}
+	if _, err := keys.OpenPacket(packet[:SaltSize-1]); err == nil {
+		t.Fatal("expected a short packet to fail")
+	}
+}
+
+func TestKeySourceLegacy(t *testing.T) {
+	if _, err := NewKeySource("", false, 125, CHACHA20_POLY1305); err == nil {
+		t.Fatal("expected an error without a psk or the legacy flag")
+	}
+
+	keys, err := NewKeySource("", true, 125, CHACHA20_POLY1305)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !keys.IsLegacy() {
+		t.Fatal("IsLegacy() = false")
+	}
+	cipher, salt, err := keys.NewClientCipher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if salt != nil {
+		t.Fatalf("legacy mode sent a salt: %x", salt)
+	}
+
+	// 旧版模式必须与旧服务器使用的 NewCipher 互通
+	old, err := NewCipher(125)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := cipher.Encrypt([]byte("compat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened, err := old.Decrypt(sealed); err != nil || string(opened) != "compat" {
+		t.Fatalf("legacy Decrypt() = %q, %v", opened, err)
+	}
+	packet, err := old.Encrypt([]byte("udp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened, err := keys.OpenPacket(packet); err != nil || string(opened) != "udp" {
+		t.Fatalf("legacy OpenPacket() = %q, %v", opened, err)
+	}
+}
+
+func TestDecodePSK(t *testing.T) {
+	key := bytes.Repeat([]byte{0xfb}, PSKSize)
+	for _, encoded := range []string{
+		base64.StdEncoding.EncodeToString(key),
+		base64.RawStdEncoding.EncodeToString(key),
+		base64.URLEncoding.EncodeToString(key),
+		base64.RawURLEncoding.EncodeToString(key),
+	} {
+		if got, err := DecodePSK(encoded); err != nil || !bytes.Equal(got, key) {
+			t.Errorf("DecodePSK(%q) = %x, %v", encoded, got, err)
+		}
+	}
+	for _, bad := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := DecodePSK(bad); err == nil {
+			t.Errorf("DecodePSK(%q): expected an error", bad)
+		}
+	}
+	if _, err := NewKeySource("short", false, 0, CHACHA20_POLY1305); err == nil {
+		t.Error("NewKeySource accepted an invalid psk")
+	}
+}
@@ -150,6 +150,14 @@ func deepCopy(s *RuntimeSettings) *RuntimeSettings {
 		gwCopy := *s.Gateway
 		newS.Gateway = &gwCopy
 	}
+	if s.Inbounds != nil {
+		inCopy := *s.Inbounds
+		newS.Inbounds = &inCopy
+	}
+	if s.Netfilter != nil {
+		nfCopy := *s.Netfilter
+		newS.Netfilter = &nfCopy
+	}
 	// ... 对其他模块执行相同的深度拷贝 ...
 	return &newS
 }
@@ -164,6 +172,10 @@ func getModuleByKey(s *RuntimeSettings, key string) interface{} {
 		return s.Logging
 	case "firewall":
 		return s.Firewall
+	case "inbounds":
+		return s.Inbounds
+	case "netfilter":
+		return s.Netfilter
 	default:
 		return nil
 	}
@@ -7,6 +7,8 @@ const (
 	RuleTypeSourceIP    RuleType = "source_ip"
 	RuleTypeDestIP      RuleType = "dest_ip"
 	RuleTypeDomain      RuleType = "domain"
+	RuleTypeInboundTag  RuleType = "inbound_tag" // 匹配流量进入的监听器标签
+	RuleTypeALPN        RuleType = "alpn"        // 匹配 TLS ClientHello 中客户端首选的 ALPN (e.g., "h2", "http/1.1")
 	RuleTypeLoadBalance RuleType = "loadbalance" // 特殊类型，代表默认负载均衡
 )
 
@@ -47,10 +49,12 @@ type ConfigurableModule interface {
 // 它以模块化的方式组织了所有可以在运行时被动态修改的配置。
 // 使用指针类型确保了当JSON文件中缺少某个模块时，对应的字段为nil，而不是一个空的结构体。
 type RuntimeSettings struct {
-	Gateway  *GatewaySettings  `json:"gateway"`
-	Routing  *RoutingSettings  `json:"routing"`
-	Logging  *LoggingSettings  `json:"logging"`
-	Firewall *FirewallSettings `json:"firewall"`
+	Gateway   *GatewaySettings   `json:"gateway"`
+	Routing   *RoutingSettings   `json:"routing"`
+	Logging   *LoggingSettings   `json:"logging"`
+	Firewall  *FirewallSettings  `json:"firewall"`
+	Inbounds  *InboundSettings   `json:"inbounds"`
+	Netfilter *NetfilterSettings `json:"netfilter"`
 }
 
 // GatewaySettings 对应 settings.json 中的 "gateway" 模块。
@@ -63,7 +67,7 @@ type GatewaySettings struct {
 
 type Rule struct {
 	Priority int      `json:"priority"`        // Lower value means higher priority
-	Type     string   `json:"type"`            // e.g., "domain", "source_ip"
+	Type     string   `json:"type"`            // e.g., "domain", "source_ip"; "alpn" 只比较客户端首选的协议
 	Value    []string `json:"value,omitempty"` // e.g., ["*.google.com"], ["192.168.1.0/24", "10.0.0.0/8"]
 	Target   string   `json:"target"`          // Server remarks, or "DIRECT", "REJECT"
 }
@@ -73,6 +77,66 @@ type RoutingSettings struct {
 	Rules []*Rule `json:"rules"` // 包含所有路由规则的列表
 }
 
+// InboundType 定义了入站监听器的类型
+type InboundType string
+
+const (
+	InboundMixed    InboundType = "mixed"    // SOCKS5 / HTTP / TLS 自动识别
+	InboundSocks5   InboundType = "socks5"   // 仅 SOCKS5
+	InboundHTTP     InboundType = "http"     // 仅 HTTP 代理 (含 CONNECT)
+	InboundTProxy   InboundType = "tproxy"   // iptables TPROXY (IP_TRANSPARENT)
+	InboundRedirect InboundType = "redirect" // iptables REDIRECT (SO_ORIGINAL_DST)
+)
+
+// InboundAuth 是入站监听器的认证配置，用于 SOCKS5 用户名/密码认证和 HTTP Proxy-Authorization。
+type InboundAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Inbound 定义了一个入站监听器。
+type Inbound struct {
+	Tag     string       `json:"tag"`            // 唯一标识，可被 inbound_tag 路由规则匹配
+	Type    InboundType  `json:"type"`           // mixed, socks5, http, tproxy, redirect
+	Listen  string       `json:"listen"`         // e.g., "0.0.0.0:1080", "[::1]:1080", "unix:/run/liuproxy.sock"
+	Enabled bool         `json:"enabled"`        // 是否启用
+	Auth    *InboundAuth `json:"auth,omitempty"` // 为空表示不需要认证
+
+	SniffTimeout int `json:"sniff_timeout,omitempty"` // 协议嗅探超时 (毫秒)，0 表示使用默认值
+	// Sniff 仅对 tproxy/redirect 生效：用首包中的 TLS SNI / HTTP Host 覆盖 IP 目标后再路由
+	Sniff bool `json:"sniff,omitempty"`
+}
+
+// InboundSettings 对应 settings.json 中的 "inbounds" 模块。
+// liuproxy.ini 中的 unified_port / tproxy_port 仍然作为默认监听器存在，此处定义的是额外的监听器。
+type InboundSettings struct {
+	Listeners []*Inbound `json:"listeners"`
+}
+
+// NetfilterMode 定义了透明代理的内核引流方式
+type NetfilterMode string
+
+const (
+	NetfilterRedirect NetfilterMode = "redirect" // nat REDIRECT，对应 redirect 类型的监听器
+	NetfilterTProxy   NetfilterMode = "tproxy"   // mangle TPROXY + fwmark 策略路由，对应 tproxy 类型的监听器
+)
+
+// NetfilterSettings 对应 settings.json 中的 "netfilter" 模块。
+// 启用后由进程自身安装透明代理所需的 nftables/iptables 规则，并在退出时清理。
+type NetfilterSettings struct {
+	Enabled       bool          `json:"enabled"`
+	Backend       string        `json:"backend,omitempty"` // "auto" (默认，优先 nftables), "nftables", "iptables"
+	Mode          NetfilterMode `json:"mode"`
+	Port          int           `json:"port,omitempty"` // 透明代理监听端口，0 表示使用 liuproxy.ini 中的 tproxy_port
+	TCP           bool          `json:"tcp"`
+	UDP           bool          `json:"udp"`
+	IPv6          bool          `json:"ipv6"`
+	MSSClamping   bool          `json:"mss_clamping"`
+	ExcludedCIDRs []string      `json:"excluded_cidrs"`        // 不经过代理的目标网段 (局域网、保留地址等)
+	FwMark        int           `json:"fwmark,omitempty"`      // TPROXY 模式的 fwmark，0 表示使用默认值 1
+	RouteTable    int           `json:"route_table,omitempty"` // TPROXY 模式的策略路由表，0 表示使用默认值 100
+}
+
 // LoadBalancerSettings 对应 settings.json 中的 "load_balancer" 模块 (占位符)。
 type LoadBalancerSettings struct {
 	// TODO: 在迭代 4.2 中具体实现
@@ -94,6 +158,26 @@ func createDefaultSettings() *RuntimeSettings {
 				{Priority: 9999, Action: "allow"},
 			},
 		},
+		Inbounds:  &InboundSettings{Listeners: []*Inbound{}},
+		Netfilter: defaultNetfilterSettings(),
+	}
+}
+
+// defaultNetfilterSettings 与 scripts/entrypoint.sh 的默认行为一致，但默认不启用，
+// 以免与仍由入口脚本安装的规则冲突。
+func defaultNetfilterSettings() *NetfilterSettings {
+	return &NetfilterSettings{
+		Enabled:     false,
+		Backend:     "auto",
+		Mode:        NetfilterRedirect,
+		TCP:         true,
+		UDP:         true,
+		MSSClamping: true,
+		ExcludedCIDRs: []string{
+			"0.0.0.0/8", "10.0.0.0/8", "127.0.0.0/8", "169.254.0.0/16",
+			"172.16.0.0/12", "192.168.0.0/16", "224.0.0.0/4", "240.0.0.0/4",
+			"::1/128", "fc00::/7", "fe80::/10", "ff00::/8",
+		},
 	}
 }
 
@@ -110,4 +194,10 @@ func ensureDefaultModules(s *RuntimeSettings) {
 	if s.Firewall == nil {
 		s.Firewall = &FirewallSettings{Rules: []*FirewallRule{}}
 	}
+	if s.Inbounds == nil {
+		s.Inbounds = &InboundSettings{Listeners: []*Inbound{}}
+	}
+	if s.Netfilter == nil {
+		s.Netfilter = defaultNetfilterSettings()
+	}
 }
@@ -0,0 +1,395 @@
+package shared
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"liuproxy_nexus/internal/shared/types"
+)
+
+// UDPSessionTimeout 是透明代理 UDP 会话的空闲超时。
+const UDPSessionTimeout = 60 * time.Second
+
+// SocksRelay 是按连接拨号的隧道策略 (shadowsocks、trojan、wireguard 等) 共用的本地入口：
+// 本地 SOCKS5 监听器、Gateway 使用的内存 SOCKS5 管道、透明代理 TCP，以及透明代理 UDP 会话。
+// 各协议只需要提供 Dial (到目标的 TCP 连接) 和 UDP 会话的 PacketConn。
+type SocksRelay struct {
+	name   string
+	logger *zerolog.Logger // 指向策略的日志器，UpdateServer 替换日志器后仍然生效
+	dial   func(target string) (net.Conn, error)
+
+	listener          net.Listener
+	listenerInfo      *types.ListenerInfo
+	activeConnections atomic.Int64
+	activeConns       sync.Map // 用于追踪所有活跃的客户端连接
+	uplinkBytes       atomic.Uint64
+	downlinkBytes     atomic.Uint64
+
+	// --- UDP 会话管理 ---
+	udpSessions sync.Map // key: sessionKey, value: *udpSession
+	done        chan struct{}
+	closeOnce   sync.Once
+	wg          sync.WaitGroup
+}
+
+// udpSession 是一个客户端到隧道的 UDP 关联，所有目标共用同一个 PacketConn。
+type udpSession struct {
+	conn   net.PacketConn
+	expiry atomic.Int64 // UnixNano
+}
+
+// NewSocksRelay 创建 SocksRelay 并启动 UDP 会话清理。name 是策略类型，用于错误信息；
+// dial 经隧道建立到 host:port 的 TCP 连接，失败时应自行上报服务器故障。
+func NewSocksRelay(name string, logger *zerolog.Logger, dial func(target string) (net.Conn, error)) *SocksRelay {
+	r := &SocksRelay{
+		name:   name,
+		logger: logger,
+		dial:   dial,
+		done:   make(chan struct{}),
+	}
+	r.wg.Add(1)
+	go r.cleanupLoop()
+	return r
+}
+
+// Listen 启动一个本地 SOCKS5 监听器。
+// 这个方法主要用于移动端等传统的转发代理场景。
+func (r *SocksRelay) Listen(port int) error {
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("%s strategy failed to listen on %s: %w", r.name, addr, err)
+	}
+	r.listener = listener
+
+	tcpAddr := listener.Addr().(*net.TCPAddr)
+	r.listenerInfo = &types.ListenerInfo{
+		Address: tcpAddr.IP.String(),
+		Port:    tcpAddr.Port,
+	}
+	r.logger.Info().Str("listen_addr", listener.Addr().String()).Msg("Strategy listener started")
+
+	r.wg.Add(1)
+	go r.acceptLoop()
+	return nil
+}
+
+func (r *SocksRelay) acceptLoop() {
+	defer r.wg.Done()
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			r.logger.Debug().Err(err).Msgf("Listener on %s stopped accepting", r.listener.Addr())
+			return
+		}
+		r.activeConns.Store(conn, struct{}{})
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			defer r.activeConns.Delete(conn)
+			r.activeConnections.Add(1)
+			defer r.activeConnections.Add(-1)
+			r.handleSocksConnection(NewCountedConn(conn, &r.uplinkBytes, &r.downlinkBytes))
+		}()
+	}
+}
+
+// GetSocksConnection 为 Gateway 提供一个内存中的 SOCKS5 连接。
+func (r *SocksRelay) GetSocksConnection() (net.Conn, error) {
+	clientPipe, serverPipe := net.Pipe()
+
+	r.activeConnections.Add(1)
+	go func() {
+		defer r.activeConnections.Add(-1)
+		// 使用 CountedConn 包装 serverPipe 以进行流量统计
+		countedPipe := NewCountedConn(serverPipe, &r.uplinkBytes, &r.downlinkBytes)
+		r.handleSocksConnection(countedPipe)
+	}()
+
+	return clientPipe, nil
+}
+
+// handleSocksConnection 处理 SOCKS5 CONNECT 请求，并经 dial 建立的隧道连接转发。
+func (r *SocksRelay) handleSocksConnection(clientConn net.Conn) {
+	defer clientConn.Close()
+
+	reader := bufio.NewReader(clientConn)
+	cmd, targetAddr, err := Socks5Handshake(clientConn, reader)
+	if err != nil {
+		r.logger.Warn().Err(err).Msg("SOCKS5 handshake with client failed.")
+		return
+	}
+	if cmd != 0x01 { // 仅支持 CONNECT，UDP 通过 HandleUDPPacket 处理
+		r.logger.Warn().Uint8("cmd", cmd).Msg("Unsupported SOCKS5 command.")
+		clientConn.Write([]byte{0x05, 0x07, 0x00, 0x01, 0, 0, 0, 0, 0, 0}) // Command not supported
+		return
+	}
+
+	remoteConn, err := r.dial(targetAddr)
+	if err != nil {
+		r.logger.Error().Err(err).Str("target", targetAddr).Msgf("Failed to dial target via %s server.", r.name)
+		clientConn.Write([]byte{0x05, 0x04, 0x00, 0x01, 0, 0, 0, 0, 0, 0}) // Host unreachable
+		return
+	}
+	defer remoteConn.Close()
+
+	if _, err := clientConn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0}); err != nil {
+		r.logger.Warn().Err(err).Msg("Failed to write SOCKS5 success reply.")
+		return
+	}
+
+	Relay(clientConn, reader, remoteConn)
+}
+
+// HandleRawTCP 是处理透明代理 TCP 流量的入口。
+func (r *SocksRelay) HandleRawTCP(inboundConn net.Conn, targetDest string) {
+	defer inboundConn.Close()
+	r.activeConnections.Add(1)
+	defer r.activeConnections.Add(-1)
+
+	remoteConn, err := r.dial(targetDest)
+	if err != nil {
+		r.logger.Error().Err(err).Str("target", targetDest).Msgf("RAW: Failed to dial target via %s server.", r.name)
+		return
+	}
+	defer remoteConn.Close()
+
+	countedInbound := NewCountedConn(inboundConn, &r.uplinkBytes, &r.downlinkBytes)
+	Relay(countedInbound, countedInbound, remoteConn)
+}
+
+// SendUDP 处理透明代理的 UDP 包：按 sessionKey 复用一个到隧道的 PacketConn，把 payload 发往 dest，
+// 回复经 packet.Reply 由收到数据报的入站写回客户端。会话不存在时调用 newConn 创建；dest 为 nil 时使用 packet.Destination。
+func (r *SocksRelay) SendUDP(sessionKey string, packet *types.UDPPacket, dest net.Addr, newConn func() (net.PacketConn, error)) error {
+	if dest == nil {
+		dest = packet.Destination
+	}
+	session, err := r.getOrCreateUDPSession(sessionKey, packet, newConn)
+	if err != nil {
+		r.logger.Error().Err(err).Str("client_ip", sessionKey).Msg("[UDP] Failed to get or create session.")
+		return err
+	}
+
+	if _, err := session.conn.WriteTo(packet.Payload, dest); err != nil {
+		r.logger.Warn().Err(err).Msg("[UDP] Failed to write to server.")
+		// 连接可能已失效，删除会话，下次将重建
+		r.udpSessions.Delete(sessionKey)
+		session.conn.Close()
+		return err
+	}
+	r.uplinkBytes.Add(uint64(len(packet.Payload)))
+	return nil
+}
+
+func (r *SocksRelay) getOrCreateUDPSession(sessionKey string, packet *types.UDPPacket, newConn func() (net.PacketConn, error)) (*udpSession, error) {
+	if v, ok := r.udpSessions.Load(sessionKey); ok {
+		session := v.(*udpSession)
+		session.expiry.Store(time.Now().Add(UDPSessionTimeout).UnixNano())
+		return session, nil
+	}
+
+	if packet.Reply == nil {
+		return nil, fmt.Errorf("UDP packet from %s has no reply path", packet.Source)
+	}
+	r.logger.Debug().Str("client_ip", sessionKey).Msg("[UDP] Creating new session.")
+	conn, err := newConn()
+	if err != nil {
+		return nil, err
+	}
+
+	session := &udpSession{conn: conn}
+	session.expiry.Store(time.Now().Add(UDPSessionTimeout).UnixNano())
+	if existing, loaded := r.udpSessions.LoadOrStore(sessionKey, session); loaded {
+		session.conn.Close()
+		return existing.(*udpSession), nil
+	}
+
+	r.wg.Add(1)
+	go r.udpReplyLoop(sessionKey, session, packet.Source, packet.Reply)
+	return session, nil
+}
+
+// udpReplyLoop 读取隧道返回的数据报，经创建会话的入站发回给原始客户端，源地址为回复的来源。
+func (r *SocksRelay) udpReplyLoop(sessionKey string, session *udpSession, clientAddr net.Addr, reply types.UDPReplyFunc) {
+	defer r.wg.Done()
+	defer func() {
+		session.conn.Close()
+		r.udpSessions.CompareAndDelete(sessionKey, session)
+	}()
+
+	buf := make([]byte, 65535)
+	for {
+		session.conn.SetReadDeadline(time.Now().Add(UDPSessionTimeout + 5*time.Second))
+		n, from, err := session.conn.ReadFrom(buf)
+		if err != nil {
+			r.logger.Debug().Err(err).Str("client_ip", clientAddr.String()).Msg("[UDP] Reply loop terminating.")
+			return
+		}
+		r.downlinkBytes.Add(uint64(n))
+		if err := reply(buf[:n], from); err != nil {
+			r.logger.Warn().Err(err).Str("client_ip", clientAddr.String()).Msg("[UDP] Failed to write back to client.")
+		}
+	}
+}
+
+func (r *SocksRelay) cleanupLoop() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			r.udpSessions.Range(func(key, value interface{}) bool {
+				session := value.(*udpSession)
+				if now.UnixNano() > session.expiry.Load() {
+					r.logger.Debug().Str("client_ip", key.(string)).Msg("[UDP] Cleaning up expired session.")
+					session.conn.Close()
+					r.udpSessions.Delete(key)
+				}
+				return true
+			})
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// Close 关闭本地监听器、所有活跃连接和 UDP 会话，并等待后台 goroutine 退出。
+func (r *SocksRelay) Close() {
+	r.closeOnce.Do(func() {
+		close(r.done)
+		if r.listener != nil {
+			r.listener.Close()
+		}
+		r.activeConns.Range(func(key, value interface{}) bool {
+			if conn, ok := key.(net.Conn); ok {
+				conn.Close()
+			}
+			return true
+		})
+		r.udpSessions.Range(func(key, value interface{}) bool {
+			value.(*udpSession).conn.Close()
+			r.udpSessions.Delete(key)
+			return true
+		})
+		r.wg.Wait()
+	})
+}
+
+func (r *SocksRelay) GetListenerInfo() *types.ListenerInfo {
+	if r.listener == nil {
+		return nil // In Gateway mode, there is no listener.
+	}
+	return r.listenerInfo
+}
+
+func (r *SocksRelay) GetMetrics() *types.Metrics {
+	return &types.Metrics{ActiveConnections: r.activeConnections.Load()}
+}
+
+func (r *SocksRelay) GetTrafficStats() types.TrafficStats {
+	return types.TrafficStats{
+		Uplink:   r.uplinkBytes.Load(),
+		Downlink: r.downlinkBytes.Load(),
+	}
+}
+
+// Relay 在客户端和远程连接之间双向转发数据，reader 是客户端一侧可能已缓冲数据的读取端。
+func Relay(clientConn net.Conn, reader io.Reader, remoteConn net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		io.Copy(remoteConn, reader)
+		CloseWrite(remoteConn)
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(clientConn, remoteConn)
+		CloseWrite(clientConn)
+	}()
+
+	wg.Wait()
+}
+
+// CloseWrite 关闭连接的写方向，不支持半关闭的连接则整体关闭。
+func CloseWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		return
+	}
+	conn.Close()
+}
+
+// Socks5Handshake 与客户端完成无认证的 SOCKS5 握手，返回请求的命令和 host:port 目标。
+func Socks5Handshake(conn net.Conn, reader *bufio.Reader) (cmd byte, targetAddr string, err error) {
+	// Auth phase
+	authHeader := make([]byte, 2)
+	if _, err = io.ReadFull(reader, authHeader); err != nil {
+		return 0, "", fmt.Errorf("failed to read auth header: %w", err)
+	}
+	if authHeader[0] != 0x05 {
+		return 0, "", fmt.Errorf("unsupported socks version: %d", authHeader[0])
+	}
+	nMethods := int(authHeader[1])
+	if _, err = io.CopyN(io.Discard, reader, int64(nMethods)); err != nil {
+		return 0, "", fmt.Errorf("failed to discard auth methods: %w", err)
+	}
+	if _, err = conn.Write([]byte{0x05, 0x00}); err != nil { // Respond with NO AUTH
+		return 0, "", fmt.Errorf("failed to write auth response: %w", err)
+	}
+
+	// Request phase
+	reqHeader := make([]byte, 4)
+	if _, err = io.ReadFull(reader, reqHeader); err != nil {
+		return 0, "", fmt.Errorf("failed to read request header: %w", err)
+	}
+
+	cmd = reqHeader[1]
+	var host string
+	switch addrType := reqHeader[3]; addrType {
+	case 0x01: // IPv4
+		addr := make([]byte, 4)
+		if _, err = io.ReadFull(reader, addr); err != nil {
+			return 0, "", err
+		}
+		host = net.IP(addr).String()
+	case 0x03: // Domain
+		lenBuf := make([]byte, 1)
+		if _, err = io.ReadFull(reader, lenBuf); err != nil {
+			return 0, "", err
+		}
+		domain := make([]byte, lenBuf[0])
+		if _, err = io.ReadFull(reader, domain); err != nil {
+			return 0, "", err
+		}
+		host = string(domain)
+	case 0x04: // IPv6
+		addr := make([]byte, 16)
+		if _, err = io.ReadFull(reader, addr); err != nil {
+			return 0, "", err
+		}
+		host = net.IP(addr).String()
+	default:
+		return cmd, "", fmt.Errorf("unsupported address type: %d", addrType)
+	}
+
+	portBuf := make([]byte, 2)
+	if _, err = io.ReadFull(reader, portBuf); err != nil {
+		return 0, "", err
+	}
+	port := binary.BigEndian.Uint16(portBuf)
+
+	return cmd, net.JoinHostPort(host, strconv.Itoa(int(port))), nil
+}
@@ -0,0 +1,196 @@
+// Package testutil 汇集各隧道策略测试共用的回显服务、SOCKS5 客户端和 AppServer 替身。
+package testutil
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/proxy"
+
+	"liuproxy_nexus/internal/shared"
+	"liuproxy_nexus/internal/shared/types"
+)
+
+// StartEchoServer 启动一个 TCP 回显服务器作为代理目标，返回其地址。
+func StartEchoServer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// StartUDPEchoServer 启动一个 UDP 回显服务器作为代理目标。
+func StartUDPEchoServer(t *testing.T) *net.UDPAddr {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteToUDP(buf[:n], addr)
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr)
+}
+
+// ExpectEcho 写入 payload 并检查对端原样返回。
+func ExpectEcho(t *testing.T, conn net.Conn, payload string) {
+	t.Helper()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte(payload)); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	got := make([]byte, len(payload))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if string(got) != payload {
+		t.Fatalf("echo = %.64q, want %.64q", got, payload)
+	}
+}
+
+// NopStateManager 模拟 AppServer，忽略策略上报的故障。
+type NopStateManager struct{}
+
+func (NopStateManager) SetServerStatusDown(serverID, reason string) {}
+
+// UDPDatagram 是策略经 UDPPacket.Reply 发回的一个数据报。
+type UDPDatagram struct {
+	Payload []byte
+	From    net.Addr
+}
+
+// UDPReply 模拟透明代理入站的回复路径，收集策略发回客户端的数据报。
+type UDPReply struct {
+	datagrams chan UDPDatagram
+}
+
+func NewUDPReply() *UDPReply {
+	return &UDPReply{datagrams: make(chan UDPDatagram, 16)}
+}
+
+// Reply 实现 types.UDPReplyFunc。
+func (r *UDPReply) Reply(payload []byte, from net.Addr) error {
+	r.datagrams <- UDPDatagram{Payload: append([]byte(nil), payload...), From: from}
+	return nil
+}
+
+// Next 等待下一个回复数据报。
+func (r *UDPReply) Next(t *testing.T) UDPDatagram {
+	t.Helper()
+	select {
+	case d := <-r.datagrams:
+		return d
+	case <-time.After(5 * time.Second):
+		t.Fatal("no UDP reply")
+		return UDPDatagram{}
+	}
+}
+
+// SameUDPAddr 判断两个地址是否指向同一个 IP 和端口，IPv4 映射地址与对应的 IPv4 地址视为相同。
+func SameUDPAddr(a, b net.Addr) bool {
+	x, errX := netip.ParseAddrPort(a.String())
+	y, errY := netip.ParseAddrPort(b.String())
+	return errX == nil && errY == nil && x.Addr().Unmap() == y.Addr().Unmap() && x.Port() == y.Port()
+}
+
+// DialThroughStrategy 通过策略的 SOCKS5 管道连接 target。
+func DialThroughStrategy(t *testing.T, strategy types.TunnelStrategy, target string) net.Conn {
+	t.Helper()
+	pipeConn, err := strategy.GetSocksConnection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialer, err := proxy.SOCKS5("tcp", "placeholder-unused:1080", nil, &shared.PipeDialer{Conn: pipeConn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dialer.Dial("tcp", target)
+	if err != nil {
+		pipeConn.Close()
+		t.Fatalf("SOCKS5 CONNECT through strategy failed: %v", err)
+	}
+	return conn
+}
+
+// SOCKS5UDPAssociate 通过 SOCKS5 控制连接发起 UDP ASSOCIATE，返回中继地址。
+func SOCKS5UDPAssociate(t *testing.T, control net.Conn) *net.UDPAddr {
+	t.Helper()
+	control.SetDeadline(time.Now().Add(5 * time.Second))
+	defer control.SetDeadline(time.Time{})
+	if _, err := control.Write([]byte{0x05, 0x01, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(control, make([]byte, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := control.Write([]byte{0x05, 0x03, 0x00, 0x01, 0, 0, 0, 0, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(control, reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply[1] != 0x00 || reply[3] != 0x01 {
+		t.Fatalf("UDP ASSOCIATE reply = %x", reply)
+	}
+	return &net.UDPAddr{IP: net.IP(reply[4:8]), Port: int(binary.BigEndian.Uint16(reply[8:10]))}
+}
+
+var udpClientPorts atomic.Int32
+
+// ExpectUDPEcho 以一个本地 UDP 客户端的名义把每个 payload 交给策略的 HandleUDPPacket，发往 dest 上的回显服务，
+// 检查回复原样经 packet.Reply 返回，并且以 dest 为来源。
+func ExpectUDPEcho(t *testing.T, strategy types.TunnelStrategy, dest net.Addr, payloads ...string) {
+	t.Helper()
+	// 每次调用使用新的客户端地址，策略为它建立新的会话和回复路径
+	client := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: int(40000 + udpClientPorts.Add(1))}
+	reply := NewUDPReply()
+
+	for _, payload := range payloads {
+		packet := &types.UDPPacket{
+			Source:      client,
+			Destination: dest,
+			Payload:     []byte(payload),
+			Reply:       reply.Reply,
+		}
+		if err := strategy.HandleUDPPacket(packet, client.String()); err != nil {
+			t.Fatalf("HandleUDPPacket() error = %v", err)
+		}
+
+		d := reply.Next(t)
+		if string(d.Payload) != payload {
+			t.Errorf("UDP reply = %q, want %q", d.Payload, payload)
+		}
+		if d.From != nil && !SameUDPAddr(d.From, dest) {
+			t.Errorf("UDP reply came from %s, want %s", d.From, dest)
+		}
+	}
+}
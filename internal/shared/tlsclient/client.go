@@ -0,0 +1,188 @@
+// Package tlsclient 是各协议共用的 uTLS 客户端：浏览器指纹、随机指纹、自定义 ALPN 和 ECH 都在这里处理，
+// worker、goremote (wss) 以及 vless / trojan 的直连 TLS 都经由它完成握手。
+package tlsclient
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	utls "github.com/refraction-networking/utls"
+
+	"liuproxy_nexus/internal/shared/types"
+	"liuproxy_nexus/internal/xray_core/transport/internet/tls"
+)
+
+// Config 描述一次 TLS 握手。
+type Config struct {
+	ServerName string
+	// Fingerprint 是 uTLS 指纹: chrome、firefox、safari、ios、android、edge、360、qq、
+	// random (启动时随机选定的浏览器) 或 randomized (每次握手随机生成)。为空时与 crypto/tls 相同。
+	Fingerprint string
+	// ALPN 为空时使用指纹自带的 ALPN (crypto/tls 时不发送)
+	ALPN []string
+	// ECHConfigList 设置后只接受完成 ECH 的握手，SNI 在外层 ClientHello 中被替换为 ECH 配置中的公共名称
+	ECHConfigList []byte
+	AllowInsecure bool
+}
+
+// FromProfile 由 profile 的 TLS 字段构建配置。profile.SNI 为空时使用 serverName，profile.ALPN 为空时使用 defaultALPN。
+func FromProfile(profile *types.ServerProfile, serverName string, defaultALPN ...string) (*Config, error) {
+	config := &Config{
+		ServerName:    serverName,
+		Fingerprint:   profile.Fingerprint,
+		ALPN:          defaultALPN,
+		AllowInsecure: profile.AllowInsecure,
+	}
+	if profile.SNI != "" {
+		config.ServerName = profile.SNI
+	}
+	if len(profile.ALPN) > 0 {
+		config.ALPN = profile.ALPN
+	}
+	if profile.ECHConfig != "" {
+		echConfigList, err := base64.StdEncoding.DecodeString(strings.TrimSpace(profile.ECHConfig))
+		if err != nil {
+			return nil, fmt.Errorf("tlsclient: invalid ECH config (want base64 ECHConfigList): %w", err)
+		}
+		config.ECHConfigList = echConfigList
+	}
+	if _, err := clientHelloID(config.Fingerprint); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func clientHelloID(fingerprint string) (utls.ClientHelloID, error) {
+	if fingerprint == "" {
+		return utls.HelloGolang, nil
+	}
+	id := tls.GetFingerprint(fingerprint)
+	if id == nil {
+		return utls.ClientHelloID{}, fmt.Errorf("tlsclient: unknown fingerprint '%s'", fingerprint)
+	}
+	return *id, nil
+}
+
+// Client 在 conn 上完成 TLS 握手。失败时不关闭 conn。
+func Client(ctx context.Context, conn net.Conn, config *Config) (*utls.UConn, error) {
+	id, err := clientHelloID(config.Fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	uconfig := &utls.Config{
+		ServerName:                     config.ServerName,
+		InsecureSkipVerify:             config.AllowInsecure,
+		NextProtos:                     config.ALPN,
+		EncryptedClientHelloConfigList: config.ECHConfigList,
+	}
+	if len(config.ECHConfigList) > 0 {
+		uconfig.MinVersion = utls.VersionTLS13
+		if config.AllowInsecure {
+			// 默认会校验拒绝 ECH 时服务端公共名称的证书，不校验证书时直接返回 ECHRejectionError
+			uconfig.EncryptedClientHelloRejectionVerify = func(utls.ConnectionState) error { return nil }
+		}
+	}
+	uconn := utls.UClient(conn, uconfig, id)
+	if id != utls.HelloGolang {
+		// crypto/tls 的 ClientHello 直接使用 uconfig；浏览器指纹的扩展来自预设，需要在生成后修改
+		if err := customizeHello(uconn, config); err != nil {
+			return nil, err
+		}
+	}
+	if err := uconn.HandshakeContext(ctx); err != nil {
+		var rejection *utls.ECHRejectionError
+		if errors.As(err, &rejection) {
+			return nil, fmt.Errorf("tlsclient: server rejected ECH, the ECH config may be outdated: %w", err)
+		}
+		return nil, err
+	}
+	return uconn, nil
+}
+
+// customizeHello 按 config 改写指纹预设中的 ALPN，并确认预设带有 ECH 扩展。
+func customizeHello(uconn *utls.UConn, config *Config) error {
+	if err := uconn.BuildHandshakeState(); err != nil {
+		return err
+	}
+	if len(config.ECHConfigList) > 0 {
+		hasECH := false
+		for _, extension := range uconn.Extensions {
+			if _, ok := extension.(utls.EncryptedClientHelloExtension); ok {
+				hasECH = true
+				break
+			}
+		}
+		if !hasECH {
+			return fmt.Errorf("tlsclient: fingerprint '%s' does not support ECH", config.Fingerprint)
+		}
+	}
+	changed := dropHybridCurvesWithoutKeyShare(uconn)
+	if len(config.ALPN) > 0 {
+		hasALPN := false
+		for _, extension := range uconn.Extensions {
+			if alpn, ok := extension.(*utls.ALPNExtension); ok {
+				alpn.AlpnProtocols = config.ALPN
+				hasALPN = true
+				break
+			}
+		}
+		if !hasALPN {
+			uconn.Extensions = append(uconn.Extensions, &utls.ALPNExtension{AlpnProtocols: config.ALPN})
+		}
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	// 重新生成 ClientHello
+	return uconn.BuildHandshakeState()
+}
+
+// dropHybridCurvesWithoutKeyShare 从 supported_groups 中去掉没有附带 key share 的混合 (后量子) 曲线。
+// randomized 指纹可能只声明而不附带它们，服务端选中后会发送 HelloRetryRequest，而 uTLS 无法在重试时生成这类密钥。
+func dropHybridCurvesWithoutKeyShare(uconn *utls.UConn) bool {
+	keyShares := make(map[utls.CurveID]bool)
+	for _, extension := range uconn.Extensions {
+		if keyShare, ok := extension.(*utls.KeyShareExtension); ok {
+			for _, share := range keyShare.KeyShares {
+				keyShares[share.Group] = true
+			}
+		}
+	}
+	changed := false
+	for _, extension := range uconn.Extensions {
+		curves, ok := extension.(*utls.SupportedCurvesExtension)
+		if !ok {
+			continue
+		}
+		kept := curves.Curves[:0]
+		for _, curve := range curves.Curves {
+			hybrid := curve == utls.X25519MLKEM768 || curve == utls.X25519Kyber768Draft00
+			if hybrid && !keyShares[curve] {
+				changed = true
+				continue
+			}
+			kept = append(kept, curve)
+		}
+		curves.Curves = kept
+	}
+	return changed
+}
+
+// Dial 经 dialer 连接 addr 并完成 TLS 握手，可以直接用作 websocket.Dialer 的 NetDialTLSContext。
+func Dial(ctx context.Context, dialer types.Dialer, network, addr string, config *Config) (net.Conn, error) {
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	tlsConn, err := Client(ctx, conn, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
@@ -0,0 +1,243 @@
+package tlsclient
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"liuproxy_nexus/internal/shared/types"
+)
+
+const (
+	testServerName = "example.com"
+	testPublicName = "public.example.com"
+)
+
+func testCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: testServerName},
+		DNSNames:     []string{testServerName, testPublicName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// testECHKey 生成 DHKEM(X25519) + HKDF-SHA256 + AES-128-GCM 的 ECH 配置，返回服务端密钥和客户端使用的 ECHConfigList。
+func testECHKey(t *testing.T) (tls.EncryptedClientHelloKey, []byte) {
+	t.Helper()
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := key.PublicKey().Bytes()
+
+	var contents []byte
+	contents = append(contents, 1)                             // config_id
+	contents = binary.BigEndian.AppendUint16(contents, 0x0020) // kem_id
+	contents = binary.BigEndian.AppendUint16(contents, uint16(len(publicKey)))
+	contents = append(contents, publicKey...)
+	contents = binary.BigEndian.AppendUint16(contents, 4)      // cipher_suites
+	contents = binary.BigEndian.AppendUint16(contents, 0x0001) // kdf_id
+	contents = binary.BigEndian.AppendUint16(contents, 0x0001) // aead_id
+	contents = append(contents, 0)                             // maximum_name_length
+	contents = append(contents, byte(len(testPublicName)))
+	contents = append(contents, testPublicName...)
+	contents = binary.BigEndian.AppendUint16(contents, 0) // extensions
+
+	config := binary.BigEndian.AppendUint16(nil, 0xfe0d)
+	config = binary.BigEndian.AppendUint16(config, uint16(len(contents)))
+	config = append(config, contents...)
+
+	configList := binary.BigEndian.AppendUint16(nil, uint16(len(config)))
+	configList = append(configList, config...)
+	return tls.EncryptedClientHelloKey{Config: config, PrivateKey: key.Bytes()}, configList
+}
+
+// serverResult 是服务端看到的一次握手。
+type serverResult struct {
+	state tls.ConnectionState
+	err   error
+}
+
+// startTLSServer 启动一个回显 TLS 服务，把每次握手的结果发到返回的 channel。
+func startTLSServer(t *testing.T, config *tls.Config) (string, chan serverResult) {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	results := make(chan serverResult, 16)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				tlsConn := conn.(*tls.Conn)
+				err := tlsConn.Handshake()
+				results <- serverResult{state: tlsConn.ConnectionState(), err: err}
+				if err == nil {
+					io.Copy(conn, conn)
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String(), results
+}
+
+func dialAndEcho(t *testing.T, addr string, config *Config) (net.Conn, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := Dial(ctx, &net.Dialer{}, "tcp", addr, config)
+	if err != nil {
+		return nil, err
+	}
+	t.Cleanup(func() { conn.Close() })
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "ping" {
+		t.Fatalf("echo = %q, want %q", buf, "ping")
+	}
+	return conn, nil
+}
+
+func expectServerResult(t *testing.T, results chan serverResult) tls.ConnectionState {
+	t.Helper()
+	select {
+	case result := <-results:
+		if result.err != nil {
+			t.Fatalf("server handshake error = %v", result.err)
+		}
+		return result.state
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not see a handshake")
+	}
+	return tls.ConnectionState{}
+}
+
+func TestClientFingerprints(t *testing.T) {
+	addr, results := startTLSServer(t, &tls.Config{
+		Certificates: []tls.Certificate{testCertificate(t)},
+		NextProtos:   []string{"h2", "http/1.1"},
+	})
+
+	for _, fingerprint := range []string{"", "chrome", "firefox", "safari", "random", "randomized"} {
+		t.Run("fingerprint="+fingerprint, func(t *testing.T) {
+			config := &Config{ServerName: testServerName, Fingerprint: fingerprint, ALPN: []string{"http/1.1"}, AllowInsecure: true}
+			if _, err := dialAndEcho(t, addr, config); err != nil {
+				t.Fatalf("Dial() error = %v", err)
+			}
+			state := expectServerResult(t, results)
+			if state.ServerName != testServerName {
+				t.Errorf("server saw SNI %q, want %q", state.ServerName, testServerName)
+			}
+			// 指纹预设自带 h2，自定义 ALPN 应当替换它
+			if state.NegotiatedProtocol != "http/1.1" {
+				t.Errorf("negotiated protocol = %q, want %q", state.NegotiatedProtocol, "http/1.1")
+			}
+		})
+	}
+}
+
+func TestClientECH(t *testing.T) {
+	echKey, echConfigList := testECHKey(t)
+	addr, results := startTLSServer(t, &tls.Config{
+		Certificates:             []tls.Certificate{testCertificate(t)},
+		EncryptedClientHelloKeys: []tls.EncryptedClientHelloKey{echKey},
+	})
+
+	for _, fingerprint := range []string{"", "chrome", "firefox"} {
+		t.Run("fingerprint="+fingerprint, func(t *testing.T) {
+			config := &Config{ServerName: testServerName, Fingerprint: fingerprint, ECHConfigList: echConfigList, AllowInsecure: true}
+			if _, err := dialAndEcho(t, addr, config); err != nil {
+				t.Fatalf("Dial() error = %v", err)
+			}
+			state := expectServerResult(t, results)
+			if !state.ECHAccepted {
+				t.Error("server did not accept ECH")
+			}
+			if state.ServerName != testServerName {
+				t.Errorf("server saw inner SNI %q, want %q", state.ServerName, testServerName)
+			}
+		})
+	}
+
+	t.Run("rejected", func(t *testing.T) {
+		// 服务端不认识的 ECH 配置: 握手必须失败，不能退回明文 SNI
+		_, otherConfigList := testECHKey(t)
+		config := &Config{ServerName: testServerName, ECHConfigList: otherConfigList, AllowInsecure: true}
+		_, err := dialAndEcho(t, addr, config)
+		if err == nil || !strings.Contains(err.Error(), "rejected ECH") {
+			t.Fatalf("Dial() error = %v, want ECH rejection", err)
+		}
+		<-results
+	})
+}
+
+func TestFromProfile(t *testing.T) {
+	profile := &types.ServerProfile{Fingerprint: "chrome"}
+	config, err := FromProfile(profile, "server.example.com", "http/1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ServerName != "server.example.com" || strings.Join(config.ALPN, ",") != "http/1.1" {
+		t.Errorf("FromProfile() = %+v, want defaults", config)
+	}
+
+	_, echConfigList := testECHKey(t)
+	profile = &types.ServerProfile{
+		SNI:       "sni.example.com",
+		ALPN:      []string{"h2"},
+		ECHConfig: base64.StdEncoding.EncodeToString(echConfigList),
+	}
+	config, err = FromProfile(profile, "server.example.com", "http/1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ServerName != "sni.example.com" || strings.Join(config.ALPN, ",") != "h2" || string(config.ECHConfigList) != string(echConfigList) {
+		t.Errorf("FromProfile() = %+v, want profile overrides", config)
+	}
+
+	if _, err := FromProfile(&types.ServerProfile{Fingerprint: "netscape"}, "server.example.com"); err == nil {
+		t.Error("FromProfile() accepted an unknown fingerprint")
+	}
+	if _, err := FromProfile(&types.ServerProfile{ECHConfig: "not base64!"}, "server.example.com"); err == nil {
+		t.Error("FromProfile() accepted an invalid ECH config")
+	}
+}
@@ -6,11 +6,14 @@ type ServerProfile struct {
 	// --- 通用字段 ---
 	ID      string `json:"id"`      // 唯一标识符 (例如 UUID)，由Dashboard生成和管理
 	Remarks string `json:"remarks"` // 用户备注
-	Type    string `json:"type"`    // 服务器类型: "goremote", "worker", "vless", "http"
+	Type    string `json:"type"`    // 服务器类型: "goremote", "worker", "vless", "http", "shadowsocks", "trojan", "wireguard"
 	Active  bool   `json:"active"`  // 是否加入默认的HAProxy负载均衡池
 
 	LocalPort int `json:"localPort,omitempty"`
 
+	// Via 指定前置服务器 (ID 或 remarks)，本服务器的连接将经由它建立，例如经上游 HTTP 代理连接 vless。
+	Via string `json:"via,omitempty"`
+
 	// --- HTTP 代理专属参数 ---
 	// ProxyProtocol specifies the protocol to use when connecting to the upstream proxy.
 	// For "http" type servers. Can be "http" (default) or "socks5".
@@ -23,16 +26,29 @@ type ServerProfile struct {
 	Port    int    `json:"port"`    // 服务器端口
 
 	// --- 传输层协议 ---
-	Network string `json:"network,omitempty"` // 传输协议: "ws", "grpc" 等, vless专属
+	Network string `json:"network,omitempty"` // 传输协议: "ws", "grpc", "tcp" 等, vless/trojan 使用
 
 	// --- WebSocket 参数 (用于 goremote, worker, vless+ws) ---
 	Scheme string `json:"scheme,omitempty"` // "ws" or "wss"
 	Path   string `json:"path,omitempty"`   // WebSocket路径, e.g., "/tunnel"
 	Host   string `json:"host,omitempty"`   // WebSocket Host请求头, 用于CDN等场景
+	// EarlyDataDelay 使用早期数据 (路径带 ed=N 参数) 时等待后续写入的最长时间 (毫秒)，
+	// 0 表示第一次写入后立即发出升级请求
+	EarlyDataDelay int `json:"earlyDataDelay,omitempty"`
+
+	// --- Shadowsocks 专属参数 (密码复用上面的 Password 字段) ---
+	Method string `json:"method,omitempty"` // 加密方法: AEAD (如 aes-256-gcm) 或 2022-blake3-*
 
 	// --- GoRemote 专属参数 ---
 	Transport string `json:"transport,omitempty"` // 新增: "tcp" (默认) 或 "ws"
-	Multiplex bool   `json:"multiplex,omitempty"` // 新增: 是否启用多路复用
+
+	// --- GoRemote / Worker 多路复用参数 ---
+	Multiplex   bool `json:"multiplex,omitempty"`   // 是否启用多路复用 (Worker 需要支持多个 StreamID 的 Worker 脚本)
+	MuxSessions int  `json:"muxSessions,omitempty"` // 多路复用时保持的会话 (物理连接) 数，默认 2
+
+	// --- GoRemote / Worker 加密参数 ---
+	PSK         string `json:"psk,omitempty"`         // 预共享密钥 (base64, 32 字节)，每条连接经 HKDF 和随机 salt 派生密钥
+	LegacyCrypt bool   `json:"legacyCrypt,omitempty"` // 未配置 psk 时使用 liuproxy.ini 中的整数 crypt (仅用于旧版服务器)
 
 	// --- gRPC 参数 (vless+grpc) ---
 	GrpcServiceName string `json:"grpcServiceName"`
@@ -40,16 +56,35 @@ type ServerProfile struct {
 	GrpcAuthority   string `json:"grpcAuthority"`
 
 	// --- Worker 专属参数 ---
-	EdgeIP string `json:"edgeIP,omitempty"` // Worker优选IP
+	EdgeIP string `json:"edgeIP,omitempty"` // Worker优选IP: 单个 IP，或逗号分隔的 IP / CIDR 列表 (自动测速并竞速拨号)
+
+	// --- TLS 参数 (vless / trojan，以及 wss 的 worker / goremote) ---
+	SNI         string   `json:"sni,omitempty"`         // TLS SNI，为空时使用服务器地址
+	Fingerprint string   `json:"fingerprint,omitempty"` // uTLS 指纹: chrome, firefox, safari, ios, android, edge, 360, qq, random, randomized
+	ALPN        []string `json:"alpn,omitempty"`        // 为空时使用传输层的默认值 (WebSocket 为 http/1.1)
+	// ECHConfig 是 base64 编码的 ECHConfigList (例如 DNS HTTPS 记录中的 ech 参数)。
+	// vless / trojan 的 ws 和 grpc 传输由 xray 处理 TLS，不支持 ECH
+	ECHConfig string `json:"echConfig,omitempty"`
 
 	// --- VLESS 专属参数  ---
-	UUID        string `json:"uuid,omitempty"`
-	Flow        string `json:"flow,omitempty"`
-	Security    string `json:"security,omitempty"` // "tls" or "reality"
-	SNI         string `json:"sni,omitempty"`      // TLS SNI
-	Fingerprint string `json:"fingerprint,omitempty"`
-	PublicKey   string `json:"publicKey,omitempty"` // REALITY公钥
-	ShortID     string `json:"shortId,omitempty"`   // REALITY ShortID
+	UUID      string `json:"uuid,omitempty"`
+	Flow      string `json:"flow,omitempty"`      // "" 或 "xtls-rprx-vision" (仅 tcp + tls/reality)
+	Security  string `json:"security,omitempty"`  // "tls" or "reality" (reality 仅用于 tcp)
+	PublicKey string `json:"publicKey,omitempty"` // REALITY公钥 / WireGuard 对端公钥
+	ShortID   string `json:"shortId,omitempty"`   // REALITY ShortID
+	// PacketEncoding UDP 封装方式: "" 每个目标一条命令 0x02 连接, "xudp" 所有目标复用一条 mux.cool 连接
+	PacketEncoding string `json:"packetEncoding,omitempty"`
+
+	// --- WireGuard 专属参数 (对端公钥复用 PublicKey，Address/Port 为对端 Endpoint) ---
+	PrivateKey   string   `json:"privateKey,omitempty"`   // 本端私钥 (base64)
+	PreSharedKey string   `json:"preSharedKey,omitempty"` // 可选的预共享密钥 (base64)
+	LocalAddress []string `json:"localAddress,omitempty"` // 隧道内本端地址, e.g. "10.0.0.2/32"
+	AllowedIPs   []string `json:"allowedIPs,omitempty"`   // 经对端转发的网段，默认 0.0.0.0/0 和 ::/0
+	MTU          int      `json:"mtu,omitempty"`          // 隧道 MTU，默认 1420
+	DNS          []string `json:"dns,omitempty"`          // 隧道内解析域名使用的 DNS 服务器，为空时在本地解析
+
+	// AllowInsecure 跳过 TLS 证书校验 (自签名证书)，用于 trojan 和 vless+tcp+tls
+	AllowInsecure bool `json:"allowInsecure,omitempty"`
 }
 
 // CommonConf 包含共有的配置
@@ -63,10 +98,24 @@ type CommonConf struct {
 // LocalConf 包含local模式特有的配置
 type LocalConf struct {
 	UnifiedPort int    `ini:"unified_port"`
-	TProxyPort  int    `ini:"tproxy_port"` // <-- 新增
+	TProxyPort  int    `ini:"tproxy_port"`  // <-- 新增
+	TProxySniff bool   `ini:"tproxy_sniff"` // 透明代理 TCP 是否用嗅探到的域名覆盖 IP 目标
 	WebPort     int    `ini:"web_port"`
 	WebUser     string `ini:"web_user"`
 	WebPassword string `ini:"web_password"`
+
+	// 出站 socket 选项，作用于所有策略和 DIRECT 的出站连接
+	OutboundMark      int    `ini:"outbound_mark"`      // SO_MARK，透明代理规则会跳过带此标记的流量
+	OutboundInterface string `ini:"outbound_interface"` // SO_BINDTODEVICE
+	OutboundSourceIP  string `ini:"outbound_source_ip"` // 出站源地址
+}
+
+// RemoteConf 包含 remote 模式 (goremote 服务器) 特有的配置
+type RemoteConf struct {
+	Listen      string `ini:"listen"`       // TCP、WebSocket 和 UDP 共用的监听地址, e.g. ":9200"
+	WSPath      string `ini:"ws_path"`      // WebSocket 路径，默认 "/"
+	PSK         string `ini:"psk"`          // base64 编码的 32 字节预共享密钥
+	LegacyCrypt bool   `ini:"legacy_crypt"` // 未配置 psk 时使用 [common] crypt 整数密钥，兼容旧客户端
 }
 
 // LogConf contains logging specific configuration
@@ -86,6 +135,7 @@ type ProxyPoolConf struct {
 type Config struct {
 	CommonConf    `ini:"common"`
 	LocalConf     `ini:"local"`
+	RemoteConf    `ini:"remote"`
 	LogConf       `ini:"log"`
 	ProxyPoolConf `ini:"proxypool"`
 }
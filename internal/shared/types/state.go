@@ -8,6 +8,25 @@ type ListenerInfo struct {
 
 // Metrics holds the runtime performance metrics of a strategy instance.
 type Metrics struct {
-	ActiveConnections int64 `json:"activeConnections"`
-	Latency           int64 `json:"latency"` // Latency in milliseconds (-1 for unknown/failed)
+	ActiveConnections int64        `json:"activeConnections"`
+	Latency           int64        `json:"latency"`           // Latency in milliseconds (-1 for unknown/failed)
+	Mux               *MuxMetrics  `json:"mux,omitempty"`     // 仅使用多路复用会话池的策略提供
+	EdgeIPs           []EdgeIPStat `json:"edgeIPs,omitempty"` // Worker 配置多个 Edge IP 时的优选排序
+}
+
+// EdgeIPStat 是一个候选 Edge IP 最近一次测量的 TCP+TLS 握手延迟。
+type EdgeIPStat struct {
+	IP      string `json:"ip"`
+	Latency int64  `json:"latency"` // 毫秒，-1 表示失败
+}
+
+// MuxMetrics 描述多路复用会话池的状态。
+type MuxMetrics struct {
+	Sessions         int   `json:"sessions"`         // 接收新流的会话数
+	DrainingSessions int   `json:"drainingSessions"` // 已退役、等待流结束的会话数
+	ActiveStreams    int   `json:"activeStreams"`    // 当前打开的流数
+	TotalStreams     int64 `json:"totalStreams"`     // 累计打开的流数
+	SessionsCreated  int64 `json:"sessionsCreated"`  // 累计建立的会话数
+	SessionsRetired  int64 `json:"sessionsRetired"`  // 达到最大存活时间或流数而退役的会话数
+	SessionsFailed   int64 `json:"sessionsFailed"`   // keepalive 超时或连接断开的会话数
 }
@@ -25,16 +25,28 @@ type TrafficStats struct {
 // UDPPacket 定义了在模块间传递的 UDP 数据包结构
 type UDPPacket struct {
 	Source      net.Addr
-	Destination net.Addr
+	Destination net.Addr // 客户端发往的原始目标
 	Payload     []byte
+	// Reply 把回复发回 Source，由收到该数据报的入站提供。from 是回复的来源 (远端目标)，
+	// TPROXY 入站以它为源地址发送；为 nil 时使用 Destination。
+	Reply UDPReplyFunc
 }
 
+// UDPReplyFunc 把来自 from 的 payload 发回透明代理的客户端。
+type UDPReplyFunc func(payload []byte, from net.Addr) error
+
 // TunnelBuilder 接口定义了一个可以提供SOCKS5连接的实体。
 // 这是 Gateway 和 Strategy 之间新的、解耦的交互方式。
 type TunnelBuilder interface {
 	GetSocksConnection() (net.Conn, error)
 }
 
+// Dialer 是策略连接其远程服务器时使用的拨号器。默认实现直接连接网络 (outbound.System)，
+// 当 ServerProfile 声明了 via 时，则经由另一个策略实例建立连接，从而实现链式代理。
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
 // StateManager 定义了一个可以直接修改服务器健康状态的接口
 type StateManager interface {
 	SetServerStatusDown(serverID, reason string)
@@ -91,6 +103,39 @@ type Dispatcher interface {
 	Dispatch(ctx context.Context, source net.Addr, target string) (TunnelStrategy, string, error)
 }
 
+type inboundTagKey struct{}
+
+// WithInboundTag 将流量进入的监听器标签附加到 context 上，供 Dispatcher 的 inbound_tag 规则匹配。
+func WithInboundTag(ctx context.Context, tag string) context.Context {
+	return context.WithValue(ctx, inboundTagKey{}, tag)
+}
+
+// SniffInfo 是嗅探阶段从流量中提取的附加信息，随 context 传入 Dispatcher 用于规则匹配。
+type SniffInfo struct {
+	ServerName  string   // TLS SNI
+	ALPN        []string // TLS ALPN，按客户端偏好排序
+	TLSVersions []uint16 // ClientHello 中 supported_versions 扩展声明的版本
+}
+
+type sniffInfoKey struct{}
+
+// WithSniffInfo 将嗅探结果附加到 context 上。
+func WithSniffInfo(ctx context.Context, info *SniffInfo) context.Context {
+	return context.WithValue(ctx, sniffInfoKey{}, info)
+}
+
+// SniffInfoFromContext 返回 context 中的嗅探结果，没有则返回 nil。
+func SniffInfoFromContext(ctx context.Context) *SniffInfo {
+	info, _ := ctx.Value(sniffInfoKey{}).(*SniffInfo)
+	return info
+}
+
+// InboundTagFromContext 返回 context 中的监听器标签，没有则返回空字符串。
+func InboundTagFromContext(ctx context.Context) string {
+	tag, _ := ctx.Value(inboundTagKey{}).(string)
+	return tag
+}
+
 // ProxyPoolStatusItem extends ProxyInfo with its current usage status within the application.
 type ProxyPoolStatusItem struct {
 	model.ProxyInfo
@@ -0,0 +1,610 @@
+// FILE: internal/sys/netfilter/netfilter.go
+package netfilter
+
+import (
+	"errors"
+	"fmt"
+	"liuproxy_nexus/internal/shared/logger"
+	"liuproxy_nexus/internal/shared/settings"
+	"net"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultFwMark     = 1
+	defaultRouteTable = 100
+
+	backendAuto     = "auto"
+	backendNftables = "nftables"
+	backendIptables = "iptables"
+)
+
+// commandRunner 执行外部命令，抽象出来以便在不同平台上替换实现。
+type commandRunner interface {
+	Run(stdin, name string, args ...string) (string, error)
+	LookPath(name string) bool
+}
+
+// ruleConfig 是经过校验和补全默认值之后的 NetfilterSettings。
+type ruleConfig struct {
+	mode     settings.NetfilterMode
+	port     int
+	tcp      bool
+	udp      bool
+	ipv6     bool
+	mss      bool
+	exclude4 []string
+	exclude6 []string
+	mark     int
+	table    int
+	bypass   int // 本程序出站连接的 SO_MARK，带此标记的包不再被重定向，0 表示不排除
+}
+
+// captureOutput 表示是否同时捕获本机发出的流量。本程序自己的连接只能通过 bypass 标记区分，
+// 未设置 outbound_mark 时捕获 OUTPUT 会让出站连接再次被重定向回本程序，因此不捕获。
+func (c *ruleConfig) captureOutput() bool {
+	return c.bypass != 0
+}
+
+func (c *ruleConfig) protocols() []string {
+	var protos []string
+	if c.tcp {
+		protos = append(protos, "tcp")
+	}
+	if c.udp {
+		protos = append(protos, "udp")
+	}
+	return protos
+}
+
+// backend 是一种规则安装方式 (nftables 或 iptables)。install 必须是幂等的：
+// 先清理本程序之前安装的规则 (包括上次异常退出遗留的)，再重新安装。
+type backend interface {
+	name() string
+	install(cfg *ruleConfig) error
+	remove() error
+	ruleset() (string, error)
+}
+
+// Status 描述当前由进程安装的规则，供 Web API 展示。
+type Status struct {
+	Enabled bool   `json:"enabled"`
+	Active  bool   `json:"active"`
+	Backend string `json:"backend,omitempty"`
+	Mode    string `json:"mode,omitempty"`
+	Port    int    `json:"port,omitempty"`
+	Ruleset string `json:"ruleset,omitempty"` // 从内核读回的实时规则
+	Error   string `json:"error,omitempty"`   // 最近一次应用配置的错误
+}
+
+// Manager 根据 "netfilter" 设置模块安装和清理透明代理所需的重定向/TPROXY 规则及策略路由。
+type Manager struct {
+	mu          sync.Mutex
+	defaultPort int
+	bypassMark  int
+	run         commandRunner
+	forward     func(ipv6 bool) error // 打开内核 IP 转发
+
+	enabled bool
+	active  backend // 为 nil 表示当前没有安装规则
+	applied *ruleConfig
+	lastErr error
+}
+
+// NewManager 创建规则管理器。defaultPort 为设置中未指定端口时使用的透明代理端口 (liuproxy.ini 的 tproxy_port)，
+// bypassMark 为出站连接的 fwmark (liuproxy.ini 的 outbound_mark)。设置后规则还会捕获本机发出的流量 (OUTPUT)，
+// PREROUTING 和 OUTPUT 都跳过带此标记的流量，使本程序自己的出站连接不会被重新捕获形成环路。
+func NewManager(defaultPort, bypassMark int) *Manager {
+	return &Manager{defaultPort: defaultPort, bypassMark: bypassMark, run: newCommandRunner(), forward: enableForwarding}
+}
+
+// OnSettingsUpdate 实现了 settings.ConfigurableModule 接口，用于热更新规则。
+func (m *Manager) OnSettingsUpdate(moduleKey string, newSettings interface{}) error {
+	if moduleKey != "netfilter" {
+		return nil
+	}
+	cfg, ok := newSettings.(*settings.NetfilterSettings)
+	if !ok {
+		return fmt.Errorf("netfilter: received incorrect settings type")
+	}
+	return m.Apply(cfg)
+}
+
+// Apply 按照配置重新安装规则。未启用时只清理已安装的规则。
+func (m *Manager) Apply(cfg *settings.NetfilterSettings) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.enabled = cfg != nil && cfg.Enabled
+	err := m.apply(cfg)
+	m.lastErr = err
+	return err
+}
+
+func (m *Manager) apply(cfg *settings.NetfilterSettings) error {
+	if err := m.teardown(); err != nil {
+		return err
+	}
+	if cfg == nil || !cfg.Enabled {
+		return nil
+	}
+
+	rc, err := m.normalize(cfg)
+	if err != nil {
+		return err
+	}
+	b, err := m.selectBackend(cfg.Backend)
+	if err != nil {
+		return err
+	}
+
+	if err := b.install(rc); err != nil {
+		b.remove()
+		return fmt.Errorf("netfilter: failed to install %s rules: %w", b.name(), err)
+	}
+	if rc.mode == settings.NetfilterTProxy {
+		if err := installPolicyRouting(m.run, rc); err != nil {
+			removePolicyRouting(m.run, rc)
+			b.remove()
+			return fmt.Errorf("netfilter: failed to install policy routing: %w", err)
+		}
+	}
+	if err := m.forward(rc.ipv6); err != nil {
+		logger.Warn().Err(err).Msg("netfilter: failed to enable IP forwarding")
+	}
+
+	m.active, m.applied = b, rc
+	logger.Info().Str("backend", b.name()).Str("mode", string(rc.mode)).Int("port", rc.port).
+		Msg("netfilter: transparent proxy rules installed")
+	return nil
+}
+
+// teardown 清理当前安装的规则，调用方需持有 m.mu。
+func (m *Manager) teardown() error {
+	if m.active == nil {
+		return nil
+	}
+	var errs []error
+	if err := m.active.remove(); err != nil {
+		errs = append(errs, fmt.Errorf("netfilter: failed to remove %s rules: %w", m.active.name(), err))
+	}
+	if m.applied.mode == settings.NetfilterTProxy {
+		if err := removePolicyRouting(m.run, m.applied); err != nil {
+			errs = append(errs, fmt.Errorf("netfilter: failed to remove policy routing: %w", err))
+		}
+	}
+	logger.Info().Str("backend", m.active.name()).Msg("netfilter: transparent proxy rules removed")
+	m.active, m.applied = nil, nil
+	return errors.Join(errs...)
+}
+
+// Close 清理所有已安装的规则，在 AppServer 停止时调用。
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.teardown()
+}
+
+// Status 返回当前状态以及从内核读回的实时规则。
+func (m *Manager) Status() *Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st := &Status{Enabled: m.enabled}
+	if m.lastErr != nil {
+		st.Error = m.lastErr.Error()
+	}
+	if m.active == nil {
+		return st
+	}
+	st.Active = true
+	st.Backend = m.active.name()
+	st.Mode = string(m.applied.mode)
+	st.Port = m.applied.port
+
+	var sb strings.Builder
+	if rules, err := m.active.ruleset(); err != nil {
+		fmt.Fprintf(&sb, "# failed to list %s rules: %v\n", m.active.name(), err)
+	} else {
+		sb.WriteString(rules)
+	}
+	if m.applied.mode == settings.NetfilterTProxy {
+		sb.WriteString(policyRoutingState(m.run, m.applied))
+	}
+	st.Ruleset = sb.String()
+	return st
+}
+
+func (m *Manager) normalize(cfg *settings.NetfilterSettings) (*ruleConfig, error) {
+	rc := &ruleConfig{
+		mode:   cfg.Mode,
+		port:   cfg.Port,
+		tcp:    cfg.TCP,
+		udp:    cfg.UDP,
+		ipv6:   cfg.IPv6,
+		mss:    cfg.MSSClamping,
+		mark:   cfg.FwMark,
+		table:  cfg.RouteTable,
+		bypass: m.bypassMark,
+	}
+	if rc.mode == "" {
+		rc.mode = settings.NetfilterRedirect
+	}
+	if rc.mode != settings.NetfilterRedirect && rc.mode != settings.NetfilterTProxy {
+		return nil, fmt.Errorf("netfilter: unsupported mode '%s'", rc.mode)
+	}
+	if rc.port == 0 {
+		rc.port = m.defaultPort
+	}
+	if rc.port <= 0 || rc.port > 65535 {
+		return nil, fmt.Errorf("netfilter: invalid transparent proxy port %d", rc.port)
+	}
+	if !rc.tcp && !rc.udp {
+		return nil, errors.New("netfilter: neither tcp nor udp is enabled")
+	}
+	if rc.mark == 0 {
+		rc.mark = defaultFwMark
+	}
+	if rc.table == 0 {
+		rc.table = defaultRouteTable
+	}
+	if rc.mode == settings.NetfilterTProxy && rc.bypass == rc.mark {
+		return nil, fmt.Errorf("netfilter: fwmark 0x%x conflicts with outbound_mark", rc.mark)
+	}
+
+	for _, cidr := range cfg.ExcludedCIDRs {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("netfilter: invalid excluded CIDR '%s': %w", cidr, err)
+		}
+		if ipNet.IP.To4() != nil {
+			rc.exclude4 = append(rc.exclude4, ipNet.String())
+		} else {
+			rc.exclude6 = append(rc.exclude6, ipNet.String())
+		}
+	}
+	return rc, nil
+}
+
+func (m *Manager) selectBackend(name string) (backend, error) {
+	nft := &nftBackend{run: m.run}
+	ipt := &iptablesBackend{run: m.run}
+	switch name {
+	case "", backendAuto:
+		if m.run.LookPath("nft") {
+			return nft, nil
+		}
+		if m.run.LookPath("iptables") {
+			return ipt, nil
+		}
+		return nil, errors.New("netfilter: neither nft nor iptables is available")
+	case backendNftables:
+		if !m.run.LookPath("nft") {
+			return nil, errors.New("netfilter: nft is not available")
+		}
+		return nft, nil
+	case backendIptables:
+		if !m.run.LookPath("iptables") {
+			return nil, errors.New("netfilter: iptables is not available")
+		}
+		return ipt, nil
+	default:
+		return nil, fmt.Errorf("netfilter: unknown backend '%s'", name)
+	}
+}
+
+// --- nftables ---
+
+const nftTable = "liuproxy"
+
+type nftBackend struct {
+	run commandRunner
+}
+
+func (b *nftBackend) name() string { return backendNftables }
+
+// install 以单个事务提交整个表：先建空表再删除，保证无论表是否存在都能从干净状态重建。
+func (b *nftBackend) install(cfg *ruleConfig) error {
+	_, err := b.run.Run(nftResetScript()+buildNftRuleset(cfg), "nft", "-f", "-")
+	return err
+}
+
+func (b *nftBackend) remove() error {
+	_, err := b.run.Run(nftResetScript(), "nft", "-f", "-")
+	return err
+}
+
+func (b *nftBackend) ruleset() (string, error) {
+	return b.run.Run("", "nft", "list", "table", "inet", nftTable)
+}
+
+func nftResetScript() string {
+	return fmt.Sprintf("table inet %s {}\ndelete table inet %s\n", nftTable, nftTable)
+}
+
+// buildNftRuleset 生成 inet 表定义，同时覆盖 IPv4 和 IPv6。
+func buildNftRuleset(cfg *ruleConfig) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "table inet %s {\n", nftTable)
+	if len(cfg.exclude4) > 0 {
+		fmt.Fprintf(&sb, "\tset bypass4 {\n\t\ttype ipv4_addr\n\t\tflags interval\n\t\telements = { %s }\n\t}\n",
+			strings.Join(cfg.exclude4, ", "))
+	}
+	if cfg.ipv6 && len(cfg.exclude6) > 0 {
+		fmt.Fprintf(&sb, "\tset bypass6 {\n\t\ttype ipv6_addr\n\t\tflags interval\n\t\telements = { %s }\n\t}\n",
+			strings.Join(cfg.exclude6, ", "))
+	}
+
+	sb.WriteString("\tchain prerouting {\n")
+	if cfg.mode == settings.NetfilterTProxy {
+		sb.WriteString("\t\ttype filter hook prerouting priority mangle; policy accept;\n")
+	} else {
+		sb.WriteString("\t\ttype nat hook prerouting priority dstnat; policy accept;\n")
+	}
+	if cfg.bypass != 0 {
+		fmt.Fprintf(&sb, "\t\tmeta mark 0x%x return\n", cfg.bypass)
+	}
+	writeNftExcludes(&sb, cfg)
+	for _, proto := range cfg.protocols() {
+		if cfg.mode == settings.NetfilterTProxy {
+			fmt.Fprintf(&sb, "\t\tmeta l4proto %s tproxy to :%d meta mark set 0x%x accept\n", proto, cfg.port, cfg.mark)
+		} else {
+			fmt.Fprintf(&sb, "\t\tmeta l4proto %s redirect to :%d\n", proto, cfg.port)
+		}
+	}
+	sb.WriteString("\t}\n")
+
+	// 本机发出的流量不经过 PREROUTING。TPROXY 模式下打上 fwmark 使其经策略路由绕回 lo，
+	// 再由 prerouting 链交给透明监听 socket；REDIRECT 模式下直接重定向。
+	if cfg.captureOutput() {
+		sb.WriteString("\tchain output {\n")
+		if cfg.mode == settings.NetfilterTProxy {
+			sb.WriteString("\t\ttype route hook output priority mangle; policy accept;\n")
+		} else {
+			sb.WriteString("\t\ttype nat hook output priority dstnat; policy accept;\n")
+		}
+		fmt.Fprintf(&sb, "\t\tmeta mark 0x%x return\n", cfg.bypass)
+		// 透明监听 socket 发回给客户端的包：TCP 属于被代理连接的回复方向，UDP 从监听端口发出
+		if cfg.mode == settings.NetfilterTProxy {
+			sb.WriteString("\t\tct direction reply return\n")
+		}
+		for _, proto := range cfg.protocols() {
+			fmt.Fprintf(&sb, "\t\t%s sport %d return\n", proto, cfg.port)
+		}
+		sb.WriteString("\t\tfib daddr type local return\n")
+		writeNftExcludes(&sb, cfg)
+		for _, proto := range cfg.protocols() {
+			if cfg.mode == settings.NetfilterTProxy {
+				fmt.Fprintf(&sb, "\t\tmeta l4proto %s meta mark set 0x%x\n", proto, cfg.mark)
+			} else {
+				fmt.Fprintf(&sb, "\t\tmeta l4proto %s redirect to :%d\n", proto, cfg.port)
+			}
+		}
+		sb.WriteString("\t}\n")
+	}
+
+	if cfg.mss && cfg.tcp {
+		sb.WriteString("\tchain forward {\n")
+		sb.WriteString("\t\ttype filter hook forward priority mangle; policy accept;\n")
+		sb.WriteString("\t\ttcp flags syn tcp option maxseg size set rt mtu\n")
+		sb.WriteString("\t}\n")
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+// writeNftExcludes 写入跳过 IPv6 (未启用时) 和排除网段的规则。
+func writeNftExcludes(sb *strings.Builder, cfg *ruleConfig) {
+	if !cfg.ipv6 {
+		sb.WriteString("\t\tmeta nfproto ipv6 return\n")
+	}
+	if len(cfg.exclude4) > 0 {
+		sb.WriteString("\t\tip daddr @bypass4 return\n")
+	}
+	if cfg.ipv6 && len(cfg.exclude6) > 0 {
+		sb.WriteString("\t\tip6 daddr @bypass6 return\n")
+	}
+}
+
+// --- iptables ---
+
+const (
+	iptChainPrerouting = "LIUPROXY_PREROUTING"
+	iptChainOutput     = "LIUPROXY_OUTPUT"
+	iptChainForward    = "LIUPROXY_FORWARD"
+)
+
+// iptJumps 是本程序在内置链上挂载的跳转。无论当前是哪种模式，清理时都会尝试移除全部跳转。
+var iptJumps = []struct{ table, hook, chain string }{
+	{"nat", "PREROUTING", iptChainPrerouting},
+	{"mangle", "PREROUTING", iptChainPrerouting},
+	{"nat", "OUTPUT", iptChainOutput},
+	{"mangle", "OUTPUT", iptChainOutput},
+	{"mangle", "FORWARD", iptChainForward},
+}
+
+type iptablesBackend struct {
+	run commandRunner
+}
+
+func (b *iptablesBackend) name() string { return backendIptables }
+
+func (b *iptablesBackend) binaries() []string {
+	bins := []string{"iptables"}
+	if b.run.LookPath("ip6tables") {
+		bins = append(bins, "ip6tables")
+	}
+	return bins
+}
+
+func (b *iptablesBackend) install(cfg *ruleConfig) error {
+	if err := b.remove(); err != nil {
+		return err
+	}
+	for _, bin := range b.binaries() {
+		if bin == "ip6tables" && !cfg.ipv6 {
+			continue
+		}
+		for _, args := range buildIptablesCommands(cfg, bin == "ip6tables") {
+			if _, err := b.run.Run("", bin, args...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// remove 删除所有跳转和自定义链。链不存在时 iptables 会报错，这里忽略这类错误以保证幂等。
+func (b *iptablesBackend) remove() error {
+	for _, bin := range b.binaries() {
+		for _, j := range iptJumps {
+			// 同一跳转可能被重复添加，循环删除直到不存在
+			for i := 0; i < 16; i++ {
+				if _, err := b.run.Run("", bin, "-t", j.table, "-D", j.hook, "-j", j.chain); err != nil {
+					break
+				}
+			}
+			b.run.Run("", bin, "-t", j.table, "-F", j.chain)
+			b.run.Run("", bin, "-t", j.table, "-X", j.chain)
+		}
+	}
+	return nil
+}
+
+func (b *iptablesBackend) ruleset() (string, error) {
+	var sb strings.Builder
+	for _, bin := range b.binaries() {
+		for _, j := range iptJumps {
+			out, err := b.run.Run("", bin, "-t", j.table, "-S", j.chain)
+			if err != nil {
+				continue // 该模式下没有使用这个链
+			}
+			fmt.Fprintf(&sb, "# %s -t %s\n%s", bin, j.table, out)
+		}
+	}
+	return sb.String(), nil
+}
+
+// buildIptablesCommands 生成单个地址族的 iptables 参数列表。
+func buildIptablesCommands(cfg *ruleConfig, ipv6 bool) [][]string {
+	excludes := cfg.exclude4
+	if ipv6 {
+		excludes = cfg.exclude6
+	}
+	table := "nat"
+	if cfg.mode == settings.NetfilterTProxy {
+		table = "mangle"
+	}
+
+	cmds := [][]string{{"-t", table, "-N", iptChainPrerouting}}
+	if cfg.bypass != 0 {
+		cmds = append(cmds, []string{"-t", table, "-A", iptChainPrerouting,
+			"-m", "mark", "--mark", fmt.Sprintf("0x%x", cfg.bypass), "-j", "RETURN"})
+	}
+	for _, cidr := range excludes {
+		cmds = append(cmds, []string{"-t", table, "-A", iptChainPrerouting, "-d", cidr, "-j", "RETURN"})
+	}
+	for _, proto := range cfg.protocols() {
+		if cfg.mode == settings.NetfilterTProxy {
+			mark := fmt.Sprintf("0x%x/0x%x", cfg.mark, cfg.mark)
+			cmds = append(cmds, []string{"-t", table, "-A", iptChainPrerouting, "-p", proto,
+				"-j", "TPROXY", "--on-port", fmt.Sprint(cfg.port), "--tproxy-mark", mark})
+		} else {
+			cmds = append(cmds, []string{"-t", table, "-A", iptChainPrerouting, "-p", proto,
+				"-j", "REDIRECT", "--to-ports", fmt.Sprint(cfg.port)})
+		}
+	}
+	cmds = append(cmds, []string{"-t", table, "-A", "PREROUTING", "-j", iptChainPrerouting})
+
+	// 本机发出的流量，见 buildNftRuleset 中的 output 链
+	if cfg.captureOutput() {
+		cmds = append(cmds,
+			[]string{"-t", table, "-N", iptChainOutput},
+			[]string{"-t", table, "-A", iptChainOutput, "-m", "mark", "--mark", fmt.Sprintf("0x%x", cfg.bypass), "-j", "RETURN"})
+		if cfg.mode == settings.NetfilterTProxy {
+			cmds = append(cmds, []string{"-t", table, "-A", iptChainOutput, "-m", "conntrack", "--ctdir", "REPLY", "-j", "RETURN"})
+		}
+		for _, proto := range cfg.protocols() {
+			cmds = append(cmds, []string{"-t", table, "-A", iptChainOutput, "-p", proto, "--sport", fmt.Sprint(cfg.port), "-j", "RETURN"})
+		}
+		cmds = append(cmds, []string{"-t", table, "-A", iptChainOutput, "-m", "addrtype", "--dst-type", "LOCAL", "-j", "RETURN"})
+		for _, cidr := range excludes {
+			cmds = append(cmds, []string{"-t", table, "-A", iptChainOutput, "-d", cidr, "-j", "RETURN"})
+		}
+		for _, proto := range cfg.protocols() {
+			if cfg.mode == settings.NetfilterTProxy {
+				cmds = append(cmds, []string{"-t", table, "-A", iptChainOutput, "-p", proto,
+					"-j", "MARK", "--set-mark", fmt.Sprintf("0x%x", cfg.mark)})
+			} else {
+				cmds = append(cmds, []string{"-t", table, "-A", iptChainOutput, "-p", proto,
+					"-j", "REDIRECT", "--to-ports", fmt.Sprint(cfg.port)})
+			}
+		}
+		cmds = append(cmds, []string{"-t", table, "-A", "OUTPUT", "-j", iptChainOutput})
+	}
+
+	if cfg.mss && cfg.tcp {
+		cmds = append(cmds,
+			[]string{"-t", "mangle", "-N", iptChainForward},
+			[]string{"-t", "mangle", "-A", iptChainForward, "-p", "tcp", "--tcp-flags", "SYN,RST", "SYN",
+				"-j", "TCPMSS", "--clamp-mss-to-pmtu"},
+			[]string{"-t", "mangle", "-A", "FORWARD", "-j", iptChainForward},
+		)
+	}
+	return cmds
+}
+
+// --- TPROXY 策略路由 ---
+
+// policyRoutingFamilies 返回需要配置策略路由的 ip 命令族参数。
+func policyRoutingFamilies(cfg *ruleConfig) [][]string {
+	families := [][]string{{"-4"}}
+	if cfg.ipv6 {
+		families = append(families, []string{"-6"})
+	}
+	return families
+}
+
+// installPolicyRouting 让带 fwmark 的包查专用路由表，该表把所有目标都视为本机地址，
+// 从而使 TPROXY 标记的包被投递到本机的透明监听 socket。
+func installPolicyRouting(run commandRunner, cfg *ruleConfig) error {
+	if err := removePolicyRouting(run, cfg); err != nil {
+		return err
+	}
+	mark, table := fmt.Sprint(cfg.mark), fmt.Sprint(cfg.table)
+	for _, family := range policyRoutingFamilies(cfg) {
+		if _, err := run.Run("", "ip", append(family, "rule", "add", "fwmark", mark, "lookup", table)...); err != nil {
+			return err
+		}
+		if _, err := run.Run("", "ip", append(family, "route", "replace", "local", "default", "dev", "lo", "table", table)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removePolicyRouting 删除 installPolicyRouting 添加的规则和路由，不存在时视为成功。
+func removePolicyRouting(run commandRunner, cfg *ruleConfig) error {
+	mark, table := fmt.Sprint(cfg.mark), fmt.Sprint(cfg.table)
+	for _, family := range [][]string{{"-4"}, {"-6"}} {
+		for i := 0; i < 16; i++ {
+			if _, err := run.Run("", "ip", append(family, "rule", "del", "fwmark", mark, "lookup", table)...); err != nil {
+				break
+			}
+		}
+		run.Run("", "ip", append(family, "route", "flush", "table", table)...)
+	}
+	return nil
+}
+
+func policyRoutingState(run commandRunner, cfg *ruleConfig) string {
+	var sb strings.Builder
+	table := fmt.Sprint(cfg.table)
+	for _, family := range policyRoutingFamilies(cfg) {
+		rules, _ := run.Run("", "ip", append(family, "rule", "show")...)
+		routes, _ := run.Run("", "ip", append(family, "route", "show", "table", table)...)
+		fmt.Fprintf(&sb, "# ip %s rule show\n%s# ip %s route show table %s\n%s", family[0], rules, family[0], table, routes)
+	}
+	return sb.String()
+}
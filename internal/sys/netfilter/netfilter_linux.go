@@ -0,0 +1,49 @@
+//go:build linux
+
+// FILE: internal/sys/netfilter/netfilter_linux.go
+package netfilter
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+type execRunner struct{}
+
+func newCommandRunner() commandRunner {
+	return execRunner{}
+}
+
+// Run 执行命令并返回标准输出。失败时把标准错误附加到 error 中，便于定位是哪条规则出错。
+func (execRunner) Run(stdin, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+func (execRunner) LookPath(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+// enableForwarding 打开内核 IP 转发，使本机可以作为旁路由/网关转发被代理的流量。
+func enableForwarding(ipv6 bool) error {
+	if err := os.WriteFile("/proc/sys/net/ipv4/ip_forward", []byte("1"), 0644); err != nil {
+		return err
+	}
+	if ipv6 {
+		return os.WriteFile("/proc/sys/net/ipv6/conf/all/forwarding", []byte("1"), 0644)
+	}
+	return nil
+}
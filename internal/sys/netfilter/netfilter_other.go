@@ -0,0 +1,26 @@
+//go:build !linux
+
+// FILE: internal/sys/netfilter/netfilter_other.go
+package netfilter
+
+import "fmt"
+
+var errUnsupported = fmt.Errorf("netfilter is not supported on this platform")
+
+type unsupportedRunner struct{}
+
+func newCommandRunner() commandRunner {
+	return unsupportedRunner{}
+}
+
+func (unsupportedRunner) Run(stdin, name string, args ...string) (string, error) {
+	return "", errUnsupported
+}
+
+func (unsupportedRunner) LookPath(name string) bool {
+	return false
+}
+
+func enableForwarding(ipv6 bool) error {
+	return errUnsupported
+}
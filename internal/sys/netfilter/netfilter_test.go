@@ -0,0 +1,401 @@
+package netfilter
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"liuproxy_nexus/internal/shared/settings"
+)
+
+// fakeRunner 记录执行的命令而不真正执行。以 failures 中任一前缀开头的命令返回错误。
+type fakeRunner struct {
+	binaries []string
+	failures []string
+	calls    []string
+	stdin    map[int]string // calls 下标 -> 标准输入
+}
+
+func (r *fakeRunner) Run(stdin, name string, args ...string) (string, error) {
+	call := strings.Join(append([]string{name}, args...), " ")
+	if stdin != "" {
+		if r.stdin == nil {
+			r.stdin = make(map[int]string)
+		}
+		r.stdin[len(r.calls)] = stdin
+	}
+	r.calls = append(r.calls, call)
+	for _, prefix := range r.failures {
+		if strings.HasPrefix(call, prefix) {
+			return "", errors.New("fake failure")
+		}
+	}
+	return "", nil
+}
+
+func (r *fakeRunner) LookPath(name string) bool {
+	return slices.Contains(r.binaries, name)
+}
+
+func newTestManager(run *fakeRunner) (*Manager, *[]bool) {
+	m := NewManager(12345, 0xff)
+	m.run = run
+	var forwarded []bool
+	m.forward = func(ipv6 bool) error {
+		forwarded = append(forwarded, ipv6)
+		return nil
+	}
+	return m, &forwarded
+}
+
+func expectCalls(t *testing.T, got, want []string) {
+	t.Helper()
+	if !slices.Equal(got, want) {
+		t.Fatalf("commands:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// dualStackTProxy 是 TPROXY 模式下开启全部选项的配置。
+func dualStackTProxy() *ruleConfig {
+	return &ruleConfig{
+		mode: settings.NetfilterTProxy, port: 12345, tcp: true, udp: true, ipv6: true, mss: true,
+		exclude4: []string{"10.0.0.0/8", "192.168.0.0/16"}, exclude6: []string{"fc00::/7"},
+		mark: 1, table: 100, bypass: 0xff,
+	}
+}
+
+func TestBuildNftRuleset(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *ruleConfig
+		want string
+	}{
+		{
+			name: "redirect tcp ipv4",
+			cfg:  &ruleConfig{mode: settings.NetfilterRedirect, port: 1080, tcp: true, mark: 1, table: 100},
+			want: `table inet liuproxy {
+	chain prerouting {
+		type nat hook prerouting priority dstnat; policy accept;
+		meta nfproto ipv6 return
+		meta l4proto tcp redirect to :1080
+	}
+}
+`,
+		},
+		{
+			// 设置了 outbound_mark 时同时捕获本机发出的流量
+			name: "redirect with outbound mark",
+			cfg:  &ruleConfig{mode: settings.NetfilterRedirect, port: 1080, tcp: true, udp: true, mark: 1, table: 100, bypass: 0xff},
+			want: `table inet liuproxy {
+	chain prerouting {
+		type nat hook prerouting priority dstnat; policy accept;
+		meta mark 0xff return
+		meta nfproto ipv6 return
+		meta l4proto tcp redirect to :1080
+		meta l4proto udp redirect to :1080
+	}
+	chain output {
+		type nat hook output priority dstnat; policy accept;
+		meta mark 0xff return
+		tcp sport 1080 return
+		udp sport 1080 return
+		fib daddr type local return
+		meta nfproto ipv6 return
+		meta l4proto tcp redirect to :1080
+		meta l4proto udp redirect to :1080
+	}
+}
+`,
+		},
+		{
+			name: "tproxy dual stack",
+			cfg:  dualStackTProxy(),
+			want: `table inet liuproxy {
+	set bypass4 {
+		type ipv4_addr
+		flags interval
+		elements = { 10.0.0.0/8, 192.168.0.0/16 }
+	}
+	set bypass6 {
+		type ipv6_addr
+		flags interval
+		elements = { fc00::/7 }
+	}
+	chain prerouting {
+		type filter hook prerouting priority mangle; policy accept;
+		meta mark 0xff return
+		ip daddr @bypass4 return
+		ip6 daddr @bypass6 return
+		meta l4proto tcp tproxy to :12345 meta mark set 0x1 accept
+		meta l4proto udp tproxy to :12345 meta mark set 0x1 accept
+	}
+	chain output {
+		type route hook output priority mangle; policy accept;
+		meta mark 0xff return
+		ct direction reply return
+		tcp sport 12345 return
+		udp sport 12345 return
+		fib daddr type local return
+		ip daddr @bypass4 return
+		ip6 daddr @bypass6 return
+		meta l4proto tcp meta mark set 0x1
+		meta l4proto udp meta mark set 0x1
+	}
+	chain forward {
+		type filter hook forward priority mangle; policy accept;
+		tcp flags syn tcp option maxseg size set rt mtu
+	}
+}
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildNftRuleset(tt.cfg); got != tt.want {
+				t.Errorf("buildNftRuleset() =\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildIptablesCommands(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *ruleConfig
+		ipv6 bool
+		want []string
+	}{
+		{
+			name: "tproxy ipv4",
+			cfg:  dualStackTProxy(),
+			want: []string{
+				"-t mangle -N LIUPROXY_PREROUTING",
+				"-t mangle -A LIUPROXY_PREROUTING -m mark --mark 0xff -j RETURN",
+				"-t mangle -A LIUPROXY_PREROUTING -d 10.0.0.0/8 -j RETURN",
+				"-t mangle -A LIUPROXY_PREROUTING -d 192.168.0.0/16 -j RETURN",
+				"-t mangle -A LIUPROXY_PREROUTING -p tcp -j TPROXY --on-port 12345 --tproxy-mark 0x1/0x1",
+				"-t mangle -A LIUPROXY_PREROUTING -p udp -j TPROXY --on-port 12345 --tproxy-mark 0x1/0x1",
+				"-t mangle -A PREROUTING -j LIUPROXY_PREROUTING",
+				"-t mangle -N LIUPROXY_OUTPUT",
+				"-t mangle -A LIUPROXY_OUTPUT -m mark --mark 0xff -j RETURN",
+				"-t mangle -A LIUPROXY_OUTPUT -m conntrack --ctdir REPLY -j RETURN",
+				"-t mangle -A LIUPROXY_OUTPUT -p tcp --sport 12345 -j RETURN",
+				"-t mangle -A LIUPROXY_OUTPUT -p udp --sport 12345 -j RETURN",
+				"-t mangle -A LIUPROXY_OUTPUT -m addrtype --dst-type LOCAL -j RETURN",
+				"-t mangle -A LIUPROXY_OUTPUT -d 10.0.0.0/8 -j RETURN",
+				"-t mangle -A LIUPROXY_OUTPUT -d 192.168.0.0/16 -j RETURN",
+				"-t mangle -A LIUPROXY_OUTPUT -p tcp -j MARK --set-mark 0x1",
+				"-t mangle -A LIUPROXY_OUTPUT -p udp -j MARK --set-mark 0x1",
+				"-t mangle -A OUTPUT -j LIUPROXY_OUTPUT",
+				"-t mangle -N LIUPROXY_FORWARD",
+				"-t mangle -A LIUPROXY_FORWARD -p tcp --tcp-flags SYN,RST SYN -j TCPMSS --clamp-mss-to-pmtu",
+				"-t mangle -A FORWARD -j LIUPROXY_FORWARD",
+			},
+		},
+		{
+			name: "redirect ipv6",
+			cfg: &ruleConfig{mode: settings.NetfilterRedirect, port: 1080, tcp: true, ipv6: true,
+				exclude4: []string{"10.0.0.0/8"}, exclude6: []string{"fc00::/7"}, mark: 1, table: 100},
+			ipv6: true,
+			want: []string{
+				"-t nat -N LIUPROXY_PREROUTING",
+				"-t nat -A LIUPROXY_PREROUTING -d fc00::/7 -j RETURN",
+				"-t nat -A LIUPROXY_PREROUTING -p tcp -j REDIRECT --to-ports 1080",
+				"-t nat -A PREROUTING -j LIUPROXY_PREROUTING",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, args := range buildIptablesCommands(tt.cfg, tt.ipv6) {
+				got = append(got, strings.Join(args, " "))
+			}
+			expectCalls(t, got, tt.want)
+		})
+	}
+}
+
+// policyRoutingRemoval 是 removePolicyRouting 在没有遗留规则时执行的命令。
+var policyRoutingRemoval = []string{
+	"ip -4 rule del fwmark 1 lookup 100",
+	"ip -4 route flush table 100",
+	"ip -6 rule del fwmark 1 lookup 100",
+	"ip -6 route flush table 100",
+}
+
+func TestManagerApplyTProxyNftables(t *testing.T) {
+	run := &fakeRunner{binaries: []string{"nft", "iptables"}, failures: []string{"ip -4 rule del", "ip -6 rule del"}}
+	m, forwarded := newTestManager(run)
+
+	cfg := &settings.NetfilterSettings{Enabled: true, Mode: settings.NetfilterTProxy, TCP: true, UDP: true, IPv6: true}
+	if err := m.Apply(cfg); err != nil {
+		t.Fatal(err)
+	}
+	// 先提交规则，再清理并安装策略路由
+	want := append([]string{"nft -f -"}, policyRoutingRemoval...)
+	want = append(want,
+		"ip -4 rule add fwmark 1 lookup 100",
+		"ip -4 route replace local default dev lo table 100",
+		"ip -6 rule add fwmark 1 lookup 100",
+		"ip -6 route replace local default dev lo table 100",
+	)
+	expectCalls(t, run.calls, want)
+	rc, _ := m.normalize(cfg)
+	if got, want := run.stdin[0], nftResetScript()+buildNftRuleset(rc); got != want {
+		t.Errorf("nft input =\n%s\nwant:\n%s", got, want)
+	}
+	if !slices.Equal(*forwarded, []bool{true}) {
+		t.Errorf("forwarding enabled with %v, want [true]", *forwarded)
+	}
+	if st := m.Status(); !st.Active || st.Backend != backendNftables || st.Mode != "tproxy" || st.Port != 12345 {
+		t.Errorf("Status() = %+v", st)
+	}
+
+	// 关闭时先删除表，再清理策略路由
+	run.calls, run.stdin = nil, nil
+	if err := m.Apply(&settings.NetfilterSettings{}); err != nil {
+		t.Fatal(err)
+	}
+	expectCalls(t, run.calls, append([]string{"nft -f -"}, policyRoutingRemoval...))
+	if got := run.stdin[0]; got != nftResetScript() {
+		t.Errorf("nft input on removal = %q, want %q", got, nftResetScript())
+	}
+	if m.Status().Active {
+		t.Error("rules still active after disabling")
+	}
+}
+
+func TestManagerApplyRollsBackPolicyRouting(t *testing.T) {
+	run := &fakeRunner{
+		binaries: []string{"nft"},
+		failures: []string{"ip -4 rule del", "ip -6 rule del", "ip -6 route replace"},
+	}
+	m, forwarded := newTestManager(run)
+
+	err := m.Apply(&settings.NetfilterSettings{Enabled: true, Mode: settings.NetfilterTProxy, TCP: true, IPv6: true})
+	if err == nil {
+		t.Fatal("Apply() succeeded although policy routing failed")
+	}
+	// 策略路由失败后依次撤销策略路由和 nft 表
+	want := append([]string{"nft -f -"}, policyRoutingRemoval...)
+	want = append(want,
+		"ip -4 rule add fwmark 1 lookup 100",
+		"ip -4 route replace local default dev lo table 100",
+		"ip -6 rule add fwmark 1 lookup 100",
+		"ip -6 route replace local default dev lo table 100",
+	)
+	want = append(want, policyRoutingRemoval...)
+	want = append(want, "nft -f -")
+	expectCalls(t, run.calls, want)
+	if got := run.stdin[len(run.calls)-1]; got != nftResetScript() {
+		t.Errorf("rollback nft input = %q, want %q", got, nftResetScript())
+	}
+	if len(*forwarded) != 0 {
+		t.Error("forwarding enabled although installation failed")
+	}
+	if st := m.Status(); st.Active || st.Error == "" {
+		t.Errorf("Status() = %+v, want inactive with an error", st)
+	}
+}
+
+// iptablesRemoval 是 iptablesBackend.remove 在没有遗留跳转时对 iptables 执行的命令。
+var iptablesRemoval = []string{
+	"iptables -t nat -D PREROUTING -j LIUPROXY_PREROUTING",
+	"iptables -t nat -F LIUPROXY_PREROUTING",
+	"iptables -t nat -X LIUPROXY_PREROUTING",
+	"iptables -t mangle -D PREROUTING -j LIUPROXY_PREROUTING",
+	"iptables -t mangle -F LIUPROXY_PREROUTING",
+	"iptables -t mangle -X LIUPROXY_PREROUTING",
+	"iptables -t nat -D OUTPUT -j LIUPROXY_OUTPUT",
+	"iptables -t nat -F LIUPROXY_OUTPUT",
+	"iptables -t nat -X LIUPROXY_OUTPUT",
+	"iptables -t mangle -D OUTPUT -j LIUPROXY_OUTPUT",
+	"iptables -t mangle -F LIUPROXY_OUTPUT",
+	"iptables -t mangle -X LIUPROXY_OUTPUT",
+	"iptables -t mangle -D FORWARD -j LIUPROXY_FORWARD",
+	"iptables -t mangle -F LIUPROXY_FORWARD",
+	"iptables -t mangle -X LIUPROXY_FORWARD",
+}
+
+func TestManagerApplyIptables(t *testing.T) {
+	run := &fakeRunner{binaries: []string{"iptables"}, failures: []string{"iptables -t nat -D", "iptables -t mangle -D"}}
+	m, _ := newTestManager(run)
+
+	cfg := &settings.NetfilterSettings{Enabled: true, Backend: backendIptables, TCP: true, ExcludedCIDRs: []string{"10.0.0.0/8"}}
+	if err := m.Apply(cfg); err != nil {
+		t.Fatal(err)
+	}
+	// 安装前先清理遗留规则；没有 ip6tables 时只安装 IPv4 规则，redirect 模式不配置策略路由
+	want := append(slices.Clone(iptablesRemoval),
+		"iptables -t nat -N LIUPROXY_PREROUTING",
+		"iptables -t nat -A LIUPROXY_PREROUTING -m mark --mark 0xff -j RETURN",
+		"iptables -t nat -A LIUPROXY_PREROUTING -d 10.0.0.0/8 -j RETURN",
+		"iptables -t nat -A LIUPROXY_PREROUTING -p tcp -j REDIRECT --to-ports 12345",
+		"iptables -t nat -A PREROUTING -j LIUPROXY_PREROUTING",
+		"iptables -t nat -N LIUPROXY_OUTPUT",
+		"iptables -t nat -A LIUPROXY_OUTPUT -m mark --mark 0xff -j RETURN",
+		"iptables -t nat -A LIUPROXY_OUTPUT -p tcp --sport 12345 -j RETURN",
+		"iptables -t nat -A LIUPROXY_OUTPUT -m addrtype --dst-type LOCAL -j RETURN",
+		"iptables -t nat -A LIUPROXY_OUTPUT -d 10.0.0.0/8 -j RETURN",
+		"iptables -t nat -A LIUPROXY_OUTPUT -p tcp -j REDIRECT --to-ports 12345",
+		"iptables -t nat -A OUTPUT -j LIUPROXY_OUTPUT",
+	)
+	expectCalls(t, run.calls, want)
+
+	run.calls = nil
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expectCalls(t, run.calls, iptablesRemoval)
+}
+
+func TestManagerApplyIptablesRollsBack(t *testing.T) {
+	run := &fakeRunner{
+		binaries: []string{"iptables"},
+		failures: []string{"iptables -t nat -D", "iptables -t mangle -D", "iptables -t nat -A PREROUTING"},
+	}
+	m, _ := newTestManager(run)
+
+	if err := m.Apply(&settings.NetfilterSettings{Enabled: true, Backend: backendIptables, TCP: true}); err == nil {
+		t.Fatal("Apply() succeeded although a rule failed")
+	}
+	// 失败的规则之后不再执行其他命令，随即清理已创建的链
+	want := append(slices.Clone(iptablesRemoval),
+		"iptables -t nat -N LIUPROXY_PREROUTING",
+		"iptables -t nat -A LIUPROXY_PREROUTING -m mark --mark 0xff -j RETURN",
+		"iptables -t nat -A LIUPROXY_PREROUTING -p tcp -j REDIRECT --to-ports 12345",
+		"iptables -t nat -A PREROUTING -j LIUPROXY_PREROUTING",
+	)
+	want = append(want, iptablesRemoval...)
+	expectCalls(t, run.calls, want)
+	if m.Status().Active {
+		t.Error("rules marked active after a failed installation")
+	}
+}
+
+func TestManagerRejectsInvalidSettings(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *settings.NetfilterSettings
+	}{
+		{"no protocol", &settings.NetfilterSettings{Enabled: true}},
+		{"unknown mode", &settings.NetfilterSettings{Enabled: true, Mode: "masquerade", TCP: true}},
+		{"mark conflicts with outbound_mark", &settings.NetfilterSettings{Enabled: true, Mode: settings.NetfilterTProxy, TCP: true, FwMark: 0xff}},
+		{"invalid CIDR", &settings.NetfilterSettings{Enabled: true, TCP: true, ExcludedCIDRs: []string{"10.0.0.0/33"}}},
+		{"unknown backend", &settings.NetfilterSettings{Enabled: true, TCP: true, Backend: "pf"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			run := &fakeRunner{binaries: []string{"nft", "iptables"}}
+			m, _ := newTestManager(run)
+			if err := m.Apply(tt.cfg); err == nil {
+				t.Fatal("Apply() succeeded")
+			}
+			if len(run.calls) != 0 {
+				t.Errorf("invalid settings executed commands: %v", run.calls)
+			}
+		})
+	}
+}
@@ -4,47 +4,133 @@
 package tproxy
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"strings"
 	"syscall"
+	"unsafe"
 )
 
-const SO_ORIGINAL_DST = 80
+const (
+	SO_ORIGINAL_DST      = 80
+	IP6T_SO_ORIGINAL_DST = 80 // 与 SO_ORIGINAL_DST 数值相同，但位于 SOL_IPV6 层
+	IPV6_TRANSPARENT     = 75
+)
 
-// GetOriginalDst 从一个被TPROXY/REDIRECT的TCP连接中获取其原始目标地址
+// GetOriginalDst 从一个被 REDIRECT 的 TCP 连接中获取其原始目标地址。
+// IPv4 连接 (包括双栈 socket 上的 IPv4 连接) 使用 SOL_IP/SO_ORIGINAL_DST，
+// IPv6 连接使用 SOL_IPV6/IP6T_SO_ORIGINAL_DST。
 func GetOriginalDst(conn net.Conn) (net.Addr, error) {
 	tcpConn, ok := conn.(*net.TCPConn)
 	if !ok {
 		return nil, fmt.Errorf("not a TCP connection")
 	}
+	localAddr, ok := tcpConn.LocalAddr().(*net.TCPAddr)
+	if !ok {
+		return nil, fmt.Errorf("unexpected local address type %T", tcpConn.LocalAddr())
+	}
 
-	file, err := tcpConn.File()
+	rawConn, err := tcpConn.SyscallConn()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get connection file descriptor: %w", err)
+		return nil, fmt.Errorf("failed to get raw connection: %w", err)
 	}
-	defer file.Close()
-	fd := file.Fd()
 
-	// 尝试获取IPv4原始目标地址
-	// The getsockopt syscall for SO_ORIGINAL_DST returns a sockaddr_in structure.
-	// In Go's syscall package, this structure is represented by syscall.RawSockaddrInet4.
-	addr, err := syscall.GetsockoptIPv6Mreq(int(fd), syscall.IPPROTO_IP, SO_ORIGINAL_DST)
+	var dst *net.TCPAddr
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		if localAddr.IP.To4() != nil {
+			dst, sockErr = getOriginalDst4(int(fd))
+		} else {
+			dst, sockErr = getOriginalDst6(int(fd))
+		}
+	})
 	if err != nil {
-		// As a fallback or for IPv6, you might need a different getsockopt call,
-		// but for classic iptables REDIRECT, this is the one.
-		return nil, fmt.Errorf("getsockopt(SO_ORIGINAL_DST) failed: %w", err)
+		return nil, fmt.Errorf("failed to access connection file descriptor: %w", err)
 	}
+	return dst, sockErr
+}
 
-	//  ipv6mr_multiaddr [16]byte
-	// The actual sockaddr_in struct is smaller and is contained within this field.
-	// struct sockaddr_in {
-	//    sa_family_t    sin_family; /* address family: AF_INET */
-	//    in_port_t      sin_port;   /* port in network byte order */
-	//    struct in_addr sin_addr;   /* internet address */
-	// };
+func getOriginalDst4(fd int) (*net.TCPAddr, error) {
+	// The getsockopt syscall for SO_ORIGINAL_DST returns a sockaddr_in structure.
+	// 标准库没有直接读取 sockaddr_in 的 getsockopt，借用 IPv6Mreq (20 字节) 作为足够大的缓冲区:
 	// sin_family (2 bytes), sin_port (2 bytes), sin_addr (4 bytes)
-	ip := net.IP(addr.Multiaddr[4:8])
+	addr, err := syscall.GetsockoptIPv6Mreq(fd, syscall.IPPROTO_IP, SO_ORIGINAL_DST)
+	if err != nil {
+		return nil, fmt.Errorf("getsockopt(SO_ORIGINAL_DST) failed: %w", err)
+	}
+	ip := make(net.IP, net.IPv4len)
+	copy(ip, addr.Multiaddr[4:8])
 	port := uint16(addr.Multiaddr[2])<<8 + uint16(addr.Multiaddr[3])
-
 	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
 }
+
+func getOriginalDst6(fd int) (*net.TCPAddr, error) {
+	// IP6T_SO_ORIGINAL_DST 返回 sockaddr_in6 (28 字节)，IPv6MTUInfo 的首个字段正好是 RawSockaddrInet6。
+	info, err := syscall.GetsockoptIPv6MTUInfo(fd, syscall.IPPROTO_IPV6, IP6T_SO_ORIGINAL_DST)
+	if err != nil {
+		return nil, fmt.Errorf("getsockopt(IP6T_SO_ORIGINAL_DST) failed: %w", err)
+	}
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, info.Addr.Addr[:])
+	// sin6_port 是网络字节序
+	portBytes := (*[2]byte)(unsafe.Pointer(&info.Addr.Port))
+	port := uint16(portBytes[0])<<8 + uint16(portBytes[1])
+
+	addr := &net.TCPAddr{IP: ip, Port: int(port)}
+	if info.Addr.Scope_id != 0 {
+		if iface, err := net.InterfaceByIndex(int(info.Addr.Scope_id)); err == nil {
+			addr.Zone = iface.Name
+		}
+	}
+	return addr, nil
+}
+
+// ListenTransparent 以 IP_TRANSPARENT (IPv6 为 IPV6_TRANSPARENT) 方式监听，用于 iptables TPROXY。
+// 被 TPROXY 的连接不会改写目标地址，accept 得到的连接的 LocalAddr 即为原始目标。
+// network 为 "tcp" 或 "udp"，需要 CAP_NET_ADMIN 权限。UDP 数据报的原始目标用 ReadFromUDP 读取。
+func ListenTransparent(network, address string) (net.Listener, net.PacketConn, error) {
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			err := c.Control(func(fd uintptr) {
+				// 监听通配地址时 Go 会创建双栈 AF_INET6 socket，同时接收 IPv4 和 IPv6 的 TPROXY 流量
+				ipv6 := strings.HasSuffix(network, "6")
+				sockErr = setTransparent(int(fd), ipv6)
+				if sockErr == nil && strings.HasPrefix(network, "udp") {
+					// 回复 socket 可能绑定在与监听器相同的端口上 (见 DialUDP)
+					if err := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+						sockErr = fmt.Errorf("setsockopt(SO_REUSEADDR) failed: %w", err)
+					} else if err := enableOrigDstAddr(int(fd), ipv6); err != nil {
+						sockErr = fmt.Errorf("setsockopt(IP_RECVORIGDSTADDR) failed: %w", err)
+					}
+				}
+			})
+			if err != nil {
+				return err
+			}
+			return sockErr
+		},
+	}
+
+	if strings.HasPrefix(network, "udp") {
+		pc, err := lc.ListenPacket(context.Background(), network, address)
+		return nil, pc, err
+	}
+	ln, err := lc.Listen(context.Background(), network, address)
+	return ln, nil, err
+}
+
+// setTransparent 设置 IP_TRANSPARENT (IPv6 为 IPV6_TRANSPARENT)，允许 socket 接收 TPROXY 流量和绑定非本机地址。
+func setTransparent(fd int, ipv6 bool) error {
+	var err error
+	if ipv6 {
+		err = syscall.SetsockoptInt(fd, syscall.SOL_IPV6, IPV6_TRANSPARENT, 1)
+	} else {
+		err = syscall.SetsockoptInt(fd, syscall.SOL_IP, syscall.IP_TRANSPARENT, 1)
+	}
+	if err != nil {
+		return fmt.Errorf("setsockopt(IP_TRANSPARENT) failed: %w", err)
+	}
+	return nil
+}
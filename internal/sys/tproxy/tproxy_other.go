@@ -12,3 +12,18 @@ import (
 func GetOriginalDst(conn net.Conn) (net.Addr, error) {
 	return nil, fmt.Errorf("transparent proxy is not supported on this platform")
 }
+
+// ListenTransparent 在非Linux系统上的存根实现
+func ListenTransparent(network, address string) (net.Listener, net.PacketConn, error) {
+	return nil, nil, fmt.Errorf("transparent proxy is not supported on this platform")
+}
+
+// ReadFromUDP 在非Linux系统上的存根实现
+func ReadFromUDP(pc net.PacketConn, b []byte) (int, *net.UDPAddr, *net.UDPAddr, error) {
+	return 0, nil, nil, fmt.Errorf("transparent proxy is not supported on this platform")
+}
+
+// DialUDP 在非Linux系统上的存根实现
+func DialUDP(laddr, raddr *net.UDPAddr) (*net.UDPConn, error) {
+	return nil, fmt.Errorf("transparent proxy is not supported on this platform")
+}
@@ -3,33 +3,115 @@
 package tproxy
 
 import (
+	"context"
+	"encoding/binary"
 	"fmt"
 	"net"
+	"strings"
+	"syscall"
 )
 
-// GetOriginalDstForUDP 从一个被 TPROXY 的 UDP "连接" (实际上是 socket fd) 中获取其原始目标地址
-// 注意：这需要在收到第一个包之后，在该 listener 的 fd 上下文中调用。
-// 这个实现比较 tricky，我们暂时使用一个简化的、依赖内核版本的方法。
-// 更通用的方法是使用 recvmsg 和 IP_RECVORIGDSTADDR，但那需要重构监听循环。
-// 我们暂时假设iptables TPROXY的目标和程序监听的端口是一致的。
-func GetOriginalDstForUDP(clientAddr net.Addr, listenPort int) (net.Addr, error) {
-	// 对于UDP TPROXY，`getsockopt(SO_ORIGINAL_DST)` 不再有效。
-	// 流量被重定向到我们监听的端口，但数据包的目标IP没有被修改。
-	// 然而，我们无法轻易地从一个 `net.PacketConn` 的 `ReadFrom` 中获得包的目标IP。
+const (
+	IPV6_RECVORIGDSTADDR = 74
+	IPV6_ORIGDSTADDR     = 74 // 与 IPV6_RECVORIGDSTADDR 数值相同，控制消息的类型
+)
 
-	// 一个常见的、但有局限性的假设是：我们不知道原始目标IP，但我们可以假设
-	// 原始目标端口和我们监听的端口是相同的，如果iptables规则是 `--on-port`。
-	// `ip rule` 会将包路由到 `lo`，但目标IP保持不变。
+// enableOrigDstAddr 让 UDP socket 在每个数据报的控制消息中附带原始目标地址。
+// 双栈 AF_INET6 socket 上 IPv4 数据报使用 IP_ORIGDSTADDR，因此两个选项都需要设置。
+func enableOrigDstAddr(fd int, ipv6 bool) error {
+	if !ipv6 {
+		return syscall.SetsockoptInt(fd, syscall.SOL_IP, syscall.IP_RECVORIGDSTADDR, 1)
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_IPV6, IPV6_RECVORIGDSTADDR, 1); err != nil {
+		return err
+	}
+	// IPV6_V6ONLY 的 socket 不接受 IPv4 选项，忽略错误
+	syscall.SetsockoptInt(fd, syscall.SOL_IP, syscall.IP_RECVORIGDSTADDR, 1)
+	return nil
+}
 
-	// 这是一个简化实现，它依赖于我们无法直接获取的信息。
-	// 在实践中，正确的方法是使用 `syscall.Recvmsg` 配合 `IP_PKTINFO` or `IPV6_PKTINFO`
-	// 来获取每个数据包的目标地址。这需要重构 `acceptUDPLoop`。
+// ReadFromUDP 从 ListenTransparent("udp", ...) 返回的连接读取一个数据报，同时返回它的原始目标地址。
+// TPROXY 不改写 UDP 数据报的目标地址，但 ReadFrom 无法取得它，需要通过 recvmsg 的
+// IP_ORIGDSTADDR / IPV6_ORIGDSTADDR 控制消息读取。
+func ReadFromUDP(pc net.PacketConn, b []byte) (int, *net.UDPAddr, *net.UDPAddr, error) {
+	conn, ok := pc.(*net.UDPConn)
+	if !ok {
+		return 0, nil, nil, fmt.Errorf("tproxy: %T is not a UDP connection", pc)
+	}
+	oob := make([]byte, syscall.CmsgSpace(syscall.SizeofSockaddrInet6))
+	n, oobn, _, src, err := conn.ReadMsgUDP(b, oob)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	dst, err := parseOrigDstAddr(oob[:oobn])
+	if err != nil {
+		return n, src, nil, err
+	}
+	return n, src, dst, nil
+}
 
-	// 作为一个折中和简化的开始，我们假设我们能通过某种方式获取目标IP。
-	// 现实是，我们不能。`GetOriginalDstForUDP`这个名字是有误导性的。
-	// 我们应该直接在`handleUDPPacket`中构造它。
+func parseOrigDstAddr(oob []byte) (*net.UDPAddr, error) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, fmt.Errorf("tproxy: failed to parse control messages: %w", err)
+	}
+	for _, msg := range msgs {
+		switch {
+		case msg.Header.Level == syscall.SOL_IP && msg.Header.Type == syscall.IP_ORIGDSTADDR &&
+			len(msg.Data) >= syscall.SizeofSockaddrInet4:
+			// struct sockaddr_in: family(2) + port(2, 网络字节序) + addr(4)
+			return &net.UDPAddr{
+				IP:   net.IP(append([]byte(nil), msg.Data[4:8]...)),
+				Port: int(binary.BigEndian.Uint16(msg.Data[2:4])),
+			}, nil
+		case msg.Header.Level == syscall.SOL_IPV6 && msg.Header.Type == IPV6_ORIGDSTADDR &&
+			len(msg.Data) >= syscall.SizeofSockaddrInet6:
+			// struct sockaddr_in6: family(2) + port(2) + flowinfo(4) + addr(16) + scope_id(4)
+			addr := &net.UDPAddr{
+				IP:   net.IP(append([]byte(nil), msg.Data[8:24]...)),
+				Port: int(binary.BigEndian.Uint16(msg.Data[2:4])),
+			}
+			if scope := binary.NativeEndian.Uint32(msg.Data[24:28]); scope != 0 {
+				if iface, err := net.InterfaceByIndex(int(scope)); err == nil {
+					addr.Zone = iface.Name
+				}
+			}
+			return addr, nil
+		}
+	}
+	return nil, fmt.Errorf("tproxy: datagram has no original destination, is the socket transparent?")
+}
 
-	// 在这个阶段，我们无法可靠地获取原始目标IP。这是一个已知的设计挑战。
-	// 我们将返回一个错误，并需要在 `handleUDPPacket` 中改变逻辑。
-	return nil, fmt.Errorf("getting original destination for UDP TPROXY with this listener setup is non-trivial; requires recvmsg")
+// DialUDP 创建一个绑定在 laddr 并连接到 raddr 的透明 UDP socket，用于 TPROXY 模式下回复客户端：
+// laddr 通常不是本机地址 (客户端原本访问的目标)，客户端收到的回复即来自该地址。
+// 之后客户端发往 laddr 的数据报会被内核交给这个 socket 而不是 TPROXY 监听器，调用方需要读取它。
+func DialUDP(laddr, raddr *net.UDPAddr) (*net.UDPConn, error) {
+	network := "udp6"
+	if laddr.IP.To4() != nil && raddr.IP.To4() != nil {
+		network = "udp4"
+	}
+	d := net.Dialer{
+		LocalAddr: laddr,
+		Control: func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			err := c.Control(func(fd uintptr) {
+				if sockErr = setTransparent(int(fd), strings.HasSuffix(network, "6")); sockErr != nil {
+					return
+				}
+				// 与 TPROXY 监听器或其他回复 socket 共用地址和端口
+				if err := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+					sockErr = fmt.Errorf("setsockopt(SO_REUSEADDR) failed: %w", err)
+				}
+			})
+			if err != nil {
+				return err
+			}
+			return sockErr
+		},
+	}
+	conn, err := d.DialContext(context.Background(), network, raddr.String())
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
 }
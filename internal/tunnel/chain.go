@@ -0,0 +1,118 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	"golang.org/x/net/proxy"
+
+	"liuproxy_nexus/internal/shared"
+	"liuproxy_nexus/internal/shared/types"
+)
+
+// viaDialer 经由前置策略 (ServerProfile.Via) 建立连接：每次拨号从前置策略获取一条内存 SOCKS5 连接，
+// 再通过 CONNECT 请求到达目标地址。前置策略实例由 viaDialer 独占，随上层策略一起关闭。
+type viaDialer struct {
+	hop       types.TunnelStrategy
+	remarks   string
+	closeOnce sync.Once
+}
+
+// newViaDialer 为 profile 构建 via 链上的拨号器，profile 没有声明 via 时返回 nil。
+// via 可以引用 profiles 中任意服务器的 ID 或 remarks，前置服务器本身也可以再声明 via。
+func newViaDialer(cfg *types.Config, profile *types.ServerProfile, profiles []*types.ServerProfile,
+	stateManager types.StateManager, visited map[*types.ServerProfile]bool) (*viaDialer, error) {
+	if profile.Via == "" {
+		return nil, nil
+	}
+	hopProfile := findProfile(profiles, profile.Via)
+	if hopProfile == nil {
+		return nil, fmt.Errorf("via server '%s' of '%s' not found", profile.Via, profile.Remarks)
+	}
+	if visited[hopProfile] {
+		return nil, fmt.Errorf("via chain of '%s' loops back to '%s'", profile.Remarks, hopProfile.Remarks)
+	}
+	visited[hopProfile] = true
+
+	next, err := newViaDialer(cfg, hopProfile, profiles, stateManager, visited)
+	if err != nil {
+		return nil, err
+	}
+	var nextDialer types.Dialer
+	if next != nil {
+		nextDialer = next
+	}
+
+	hop, err := newStrategy(cfg, hopProfile, stateManager, nextDialer)
+	if err != nil {
+		if next != nil {
+			next.Close()
+		}
+		return nil, fmt.Errorf("failed to create via server '%s': %w", hopProfile.Remarks, err)
+	}
+	if err := hop.InitializeForGateway(); err != nil {
+		hop.CloseTunnel()
+		return nil, fmt.Errorf("failed to initialize via server '%s': %w", hopProfile.Remarks, err)
+	}
+	return &viaDialer{hop: hop, remarks: hopProfile.Remarks}, nil
+}
+
+// findProfile 按 ID 查找服务器，找不到时再按 remarks 匹配。
+func findProfile(profiles []*types.ServerProfile, ref string) *types.ServerProfile {
+	for _, p := range profiles {
+		if p.ID == ref {
+			return p
+		}
+	}
+	for _, p := range profiles {
+		if p.Remarks == ref {
+			return p
+		}
+	}
+	return nil
+}
+
+func (d *viaDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("via server '%s' only carries TCP, cannot dial %s %s", d.remarks, network, address)
+	}
+
+	pipeConn, err := d.hop.GetSocksConnection()
+	if err != nil {
+		return nil, fmt.Errorf("via server '%s': failed to get pipe connection: %w", d.remarks, err)
+	}
+	socks, err := proxy.SOCKS5("tcp", "via-pipe:1080", nil, &shared.PipeDialer{Conn: pipeConn})
+	if err != nil {
+		pipeConn.Close()
+		return nil, err
+	}
+	conn, err := socks.(proxy.ContextDialer).DialContext(ctx, "tcp", address)
+	if err != nil {
+		pipeConn.Close()
+		return nil, fmt.Errorf("via server '%s': %w", d.remarks, err)
+	}
+	return conn, nil
+}
+
+// Close 关闭前置策略实例，进而关闭整条 via 链。
+func (d *viaDialer) Close() error {
+	d.closeOnce.Do(d.hop.CloseTunnel)
+	return nil
+}
+
+// viaStateManager 把前置策略上报的故障记到链路入口的服务器上：前置服务器可能同时是池中的独立服务器，
+// via 链上的失败不应影响它自身的健康状态。
+type viaStateManager struct {
+	parent   types.StateManager
+	serverID string
+}
+
+func (m *viaStateManager) SetServerStatusDown(serverID, reason string) {
+	if m.parent != nil {
+		m.parent.SetServerStatusDown(m.serverID, "via chain: "+reason)
+	}
+}
@@ -0,0 +1,302 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"liuproxy_nexus/internal/shared/outbound"
+	"liuproxy_nexus/internal/shared/testutil"
+	"liuproxy_nexus/internal/shared/types"
+)
+
+// socksServer 是一个最小的无认证 SOCKS5 服务器，记录每个 CONNECT 请求的目标后直连过去。
+type socksServer struct {
+	addr string
+
+	mu      sync.Mutex
+	targets []string
+}
+
+func startSOCKSServer(t *testing.T) *socksServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &socksServer{addr: ln.Addr().String()}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *socksServer) serve(conn net.Conn) {
+	defer conn.Close()
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	if _, err := io.CopyN(io.Discard, conn, int64(header[1])); err != nil {
+		return
+	}
+	conn.Write([]byte{0x05, 0x00})
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return
+	}
+	var host string
+	switch request[3] {
+	case 0x01, 0x04:
+		ip := make([]byte, 4)
+		if request[3] == 0x04 {
+			ip = make([]byte, 16)
+		}
+		io.ReadFull(conn, ip)
+		host = net.IP(ip).String()
+	case 0x03:
+		n := make([]byte, 1)
+		io.ReadFull(conn, n)
+		name := make([]byte, n[0])
+		io.ReadFull(conn, name)
+		host = string(name)
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return
+	}
+	target := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+	s.mu.Lock()
+	s.targets = append(s.targets, target)
+	s.mu.Unlock()
+
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		conn.Write([]byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+	go io.Copy(upstream, conn)
+	io.Copy(conn, upstream)
+}
+
+func (s *socksServer) requestedTargets() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.targets...)
+}
+
+// socksProfile 返回一个指向上游 SOCKS5 代理 addr 的服务器配置。
+func socksProfile(id, remarks, addr, via string) *types.ServerProfile {
+	host, port, _ := net.SplitHostPort(addr)
+	p, _ := strconv.Atoi(port)
+	return &types.ServerProfile{
+		ID:            id,
+		Remarks:       remarks,
+		Type:          "http",
+		ProxyProtocol: "socks5",
+		Address:       host,
+		Port:          p,
+		Via:           via,
+	}
+}
+
+// recordingStateManager 记录策略上报的故障。
+type recordingStateManager struct {
+	mu    sync.Mutex
+	downs []string // "serverID: reason"
+}
+
+func (m *recordingStateManager) SetServerStatusDown(serverID, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.downs = append(m.downs, serverID+": "+reason)
+}
+
+func (m *recordingStateManager) reports() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.downs...)
+}
+
+func TestFindProfile(t *testing.T) {
+	a := &types.ServerProfile{ID: "id-a", Remarks: "a"}
+	b := &types.ServerProfile{ID: "id-b", Remarks: "id-a"} // remarks 与 a 的 ID 相同
+	profiles := []*types.ServerProfile{b, a}
+
+	for ref, want := range map[string]*types.ServerProfile{
+		"id-a":    a, // ID 优先于 remarks
+		"a":       a,
+		"id-b":    b,
+		"missing": nil,
+	} {
+		if got := findProfile(profiles, ref); got != want {
+			t.Errorf("findProfile(%q) = %v, want %v", ref, got, want)
+		}
+	}
+}
+
+func TestViaChain(t *testing.T) {
+	echo := testutil.StartEchoServer(t)
+	first, second, entry := startSOCKSServer(t), startSOCKSServer(t), startSOCKSServer(t)
+	// 连接顺序：first -> second -> entry -> echo；entry 按 remarks 引用 second，second 按 ID 引用 first
+	profiles := []*types.ServerProfile{
+		socksProfile("entry-id", "entry", entry.addr, "second"),
+		socksProfile("second-id", "second", second.addr, "first-id"),
+		socksProfile("first-id", "first", first.addr, ""),
+	}
+	profiles[0].Active = true
+
+	strategy, err := NewStrategy(&types.Config{}, profiles, &recordingStateManager{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer strategy.CloseTunnel()
+
+	conn := testutil.DialThroughStrategy(t, strategy, echo)
+	defer conn.Close()
+	testutil.ExpectEcho(t, conn, "through the chain")
+
+	for name, tt := range map[string]struct {
+		server *socksServer
+		want   string
+	}{
+		"first":  {first, second.addr},
+		"second": {second, entry.addr},
+		"entry":  {entry, echo},
+	} {
+		if got := tt.server.requestedTargets(); len(got) != 1 || got[0] != tt.want {
+			t.Errorf("%s received CONNECT %v, want [%s]", name, got, tt.want)
+		}
+	}
+}
+
+func TestViaChainErrors(t *testing.T) {
+	addr := startSOCKSServer(t).addr
+	wg := &types.ServerProfile{ID: "wg-id", Remarks: "wg", Type: "wireguard", Via: "hop"}
+
+	for _, tt := range []struct {
+		name     string
+		profiles []*types.ServerProfile
+		want     string
+	}{
+		{
+			name:     "missing",
+			profiles: []*types.ServerProfile{socksProfile("a-id", "a", addr, "nowhere")},
+			want:     "via server 'nowhere' of 'a' not found",
+		},
+		{
+			name:     "self loop",
+			profiles: []*types.ServerProfile{socksProfile("a-id", "a", addr, "a")},
+			want:     "loops back to 'a'",
+		},
+		{
+			name: "loop",
+			profiles: []*types.ServerProfile{
+				socksProfile("a-id", "a", addr, "b"),
+				socksProfile("b-id", "b", addr, "c-id"),
+				socksProfile("c-id", "c", addr, "b"),
+			},
+			want: "via chain of 'c' loops back to 'b'",
+		},
+		{
+			name:     "wireguard",
+			profiles: []*types.ServerProfile{wg, socksProfile("hop-id", "hop", addr, "")},
+			want:     "wireguard server 'wg' cannot use via",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.profiles[0].Active = true
+			strategy, err := NewStrategy(&types.Config{}, tt.profiles, nil)
+			if err == nil {
+				strategy.CloseTunnel()
+				t.Fatal("NewStrategy() succeeded")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("NewStrategy() error = %q, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestViaChainReportsHopFailureOnEntry(t *testing.T) {
+	// 第一跳指向已关闭的端口
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadAddr := ln.Addr().String()
+	ln.Close()
+
+	profiles := []*types.ServerProfile{
+		socksProfile("entry-id", "entry", startSOCKSServer(t).addr, "hop"),
+		socksProfile("hop-id", "hop", deadAddr, ""),
+	}
+	profiles[0].Active = true
+	states := &recordingStateManager{}
+	strategy, err := NewStrategy(&types.Config{}, profiles, states)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer strategy.CloseTunnel()
+
+	pipeConn, err := strategy.GetSocksConnection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pipeConn.Close()
+	// CONNECT 127.0.0.1:9 会在第一跳失败
+	pipeConn.Write([]byte{0x05, 0x01, 0x00, 0x05, 0x01, 0x00, 0x01, 127, 0, 0, 1, 0, 9})
+	io.ReadAll(pipeConn)
+
+	reports := states.reports()
+	if len(reports) == 0 {
+		t.Fatal("hop failure was not reported")
+	}
+	var viaReported bool
+	for _, r := range reports {
+		if !strings.HasPrefix(r, "entry-id: ") {
+			t.Errorf("failure reported against %q, want the entry server", r)
+		}
+		viaReported = viaReported || strings.HasPrefix(r, "entry-id: via chain: ")
+	}
+	if !viaReported {
+		t.Errorf("reports %v do not include the via hop's failure", reports)
+	}
+}
+
+// closeRecordingStrategy 在 CloseTunnel 时像真实策略一样释放自己的拨号器，并记录关闭次数。
+type closeRecordingStrategy struct {
+	types.TunnelStrategy
+	dialer types.Dialer
+	closed int
+}
+
+func (s *closeRecordingStrategy) CloseTunnel() {
+	s.closed++
+	outbound.CloseDialer(s.dialer)
+}
+
+func TestCloseDialerClosesWholeChain(t *testing.T) {
+	last := &closeRecordingStrategy{}
+	middle := &closeRecordingStrategy{dialer: &viaDialer{hop: last, remarks: "last"}}
+	chain := &viaDialer{hop: middle, remarks: "middle"}
+
+	outbound.CloseDialer(chain)
+	outbound.CloseDialer(chain)
+	if middle.closed != 1 || last.closed != 1 {
+		t.Errorf("hops closed %d and %d times, want once each", middle.closed, last.closed)
+	}
+}
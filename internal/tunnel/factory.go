@@ -5,13 +5,19 @@ import (
 	"liuproxy_nexus/internal/shared/types"
 	"liuproxy_nexus/internal/tunnel/goremote"
 	"liuproxy_nexus/internal/tunnel/httpproxy"
+	"liuproxy_nexus/internal/tunnel/shadowsocks"
 	"liuproxy_nexus/internal/tunnel/socks5proxy"
+	"liuproxy_nexus/internal/tunnel/trojan"
 	"liuproxy_nexus/internal/tunnel/vless"
+	"liuproxy_nexus/internal/tunnel/wireguard"
 	"liuproxy_nexus/internal/tunnel/worker"
 )
 
 // NewStrategy is the factory function to create a new strategy based on the active profile.
 // This is the single entry point for creating any strategy.
+//
+// The first active profile is the one being created; the remaining profiles are only used to
+// resolve its `via` chain, so callers should pass every known profile after the active one.
 func NewStrategy(cfg *types.Config, profiles []*types.ServerProfile, stateManager types.StateManager) (types.TunnelStrategy, error) {
 	var activeProfile *types.ServerProfile
 	for _, p := range profiles {
@@ -25,24 +31,52 @@ func NewStrategy(cfg *types.Config, profiles []*types.ServerProfile, stateManage
 		return nil, nil
 	}
 
-	switch activeProfile.Type {
+	hopState := &viaStateManager{parent: stateManager, serverID: activeProfile.ID}
+	via, err := newViaDialer(cfg, activeProfile, profiles, hopState, map[*types.ServerProfile]bool{activeProfile: true})
+	if err != nil {
+		return nil, err
+	}
+	if via == nil {
+		return newStrategy(cfg, activeProfile, stateManager, nil)
+	}
+	strategy, err := newStrategy(cfg, activeProfile, stateManager, via)
+	if err != nil {
+		via.Close()
+	}
+	return strategy, err
+}
+
+// newStrategy creates a single strategy whose connections to its server are made through dialer.
+// A nil dialer means the server is reached directly.
+func newStrategy(cfg *types.Config, profile *types.ServerProfile, stateManager types.StateManager, dialer types.Dialer) (types.TunnelStrategy, error) {
+	switch profile.Type {
 	case "vless":
-		return vless.NewVlessStrategy(cfg, activeProfile, stateManager)
+		return vless.NewVlessStrategy(cfg, profile, stateManager, dialer)
 	case "worker":
-		return worker.NewWorkerStrategy(cfg, activeProfile, stateManager)
+		return worker.NewWorkerStrategy(cfg, profile, stateManager, dialer)
 	case "goremote", "remote", "":
-		return goremote.NewGoRemoteStrategy(cfg, activeProfile, stateManager)
+		return goremote.NewGoRemoteStrategy(cfg, profile, stateManager, dialer)
+	case "shadowsocks", "ss":
+		return shadowsocks.NewShadowsocksStrategy(cfg, profile, stateManager, dialer)
+	case "trojan":
+		return trojan.NewTrojanStrategy(cfg, profile, stateManager, dialer)
+	case "wireguard", "wg":
+		// A via chain only carries TCP (SOCKS5 CONNECT), but WireGuard runs entirely over UDP.
+		if dialer != nil {
+			return nil, fmt.Errorf("wireguard server '%s' cannot use via: via servers only carry TCP and WireGuard runs over UDP", profile.Remarks)
+		}
+		return wireguard.NewWireGuardStrategy(cfg, profile, stateManager, dialer)
 	case "http":
 		// Check the specific protocol for the "http" type server profile.
-		switch activeProfile.ProxyProtocol {
+		switch profile.ProxyProtocol {
 		case "socks5":
-			return socks5proxy.NewSOCKS5Strategy(cfg, activeProfile, stateManager)
+			return socks5proxy.NewSOCKS5Strategy(cfg, profile, stateManager, dialer)
 		case "http", "": // Default to HTTP/HTTPS proxy
-			return httpproxy.NewHTTPStrategy(cfg, activeProfile, stateManager)
+			return httpproxy.NewHTTPStrategy(cfg, profile, stateManager, dialer)
 		default:
-			return nil, fmt.Errorf("unknown proxy_protocol for http type: '%s'", activeProfile.ProxyProtocol)
+			return nil, fmt.Errorf("unknown proxy_protocol for http type: '%s'", profile.ProxyProtocol)
 		}
 	default:
-		return nil, fmt.Errorf("unknown or unsupported strategy type: '%s'", activeProfile.Type)
+		return nil, fmt.Errorf("unknown or unsupported strategy type: '%s'", profile.Type)
 	}
 }
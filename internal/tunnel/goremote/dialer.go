@@ -2,14 +2,16 @@ package goremote
 
 import (
 	"fmt"
+	"liuproxy_nexus/internal/shared/outbound"
+	"liuproxy_nexus/internal/shared/types"
 	"net"
 	"time"
 )
 
 // DialTCP 负责为 GoRemote 策略建立一个纯 TCP 连接
-func DialTCP(addr string) (net.Conn, error) {
+func DialTCP(dialer types.Dialer, addr string) (net.Conn, error) {
 	//logger.Debug().Str("addr", addr).Msg("[GoRemote Dialer] Dialing new raw TCP connection...")
-	conn, err := net.DialTimeout("tcp", addr, 15*time.Second)
+	conn, err := outbound.DialWithTimeout(dialer, "tcp", addr, 15*time.Second)
 	if err != nil {
 		return nil, fmt.Errorf("goremote raw TCP dial failed: %w", err)
 	}
@@ -0,0 +1,265 @@
+package goremote
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"liuproxy_nexus/internal/shared/securecrypt"
+)
+
+// goremote v3 握手 (仅 PSK 模式，旧版整数密钥模式仍使用无握手的 v2 帧格式):
+//
+//	客户端 -> 服务器: [版本 1B][客户端 nonce 32B][Unix 时间戳 8B] 后接第一帧 (加密的 Metadata)
+//	服务器 -> 客户端: [服务器 nonce 32B] 后接数据帧
+//	数据帧: [2 字节长度][AEAD 密文]，nonce 为每个方向独立递增的计数器，不随帧发送
+//
+// 上行密钥 = HKDF(psk, 客户端 nonce, info || 版本 || 时间戳)，篡改时间戳或 nonce 都会使第一帧解密失败；
+// 客户端无需等待服务器应答即可发送数据。下行密钥 = HKDF(psk, 客户端 nonce || 服务器 nonce, info)，
+// 重放的请求即使通过了服务器检查，也无法解密服务器发回的数据。
+// 服务器只接受时间窗口内的时间戳，并用 ReplayFilter 拒绝窗口内重复出现的客户端 nonce。
+const (
+	ProtocolVersion byte = 0x03
+	// HandshakeNonceSize 是客户端和服务器 nonce 的长度。
+	HandshakeNonceSize = 32
+	// TimestampWindow 是服务器接受的客户端时钟偏差。
+	TimestampWindow = 2 * time.Minute
+
+	clientHelloSize = 1 + HandshakeNonceSize + 8
+	// maxFramePayload 保证加密后的帧长度能放入 2 字节长度字段。
+	maxFramePayload = 16 * 1024
+
+	infoUplink   = "liuproxy-goremote-v3-c2s"
+	infoDownlink = "liuproxy-goremote-v3-s2c"
+)
+
+var (
+	errBadVersion   = errors.New("goremote: unsupported protocol version")
+	errStaleRequest = errors.New("goremote: request timestamp outside the allowed window")
+	errReplayed     = errors.New("goremote: replayed request")
+)
+
+// frameCipher 是一个方向上的帧加密器。v3 使用 *securecrypt.CounterCipher，旧版使用 *securecrypt.Cipher。
+type frameCipher interface {
+	Encrypt(plaintext []byte) ([]byte, error)
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
+
+// writeFrame 加密并写出 p，过长的数据会被拆分成多帧。每帧的长度和密文合并为一次写入。
+func writeFrame(w io.Writer, c frameCipher, p []byte) error {
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > maxFramePayload {
+			chunk = chunk[:maxFramePayload]
+		}
+		p = p[len(chunk):]
+
+		encrypted, err := c.Encrypt(chunk)
+		if err != nil {
+			return err
+		}
+		frame := make([]byte, 0, 2+len(encrypted))
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(encrypted)))
+		frame = append(frame, encrypted...)
+		if _, err := w.Write(frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeDatagram 把一个数据报加密为单独一帧，数据报不会被拆分，过大时返回错误。
+func writeDatagram(w io.Writer, c frameCipher, p []byte) error {
+	encrypted, err := c.Encrypt(p)
+	if err != nil {
+		return err
+	}
+	if len(encrypted) > 0xFFFF {
+		return fmt.Errorf("datagram too large: %d bytes", len(p))
+	}
+	frame := make([]byte, 0, 2+len(encrypted))
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(encrypted)))
+	frame = append(frame, encrypted...)
+	_, err = w.Write(frame)
+	return err
+}
+
+// readFrame 读取并解密一帧。
+func readFrame(r io.Reader, c frameCipher) ([]byte, error) {
+	lenBuf := make([]byte, 2)
+	if _, err := io.ReadFull(r, lenBuf); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(lenBuf))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return c.Decrypt(buf)
+}
+
+func uplinkInfo(timestamp []byte) []byte {
+	info := append([]byte(infoUplink), ProtocolVersion)
+	return append(info, timestamp...)
+}
+
+func randomNonce() ([]byte, error) {
+	nonce := make([]byte, HandshakeNonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return nonce, nil
+}
+
+// writeClientHello 写出客户端握手和加密的元数据，返回上行加密器和客户端 nonce。
+func writeClientHello(w io.Writer, keys *securecrypt.KeySource, metadata []byte, now time.Time) (frameCipher, []byte, error) {
+	clientNonce, err := randomNonce()
+	if err != nil {
+		return nil, nil, err
+	}
+	hello := make([]byte, 0, clientHelloSize)
+	hello = append(hello, ProtocolVersion)
+	hello = append(hello, clientNonce...)
+	hello = binary.BigEndian.AppendUint64(hello, uint64(now.Unix()))
+
+	up, err := keys.DeriveCounterCipher(clientNonce, uplinkInfo(hello[1+HandshakeNonceSize:]))
+	if err != nil {
+		return nil, nil, err
+	}
+	encryptedMeta, err := up.Encrypt(metadata)
+	if err != nil {
+		return nil, nil, err
+	}
+	// 握手和元数据合并为一次写入，WebSocket 传输下它们位于同一个消息中
+	hello = binary.BigEndian.AppendUint16(hello, uint16(len(encryptedMeta)))
+	hello = append(hello, encryptedMeta...)
+	if _, err := w.Write(hello); err != nil {
+		return nil, nil, err
+	}
+	return up, clientNonce, nil
+}
+
+// readServerHello 读取服务器 nonce，返回下行解密器。
+func readServerHello(r io.Reader, keys *securecrypt.KeySource, clientNonce []byte) (frameCipher, error) {
+	serverNonce := make([]byte, HandshakeNonceSize)
+	if _, err := io.ReadFull(r, serverNonce); err != nil {
+		return nil, fmt.Errorf("failed to read server hello: %w", err)
+	}
+	return keys.DeriveCounterCipher(append(append([]byte{}, clientNonce...), serverNonce...), []byte(infoDownlink))
+}
+
+// readClientHello 在服务器端读取并校验客户端握手，返回解密后的元数据、上行解密器和客户端 nonce。
+// 只有元数据解密成功后 nonce 才会被记入 filter，伪造的请求无法占用过滤器。
+func readClientHello(r io.Reader, keys *securecrypt.KeySource, filter *ReplayFilter, now time.Time) ([]byte, frameCipher, []byte, error) {
+	hello := make([]byte, clientHelloSize)
+	if _, err := io.ReadFull(r, hello); err != nil {
+		return nil, nil, nil, err
+	}
+	if hello[0] != ProtocolVersion {
+		return nil, nil, nil, errBadVersion
+	}
+	clientNonce := hello[1 : 1+HandshakeNonceSize]
+	timestamp := hello[1+HandshakeNonceSize:]
+	sent := time.Unix(int64(binary.BigEndian.Uint64(timestamp)), 0)
+	if d := now.Sub(sent); d > TimestampWindow || d < -TimestampWindow {
+		return nil, nil, nil, errStaleRequest
+	}
+
+	up, err := keys.DeriveCounterCipher(clientNonce, uplinkInfo(timestamp))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	metadata, err := readFrame(r, up)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if !filter.Check(clientNonce, now) {
+		return nil, nil, nil, errReplayed
+	}
+	return metadata, up, clientNonce, nil
+}
+
+// writeServerHello 写出服务器 nonce，返回下行加密器。
+func writeServerHello(w io.Writer, keys *securecrypt.KeySource, clientNonce []byte) (frameCipher, error) {
+	serverNonce, err := randomNonce()
+	if err != nil {
+		return nil, err
+	}
+	down, err := keys.DeriveCounterCipher(append(append([]byte{}, clientNonce...), serverNonce...), []byte(infoDownlink))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(serverNonce); err != nil {
+		return nil, err
+	}
+	return down, nil
+}
+
+// UDP 数据报 (PSK 模式) 为 SealPacket([Unix 时间戳 8B][SOCKS5 UDP 数据])，即 salt || 密文。
+// 接收方与 TCP 握手一样只接受时间窗口内的时间戳，并用 ReplayFilter 拒绝重复出现的 salt。
+// 旧版整数密钥模式的数据报没有 salt，格式不变，仍然可以被重放。
+
+// sealPacket 加密一个发往对端的数据报。
+func sealPacket(keys *securecrypt.KeySource, payload []byte, now time.Time) ([]byte, error) {
+	if keys.IsLegacy() {
+		return keys.SealPacket(payload)
+	}
+	plaintext := make([]byte, 0, 8+len(payload))
+	plaintext = binary.BigEndian.AppendUint64(plaintext, uint64(now.Unix()))
+	return keys.SealPacket(append(plaintext, payload...))
+}
+
+// openPacket 解密并校验 sealPacket 生成的数据报。只有解密成功后 salt 才会被记入 filter。
+func openPacket(keys *securecrypt.KeySource, filter *ReplayFilter, packet []byte, now time.Time) ([]byte, error) {
+	plaintext, err := keys.OpenPacket(packet)
+	if err != nil || keys.IsLegacy() {
+		return plaintext, err
+	}
+	if len(plaintext) < 8 {
+		return nil, errors.New("goremote: packet is too short")
+	}
+	sent := time.Unix(int64(binary.BigEndian.Uint64(plaintext)), 0)
+	if d := now.Sub(sent); d > TimestampWindow || d < -TimestampWindow {
+		return nil, errStaleRequest
+	}
+	if !filter.Check(packet[:securecrypt.SaltSize], now) {
+		return nil, errReplayed
+	}
+	return plaintext[8:], nil
+}
+
+// ReplayFilter 记录最近出现过的客户端 nonce (或数据报 salt)。时间窗口之外的请求已被时间戳检查拒绝，
+// 因此记录只需保留两个窗口长度，内存占用与窗口内的连接数成正比。
+type ReplayFilter struct {
+	mu        sync.Mutex
+	seen      map[[HandshakeNonceSize]byte]time.Time
+	lastPrune time.Time
+}
+
+func NewReplayFilter() *ReplayFilter {
+	return &ReplayFilter{seen: make(map[[HandshakeNonceSize]byte]time.Time)}
+}
+
+// Check 记录 nonce。如果它在保留期内已经出现过，返回 false。
+func (f *ReplayFilter) Check(nonce []byte, now time.Time) bool {
+	key := [HandshakeNonceSize]byte(nonce)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if now.Sub(f.lastPrune) > TimestampWindow {
+		for k, expiry := range f.seen {
+			if now.After(expiry) {
+				delete(f.seen, k)
+			}
+		}
+		f.lastPrune = now
+	}
+	if expiry, ok := f.seen[key]; ok && !now.After(expiry) {
+		return false
+	}
+	f.seen[key] = now.Add(2 * TimestampWindow)
+	return true
+}
@@ -0,0 +1,254 @@
+package goremote
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"liuproxy_nexus/internal/shared/securecrypt"
+)
+
+func newTestKeys(t *testing.T) *securecrypt.KeySource {
+	t.Helper()
+	psk := make([]byte, securecrypt.PSKSize)
+	if _, err := rand.Read(psk); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := securecrypt.NewKeySource(base64.StdEncoding.EncodeToString(psk), false, 0, securecrypt.CHACHA20_POLY1305)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// clientRequest 生成一个完整的客户端请求：握手、元数据和一个数据帧。
+func clientRequest(t *testing.T, keys *securecrypt.KeySource, now time.Time) ([]byte, frameCipher, []byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	up, clientNonce, err := writeClientHello(&buf, keys, []byte("metadata"), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFrame(&buf, up, []byte("payload")); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), up, clientNonce
+}
+
+func TestHandshakeRoundTrip(t *testing.T) {
+	keys := newTestKeys(t)
+	now := time.Now()
+	request, up, clientNonce := clientRequest(t, keys, now)
+
+	r := bytes.NewReader(request)
+	metadata, serverUp, serverNonce, err := readClientHello(r, keys, NewReplayFilter(), now)
+	if err != nil {
+		t.Fatalf("readClientHello() error = %v", err)
+	}
+	if string(metadata) != "metadata" || !bytes.Equal(serverNonce, clientNonce) {
+		t.Fatalf("metadata = %q, nonce = %x", metadata, serverNonce)
+	}
+	if payload, err := readFrame(r, serverUp); err != nil || string(payload) != "payload" {
+		t.Fatalf("readFrame() = %q, %v", payload, err)
+	}
+
+	// 下行：服务器握手 + 帧
+	var response bytes.Buffer
+	down, err := writeServerHello(&response, keys, clientNonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	large := bytes.Repeat([]byte("x"), 3*maxFramePayload+1)
+	if err := writeFrame(&response, down, large); err != nil {
+		t.Fatal(err)
+	}
+	clientDown, err := readServerHello(&response, keys, clientNonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []byte
+	for response.Len() > 0 {
+		chunk, err := readFrame(&response, clientDown)
+		if err != nil {
+			t.Fatalf("readFrame() error = %v", err)
+		}
+		got = append(got, chunk...)
+	}
+	if !bytes.Equal(got, large) {
+		t.Fatalf("downlink got %d bytes, want %d", len(got), len(large))
+	}
+
+	// 上行和下行密钥不同，上行帧不能被反射回客户端
+	var reflected bytes.Buffer
+	if err := writeFrame(&reflected, up, []byte("reflect")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readFrame(&reflected, clientDown); err == nil {
+		t.Fatal("an uplink frame decrypted with the downlink key")
+	}
+}
+
+func TestHandshakeTampering(t *testing.T) {
+	keys := newTestKeys(t)
+	now := time.Now()
+	request, _, _ := clientRequest(t, keys, now)
+	metaEnd := clientHelloSize + 2 + int(binary.BigEndian.Uint16(request[clientHelloSize:]))
+
+	tests := map[string]int{
+		"version":   0,
+		"nonce":     1,
+		"timestamp": clientHelloSize - 1,
+		"metadata":  metaEnd - 1,
+	}
+	for name, offset := range tests {
+		tampered := bytes.Clone(request)
+		tampered[offset] ^= 0x01
+		if _, _, _, err := readClientHello(bytes.NewReader(tampered), keys, NewReplayFilter(), now); err == nil {
+			t.Errorf("%s: tampered request accepted", name)
+		}
+	}
+
+	// 篡改数据帧
+	tampered := bytes.Clone(request)
+	tampered[len(tampered)-1] ^= 0x01
+	r := bytes.NewReader(tampered)
+	_, up, _, err := readClientHello(r, keys, NewReplayFilter(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readFrame(r, up); err == nil {
+		t.Error("tampered data frame accepted")
+	}
+
+	// 篡改服务器 nonce
+	var response bytes.Buffer
+	clientNonce := request[1 : 1+HandshakeNonceSize]
+	down, err := writeServerHello(&response, keys, clientNonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFrame(&response, down, []byte("reply")); err != nil {
+		t.Fatal(err)
+	}
+	tamperedResponse := response.Bytes()
+	tamperedResponse[0] ^= 0x01
+	clientDown, err := readServerHello(bytes.NewReader(tamperedResponse), keys, clientNonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readFrame(bytes.NewReader(tamperedResponse[HandshakeNonceSize:]), clientDown); err == nil {
+		t.Error("reply accepted after the server nonce was tampered with")
+	}
+}
+
+func TestHandshakeReplay(t *testing.T) {
+	keys := newTestKeys(t)
+	filter := NewReplayFilter()
+	now := time.Now()
+	request, _, _ := clientRequest(t, keys, now)
+
+	if _, _, _, err := readClientHello(bytes.NewReader(request), keys, filter, now); err != nil {
+		t.Fatalf("first request rejected: %v", err)
+	}
+	if _, _, _, err := readClientHello(bytes.NewReader(request), keys, filter, now.Add(time.Second)); err != errReplayed {
+		t.Fatalf("replayed request: err = %v, want %v", err, errReplayed)
+	}
+	// 窗口过后重放的请求被时间戳检查拒绝
+	if _, _, _, err := readClientHello(bytes.NewReader(request), keys, filter, now.Add(TimestampWindow+time.Second)); err != errStaleRequest {
+		t.Fatalf("late replay: err = %v, want %v", err, errStaleRequest)
+	}
+
+	// 过期或来自未来的时间戳
+	for _, skew := range []time.Duration{-TimestampWindow - time.Second, TimestampWindow + time.Second} {
+		stale, _, _ := clientRequest(t, keys, now.Add(skew))
+		if _, _, _, err := readClientHello(bytes.NewReader(stale), keys, filter, now); err != errStaleRequest {
+			t.Errorf("skew %v: err = %v, want %v", skew, err, errStaleRequest)
+		}
+	}
+
+	// 同一会话内重放或乱序的数据帧
+	var buf bytes.Buffer
+	up, _, err := writeClientHello(&buf, keys, []byte("metadata"), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	helloLen := buf.Len()
+	for _, p := range []string{"first", "second"} {
+		if err := writeFrame(&buf, up, []byte(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	frames := buf.Bytes()[helloLen:]
+	first := frames[:len(frames)/2]
+	replayed := append(append(bytes.Clone(buf.Bytes()[:helloLen]), first...), first...)
+	r := bytes.NewReader(replayed)
+	_, serverUp, _, err := readClientHello(r, keys, NewReplayFilter(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readFrame(r, serverUp); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readFrame(r, serverUp); err == nil {
+		t.Error("a replayed data frame was accepted")
+	}
+}
+
+func TestPacketReplay(t *testing.T) {
+	keys := newTestKeys(t)
+	filter := NewReplayFilter()
+	now := time.Now()
+	packet, err := sealPacket(keys, []byte("datagram"), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := openPacket(keys, filter, packet, now)
+	if err != nil || string(payload) != "datagram" {
+		t.Fatalf("openPacket() = %q, %v", payload, err)
+	}
+	if _, err := openPacket(keys, filter, packet, now.Add(time.Second)); err != errReplayed {
+		t.Fatalf("replayed packet: err = %v, want %v", err, errReplayed)
+	}
+	if _, err := openPacket(keys, filter, packet, now.Add(TimestampWindow+time.Second)); err != errStaleRequest {
+		t.Fatalf("late replay: err = %v, want %v", err, errStaleRequest)
+	}
+	for _, skew := range []time.Duration{-TimestampWindow - time.Second, TimestampWindow + time.Second} {
+		stale, err := sealPacket(keys, []byte("datagram"), now.Add(skew))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := openPacket(keys, filter, stale, now); err != errStaleRequest {
+			t.Errorf("skew %v: err = %v, want %v", skew, err, errStaleRequest)
+		}
+	}
+
+	// 篡改的数据报不会占用过滤器
+	tampered, err := sealPacket(keys, []byte("datagram"), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered[len(tampered)-1] ^= 1
+	if _, err := openPacket(keys, filter, tampered, now); err == nil {
+		t.Fatal("tampered packet accepted")
+	}
+	if len(filter.seen) != 1 {
+		t.Errorf("filter holds %d salts, want 1", len(filter.seen))
+	}
+}
+
+func TestReplayFilterPrune(t *testing.T) {
+	filter := NewReplayFilter()
+	now := time.Now()
+	nonce := bytes.Repeat([]byte{1}, HandshakeNonceSize)
+	if !filter.Check(nonce, now) || filter.Check(nonce, now.Add(time.Minute)) {
+		t.Fatal("unexpected Check result")
+	}
+	filter.Check(bytes.Repeat([]byte{2}, HandshakeNonceSize), now.Add(3*TimestampWindow))
+	if len(filter.seen) != 1 {
+		t.Fatalf("expired entries were not pruned: %d left", len(filter.seen))
+	}
+}
@@ -0,0 +1,78 @@
+package goremote
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/xtaci/smux"
+
+	"liuproxy_nexus/internal/shared/muxpool"
+)
+
+// 会话达到最大存活时间或累计流数后退役，避免单条长连接被识别或积累状态
+var muxPoolConfig = muxpool.Config{
+	MaxAge:     30 * time.Minute,
+	MaxStreams: 4096,
+}
+
+// newMuxPool 创建 smux 会话池，dial 建立一条到服务器的物理连接。
+func (s *GoRemoteStrategy) newMuxPool(size int) *muxpool.Pool[*smux.Session] {
+	config := muxPoolConfig
+	config.Size = size
+	return muxpool.New(config, func() (*smux.Session, error) {
+		return dialMuxSession(s.dialRemote)
+	}, s.logger)
+}
+
+// openStream 在会话池中流数最少的会话上打开一个新流。
+func (s *GoRemoteStrategy) openStream() (*smux.Stream, error) {
+	var stream *smux.Stream
+	err := s.mux.Open(func(session *smux.Session) (err error) {
+		stream, err = session.OpenStream()
+		return err
+	})
+	return stream, err
+}
+
+// dialMuxSession 在新连接上建立 smux 客户端会话。
+func dialMuxSession(dial func() (net.Conn, error)) (*smux.Session, error) {
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	smuxConfig := smux.DefaultConfig()
+	smuxConfig.Version = smuxVersion
+	smuxConfig.KeepAliveInterval = 10 * time.Second
+	smuxConfig.KeepAliveTimeout = 30 * time.Second
+	watched := &readErrorConn{Conn: conn, failed: make(chan struct{})}
+	session, err := smux.Client(watched, smuxConfig)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go func() {
+		select {
+		case <-session.CloseChan():
+		case <-watched.failed:
+			// smux 读出错后不会自行关闭会话，要等 keepalive 超时；连接断开时立即关闭，池随即补充
+			session.Close()
+		}
+	}()
+	return session, nil
+}
+
+// readErrorConn 在第一次读出错时关闭 failed。
+type readErrorConn struct {
+	net.Conn
+	failed chan struct{}
+	once   sync.Once
+}
+
+func (c *readErrorConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if err != nil {
+		c.once.Do(func() { close(c.failed) })
+	}
+	return n, err
+}
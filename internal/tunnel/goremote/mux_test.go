@@ -0,0 +1,32 @@
+package goremote
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xtaci/smux"
+)
+
+// TestDialMuxSessionClosesOnConnError 检查物理连接断开时会话立即关闭，而不是等待 keepalive 超时。
+func TestDialMuxSessionClosesOnConnError(t *testing.T) {
+	client, server := net.Pipe()
+	session, err := dialMuxSession(func() (net.Conn, error) { return client, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if _, err := smux.Server(server, &smux.Config{
+		Version: smuxVersion, KeepAliveInterval: 10 * time.Second, KeepAliveTimeout: 30 * time.Second,
+		MaxFrameSize: 32768, MaxReceiveBuffer: 4194304, MaxStreamBuffer: 65536,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	server.Close()
+	select {
+	case <-session.CloseChan():
+	case <-time.After(5 * time.Second):
+		t.Fatal("session still open after the connection failed")
+	}
+}
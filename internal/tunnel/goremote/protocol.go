@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strconv"
 )
 
 // StreamType 定义了 goremote v3 协议中的流类型
@@ -129,3 +130,53 @@ func ReadMetadata(reader io.Reader) (*Metadata, error) {
 
 	return meta, nil
 }
+
+// appendSocksAddr 以 SOCKS5 编码 [ATYP][ADDR][PORT] 追加 UDP 地址。
+func appendSocksAddr(b []byte, addr *net.UDPAddr) []byte {
+	if ipv4 := addr.IP.To4(); ipv4 != nil {
+		b = append(b, AddrTypeIPv4)
+		b = append(b, ipv4...)
+	} else {
+		b = append(b, AddrTypeIPv6)
+		b = append(b, addr.IP.To16()...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(addr.Port))
+}
+
+// readSocksAddr 解析 SOCKS5 编码的地址 (IPv4、域名或 IPv6)，返回 host:port 和剩余的数据。
+func readSocksAddr(data []byte) (string, []byte, error) {
+	if len(data) < 1 {
+		return "", nil, io.ErrShortBuffer
+	}
+	offset := 1
+	var host string
+	switch data[0] {
+	case AddrTypeIPv4:
+		if len(data) < offset+4+2 {
+			return "", nil, io.ErrShortBuffer
+		}
+		host = net.IP(data[offset : offset+4]).String()
+		offset += 4
+	case AddrTypeIPv6:
+		if len(data) < offset+16+2 {
+			return "", nil, io.ErrShortBuffer
+		}
+		host = net.IP(data[offset : offset+16]).String()
+		offset += 16
+	case AddrTypeDomain:
+		if len(data) < offset+1 {
+			return "", nil, io.ErrShortBuffer
+		}
+		domainLen := int(data[offset])
+		offset++
+		if len(data) < offset+domainLen+2 {
+			return "", nil, io.ErrShortBuffer
+		}
+		host = string(data[offset : offset+domainLen])
+		offset += domainLen
+	default:
+		return "", nil, fmt.Errorf("unsupported address type: %d", data[0])
+	}
+	port := binary.BigEndian.Uint16(data[offset:])
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), data[offset+2:], nil
+}
@@ -0,0 +1,605 @@
+package goremote
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/xtaci/smux"
+
+	"liuproxy_nexus/internal/shared"
+	"liuproxy_nexus/internal/shared/outbound"
+	"liuproxy_nexus/internal/shared/securecrypt"
+	"liuproxy_nexus/internal/shared/types"
+)
+
+const (
+	// serverHandshakeTimeout 限制客户端发送握手 (或 WebSocket 升级请求) 的时间
+	serverHandshakeTimeout = 30 * time.Second
+	serverDialTimeout      = 10 * time.Second
+	// smuxVersion 是客户端使用的 smux 协议版本，也是 mux 连接的第一个字节
+	smuxVersion = 2
+)
+
+// Server 是 goremote 服务器端 (remote 模式)。TCP、WebSocket 和 UDP 共用一个端口：
+//   - TCP 连接以 "GET " 开头时按 HTTP 处理，路径匹配 WSPath 的请求升级为 WebSocket；
+//   - 连接 (TCP 或 WebSocket) 的第一个字节为 smux 版本号时按多路复用会话处理，每个流是一条独立的 goremote 流；
+//   - 否则整条连接就是一条 goremote 流 (v3 握手或旧版帧格式，取决于密钥模式)；
+//   - UDP 数据报为 sealPacket 加密的 SOCKS5 UDP 请求。
+type Server struct {
+	config *types.Config
+	logger zerolog.Logger
+	keys   *securecrypt.KeySource
+	dialer types.Dialer
+	filter *ReplayFilter
+
+	tcpListener net.Listener
+	udpConn     net.PacketConn
+	httpServer  *http.Server
+	wsConns     *connListener
+
+	udpSessions sync.Map // key: 客户端地址, value: *serverUDPSession
+	conns       sync.Map // 活跃的入站连接，关闭服务器时一并关闭
+	done        chan struct{}
+	closeOnce   sync.Once
+	wg          sync.WaitGroup
+}
+
+// NewServer 根据 liuproxy.ini 的 [remote] 配置创建服务器。dialer 用于连接目标，nil 表示直连。
+func NewServer(cfg *types.Config, dialer types.Dialer) (*Server, error) {
+	keys, err := securecrypt.NewKeySource(cfg.RemoteConf.PSK, cfg.RemoteConf.LegacyCrypt, cfg.Crypt, securecrypt.CHACHA20_POLY1305)
+	if err != nil {
+		return nil, fmt.Errorf("goremote server: %w", err)
+	}
+	s := &Server{
+		config: cfg,
+		keys:   keys,
+		dialer: outbound.Or(dialer),
+		filter: NewReplayFilter(),
+		done:   make(chan struct{}),
+		logger: log.With().Str("component", "goremote-server").Logger(),
+	}
+	if keys.IsLegacy() {
+		s.logger.Warn().Msg("Using the legacy integer crypt key. Requests can be replayed; configure a psk once all clients support it.")
+	}
+	return s, nil
+}
+
+// Start 在 RemoteConf.Listen 上同时监听 TCP 和 UDP，并在后台开始服务。
+func (s *Server) Start() error {
+	tcpListener, err := net.Listen("tcp", s.config.RemoteConf.Listen)
+	if err != nil {
+		return fmt.Errorf("goremote server: %w", err)
+	}
+	// UDP 使用与 TCP 相同的端口 (Listen 中的端口可能为 0)
+	udpAddr := net.JoinHostPort(hostOf(s.config.RemoteConf.Listen), strconv.Itoa(tcpListener.Addr().(*net.TCPAddr).Port))
+	udpConn, err := net.ListenPacket("udp", udpAddr)
+	if err != nil {
+		tcpListener.Close()
+		return fmt.Errorf("goremote server: %w", err)
+	}
+	s.Serve(tcpListener, udpConn)
+	s.logger.Info().Str("addr", tcpListener.Addr().String()).Bool("legacy", s.keys.IsLegacy()).Msg("goremote server started.")
+	return nil
+}
+
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// Serve 在给定的监听器上开始服务，立即返回。
+func (s *Server) Serve(tcpListener net.Listener, udpConn net.PacketConn) {
+	s.tcpListener = tcpListener
+	s.udpConn = udpConn
+	s.wsConns = newConnListener(tcpListener.Addr())
+	s.httpServer = &http.Server{
+		Handler:           http.HandlerFunc(s.handleHTTP),
+		ReadHeaderTimeout: serverHandshakeTimeout,
+	}
+
+	s.wg.Add(3)
+	go s.acceptLoop()
+	go func() {
+		defer s.wg.Done()
+		s.httpServer.Serve(s.wsConns)
+	}()
+	go s.serveUDP()
+}
+
+// Addr 返回 TCP 监听地址，UDP 使用同一端口。
+func (s *Server) Addr() net.Addr { return s.tcpListener.Addr() }
+
+// Close 停止监听并关闭所有连接和 UDP 会话。
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.tcpListener.Close()
+		s.udpConn.Close()
+		s.httpServer.Close()
+		s.conns.Range(func(key, value interface{}) bool {
+			key.(io.Closer).Close()
+			return true
+		})
+		s.udpSessions.Range(func(key, value interface{}) bool {
+			value.(*serverUDPSession).conn.Close()
+			return true
+		})
+		s.wg.Wait()
+		s.logger.Info().Msg("goremote server closed.")
+	})
+	return nil
+}
+
+// track 记录一个活跃连接，返回的函数在连接结束时调用。
+func (s *Server) track(c io.Closer) func() {
+	s.conns.Store(c, struct{}{})
+	select {
+	case <-s.done:
+		c.Close()
+	default:
+	}
+	return func() { s.conns.Delete(c) }
+}
+
+func (s *Server) acceptLoop() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.tcpListener.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+			default:
+				s.logger.Error().Err(err).Msg("Accept failed, TCP listener stopped.")
+			}
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handleTCP(conn)
+		}()
+	}
+}
+
+// handleTCP 区分 HTTP (WebSocket) 和 goremote 连接。
+func (s *Server) handleTCP(conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(serverHandshakeTimeout))
+	reader := bufio.NewReader(conn)
+	head, err := reader.Peek(4)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	buffered := &bufferedConn{Conn: conn, reader: reader}
+	if string(head) == "GET " {
+		// 交给 http.Server，连接由它管理
+		if !s.wsConns.push(buffered) {
+			conn.Close()
+		}
+		return
+	}
+	s.serveConn(buffered)
+}
+
+// handleHTTP 把匹配 WSPath 的请求升级为 WebSocket，其他请求一律返回 404。
+func (s *Server) handleHTTP(w http.ResponseWriter, r *http.Request) {
+	path := s.config.RemoteConf.WSPath
+	if path == "" {
+		path = "/"
+	}
+	if r.URL.Path != path || !websocket.IsWebSocketUpgrade(r) {
+		http.NotFound(w, r)
+		return
+	}
+	// 客户端的早期数据 (握手或 smux 的第一个帧) 在升级请求头中，放回连接的开头
+	earlyData, responseHeader := shared.AcceptEarlyData(r)
+	upgrader := websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+	ws, err := upgrader.Upgrade(w, r, responseHeader)
+	if err != nil {
+		s.logger.Debug().Err(err).Msg("WebSocket upgrade failed.")
+		return
+	}
+	conn := shared.NewPrefixedConn(shared.NewWebSocketConnAdapter(ws), earlyData)
+	conn.SetReadDeadline(time.Now().Add(serverHandshakeTimeout))
+	s.serveConn(conn)
+}
+
+// serveConn 处理一条已建立的连接 (TCP 或 WebSocket)：smux 会话或单条 goremote 流。
+func (s *Server) serveConn(conn net.Conn) {
+	untrack := s.track(conn)
+	defer untrack()
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	first, err := reader.Peek(1)
+	if err != nil {
+		return
+	}
+	buffered := &bufferedConn{Conn: conn, reader: reader}
+	if first[0] != smuxVersion {
+		s.serveStream(buffered)
+		return
+	}
+
+	conn.SetReadDeadline(time.Time{})
+	smuxConfig := smux.DefaultConfig()
+	smuxConfig.Version = smuxVersion
+	smuxConfig.KeepAliveInterval = 10 * time.Second
+	smuxConfig.KeepAliveTimeout = 30 * time.Second
+	session, err := smux.Server(buffered, smuxConfig)
+	if err != nil {
+		s.logger.Warn().Err(err).Msg("[Mux] Failed to create smux session.")
+		return
+	}
+	defer session.Close()
+	for {
+		stream, err := session.AcceptStream()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer stream.Close()
+			stream.SetReadDeadline(time.Now().Add(serverHandshakeTimeout))
+			s.serveStream(stream)
+		}()
+	}
+}
+
+// streamConn 是一条 goremote 流：独立连接或 smux 流。
+type streamConn interface {
+	io.ReadWriteCloser
+	SetReadDeadline(t time.Time) error
+}
+
+// acceptStream 读取一条流的握手和元数据，返回元数据及两个方向的加密器。
+func (s *Server) acceptStream(conn streamConn) (*Metadata, frameCipher, frameCipher, error) {
+	var (
+		metadata []byte
+		up, down frameCipher
+	)
+	if s.keys.IsLegacy() {
+		cipher, err := s.keys.ReadServerCipher(conn)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if metadata, err = readFrame(conn, cipher); err != nil {
+			return nil, nil, nil, err
+		}
+		up, down = cipher, cipher
+	} else {
+		var clientNonce []byte
+		var err error
+		metadata, up, clientNonce, err = readClientHello(conn, s.keys, s.filter, time.Now())
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if down, err = writeServerHello(conn, s.keys, clientNonce); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	meta, err := ReadMetadata(bytes.NewReader(metadata))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return meta, up, down, nil
+}
+
+// serveStream 处理一条 goremote 流：校验握手，连接目标并双向转发。
+func (s *Server) serveStream(conn streamConn) {
+	meta, up, down, err := s.acceptStream(conn)
+	if err != nil {
+		// 不回应任何内容，探测者无法区分失败原因
+		if !errors.Is(err, io.EOF) {
+			s.logger.Debug().Err(err).Msg("Rejected stream.")
+		}
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	switch meta.Type {
+	case StreamTCP:
+	case StreamUDP:
+		s.serveUDPStream(conn, up, down)
+		return
+	default:
+		s.logger.Warn().Uint8("type", meta.Type).Msg("Unsupported stream type.")
+		return
+	}
+
+	target := net.JoinHostPort(meta.Addr, strconv.Itoa(meta.Port))
+	targetConn, err := outbound.DialWithTimeout(s.dialer, "tcp", target, serverDialTimeout)
+	if err != nil {
+		s.logger.Debug().Err(err).Str("target", target).Msg("Failed to dial target.")
+		return
+	}
+	defer targetConn.Close()
+	s.relay(conn, targetConn, up, down)
+}
+
+// relay 在客户端流和目标连接之间双向转发，任一方向解密失败时立即断开两端。
+func (s *Server) relay(conn io.ReadWriteCloser, target net.Conn, up, down frameCipher) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	// Uplink (client -> target)
+	go func() {
+		defer wg.Done()
+		for {
+			payload, err := readFrame(conn, up)
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					closeWrite(target)
+				} else {
+					target.Close()
+					conn.Close()
+				}
+				return
+			}
+			if _, err := target.Write(payload); err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}()
+
+	// Downlink (target -> client)
+	go func() {
+		defer wg.Done()
+		buf := make([]byte, s.config.BufferSize)
+		for {
+			n, err := target.Read(buf)
+			if n > 0 {
+				if wErr := writeFrame(conn, down, buf[:n]); wErr != nil {
+					target.Close()
+					return
+				}
+			}
+			if err != nil {
+				// smux 流和 WebSocket 没有半关闭，只能整体关闭
+				if !closeWrite(conn) {
+					conn.Close()
+				}
+				return
+			}
+		}
+	}()
+
+	wg.Wait()
+}
+
+func closeWrite(c interface{}) bool {
+	if w, ok := c.(interface{ CloseWrite() error }); ok {
+		return w.CloseWrite() == nil
+	}
+	return false
+}
+
+// --- UDP ---
+
+// serverUDPSession 是一个客户端地址对应的出站 UDP 套接字，所有目标共用它。
+type serverUDPSession struct {
+	conn       net.PacketConn
+	lastActive atomic.Int64 // UnixNano
+}
+
+func (s *Server) serveUDP() {
+	defer s.wg.Done()
+	buf := make([]byte, 65535)
+	for {
+		n, clientAddr, err := s.udpConn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		request, err := openPacket(s.keys, s.filter, buf[:n], time.Now())
+		if err != nil {
+			continue
+		}
+		target, payload, err := readUDPRequest(request)
+		if err != nil {
+			s.logger.Debug().Err(err).Msg("[UDP] Invalid request.")
+			continue
+		}
+		session, err := s.getOrCreateUDPSession(clientAddr)
+		if err != nil {
+			s.logger.Warn().Err(err).Msg("[UDP] Failed to create session.")
+			continue
+		}
+		targetAddr, err := net.ResolveUDPAddr("udp", target)
+		if err != nil {
+			continue
+		}
+		session.lastActive.Store(time.Now().UnixNano())
+		session.conn.WriteTo(payload, targetAddr)
+	}
+}
+
+func (s *Server) getOrCreateUDPSession(clientAddr net.Addr) (*serverUDPSession, error) {
+	key := clientAddr.String()
+	if v, ok := s.udpSessions.Load(key); ok {
+		return v.(*serverUDPSession), nil
+	}
+	lc := net.ListenConfig{Control: outbound.Control}
+	conn, err := lc.ListenPacket(context.Background(), "udp", "")
+	if err != nil {
+		return nil, err
+	}
+	session := &serverUDPSession{conn: conn}
+	session.lastActive.Store(time.Now().UnixNano())
+	s.udpSessions.Store(key, session)
+
+	s.wg.Add(1)
+	go s.udpReplyLoop(key, session, clientAddr)
+	return session, nil
+}
+
+// udpReplyLoop 把目标的回复封装为 SOCKS5 UDP 包加密后发回客户端，会话空闲超时后关闭。
+func (s *Server) udpReplyLoop(key string, session *serverUDPSession, clientAddr net.Addr) {
+	defer s.wg.Done()
+	defer func() {
+		session.conn.Close()
+		s.udpSessions.CompareAndDelete(key, session)
+	}()
+
+	buf := make([]byte, 65535)
+	for {
+		session.conn.SetReadDeadline(time.Now().Add(udpGatewaySessionTimeout))
+		n, from, err := session.conn.ReadFrom(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() &&
+				time.Since(time.Unix(0, session.lastActive.Load())) < udpGatewaySessionTimeout {
+				continue
+			}
+			return
+		}
+		session.lastActive.Store(time.Now().UnixNano())
+		reply, err := sealPacket(s.keys, appendUDPReply(nil, from.(*net.UDPAddr), buf[:n]), time.Now())
+		if err != nil {
+			continue
+		}
+		s.udpConn.WriteTo(reply, clientAddr)
+	}
+}
+
+// serveUDPStream 处理一条 StreamUDP 流：每帧一个数据报，上行为 [目标地址][数据]，
+// 下行为 [源地址][数据]。两个方向都空闲超过 udpFlowTimeout 后关闭流。
+func (s *Server) serveUDPStream(conn streamConn, up, down frameCipher) {
+	lc := net.ListenConfig{Control: outbound.Control}
+	packetConn, err := lc.ListenPacket(context.Background(), "udp", "")
+	if err != nil {
+		s.logger.Warn().Err(err).Msg("[UDP-Stream] Failed to create outbound socket.")
+		return
+	}
+	defer packetConn.Close()
+	var lastActive atomic.Int64
+	lastActive.Store(time.Now().UnixNano())
+
+	// Downlink (targets -> stream)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer conn.Close()
+		buf := make([]byte, 65535)
+		for {
+			packetConn.SetReadDeadline(time.Now().Add(udpFlowTimeout))
+			n, from, err := packetConn.ReadFrom(buf)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() &&
+					time.Since(time.Unix(0, lastActive.Load())) < udpFlowTimeout {
+					continue
+				}
+				return
+			}
+			lastActive.Store(time.Now().UnixNano())
+			datagram := appendSocksAddr(nil, from.(*net.UDPAddr))
+			if err := writeDatagram(conn, down, append(datagram, buf[:n]...)); err != nil {
+				return
+			}
+		}
+	}()
+
+	// Uplink (stream -> targets)
+	for {
+		datagram, err := readFrame(conn, up)
+		if err != nil {
+			return
+		}
+		target, payload, err := readSocksAddr(datagram)
+		if err != nil {
+			continue
+		}
+		targetAddr, err := net.ResolveUDPAddr("udp", target)
+		if err != nil {
+			continue
+		}
+		lastActive.Store(time.Now().UnixNano())
+		packetConn.WriteTo(payload, targetAddr)
+	}
+}
+
+// readUDPRequest 解析 SOCKS5 UDP 请求头 [RSV 2B][FRAG 1B][ATYP][ADDR][PORT]，返回目标地址和负载。
+func readUDPRequest(data []byte) (string, []byte, error) {
+	if len(data) < 4 || data[2] != 0 {
+		return "", nil, fmt.Errorf("invalid or fragmented SOCKS5 UDP header")
+	}
+	return readSocksAddr(data[3:])
+}
+
+// appendUDPReply 以 from 为源地址构造 SOCKS5 UDP 回复包。
+func appendUDPReply(b []byte, from *net.UDPAddr, payload []byte) []byte {
+	b = append(b, 0x00, 0x00, 0x00)
+	b = appendSocksAddr(b, from)
+	return append(b, payload...)
+}
+
+// --- 连接辅助类型 ---
+
+// bufferedConn 从已经 Peek 过的 bufio.Reader 中读取。
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) { return c.reader.Read(b) }
+
+func (c *bufferedConn) CloseWrite() error {
+	if w, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return w.CloseWrite()
+	}
+	return fmt.Errorf("close write not supported")
+}
+
+// connListener 是一个由 acceptLoop 投递连接的 net.Listener，用于把 HTTP 连接交给 http.Server。
+type connListener struct {
+	addr      net.Addr
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newConnListener(addr net.Addr) *connListener {
+	return &connListener{addr: addr, conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+func (l *connListener) push(conn net.Conn) bool {
+	select {
+	case l.conns <- conn:
+		return true
+	case <-l.done:
+		return false
+	}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr { return l.addr }
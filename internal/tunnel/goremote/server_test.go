@@ -0,0 +1,309 @@
+package goremote
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"liuproxy_nexus/internal/shared/outbound"
+	"liuproxy_nexus/internal/shared/securecrypt"
+	"liuproxy_nexus/internal/shared/testutil"
+	"liuproxy_nexus/internal/shared/types"
+)
+
+// startEchoServers 在回环地址上启动 TCP 和 UDP 回显服务，二者使用各自的端口。
+func startEchoServers(t *testing.T) (tcpAddr, udpAddr string) {
+	t.Helper()
+	return testutil.StartEchoServer(t), testutil.StartUDPEchoServer(t).String()
+}
+
+func newTestPSK(t *testing.T) string {
+	t.Helper()
+	psk := make([]byte, securecrypt.PSKSize)
+	if _, err := rand.Read(psk); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(psk)
+}
+
+func startTestServer(t *testing.T, remote types.RemoteConf) *Server {
+	t.Helper()
+	remote.Listen = "127.0.0.1:0"
+	cfg := &types.Config{CommonConf: types.CommonConf{BufferSize: 4096, Crypt: 125}, RemoteConf: remote}
+	server, err := NewServer(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+func TestClientAgainstServer(t *testing.T) {
+	echoTCP, echoUDP := startEchoServers(t)
+	psk := newTestPSK(t)
+	servers := map[string]*Server{
+		"psk":    startTestServer(t, types.RemoteConf{PSK: psk, WSPath: "/tunnel"}),
+		"legacy": startTestServer(t, types.RemoteConf{LegacyCrypt: true, WSPath: "/tunnel"}),
+	}
+
+	for _, keyMode := range []string{"psk", "legacy"} {
+		for _, transport := range []string{"tcp", "ws", "ws-ed"} {
+			for _, multiplex := range []bool{false, true} {
+				name := keyMode + "/" + transport
+				if multiplex {
+					name += "/mux"
+				}
+				t.Run(name, func(t *testing.T) {
+					port := servers[keyMode].Addr().(*net.TCPAddr).Port
+					profile := &types.ServerProfile{
+						ID: name, Type: "goremote", Address: "127.0.0.1", Port: port,
+						Transport: transport, Path: "/tunnel", Multiplex: multiplex,
+					}
+					if transport == "ws-ed" {
+						// 握手随升级请求发出
+						profile.Transport, profile.Path, profile.EarlyDataDelay = "ws", "/tunnel?ed=2048", 20
+					}
+					if keyMode == "psk" {
+						profile.PSK = psk
+					} else {
+						profile.LegacyCrypt = true
+					}
+					testClient(t, profile, echoTCP, echoUDP)
+				})
+			}
+		}
+	}
+}
+
+func testClient(t *testing.T, profile *types.ServerProfile, echoTCP, echoUDP string) {
+	cfg := &types.Config{CommonConf: types.CommonConf{BufferSize: 4096, Crypt: 125}}
+	dialer := &countingDialer{}
+	strategy, err := NewGoRemoteStrategy(cfg, profile, testutil.NopStateManager{}, dialer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer strategy.CloseTunnel()
+
+	if err := strategy.CheckHealth(); err != nil {
+		t.Fatalf("CheckHealth() error = %v", err)
+	}
+
+	// SOCKS5 CONNECT，同一策略上的多条连接 (mux 模式下复用一个会话)
+	for i := 0; i < 2; i++ {
+		conn := testutil.DialThroughStrategy(t, strategy, echoTCP)
+		testutil.ExpectEcho(t, conn, "hello over socks")
+		testutil.ExpectEcho(t, conn, strings.Repeat("large payload ", 20000))
+		conn.Close()
+	}
+
+	// 透明代理 TCP
+	client, inbound := net.Pipe()
+	go strategy.HandleRawTCP(inbound, echoTCP)
+	testutil.ExpectEcho(t, client, "hello over transparent tcp")
+	client.Close()
+
+	// 透明代理 UDP
+	dest, _ := net.ResolveUDPAddr("udp", echoUDP)
+	testutil.ExpectUDPEcho(t, strategy, dest, "hello over udp", "second datagram")
+
+	// SOCKS5 UDP ASSOCIATE
+	clientConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientConn.Close()
+	pipeConn, err := strategy.GetSocksConnection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pipeConn.Close()
+	relayAddr := testutil.SOCKS5UDPAssociate(t, pipeConn)
+	destAddr := dest.AddrPort()
+	request := append([]byte{0, 0, 0, 1}, destAddr.Addr().Unmap().AsSlice()...)
+	request = append(request, byte(destAddr.Port()>>8), byte(destAddr.Port()))
+	for _, payload := range []string{"hello over associate", "again"} {
+		if _, err := clientConn.WriteTo(append(bytes.Clone(request), payload...), relayAddr); err != nil {
+			t.Fatal(err)
+		}
+		clientConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		reply := make([]byte, 1500)
+		n, _, err := clientConn.ReadFrom(reply)
+		if err != nil {
+			t.Fatalf("no UDP ASSOCIATE reply: %v", err)
+		}
+		if !bytes.Equal(reply[:n], append(bytes.Clone(request), payload...)) {
+			t.Errorf("UDP ASSOCIATE reply = %v", reply[:n])
+		}
+	}
+
+	if stats := strategy.GetTrafficStats(); stats.Uplink == 0 || stats.Downlink == 0 {
+		t.Errorf("traffic stats not counted: %+v", stats)
+	}
+	if profile.Multiplex {
+		// TCP 和 UDP 都走会话池: 3 个 TCP 流和 2 个 UDP 流，不再单独拨号 UDP
+		if mux := strategy.GetMetrics().Mux; mux == nil || mux.TotalStreams != 5 || mux.Sessions == 0 {
+			t.Errorf("unexpected mux metrics: %+v", mux)
+		}
+		if n := dialer.udpDials.Load(); n != 0 {
+			t.Errorf("multiplexed strategy dialed UDP %d times", n)
+		}
+	}
+}
+
+// countingDialer 记录策略经它拨出的 UDP 连接数。
+type countingDialer struct {
+	udpDials atomic.Int32
+}
+
+func (d *countingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if strings.HasPrefix(network, "udp") {
+		d.udpDials.Add(1)
+	}
+	return outbound.DialContext(ctx, network, address)
+}
+
+func TestMuxUDPFlowIdleTimeout(t *testing.T) {
+	old := udpFlowTimeout
+	udpFlowTimeout = 300 * time.Millisecond
+	defer func() { udpFlowTimeout = old }()
+
+	_, echoUDP := startEchoServers(t)
+	psk := newTestPSK(t)
+	server := startTestServer(t, types.RemoteConf{PSK: psk})
+	profile := &types.ServerProfile{
+		ID: "udp-idle", Address: "127.0.0.1", Port: server.Addr().(*net.TCPAddr).Port,
+		Transport: "tcp", Multiplex: true, PSK: psk,
+	}
+	cfg := &types.Config{CommonConf: types.CommonConf{BufferSize: 4096}}
+	strategy, err := NewGoRemoteStrategy(cfg, profile, testutil.NopStateManager{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer strategy.CloseTunnel()
+	s := strategy.(*GoRemoteStrategy)
+
+	dest, _ := net.ResolveUDPAddr("udp", echoUDP)
+	source := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}
+	packet := &types.UDPPacket{Source: source, Destination: dest, Payload: []byte("ping"), Reply: testutil.NewUDPReply().Reply}
+	if err := s.HandleUDPPacket(packet, source.String()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.udpFlows.Load(source.String()); !ok {
+		t.Fatal("no UDP flow was opened")
+	}
+
+	// 空闲超时后流在两端关闭，会话本身保留
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, open := s.udpFlows.Load(source.String())
+		mux := s.GetMetrics().Mux
+		if !open && mux.ActiveStreams == 0 && mux.Sessions > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("UDP flow not closed after idle timeout: open=%v metrics=%+v", open, mux)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// TestServerRejectsReplay 把一个完整的客户端请求原样发送两次，第二次不应得到任何回应。
+func TestServerRejectsReplay(t *testing.T) {
+	echoTCP, _ := startEchoServers(t)
+	psk := newTestPSK(t)
+	server := startTestServer(t, types.RemoteConf{PSK: psk})
+	keys, err := securecrypt.NewKeySource(psk, false, 0, securecrypt.CHACHA20_POLY1305)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host, port, _ := net.SplitHostPort(echoTCP)
+	portNum, _ := strconv.Atoi(port)
+	var metaBuf bytes.Buffer
+	if err := WriteMetadata(&metaBuf, &Metadata{Type: StreamTCP, Addr: host, Port: portNum}); err != nil {
+		t.Fatal(err)
+	}
+	var request bytes.Buffer
+	up, clientNonce, err := writeClientHello(&request, keys, metaBuf.Bytes(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFrame(&request, up, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	send := func() ([]byte, error) {
+		conn, err := net.Dial("tcp", server.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if _, err := conn.Write(request.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		down, err := readServerHello(conn, keys, clientNonce)
+		if err != nil {
+			return nil, err
+		}
+		return readFrame(conn, down)
+	}
+
+	if reply, err := send(); err != nil || string(reply) != "ping" {
+		t.Fatalf("first request: reply = %q, err = %v", reply, err)
+	}
+	if reply, err := send(); err == nil {
+		t.Fatalf("replayed request got a reply: %q", reply)
+	}
+}
+
+func TestServerWebSocketPath(t *testing.T) {
+	server := startTestServer(t, types.RemoteConf{PSK: newTestPSK(t), WSPath: "/tunnel"})
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET /other HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	status := make([]byte, 12)
+	if _, err := io.ReadFull(conn, status); err != nil {
+		t.Fatal(err)
+	}
+	if string(status) != "HTTP/1.1 404" {
+		t.Fatalf("status = %q, want 404", status)
+	}
+}
+
+func TestReadUDPRequest(t *testing.T) {
+	tests := []struct {
+		packet []byte
+		target string
+	}{
+		{append([]byte{0, 0, 0, 1, 10, 0, 0, 1, 0, 53}, "a"...), "10.0.0.1:53"},
+		{append(append([]byte{0, 0, 0, 3, 11}, "example.com"...), 1, 187, 'a'), "example.com:443"},
+		{append(append([]byte{0, 0, 0, 4}, net.ParseIP("2001:db8::1")...), 0, 53, 'a'), "[2001:db8::1]:53"},
+	}
+	for _, tt := range tests {
+		target, payload, err := readUDPRequest(tt.packet)
+		if err != nil || target != tt.target || string(payload) != "a" {
+			t.Errorf("readUDPRequest() = %q, %q, %v; want %q", target, payload, err, tt.target)
+		}
+	}
+	for _, bad := range [][]byte{{0, 0, 0}, {0, 0, 1, 1, 10, 0, 0, 1, 0, 53}, {0, 0, 0, 3, 20, 'a'}} {
+		if _, _, err := readUDPRequest(bad); err == nil {
+			t.Errorf("readUDPRequest(%v): expected an error", bad)
+		}
+	}
+}
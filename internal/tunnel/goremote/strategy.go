@@ -9,10 +9,12 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/xtaci/smux"
-	"golang.org/x/net/proxy"
 	"io"
 	"liuproxy_nexus/internal/shared"
+	"liuproxy_nexus/internal/shared/muxpool"
+	"liuproxy_nexus/internal/shared/outbound"
 	"liuproxy_nexus/internal/shared/securecrypt"
+	"liuproxy_nexus/internal/shared/tlsclient"
 	"liuproxy_nexus/internal/shared/types"
 	"net"
 	"net/http"
@@ -40,40 +42,65 @@ type GoRemoteStrategy struct {
 	profile      *types.ServerProfile
 	logger       zerolog.Logger
 	stateManager types.StateManager
+	dialer       types.Dialer
+	keys         *securecrypt.KeySource
 
 	// 用于流量统计
 	uplinkBytes   atomic.Uint64
 	downlinkBytes atomic.Uint64
+	filter        *ReplayFilter // 拒绝重放的 UDP 回复
 
 	// --- UDP 会话管理 ---
 	udpSessions       sync.Map // key: clientIP, value: *udpGatewaySession
 	udpSessionCleanup *time.Ticker
+	done              chan struct{}
 	closeOnce         sync.Once
 	wg                sync.WaitGroup
 
-	// Mux 会话管理 ---
-	session      *smux.Session
-	sessionMutex sync.Mutex
+	// Mux 会话池 ---
+	mux      *muxpool.Pool[*smux.Session]
+	udpFlows sync.Map // 多路复用模式下的 UDP 流, key: sessionKey, value: *muxUDPFlow
 }
 
 var _ types.TunnelStrategy = (*GoRemoteStrategy)(nil)
 
-func NewGoRemoteStrategy(cfg *types.Config, profile *types.ServerProfile, stateManager types.StateManager) (types.TunnelStrategy, error) {
+func NewGoRemoteStrategy(cfg *types.Config, profile *types.ServerProfile, stateManager types.StateManager, dialer types.Dialer) (types.TunnelStrategy, error) {
+	keys, err := newKeySource(cfg, profile)
+	if err != nil {
+		return nil, err
+	}
 	s := &GoRemoteStrategy{
 		config:            cfg,
 		profile:           profile,
 		stateManager:      stateManager,
+		keys:              keys,
+		dialer:            outbound.Or(dialer),
+		filter:            NewReplayFilter(),
 		udpSessionCleanup: time.NewTicker(30 * time.Second),
+		done:              make(chan struct{}),
 		logger: log.With().
 			Str("strategy_type", "goremote-v3").
 			Str("server_id", profile.ID).
 			Str("remarks", profile.Remarks).Logger(),
 	}
+	s.mux = s.newMuxPool(profile.MuxSessions)
+	if keys.IsLegacy() {
+		s.logger.Warn().Msg("Using the legacy integer crypt key. Configure a psk once the server supports it.")
+	}
 	s.wg.Add(1)
 	go s.cleanupLoop()
 	return s, nil
 }
 
+// newKeySource 根据 profile 的 psk / legacyCrypt 创建密钥来源。
+func newKeySource(cfg *types.Config, profile *types.ServerProfile) (*securecrypt.KeySource, error) {
+	keys, err := securecrypt.NewKeySource(profile.PSK, profile.LegacyCrypt, cfg.Crypt, securecrypt.CHACHA20_POLY1305)
+	if err != nil {
+		return nil, fmt.Errorf("goremote: %w", err)
+	}
+	return keys, nil
+}
+
 // Initialize and InitializeForGateway are no-ops for a stateless strategy.
 func (s *GoRemoteStrategy) Initialize() error {
 	//s.logger.Debug().Msg("Stateless strategy, Initialize() is a no-op.")
@@ -146,7 +173,9 @@ func (s *GoRemoteStrategy) dialRemote() (net.Conn, error) {
 			scheme = "wss"
 		}
 		host := net.JoinHostPort(s.profile.Address, strconv.Itoa(s.profile.Port))
-		u := url.URL{Scheme: scheme, Host: host, Path: s.profile.Path}
+		// 路径可以带查询参数，例如早期数据的 "/tunnel?ed=2048"
+		path, query, _ := strings.Cut(s.profile.Path, "?")
+		u := url.URL{Scheme: scheme, Host: host, Path: path, RawQuery: query}
 		if u.Path == "" {
 			u.Path = "/"
 		}
@@ -159,11 +188,18 @@ func (s *GoRemoteStrategy) dialRemote() (net.Conn, error) {
 		}
 		header.Set("User-Agent", "liuproxy-goremote-client/3.1")
 
-		return DialWS(context.Background(), u.String(), header)
+		var tlsConfig *tlsclient.Config
+		if scheme == "wss" {
+			var err error
+			if tlsConfig, err = tlsclient.FromProfile(s.profile, s.profile.Address, "http/1.1"); err != nil {
+				return nil, err
+			}
+		}
+		return DialWS(context.Background(), s.dialer, u.String(), header, tlsConfig, time.Duration(s.profile.EarlyDataDelay)*time.Millisecond)
 
 	case "tcp", "": // 默认或明确指定为 TCP
 		remoteAddr := net.JoinHostPort(s.profile.Address, strconv.Itoa(s.profile.Port))
-		return DialTCP(remoteAddr)
+		return DialTCP(s.dialer, remoteAddr)
 
 	default:
 		return nil, fmt.Errorf("unsupported transport for goremote: %s", s.profile.Transport)
@@ -190,11 +226,15 @@ func (s *GoRemoteStrategy) relayTCPMultiConn(inboundConn net.Conn, targetDest st
 
 	// 2. 发送模式协商字节 (隐式协商，无需发送)
 
-	// 3. 发送加密元数据
-	s.sendEncryptedMetadata(remoteConn, targetDest)
+	// 3. 发送握手和加密元数据
+	ciphers, err := s.sendEncryptedMetadata(remoteConn, StreamTCP, targetDest)
+	if err != nil {
+		s.logger.Error().Err(err).Msg("[Relay-MultiConn] Failed to send metadata.")
+		return
+	}
 
 	// 4. 双向转发
-	s.bidirectionalCopy(countedInbound, remoteConn)
+	s.bidirectionalCopy(countedInbound, remoteConn, ciphers)
 	//s.logger.Debug().Msg("[Relay-MultiConn] Bidirectional copy finished.")
 }
 
@@ -203,139 +243,99 @@ func (s *GoRemoteStrategy) relayTCPMux(inboundConn net.Conn, targetDest string)
 	countedInbound := shared.NewCountedConn(inboundConn, &s.uplinkBytes, &s.downlinkBytes)
 	defer countedInbound.Close()
 
-	// 1. 获取或创建 Mux 会话
-	session, err := s.getOrCreateMuxSession()
+	// 1. 在会话池中流数最少的会话上打开一个新流
+	stream, err := s.openStream()
 	if err != nil {
-		s.logger.Error().Err(err).Msg("[Relay-Mux] Failed to get or create mux session.")
+		s.logger.Error().Err(err).Msg("[Relay-Mux] Failed to open new stream.")
 		if s.stateManager != nil {
 			s.stateManager.SetServerStatusDown(s.profile.ID, err.Error())
 		}
 		return
 	}
+	defer stream.Close()
+	//s.logger.Debug().Uint32("stream_id", stream.ID()).Msg("[Relay-Mux] New stream opened.")
 
-	// 2. 在会话上打开一个新流
-	stream, err := session.OpenStream()
+	// 2. 在流上发送握手和加密元数据，每个流使用独立的会话密钥
+	ciphers, err := s.sendEncryptedMetadata(stream, StreamTCP, targetDest)
 	if err != nil {
-		s.logger.Error().Err(err).Msg("[Relay-Mux] Failed to open new stream.")
-		// 会话可能已损坏，清除它以便下次重建
-		s.sessionMutex.Lock()
-		if s.session != nil {
-			s.session.Close()
-			s.session = nil
-		}
-		s.sessionMutex.Unlock()
+		s.logger.Error().Err(err).Msg("[Relay-Mux] Failed to send metadata.")
 		return
 	}
-	defer stream.Close()
-	//s.logger.Debug().Uint32("stream_id", stream.ID()).Msg("[Relay-Mux] New stream opened.")
-
-	// 3. 在流上发送加密元数据
-	s.sendEncryptedMetadata(stream, targetDest)
 
-	// 4. 双向转发
-	s.bidirectionalCopy(countedInbound, stream)
+	// 3. 双向转发
+	s.bidirectionalCopy(countedInbound, stream, ciphers)
 	//s.logger.Debug().Uint32("stream_id", stream.ID()).Msg("[Relay-Mux] Bidirectional copy finished.")
 }
 
-// getOrCreateMuxSession (新增): 管理 Mux 会话的生命周期
-func (s *GoRemoteStrategy) getOrCreateMuxSession() (*smux.Session, error) {
-	s.sessionMutex.Lock()
-	defer s.sessionMutex.Unlock()
-
-	// 如果会话存在且健康，直接返回
-	if s.session != nil && !s.session.IsClosed() {
-		return s.session, nil
-	}
-
-	s.logger.Info().Msg("[Mux] No active session found, creating a new one...")
-
-	// 【关键修改】使用新的拨号辅助函数建立物理连接
-	conn, err := s.dialRemote()
-	if err != nil {
-		return nil, fmt.Errorf("mux physical dial failed: %w", err)
-	}
-
-	// 3. 创建 smux 客户端会话
-	smuxConfig := smux.DefaultConfig()
-	smuxConfig.Version = 2
-	smuxConfig.KeepAliveInterval = 10 * time.Second
-	smuxConfig.KeepAliveTimeout = 30 * time.Second
-
-	session, err := smux.Client(conn, smuxConfig)
-	if err != nil {
-		conn.Close()
-		return nil, fmt.Errorf("smux client session creation failed: %w", err)
-	}
-
-	s.session = session
-	//s.logger.Info().Msg("[Mux] New session established successfully.")
-	return s.session, nil
+// streamCiphers 是一条 goremote 流两个方向的加密状态。
+// v3 的下行解密器在读到服务器 nonce 之后才能派生，由 downlink 负责。
+type streamCiphers struct {
+	keys        *securecrypt.KeySource
+	up          frameCipher
+	down        frameCipher
+	clientNonce []byte
 }
 
-// sendEncryptedMetadata : 提取出的通用函数，用于发送加密元数据
-func (s *GoRemoteStrategy) sendEncryptedMetadata(writer io.Writer, targetDest string) {
-	cipher, err := securecrypt.NewCipher(s.config.Crypt)
-	if err != nil {
-		//s.logger.Error().Err(err).Msg("[Metadata] Failed to create cipher.")
-		return
+// downlink 返回下行解密器，v3 下首次调用时从 r 读取服务器握手。
+func (c *streamCiphers) downlink(r io.Reader) (frameCipher, error) {
+	if c.down == nil {
+		down, err := readServerHello(r, c.keys, c.clientNonce)
+		if err != nil {
+			return nil, err
+		}
+		c.down = down
 	}
+	return c.down, nil
+}
 
+// sendEncryptedMetadata : 提取出的通用函数，用于发送握手和加密元数据。
+// PSK 模式使用 v3 握手 (见 handshake.go)；旧版模式为 [2 字节长度] [加密的元数据]，两个方向共用固定密钥。
+func (s *GoRemoteStrategy) sendEncryptedMetadata(writer io.Writer, streamType StreamType, targetDest string) (*streamCiphers, error) {
 	host, portStr, _ := net.SplitHostPort(targetDest)
 	port, _ := strconv.Atoi(portStr)
 	meta := &Metadata{
-		Type: StreamTCP,
+		Type: streamType,
 		Addr: host,
 		Port: port,
 	}
 
 	var metaBuf bytes.Buffer
 	if err := WriteMetadata(&metaBuf, meta); err != nil {
-		//s.logger.Error().Err(err).Msg("[Metadata] Failed to serialize.")
-		return
+		return nil, fmt.Errorf("failed to serialize metadata: %w", err)
 	}
 
-	encryptedMeta, err := cipher.Encrypt(metaBuf.Bytes())
-	if err != nil {
-		//s.logger.Error().Err(err).Msg("[Metadata] Failed to encrypt.")
-		return
+	keys := s.keys
+	if keys.IsLegacy() {
+		cipher, _, err := keys.NewClientCipher()
+		if err != nil {
+			return nil, err
+		}
+		if err := writeFrame(writer, cipher, metaBuf.Bytes()); err != nil {
+			return nil, fmt.Errorf("failed to write metadata: %w", err)
+		}
+		return &streamCiphers{keys: keys, up: cipher, down: cipher}, nil
 	}
 
-	lenBuf := make([]byte, 2)
-	binary.BigEndian.PutUint16(lenBuf, uint16(len(encryptedMeta)))
-	if _, err := writer.Write(lenBuf); err != nil {
-		//s.logger.Error().Err(err).Msg("[Metadata] Failed to write length header.")
-		return
-	}
-	if _, err := writer.Write(encryptedMeta); err != nil {
-		//s.logger.Error().Err(err).Msg("[Metadata] Failed to write payload.")
-		return
+	up, clientNonce, err := writeClientHello(writer, keys, metaBuf.Bytes(), time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to write client hello: %w", err)
 	}
-	//s.logger.Debug().Msg("[Metadata] Encrypted metadata sent.")
+	return &streamCiphers{keys: keys, up: up, clientNonce: clientNonce}, nil
 }
 
 // bidirectionalCopy (新增): 提取出的通用双向转发函数
-func (s *GoRemoteStrategy) bidirectionalCopy(client net.Conn, remote io.ReadWriteCloser) {
+func (s *GoRemoteStrategy) bidirectionalCopy(client net.Conn, remote io.ReadWriteCloser, ciphers *streamCiphers) {
 	var wg sync.WaitGroup
 	wg.Add(2)
-	cipher, _ := securecrypt.NewCipher(s.config.Crypt)
 
 	// Uplink (client -> remote)
 	go func() {
 		defer wg.Done()
 		buf := make([]byte, s.config.CommonConf.BufferSize)
-		lenBuf := make([]byte, 2)
 		for {
 			n, err := client.Read(buf)
 			if n > 0 {
-				encrypted, wErr := cipher.Encrypt(buf[:n])
-				if wErr != nil {
-					break
-				}
-				binary.BigEndian.PutUint16(lenBuf, uint16(len(encrypted)))
-				if _, wErr = remote.Write(lenBuf); wErr != nil {
-					break
-				}
-				if _, wErr = remote.Write(encrypted); wErr != nil {
+				if wErr := writeFrame(remote, ciphers.up, buf[:n]); wErr != nil {
 					break
 				}
 			}
@@ -351,27 +351,21 @@ func (s *GoRemoteStrategy) bidirectionalCopy(client net.Conn, remote io.ReadWrit
 	// Downlink (remote -> client)
 	go func() {
 		defer wg.Done()
-		lenBuf := make([]byte, 2)
+		defer client.Close()
+		down, err := ciphers.downlink(remote)
+		if err != nil {
+			return
+		}
 		for {
-			_, err := io.ReadFull(remote, lenBuf)
-			if err != nil {
-				break
-			}
-			payloadLen := binary.BigEndian.Uint16(lenBuf)
-			buf := make([]byte, payloadLen)
-			_, err = io.ReadFull(remote, buf)
+			// 解密失败 (篡改、重放或乱序的帧) 时立即断开
+			decrypted, err := readFrame(remote, down)
 			if err != nil {
 				break
 			}
-			decrypted, rErr := cipher.Decrypt(buf)
-			if rErr != nil {
-				break
-			}
 			if _, wErr := client.Write(decrypted); wErr != nil {
 				break
 			}
 		}
-		client.Close()
 	}()
 
 	wg.Wait()
@@ -381,14 +375,13 @@ func (s *GoRemoteStrategy) bidirectionalCopy(client net.Conn, remote io.ReadWrit
 func (s *GoRemoteStrategy) CloseTunnel() {
 	s.closeOnce.Do(func() {
 		s.logger.Info().Msg("Closing goremote strategy...")
+		close(s.done)
 
-		s.sessionMutex.Lock()
-		if s.session != nil {
-			s.logger.Debug().Msg("[Mux] Closing mux session.")
-			s.session.Close()
-			s.session = nil
-		}
-		s.sessionMutex.Unlock()
+		s.udpFlows.Range(func(key, value interface{}) bool {
+			value.(*muxUDPFlow).close()
+			return true
+		})
+		s.mux.Close()
 
 		if s.udpSessionCleanup != nil {
 			s.udpSessionCleanup.Stop()
@@ -400,14 +393,19 @@ func (s *GoRemoteStrategy) CloseTunnel() {
 			return true
 		})
 		s.wg.Wait()
+		outbound.CloseDialer(s.dialer)
 		s.logger.Info().Msg("goremote strategy closed.")
 	})
 }
 
 // HandleUDPPacket : 处理透明代理的 UDP 包
 func (s *GoRemoteStrategy) HandleUDPPacket(packet *types.UDPPacket, sessionKey string) error {
+	if s.profile.Multiplex {
+		return s.handleUDPPacketMux(packet, sessionKey)
+	}
+
 	// 1. 获取或创建到 remote 的 UDP "连接"
-	session, err := s.getOrCreateUDPSession(sessionKey, packet.Source)
+	session, err := s.getOrCreateUDPSession(sessionKey, packet)
 	if err != nil {
 		s.logger.Error().Err(err).Str("client_ip", sessionKey).Msg("[Transparent-UDP] Failed to get or create session.")
 		return err
@@ -430,9 +428,8 @@ func (s *GoRemoteStrategy) HandleUDPPacket(packet *types.UDPPacket, sessionKey s
 	binary.Write(&socks5Buf, binary.BigEndian, uint16(dest.Port))
 	socks5Buf.Write(packet.Payload)
 
-	// 3. 加密并发送
-	cipher, _ := securecrypt.NewCipher(s.config.Crypt)
-	encryptedPayload, err := cipher.Encrypt(socks5Buf.Bytes())
+	// 3. 加密并发送 (PSK 模式下每个数据报带有自己的 salt 和时间戳)
+	encryptedPayload, err := sealPacket(s.keys, socks5Buf.Bytes(), time.Now())
 	if err != nil {
 		return err
 	}
@@ -522,21 +519,25 @@ func (s *GoRemoteStrategy) GetListenerInfo() *types.ListenerInfo {
 func (s *GoRemoteStrategy) GetMetrics() *types.Metrics {
 	// Short connections, active connections are not easily tracked centrally.
 	// This can be improved in the future if needed.
-	return &types.Metrics{ActiveConnections: -1}
+	metrics := &types.Metrics{ActiveConnections: -1}
+	if s.profile.Multiplex {
+		metrics.Mux = s.mux.Metrics()
+	}
+	return metrics
 }
 
 func (s *GoRemoteStrategy) UpdateServer(newProfile *types.ServerProfile) error {
-	s.sessionMutex.Lock()
-	defer s.sessionMutex.Unlock()
+	keys, err := newKeySource(s.config, newProfile)
+	if err != nil {
+		return err
+	}
 
-	// 仅更新 profile，不再需要重新创建 dialer
+	// 仅更新 profile 和密钥，不再需要重新创建 dialer
 	s.profile = newProfile
+	s.keys = keys
 
-	// 如果 multiplex 设置有变，关闭旧的 Mux 会话
-	if s.session != nil {
-		s.session.Close()
-		s.session = nil
-	}
+	// 关闭旧的 Mux 会话，之后的流按新配置重新建立
+	s.mux.Reset(newProfile.MuxSessions)
 	return nil
 }
 
@@ -546,73 +547,17 @@ func (s *GoRemoteStrategy) CheckHealth() error {
 	if err != nil {
 		return err
 	}
-	conn.Close()
+	defer conn.Close()
+	// 使用早期数据时拨号推迟到第一次写入，这里立即完成握手
+	if ec, ok := conn.(*shared.EarlyDataConn); ok {
+		return ec.Handshake()
+	}
 	return nil
 }
 
-// pipeDialer 实现了 proxy.Dialer 接口，但它的 Dial 方法总是返回预设的 net.Conn
-type pipeDialer struct {
-	conn net.Conn
-}
-
-func (d *pipeDialer) Dial(network, addr string) (net.Conn, error) {
-	return d.conn, nil
-}
-
+// CheckHealthAdvanced 通过隧道请求 Cloudflare trace，返回延迟和出口 IP。
 func (s *GoRemoteStrategy) CheckHealthAdvanced() (latency int64, exitIP string, err error) {
-	pipeConn, err := s.GetSocksConnection()
-	if err != nil {
-		return -1, "", fmt.Errorf("goremote CheckHealth: failed to get pipe connection: %w", err)
-	}
-	defer pipeConn.Close()
-	// 注意：这里的 pipeConn 在出错或 transport 关闭时会被自动关闭
-
-	// 创建一个使用我们内存管道的 SOCKS5 拨号器
-	dialer, err := proxy.SOCKS5("tcp", "placeholder-unused:1080", nil, &pipeDialer{conn: pipeConn})
-	if err != nil {
-		pipeConn.Close()
-		return -1, "", fmt.Errorf("goremote CheckHealth: failed to create SOCKS5 dialer: %w", err)
-	}
-
-	transport := &http.Transport{
-		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-			// DialContext 将忽略传入的 addr，强制使用我们的 SOCKS5 隧道
-			return dialer.Dial(network, addr)
-		},
-	}
-	client := &http.Client{
-		Transport: transport,
-		Timeout:   10 * time.Second,
-	}
-
-	const healthCheckURL = "https://www.cloudflare.com/cdn-cgi/trace"
-	start := time.Now()
-	resp, err := client.Get(healthCheckURL)
-	if err != nil {
-		return -1, "", fmt.Errorf("goremote CheckHealth: http get failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	latencyMs := time.Since(start).Milliseconds()
-
-	if resp.StatusCode != http.StatusOK {
-		return latencyMs, "", fmt.Errorf("goremote CheckHealth: unexpected status code: %d", resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return latencyMs, "", fmt.Errorf("goremote CheckHealth: failed to read response body: %w", err)
-	}
-
-	scanner := bufio.NewScanner(bytes.NewReader(body))
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "ip=") {
-			exitIP = strings.TrimPrefix(line, "ip=")
-			break
-		}
-	}
-	return latencyMs, exitIP, nil
+	return shared.CheckTraceHealth("goremote", shared.TraceURL, s.GetSocksConnection)
 }
 
 func (s *GoRemoteStrategy) GetTrafficStats() types.TrafficStats {
@@ -625,16 +570,19 @@ func (s *GoRemoteStrategy) GetTrafficStats() types.TrafficStats {
 // --- UDP 辅助函数 ---
 
 // getOrCreateUDPSession 为透明代理管理 UDP 会话
-func (s *GoRemoteStrategy) getOrCreateUDPSession(sessionKey string, clientAddr net.Addr) (*udpGatewaySession, error) {
+func (s *GoRemoteStrategy) getOrCreateUDPSession(sessionKey string, packet *types.UDPPacket) (*udpGatewaySession, error) {
 	if s, ok := s.udpSessions.Load(sessionKey); ok {
 		session := s.(*udpGatewaySession)
 		session.expiry = time.Now().Add(udpGatewaySessionTimeout)
 		return session, nil
 	}
+	if packet.Reply == nil {
+		return nil, fmt.Errorf("UDP packet from %s has no reply path", packet.Source)
+	}
 
 	s.logger.Debug().Str("client_ip", sessionKey).Msg("[Transparent-UDP] Creating new session.")
 	remoteAddr := net.JoinHostPort(s.profile.Address, strconv.Itoa(s.profile.Port))
-	remoteConn, err := net.Dial("udp", remoteAddr)
+	remoteConn, err := s.dialer.DialContext(context.Background(), "udp", remoteAddr)
 	if err != nil {
 		return nil, err
 	}
@@ -646,26 +594,14 @@ func (s *GoRemoteStrategy) getOrCreateUDPSession(sessionKey string, clientAddr n
 	s.udpSessions.Store(sessionKey, newSession)
 
 	// 为每个会话（即每个客户端）启动一个回复监听 goroutine
-	go s.udpTransparentReplyLoop(newSession, clientAddr)
+	go s.udpTransparentReplyLoop(newSession, packet.Source, packet.Reply)
 
 	return newSession, nil
 }
 
-// udpTransparentReplyLoop 监听来自 remote 的回复，并将其发回给正确的透明代理客户端
-func (s *GoRemoteStrategy) udpTransparentReplyLoop(session *udpGatewaySession, clientAddr net.Addr) {
+// udpTransparentReplyLoop 监听来自 remote 的回复，并经收到数据报的入站发回给正确的透明代理客户端
+func (s *GoRemoteStrategy) udpTransparentReplyLoop(session *udpGatewaySession, clientAddr net.Addr, reply types.UDPReplyFunc) {
 	buf := make([]byte, s.config.BufferSize)
-	cipher, _ := securecrypt.NewCipher(s.config.Crypt)
-
-	// 从 AppServer 获取 UDP 监听器，这是个技巧
-	var mainUDPListener net.PacketConn
-	if provider, ok := s.stateManager.(interface{ GetUDPListener() net.PacketConn }); ok {
-		mainUDPListener = provider.GetUDPListener()
-	}
-
-	if mainUDPListener == nil {
-		s.logger.Error().Msg("[Transparent-UDP] Could not get main UDP listener from StateManager. Reply loop will fail.")
-		return
-	}
 
 	for {
 		session.remoteConn.SetReadDeadline(time.Now().Add(udpGatewaySessionTimeout + 5*time.Second))
@@ -677,19 +613,18 @@ func (s *GoRemoteStrategy) udpTransparentReplyLoop(session *udpGatewaySession, c
 			return
 		}
 
-		decrypted, err := cipher.Decrypt(buf[:n])
+		decrypted, err := openPacket(s.keys, s.filter, buf[:n], time.Now())
 		if err != nil {
 			continue
 		}
 
-		_, data, err := parseSocks5UDPHeader(decrypted)
+		from, data, err := parseSocks5UDPHeader(decrypted)
 		if err != nil {
 			continue
 		}
 
-		// 将解包后的数据通过主监听器发回给原始客户端
-		_, err = mainUDPListener.WriteTo(data, clientAddr)
-		if err != nil {
+		// 将解包后的数据发回给原始客户端，源地址为回复的来源
+		if err := reply(data, from); err != nil {
 			s.logger.Warn().Err(err).Str("client_ip", clientAddr.String()).Msg("[Transparent-UDP] Failed to write back to client.")
 		}
 	}
@@ -718,36 +653,44 @@ func (s *GoRemoteStrategy) handleUDPForward(tcpControlConn net.Conn) {
 	}
 	s.logger.Debug().Str("listen_addr", localUDPAddr.String()).Msg("[UDP-Forward] Local UDP listener created.")
 
+	if s.profile.Multiplex {
+		s.forwardUDPMux(localListener, tcpControlConn)
+		return
+	}
+
 	// 3. 建立到 remote 的 UDP 连接
 	remoteAddr := net.JoinHostPort(s.profile.Address, strconv.Itoa(s.profile.Port))
-	remoteConn, err := net.Dial("udp", remoteAddr)
+	remoteConn, err := s.dialer.DialContext(context.Background(), "udp", remoteAddr)
 	if err != nil {
 		s.logger.Error().Err(err).Msg("[UDP-Forward] Failed to dial remote UDP.")
 		return
 	}
 	defer remoteConn.Close()
 
-	// 4. 启动双向转发
+	// 4. 启动双向转发。SOCKS5 客户端的 UDP 地址在收到第一个包后才知道
+	var clientAddr atomic.Value // net.Addr
 	var wg sync.WaitGroup
 	wg.Add(2)
-	cipher, _ := securecrypt.NewCipher(s.config.Crypt)
 
 	// Uplink (local listener -> remote)
 	go func() {
 		defer wg.Done()
 		buf := make([]byte, s.config.BufferSize)
 		for {
-			n, clientAddr, err := localListener.ReadFrom(buf)
+			n, from, err := localListener.ReadFrom(buf)
 			if err != nil {
 				return
 			}
 			// buf[:n] 已经是完整的 SOCKS5 UDP 请求包了
-			encrypted, _ := cipher.Encrypt(buf[:n])
+			encrypted, err := sealPacket(s.keys, buf[:n], time.Now())
+			if err != nil {
+				continue
+			}
 			if _, err := remoteConn.Write(encrypted); err != nil {
 				return
 			}
 			// 将 clientAddr 存起来，用于下行转发
-			s.udpSessions.Store(tcpControlConn.RemoteAddr().String(), clientAddr)
+			clientAddr.Store(from)
 		}
 	}()
 
@@ -760,16 +703,13 @@ func (s *GoRemoteStrategy) handleUDPForward(tcpControlConn net.Conn) {
 			if err != nil {
 				return
 			}
-			decrypted, err := cipher.Decrypt(buf[:n])
+			decrypted, err := openPacket(s.keys, s.filter, buf[:n], time.Now())
 			if err != nil {
 				continue
 			}
 			// 找到对应的客户端地址并转发
-			if val, ok := s.udpSessions.Load(tcpControlConn.RemoteAddr().String()); ok {
-				clientAddr := val.(net.Addr)
-				if _, err := localListener.WriteTo(decrypted, clientAddr); err != nil {
-					// continue
-				}
+			if addr, ok := clientAddr.Load().(net.Addr); ok {
+				localListener.WriteTo(decrypted, addr)
 			}
 		}
 	}()
@@ -795,20 +735,12 @@ func (s *GoRemoteStrategy) cleanupLoop() {
 				}
 				return true
 			})
-		case <-s.closedChan():
+		case <-s.done:
 			return
 		}
 	}
 }
 
-func (s *GoRemoteStrategy) closedChan() <-chan struct{} {
-	ch := make(chan struct{})
-	s.closeOnce.Do(func() {
-		close(ch)
-	})
-	return ch
-}
-
 // parseSocks5UDPHeader (辅助函数)
 func parseSocks5UDPHeader(data []byte) (*net.UDPAddr, []byte, error) {
 	if len(data) < 4 {
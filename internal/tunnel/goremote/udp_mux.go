@@ -0,0 +1,193 @@
+package goremote
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xtaci/smux"
+
+	"liuproxy_nexus/internal/shared/types"
+)
+
+// udpFlowTimeout 是 StreamUDP 流的空闲超时，客户端和服务器两端各自检查。
+var udpFlowTimeout = udpGatewaySessionTimeout
+
+// muxUDPFlow 是多路复用模式下承载一个 UDP 客户端 (透明代理的 sessionKey 或一个 UDP ASSOCIATE) 的 StreamUDP 流。
+// 每帧一个数据报：上行为 [目标地址][数据]，下行为 [源地址][数据]，地址使用 SOCKS5 编码。
+type muxUDPFlow struct {
+	stream     *smux.Stream
+	ciphers    *streamCiphers
+	writeMu    sync.Mutex // 上行计数器 nonce 要求帧按顺序加密和写出
+	lastActive atomic.Int64
+}
+
+// openUDPFlow 在会话池上打开一条 StreamUDP 流，deliver 接收每个下行数据报 ([源地址][数据])。
+// 流空闲超过 udpFlowTimeout 或出错时关闭，并调用 onClose。
+func (s *GoRemoteStrategy) openUDPFlow(deliver func(datagram []byte), onClose func()) (*muxUDPFlow, error) {
+	stream, err := s.openStream()
+	if err != nil {
+		return nil, err
+	}
+	// 元数据中的地址没有意义，每个数据报带有自己的目标地址
+	ciphers, err := s.sendEncryptedMetadata(stream, StreamUDP, "0.0.0.0:0")
+	if err != nil {
+		stream.Close()
+		return nil, err
+	}
+	flow := &muxUDPFlow{stream: stream, ciphers: ciphers}
+	flow.lastActive.Store(time.Now().UnixNano())
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer onClose()
+		flow.readLoop(deliver, &s.downlinkBytes)
+	}()
+	return flow, nil
+}
+
+// writeDatagram 发送一个 [目标地址][数据] 数据报。
+func (f *muxUDPFlow) writeDatagram(datagram []byte) error {
+	f.writeMu.Lock()
+	defer f.writeMu.Unlock()
+	f.lastActive.Store(time.Now().UnixNano())
+	return writeDatagram(f.stream, f.ciphers.up, datagram)
+}
+
+func (f *muxUDPFlow) readLoop(deliver func(datagram []byte), downlinkBytes *atomic.Uint64) {
+	defer f.stream.Close()
+	f.stream.SetReadDeadline(time.Now().Add(udpFlowTimeout))
+	down, err := f.ciphers.downlink(f.stream)
+	if err != nil {
+		return
+	}
+	for {
+		f.stream.SetReadDeadline(time.Now().Add(udpFlowTimeout))
+		datagram, err := readFrame(f.stream, down)
+		if err != nil {
+			if errors.Is(err, smux.ErrTimeout) && time.Since(time.Unix(0, f.lastActive.Load())) < udpFlowTimeout {
+				continue
+			}
+			return
+		}
+		f.lastActive.Store(time.Now().UnixNano())
+		downlinkBytes.Add(uint64(len(datagram)))
+		deliver(datagram)
+	}
+}
+
+func (f *muxUDPFlow) close() { f.stream.Close() }
+
+// getOrCreateUDPFlow 按 key 复用 UDP 流，流关闭后从 udpFlows 中移除。
+func (s *GoRemoteStrategy) getOrCreateUDPFlow(key string, deliver func(datagram []byte)) (*muxUDPFlow, error) {
+	if v, ok := s.udpFlows.Load(key); ok {
+		return v.(*muxUDPFlow), nil
+	}
+	s.logger.Debug().Str("client_ip", key).Msg("[Mux-UDP] Opening new UDP stream.")
+	var flow *muxUDPFlow
+	var ready sync.WaitGroup
+	ready.Add(1)
+	flow, err := s.openUDPFlow(deliver, func() {
+		ready.Wait()
+		s.udpFlows.CompareAndDelete(key, flow)
+	})
+	if err != nil {
+		ready.Done()
+		return nil, err
+	}
+	if existing, loaded := s.udpFlows.LoadOrStore(key, flow); loaded {
+		ready.Done()
+		flow.close()
+		return existing.(*muxUDPFlow), nil
+	}
+	ready.Done()
+	return flow, nil
+}
+
+// handleUDPPacketMux 是多路复用模式下的透明代理 UDP：每个 sessionKey 一条 StreamUDP 流。
+func (s *GoRemoteStrategy) handleUDPPacketMux(packet *types.UDPPacket, sessionKey string) error {
+	dest, ok := packet.Destination.(*net.UDPAddr)
+	if !ok {
+		return fmt.Errorf("invalid destination address type for UDP")
+	}
+	if packet.Reply == nil {
+		return fmt.Errorf("UDP packet from %s has no reply path", packet.Source)
+	}
+	reply := packet.Reply
+	flow, err := s.getOrCreateUDPFlow(sessionKey, func(datagram []byte) {
+		if from, data, err := readSocksAddr(datagram); err == nil {
+			var fromAddr net.Addr
+			if addrPort, err := netip.ParseAddrPort(from); err == nil {
+				fromAddr = net.UDPAddrFromAddrPort(addrPort)
+			}
+			reply(data, fromAddr)
+		}
+	})
+	if err != nil {
+		s.logger.Error().Err(err).Str("client_ip", sessionKey).Msg("[Mux-UDP] Failed to open UDP stream.")
+		return err
+	}
+
+	datagram := appendSocksAddr(nil, dest)
+	if err := flow.writeDatagram(append(datagram, packet.Payload...)); err != nil {
+		s.logger.Warn().Err(err).Msg("[Mux-UDP] Failed to write to stream.")
+		flow.close()
+		return err
+	}
+	s.uplinkBytes.Add(uint64(len(packet.Payload)))
+	return nil
+}
+
+// forwardUDPMux 是多路复用模式下的 UDP ASSOCIATE：本地监听器收到的 SOCKS5 UDP 请求去掉
+// [RSV][FRAG] 后即为流上的数据报，回复加上同样的前缀发回客户端。直到 TCP 控制连接断开。
+func (s *GoRemoteStrategy) forwardUDPMux(localListener net.PacketConn, tcpControlConn net.Conn) {
+	key := fmt.Sprintf("socks5-udp/%p", tcpControlConn)
+	var clientAddr atomic.Value // net.Addr
+	deliver := func(datagram []byte) {
+		if addr, ok := clientAddr.Load().(net.Addr); ok {
+			localListener.WriteTo(append([]byte{0x00, 0x00, 0x00}, datagram...), addr)
+		}
+	}
+	defer func() {
+		// 先关闭监听器，避免读协程在之后重新打开流
+		localListener.Close()
+		if v, ok := s.udpFlows.LoadAndDelete(key); ok {
+			v.(*muxUDPFlow).close()
+		}
+	}()
+
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, from, err := localListener.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			// 不支持分片 (FRAG != 0)
+			if n < 4 || buf[2] != 0 {
+				continue
+			}
+			clientAddr.Store(from)
+			flow, err := s.getOrCreateUDPFlow(key, deliver)
+			if err != nil {
+				s.logger.Warn().Err(err).Msg("[UDP-Forward] Failed to open UDP stream.")
+				continue
+			}
+			if err := flow.writeDatagram(buf[3:n]); err != nil {
+				flow.close()
+				continue
+			}
+			s.uplinkBytes.Add(uint64(n))
+		}
+	}()
+
+	// 阻塞，直到TCP控制连接断开
+	io.Copy(io.Discard, tcpControlConn)
+	s.logger.Debug().Msg("[UDP-Forward] TCP control connection closed, terminating UDP forwarding.")
+}
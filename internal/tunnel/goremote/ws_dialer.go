@@ -9,21 +9,42 @@ import (
 
 	"github.com/gorilla/websocket"
 	"liuproxy_nexus/internal/shared"
+	"liuproxy_nexus/internal/shared/tlsclient"
+	"liuproxy_nexus/internal/shared/types"
 )
 
-// DialWS 负责为 GoRemote 策略建立一个 WebSocket 连接
-func DialWS(ctx context.Context, urlStr string, header http.Header) (net.Conn, error) {
+// DialWS 负责为 GoRemote 策略建立一个 WebSocket 连接。
+// wss 的 TLS 握手由 tlsclient 按 tlsConfig 完成，tlsConfig 为 nil 时使用 gorilla 默认的 crypto/tls。
+// URL 带有 ed 参数时使用早期数据: 返回的连接在第一次写入时才拨号，握手数据随升级请求发出。
+func DialWS(ctx context.Context, netDialer types.Dialer, urlStr string, header http.Header, tlsConfig *tlsclient.Config, earlyDataDelay time.Duration) (net.Conn, error) {
 	//logger.Debug().Str("url", urlStr).Msg("[GoRemote Dialer] Dialing new WebSocket connection...")
 
 	dialer := websocket.Dialer{
 		HandshakeTimeout: 15 * time.Second,
+		NetDialContext:   netDialer.DialContext,
 	}
-
-	wsConn, _, err := dialer.DialContext(ctx, urlStr, header)
-	if err != nil {
-		return nil, fmt.Errorf("goremote websocket dial failed: %w", err)
+	if tlsConfig != nil {
+		dialer.NetDialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return tlsclient.Dial(ctx, netDialer, network, addr, tlsConfig)
+		}
 	}
 
-	//logger.Debug().Str("remote_addr", wsConn.RemoteAddr().String()).Msg("[GoRemote Dialer] SUCCESS: WebSocket connection established.")
-	return shared.NewWebSocketConnAdapter(wsConn), nil
+	urlStr, maxEarlyData := shared.ParseEarlyData(urlStr)
+	dial := func(earlyData []byte) (net.Conn, error) {
+		requestHeader := header
+		if len(earlyData) > 0 {
+			requestHeader = header.Clone()
+			requestHeader.Set(shared.EarlyDataHeader, shared.EncodeEarlyData(earlyData))
+		}
+		wsConn, _, err := dialer.DialContext(ctx, urlStr, requestHeader)
+		if err != nil {
+			return nil, fmt.Errorf("goremote websocket dial failed: %w", err)
+		}
+		//logger.Debug().Str("remote_addr", wsConn.RemoteAddr().String()).Msg("[GoRemote Dialer] SUCCESS: WebSocket connection established.")
+		return shared.NewWebSocketConnAdapter(wsConn), nil
+	}
+	if maxEarlyData > 0 {
+		return shared.NewEarlyDataConn(maxEarlyData, earlyDataDelay, dial), nil
+	}
+	return dial(nil)
 }
@@ -17,6 +17,7 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"liuproxy_nexus/internal/shared"
+	"liuproxy_nexus/internal/shared/outbound"
 	"liuproxy_nexus/internal/shared/types"
 )
 
@@ -26,6 +27,7 @@ type HTTPStrategy struct {
 	profile           *types.ServerProfile
 	logger            zerolog.Logger
 	stateManager      types.StateManager
+	dialer            types.Dialer
 	activeConnections atomic.Int64
 	uplinkBytes       atomic.Uint64
 	downlinkBytes     atomic.Uint64
@@ -34,11 +36,12 @@ type HTTPStrategy struct {
 // Ensure HTTPStrategy implements TunnelStrategy interface
 var _ types.TunnelStrategy = (*HTTPStrategy)(nil)
 
-func NewHTTPStrategy(cfg *types.Config, profile *types.ServerProfile, stateManager types.StateManager) (types.TunnelStrategy, error) {
+func NewHTTPStrategy(cfg *types.Config, profile *types.ServerProfile, stateManager types.StateManager, dialer types.Dialer) (types.TunnelStrategy, error) {
 	s := &HTTPStrategy{
 		config:       cfg,
 		profile:      profile,
 		stateManager: stateManager,
+		dialer:       outbound.Or(dialer),
 		logger: log.With().
 			Str("strategy_type", "http").
 			Str("server_id", profile.ID).
@@ -86,7 +89,7 @@ func (s *HTTPStrategy) handleSocksConnection(clientConn net.Conn) {
 
 	//s.logger.Debug().Str("proxy_addr", proxyAddr).Msg("DIAGNOSTIC: Dialing upstream HTTP proxy...")
 
-	proxyConn, err := net.DialTimeout("tcp", proxyAddr, 10*time.Second)
+	proxyConn, err := outbound.DialWithTimeout(s.dialer, "tcp", proxyAddr, 10*time.Second)
 	if err != nil {
 		s.logger.Error().Err(err).Str("proxy_addr", proxyAddr).Msg("DIAGNOSTIC: Failed to dial upstream HTTP proxy.")
 		if s.stateManager != nil {
@@ -172,7 +175,7 @@ func (s *HTTPStrategy) handleSocksConnection(clientConn net.Conn) {
 
 func (s *HTTPStrategy) Initialize() error                    { return nil }
 func (s *HTTPStrategy) InitializeForGateway() error          { return nil }
-func (s *HTTPStrategy) CloseTunnel()                         {}
+func (s *HTTPStrategy) CloseTunnel()                         { outbound.CloseDialer(s.dialer) }
 func (s *HTTPStrategy) GetType() string                      { return "http" }
 func (s *HTTPStrategy) GetListenerInfo() *types.ListenerInfo { return nil }
 func (s *HTTPStrategy) GetMetrics() *types.Metrics {
@@ -224,7 +227,7 @@ func (s *HTTPStrategy) CheckHealthAdvanced() (latency int64, exitIP string, err
 	start := time.Now()
 	proxyAddr := net.JoinHostPort(s.profile.Address, strconv.Itoa(s.profile.Port))
 
-	conn, err := net.DialTimeout("tcp", proxyAddr, 5*time.Second)
+	conn, err := outbound.DialWithTimeout(s.dialer, "tcp", proxyAddr, 5*time.Second)
 	if err != nil {
 		return -1, "", err
 	}
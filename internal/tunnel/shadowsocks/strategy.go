@@ -0,0 +1,127 @@
+package shadowsocks
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	ss "github.com/sagernet/sing-shadowsocks"
+	"github.com/sagernet/sing-shadowsocks/shadowaead"
+	"github.com/sagernet/sing-shadowsocks/shadowaead_2022"
+	"github.com/sagernet/sing/common"
+
+	"liuproxy_nexus/internal/shared"
+	"liuproxy_nexus/internal/shared/outbound"
+	"liuproxy_nexus/internal/shared/types"
+)
+
+// ShadowsocksStrategy 实现了 TunnelStrategy 接口，通过 Shadowsocks (AEAD / 2022) 服务器转发 TCP 和 UDP。
+type ShadowsocksStrategy struct {
+	config       *types.Config
+	profile      *types.ServerProfile
+	method       ss.Method
+	logger       zerolog.Logger
+	stateManager types.StateManager
+	dialer       types.Dialer
+	relay        *shared.SocksRelay // 本地 SOCKS5 入口、透明代理 TCP 和 UDP 会话
+
+	closeOnce sync.Once
+}
+
+var _ types.TunnelStrategy = (*ShadowsocksStrategy)(nil)
+
+// NewShadowsocksStrategy 创建一个新的 Shadowsocks 策略实例。
+func NewShadowsocksStrategy(cfg *types.Config, profile *types.ServerProfile, stateManager types.StateManager, dialer types.Dialer) (types.TunnelStrategy, error) {
+	if profile == nil {
+		return nil, fmt.Errorf("shadowsocks strategy requires a non-nil profile")
+	}
+	method, err := newMethod(profile.Method, profile.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &ShadowsocksStrategy{
+		config:       cfg,
+		profile:      profile,
+		method:       method,
+		stateManager: stateManager,
+		dialer:       outbound.Or(dialer),
+		logger: log.With().
+			Str("strategy_type", "shadowsocks").
+			Str("server_id", profile.ID).
+			Str("remarks", profile.Remarks).Logger(),
+	}
+	s.relay = shared.NewSocksRelay("shadowsocks", &s.logger, s.dialTarget)
+	return s, nil
+}
+
+// newMethod 根据加密方法名创建客户端。只支持 AEAD 和 2022-blake3 系列，
+// 2022 方法的密码是 base64 编码的 PSK (多个 PSK 用 ":" 分隔，用于 EIH 中继)。
+func newMethod(method, password string) (ss.Method, error) {
+	switch {
+	case common.Contains(shadowaead.List, method):
+		return shadowaead.New(method, nil, password)
+	case common.Contains(shadowaead_2022.List, method):
+		return shadowaead_2022.NewWithPassword(method, password, nil)
+	default:
+		return nil, fmt.Errorf("unsupported shadowsocks method: '%s'", method)
+	}
+}
+
+func (s *ShadowsocksStrategy) serverAddr() string {
+	return net.JoinHostPort(s.profile.Address, strconv.Itoa(s.profile.Port))
+}
+
+// InitializeForGateway 在网关模式下被调用。Shadowsocks 按连接拨号，这是一个空操作。
+func (s *ShadowsocksStrategy) InitializeForGateway() error {
+	s.logger.Debug().Msg("Shadowsocks: Initializing for Gateway (no-op).")
+	return nil
+}
+
+func (s *ShadowsocksStrategy) GetType() string { return "shadowsocks" }
+
+func (s *ShadowsocksStrategy) CloseTunnel() {
+	s.closeOnce.Do(func() {
+		s.relay.Close()
+		outbound.CloseDialer(s.dialer)
+		s.logger.Info().Msg("shadowsocks strategy closed.")
+	})
+}
+
+func (s *ShadowsocksStrategy) GetListenerInfo() *types.ListenerInfo { return s.relay.GetListenerInfo() }
+func (s *ShadowsocksStrategy) GetMetrics() *types.Metrics           { return s.relay.GetMetrics() }
+func (s *ShadowsocksStrategy) GetTrafficStats() types.TrafficStats  { return s.relay.GetTrafficStats() }
+
+func (s *ShadowsocksStrategy) UpdateServer(profile *types.ServerProfile) error {
+	method, err := newMethod(profile.Method, profile.Password)
+	if err != nil {
+		return err
+	}
+	s.profile = profile
+	s.method = method
+	s.logger = log.With().
+		Str("strategy_type", "shadowsocks").
+		Str("server_id", profile.ID).
+		Str("remarks", profile.Remarks).Logger()
+	s.logger.Info().Msg("Shadowsocks profile updated. New settings will apply to subsequent connections.")
+	return nil
+}
+
+// CheckHealth (旧接口实现): 轻量级TCP拨号，用于移动端。
+func (s *ShadowsocksStrategy) CheckHealth() error {
+	conn, err := outbound.DialWithTimeout(s.dialer, "tcp", s.serverAddr(), 5*time.Second)
+	if err != nil {
+		return err
+	}
+	conn.Close()
+	return nil
+}
+
+// CheckHealthAdvanced 通过隧道请求 Cloudflare trace，返回延迟和出口 IP。
+func (s *ShadowsocksStrategy) CheckHealthAdvanced() (latency int64, exitIP string, err error) {
+	return shared.CheckTraceHealth("shadowsocks", shared.TraceURL, s.GetSocksConnection)
+}
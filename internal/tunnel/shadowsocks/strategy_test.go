@@ -0,0 +1,200 @@
+package shadowsocks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net"
+	"testing"
+
+	ss "github.com/sagernet/sing-shadowsocks"
+	"github.com/sagernet/sing-shadowsocks/shadowaead"
+	"github.com/sagernet/sing-shadowsocks/shadowaead_2022"
+	"github.com/sagernet/sing/common/buf"
+	"github.com/sagernet/sing/common/bufio"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+
+	"liuproxy_nexus/internal/shared"
+	"liuproxy_nexus/internal/shared/testutil"
+	"liuproxy_nexus/internal/shared/types"
+)
+
+// testHandler 是进程内 Shadowsocks 服务器的上游：TCP 连接转发到请求的目标，UDP 数据报原样回显。
+type testHandler struct {
+	t *testing.T
+}
+
+func (h *testHandler) NewConnection(ctx context.Context, conn net.Conn, metadata M.Metadata) error {
+	defer conn.Close()
+	target, err := net.Dial("tcp", metadata.Destination.String())
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+	shared.Relay(conn, conn, target)
+	return nil
+}
+
+func (h *testHandler) NewPacketConnection(ctx context.Context, conn N.PacketConn, metadata M.Metadata) error {
+	defer conn.Close()
+	for {
+		buffer := buf.NewPacket()
+		destination, err := conn.ReadPacket(buffer)
+		if err != nil {
+			buffer.Release()
+			return nil
+		}
+		// 服务器写回时需要在数据前加上地址和 salt，回显时预留头部空间
+		reply := buf.NewPacket()
+		reply.Resize(1024, 0)
+		reply.Write(buffer.Bytes())
+		buffer.Release()
+		if err := conn.WritePacket(reply, destination); err != nil {
+			return err
+		}
+	}
+}
+
+func (h *testHandler) NewError(ctx context.Context, err error) {
+	h.t.Logf("shadowsocks server: %v", err)
+}
+
+// startTestServer 在本地启动一个同时监听 TCP 和 UDP 的 Shadowsocks 服务器，返回其端口。
+func startTestServer(t *testing.T, method, password string) int {
+	t.Helper()
+	handler := &testHandler{t: t}
+	var service ss.Service
+	var err error
+	if len(method) > 5 && method[:5] == "2022-" {
+		service, err = shadowaead_2022.NewServiceWithPassword(method, password, 60, handler, nil)
+	} else {
+		service, err = shadowaead.NewService(method, nil, password, 60, handler)
+	}
+	if err != nil {
+		t.Fatalf("failed to create %s service: %v", method, err)
+	}
+
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := tcpListener.Addr().(*net.TCPAddr).Port
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	if err != nil {
+		tcpListener.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		tcpListener.Close()
+		udpConn.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := tcpListener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				metadata := M.Metadata{Source: M.SocksaddrFromNet(conn.RemoteAddr())}
+				if err := service.NewConnection(context.Background(), conn, metadata); err != nil {
+					conn.Close()
+				}
+			}()
+		}
+	}()
+
+	go func() {
+		packetConn := bufio.NewPacketConn(udpConn)
+		for {
+			buffer := buf.NewPacket()
+			n, addr, err := udpConn.ReadFrom(buffer.FreeBytes())
+			if err != nil {
+				buffer.Release()
+				return
+			}
+			buffer.Truncate(n)
+			metadata := M.Metadata{Source: M.SocksaddrFromNet(addr)}
+			if err := service.NewPacket(context.Background(), packetConn, buffer, metadata); err != nil {
+				buffer.Release()
+			}
+		}
+	}()
+
+	return port
+}
+
+func randomKey(t *testing.T, size int) string {
+	t.Helper()
+	key := make([]byte, size)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func TestShadowsocksStrategy(t *testing.T) {
+	tests := []struct {
+		method  string
+		keySize int // 0 表示使用普通密码
+	}{
+		{method: "aes-128-gcm"},
+		{method: "aes-256-gcm"},
+		{method: "chacha20-ietf-poly1305"},
+		{method: "2022-blake3-aes-128-gcm", keySize: 16},
+		{method: "2022-blake3-aes-256-gcm", keySize: 32},
+		{method: "2022-blake3-chacha20-poly1305", keySize: 32},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			password := "liuproxy-test-password"
+			if tt.keySize > 0 {
+				password = randomKey(t, tt.keySize)
+			}
+			port := startTestServer(t, tt.method, password)
+			echoAddr := testutil.StartEchoServer(t)
+
+			profile := &types.ServerProfile{
+				ID: "ss-test", Remarks: "ss-test", Type: "shadowsocks", Active: true,
+				Address: "127.0.0.1", Port: port, Method: tt.method, Password: password,
+			}
+			strategy, err := NewShadowsocksStrategy(&types.Config{}, profile, testutil.NopStateManager{}, nil)
+			if err != nil {
+				t.Fatalf("NewShadowsocksStrategy() error = %v", err)
+			}
+			defer strategy.CloseTunnel()
+
+			t.Run("socks", func(t *testing.T) {
+				conn := testutil.DialThroughStrategy(t, strategy, echoAddr)
+				defer conn.Close()
+				testutil.ExpectEcho(t, conn, "hello over socks")
+			})
+
+			t.Run("raw tcp", func(t *testing.T) {
+				client, inbound := net.Pipe()
+				defer client.Close()
+				go strategy.HandleRawTCP(inbound, echoAddr)
+				testutil.ExpectEcho(t, client, "hello over transparent tcp")
+			})
+
+			t.Run("udp", func(t *testing.T) {
+				testutil.ExpectUDPEcho(t, strategy, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 53}, "hello over udp")
+			})
+
+			if stats := strategy.GetTrafficStats(); stats.Uplink == 0 || stats.Downlink == 0 {
+				t.Errorf("traffic stats not counted: %+v", stats)
+			}
+		})
+	}
+}
+
+func TestShadowsocksStrategyRejectsUnsupportedMethod(t *testing.T) {
+	for _, method := range []string{"", "rc4-md5", "none"} {
+		profile := &types.ServerProfile{ID: "ss", Address: "127.0.0.1", Port: 8388, Method: method, Password: "x"}
+		if _, err := NewShadowsocksStrategy(&types.Config{}, profile, nil, nil); err == nil {
+			t.Errorf("method %q: expected an error", method)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package shadowsocks
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	M "github.com/sagernet/sing/common/metadata"
+)
+
+// Initialize 启动一个本地 SOCKS5 监听器。
+// 这个方法主要用于移动端等传统的转发代理场景。
+func (s *ShadowsocksStrategy) Initialize() error { return s.relay.Listen(s.profile.LocalPort) }
+
+// GetSocksConnection 为 Gateway 提供一个内存中的 SOCKS5 连接。
+func (s *ShadowsocksStrategy) GetSocksConnection() (net.Conn, error) {
+	return s.relay.GetSocksConnection()
+}
+
+// HandleRawTCP 是处理透明代理 TCP 流量的入口。
+func (s *ShadowsocksStrategy) HandleRawTCP(inboundConn net.Conn, targetDest string) {
+	s.relay.HandleRawTCP(inboundConn, targetDest)
+}
+
+// dialTarget 连接 Shadowsocks 服务器并发送请求头，返回到 targetAddr 的加密连接。
+func (s *ShadowsocksStrategy) dialTarget(targetAddr string) (net.Conn, error) {
+	destination := M.ParseSocksaddr(targetAddr)
+	if !destination.IsValid() {
+		return nil, fmt.Errorf("invalid target address: %s", targetAddr)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, err := s.dialer.DialContext(ctx, "tcp", s.serverAddr())
+	if err != nil {
+		if s.stateManager != nil {
+			s.stateManager.SetServerStatusDown(s.profile.ID, err.Error())
+		}
+		return nil, err
+	}
+
+	ssConn, err := s.method.DialConn(conn, destination)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssConn, nil
+}
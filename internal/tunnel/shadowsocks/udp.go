@@ -0,0 +1,22 @@
+package shadowsocks
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"liuproxy_nexus/internal/shared/types"
+)
+
+// HandleUDPPacket 处理透明代理的 UDP 包：按 sessionKey 复用到服务器的 UDP 关联，所有目标共用同一个加密 PacketConn。
+func (s *ShadowsocksStrategy) HandleUDPPacket(packet *types.UDPPacket, sessionKey string) error {
+	return s.relay.SendUDP(sessionKey, packet, nil, func() (net.PacketConn, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		conn, err := s.dialer.DialContext(ctx, "udp", s.serverAddr())
+		if err != nil {
+			return nil, err
+		}
+		return s.method.DialPacketConn(conn), nil
+	})
+}
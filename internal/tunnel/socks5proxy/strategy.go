@@ -2,6 +2,7 @@ package socks5proxy
 
 import (
 	"bufio"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -16,6 +17,7 @@ import (
 	"golang.org/x/net/proxy"
 
 	"liuproxy_nexus/internal/shared"
+	"liuproxy_nexus/internal/shared/outbound"
 	"liuproxy_nexus/internal/shared/types"
 )
 
@@ -25,6 +27,7 @@ type SOCKS5Strategy struct {
 	profile           *types.ServerProfile
 	logger            zerolog.Logger
 	stateManager      types.StateManager
+	dialer            types.Dialer
 	activeConnections atomic.Int64
 	uplinkBytes       atomic.Uint64
 	downlinkBytes     atomic.Uint64
@@ -32,11 +35,12 @@ type SOCKS5Strategy struct {
 
 var _ types.TunnelStrategy = (*SOCKS5Strategy)(nil)
 
-func NewSOCKS5Strategy(cfg *types.Config, profile *types.ServerProfile, stateManager types.StateManager) (types.TunnelStrategy, error) {
+func NewSOCKS5Strategy(cfg *types.Config, profile *types.ServerProfile, stateManager types.StateManager, dialer types.Dialer) (types.TunnelStrategy, error) {
 	s := &SOCKS5Strategy{
 		config:       cfg,
 		profile:      profile,
 		stateManager: stateManager,
+		dialer:       outbound.Or(dialer),
 		logger: log.With().
 			Str("strategy_type", "socks5").
 			Str("server_id", profile.ID).
@@ -73,7 +77,7 @@ func (s *SOCKS5Strategy) handleSocksConnection(clientConn net.Conn) {
 	}
 
 	proxyAddr := net.JoinHostPort(s.profile.Address, strconv.Itoa(s.profile.Port))
-	dialer, err := proxy.SOCKS5("tcp", proxyAddr, nil, &net.Dialer{Timeout: 10 * time.Second})
+	dialer, err := proxy.SOCKS5("tcp", proxyAddr, nil, &forwardDialer{dialer: s.dialer, timeout: 10 * time.Second})
 	if err != nil {
 		s.logger.Error().Err(err).Msg("Failed to create SOCKS5 dialer.")
 		clientConn.Write([]byte{0x05, 0x01, 0x00, 0x01, 0, 0, 0, 0, 0, 0}) // General failure
@@ -175,7 +179,7 @@ func (s *SOCKS5Strategy) socks5Handshake(conn net.Conn, reader *bufio.Reader) (b
 
 func (s *SOCKS5Strategy) Initialize() error                    { return nil }
 func (s *SOCKS5Strategy) InitializeForGateway() error          { return nil }
-func (s *SOCKS5Strategy) CloseTunnel()                         {}
+func (s *SOCKS5Strategy) CloseTunnel()                         { outbound.CloseDialer(s.dialer) }
 func (s *SOCKS5Strategy) GetType() string                      { return "http" } // Keep type as http for UI consistency
 func (s *SOCKS5Strategy) GetListenerInfo() *types.ListenerInfo { return nil }
 
@@ -211,6 +215,20 @@ func (s *SOCKS5Strategy) CheckHealth() error {
 	return err
 }
 
+// forwardDialer 把策略的 types.Dialer 适配为 proxy.SOCKS5 所需的上游拨号器。
+type forwardDialer struct {
+	dialer  types.Dialer
+	timeout time.Duration
+}
+
+func (d *forwardDialer) Dial(network, addr string) (net.Conn, error) {
+	return outbound.DialWithTimeout(d.dialer, network, addr, d.timeout)
+}
+
+func (d *forwardDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d.dialer.DialContext(ctx, network, addr)
+}
+
 type pipeDialer struct{ conn net.Conn }
 
 func (d *pipeDialer) Dial(network, addr string) (net.Conn, error) { return d.conn, nil }
@@ -220,7 +238,7 @@ func (s *SOCKS5Strategy) CheckHealthAdvanced() (latency int64, exitIP string, er
 	start := time.Now()
 	proxyAddr := net.JoinHostPort(s.profile.Address, strconv.Itoa(s.profile.Port))
 
-	conn, err := net.DialTimeout("tcp", proxyAddr, 10*time.Second)
+	conn, err := outbound.DialWithTimeout(s.dialer, "tcp", proxyAddr, 10*time.Second)
 	if err != nil {
 		return -1, "", err
 	}
@@ -0,0 +1,94 @@
+package trojan
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"liuproxy_nexus/internal/shared/tlsclient"
+	xraynet "liuproxy_nexus/internal/xray_core/common/net"
+	"liuproxy_nexus/internal/xray_core/common/serial"
+	"liuproxy_nexus/internal/xray_core/transport/internet"
+	"liuproxy_nexus/internal/xray_core/transport/internet/tls"
+	"liuproxy_nexus/internal/xray_core/transport/internet/websocket"
+)
+
+// tlsConfig 根据 profile 构建 xray 的 TLS 配置。SNI 未设置时回退到 WebSocket 的 Host。
+func (s *TrojanStrategy) tlsConfig() *tls.Config {
+	serverName := s.profile.SNI
+	if serverName == "" {
+		serverName = s.profile.Host
+	}
+	return &tls.Config{
+		ServerName:    serverName,
+		Fingerprint:   s.profile.Fingerprint,
+		AllowInsecure: s.profile.AllowInsecure,
+	}
+}
+
+func (s *TrojanStrategy) destination() xraynet.Destination {
+	return xraynet.TCPDestination(xraynet.ParseAddress(s.profile.Address), xraynet.Port(s.profile.Port))
+}
+
+// dialServer 建立到 Trojan 服务器的加密连接，根据 Network 选择直连 TLS 或 WebSocket 传输。
+// 所有底层 TCP 连接都经由 s.dialer 建立，以支持 fwmark 和 via 链。
+func (s *TrojanStrategy) dialServer(ctx context.Context) (net.Conn, error) {
+	switch s.profile.Network {
+	case "", "tcp":
+		return s.dialTLS(ctx)
+	case "ws":
+		return s.dialWS(internet.ContextWithDialer(ctx, s.dialer.DialContext))
+	default:
+		return nil, fmt.Errorf("unsupported trojan network: '%s'", s.profile.Network)
+	}
+}
+
+// dialTLS 直接通过 TLS 连接服务器，握手由共用的 uTLS 客户端完成 (指纹、ALPN、ECH)。
+func (s *TrojanStrategy) dialTLS(ctx context.Context) (net.Conn, error) {
+	serverName := s.profile.Host
+	if serverName == "" {
+		serverName = s.profile.Address
+	}
+	config, err := tlsclient.FromProfile(s.profile, serverName, "h2", "http/1.1")
+	if err != nil {
+		return nil, err
+	}
+	conn, err := s.dialer.DialContext(ctx, "tcp", s.serverAddr())
+	if err != nil {
+		return nil, err
+	}
+	tlsConn, err := tlsclient.Client(ctx, conn, config)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("tls handshake failed: %w", err)
+	}
+	return tlsConn, nil
+}
+
+// dialWS 通过 WebSocket 传输连接服务器，TLS/uTLS 由 xray 的 websocket dialer 处理。
+// Security 为 "none" 时使用明文 ws (例如前置了 TLS 终结的 CDN)。
+func (s *TrojanStrategy) dialWS(ctx context.Context) (net.Conn, error) {
+	wsConfig := &websocket.Config{Path: s.profile.Path}
+	if s.profile.Host != "" {
+		wsConfig.Header = []*websocket.Header{{Key: "Host", Value: s.profile.Host}}
+	}
+	streamSettings := &internet.StreamConfig{
+		ProtocolName: "websocket",
+		TransportSettings: []*internet.TransportConfig{
+			{
+				ProtocolName: "websocket",
+				Settings:     serial.ToTypedMessage(wsConfig),
+			},
+		},
+	}
+	if s.profile.Security != "none" {
+		streamSettings.SecurityType = serial.GetMessageType(&tls.Config{})
+		streamSettings.SecuritySettings = []*serial.TypedMessage{serial.ToTypedMessage(s.tlsConfig())}
+	}
+
+	memoryStreamConfig, err := internet.ToMemoryStreamConfig(streamSettings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create memory stream config: %w", err)
+	}
+	return internet.Dial(ctx, s.destination(), memoryStreamConfig)
+}
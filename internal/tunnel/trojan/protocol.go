@@ -0,0 +1,155 @@
+package trojan
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// Trojan 请求命令
+const (
+	commandConnect   byte = 0x01
+	commandAssociate byte = 0x03
+)
+
+// 地址类型，与 SOCKS5 相同
+const (
+	atypIPv4   byte = 0x01
+	atypDomain byte = 0x03
+	atypIPv6   byte = 0x04
+)
+
+const maxUDPPayload = 65535
+
+var crlf = []byte{'\r', '\n'}
+
+// passwordHash 返回协议头中使用的密码摘要：hex(SHA224(password))，固定 56 字节。
+func passwordHash(password string) []byte {
+	sum := sha256.Sum224([]byte(password))
+	hash := make([]byte, hex.EncodedLen(len(sum)))
+	hex.Encode(hash, sum[:])
+	return hash
+}
+
+// encodeRequest 构造 Trojan 请求头：
+// hex(SHA224(password)) CRLF CMD ATYP DST.ADDR DST.PORT CRLF
+func encodeRequest(hash []byte, cmd byte, target string) ([]byte, error) {
+	buf := make([]byte, 0, len(hash)+2+1+1+256+2+2)
+	buf = append(buf, hash...)
+	buf = append(buf, crlf...)
+	buf = append(buf, cmd)
+	buf, err := appendAddress(buf, target)
+	if err != nil {
+		return nil, err
+	}
+	return append(buf, crlf...), nil
+}
+
+// encodePacket 构造 UDP ASSOCIATE 流中的一个数据报帧：
+// ATYP DST.ADDR DST.PORT LENGTH CRLF PAYLOAD
+func encodePacket(target string, payload []byte) ([]byte, error) {
+	if len(payload) > maxUDPPayload {
+		return nil, fmt.Errorf("udp payload too large: %d bytes", len(payload))
+	}
+	buf := make([]byte, 0, 1+256+2+2+2+len(payload))
+	buf, err := appendAddress(buf, target)
+	if err != nil {
+		return nil, err
+	}
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(payload)))
+	buf = append(buf, crlf...)
+	return append(buf, payload...), nil
+}
+
+// readPacket 从 UDP ASSOCIATE 流中读取一个数据报帧，返回来源地址和写入 buf 的负载长度。
+func readPacket(r io.Reader, buf []byte) (addr string, n int, err error) {
+	addr, err = readAddress(r)
+	if err != nil {
+		return "", 0, err
+	}
+	header := make([]byte, 4) // LENGTH + CRLF
+	if _, err = io.ReadFull(r, header); err != nil {
+		return "", 0, err
+	}
+	length := int(binary.BigEndian.Uint16(header[:2]))
+	if length > len(buf) {
+		return "", 0, fmt.Errorf("udp packet too large: %d bytes", length)
+	}
+	if _, err = io.ReadFull(r, buf[:length]); err != nil {
+		return "", 0, err
+	}
+	return addr, length, nil
+}
+
+// appendAddress 按 SOCKS5 地址格式编码 host:port 并追加到 b。
+func appendAddress(b []byte, target string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target address %q: %w", target, err)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in %q: %w", target, err)
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			b = append(b, atypIPv4)
+			b = append(b, ip4...)
+		} else {
+			b = append(b, atypIPv6)
+			b = append(b, ip.To16()...)
+		}
+	} else {
+		if len(host) == 0 || len(host) > 255 {
+			return nil, fmt.Errorf("invalid domain length: %d", len(host))
+		}
+		b = append(b, atypDomain, byte(len(host)))
+		b = append(b, host...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(port)), nil
+}
+
+// readAddress 读取 SOCKS5 格式的地址，返回 host:port。
+func readAddress(r io.Reader) (string, error) {
+	atyp := make([]byte, 1)
+	if _, err := io.ReadFull(r, atyp); err != nil {
+		return "", err
+	}
+
+	var host string
+	switch atyp[0] {
+	case atypIPv4, atypIPv6:
+		size := net.IPv4len
+		if atyp[0] == atypIPv6 {
+			size = net.IPv6len
+		}
+		ip := make([]byte, size)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case atypDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(r, length); err != nil {
+			return "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(r, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		return "", fmt.Errorf("unsupported address type: %d", atyp[0])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
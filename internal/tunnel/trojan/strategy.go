@@ -0,0 +1,124 @@
+package trojan
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"liuproxy_nexus/internal/shared"
+	"liuproxy_nexus/internal/shared/outbound"
+	"liuproxy_nexus/internal/shared/types"
+)
+
+// healthCheckURL 是 CheckHealthAdvanced 通过隧道请求的地址，测试中会替换为本地服务器。
+var healthCheckURL = shared.TraceURL
+
+// TrojanStrategy 实现了 TunnelStrategy 接口，通过 Trojan (TLS 或 WebSocket 传输) 服务器转发 TCP 和 UDP。
+type TrojanStrategy struct {
+	config       *types.Config
+	profile      *types.ServerProfile
+	passwordHash []byte
+	logger       zerolog.Logger
+	stateManager types.StateManager
+	dialer       types.Dialer
+	relay        *shared.SocksRelay // 本地 SOCKS5 入口、透明代理 TCP 和 UDP 会话
+
+	closeOnce sync.Once
+}
+
+var _ types.TunnelStrategy = (*TrojanStrategy)(nil)
+
+// NewTrojanStrategy 创建一个新的 Trojan 策略实例。
+func NewTrojanStrategy(cfg *types.Config, profile *types.ServerProfile, stateManager types.StateManager, dialer types.Dialer) (types.TunnelStrategy, error) {
+	if profile == nil {
+		return nil, fmt.Errorf("trojan strategy requires a non-nil profile")
+	}
+	if err := validateProfile(profile); err != nil {
+		return nil, err
+	}
+
+	s := &TrojanStrategy{
+		config:       cfg,
+		profile:      profile,
+		passwordHash: passwordHash(profile.Password),
+		stateManager: stateManager,
+		dialer:       outbound.Or(dialer),
+		logger: log.With().
+			Str("strategy_type", "trojan").
+			Str("server_id", profile.ID).
+			Str("remarks", profile.Remarks).Logger(),
+	}
+	s.relay = shared.NewSocksRelay("trojan", &s.logger, s.dialTarget)
+	return s, nil
+}
+
+func validateProfile(profile *types.ServerProfile) error {
+	if profile.Password == "" {
+		return fmt.Errorf("trojan profile '%s' has no password", profile.Remarks)
+	}
+	switch profile.Network {
+	case "", "tcp", "ws":
+		return nil
+	default:
+		return fmt.Errorf("unsupported trojan network: '%s'", profile.Network)
+	}
+}
+
+func (s *TrojanStrategy) serverAddr() string {
+	return net.JoinHostPort(s.profile.Address, strconv.Itoa(s.profile.Port))
+}
+
+// InitializeForGateway 在网关模式下被调用。Trojan 按连接拨号，这是一个空操作。
+func (s *TrojanStrategy) InitializeForGateway() error {
+	s.logger.Debug().Msg("Trojan: Initializing for Gateway (no-op).")
+	return nil
+}
+
+func (s *TrojanStrategy) GetType() string { return "trojan" }
+
+func (s *TrojanStrategy) CloseTunnel() {
+	s.closeOnce.Do(func() {
+		s.relay.Close()
+		outbound.CloseDialer(s.dialer)
+		s.logger.Info().Msg("trojan strategy closed.")
+	})
+}
+
+func (s *TrojanStrategy) GetListenerInfo() *types.ListenerInfo { return s.relay.GetListenerInfo() }
+func (s *TrojanStrategy) GetMetrics() *types.Metrics           { return s.relay.GetMetrics() }
+func (s *TrojanStrategy) GetTrafficStats() types.TrafficStats  { return s.relay.GetTrafficStats() }
+
+func (s *TrojanStrategy) UpdateServer(profile *types.ServerProfile) error {
+	if err := validateProfile(profile); err != nil {
+		return err
+	}
+	s.profile = profile
+	s.passwordHash = passwordHash(profile.Password)
+	s.logger = log.With().
+		Str("strategy_type", "trojan").
+		Str("server_id", profile.ID).
+		Str("remarks", profile.Remarks).Logger()
+	s.logger.Info().Msg("Trojan profile updated. New settings will apply to subsequent connections.")
+	return nil
+}
+
+// CheckHealth (旧接口实现): 轻量级TCP拨号，用于移动端。
+func (s *TrojanStrategy) CheckHealth() error {
+	conn, err := outbound.DialWithTimeout(s.dialer, "tcp", s.serverAddr(), 5*time.Second)
+	if err != nil {
+		return err
+	}
+	conn.Close()
+	return nil
+}
+
+// CheckHealthAdvanced 通过隧道请求 Cloudflare trace，返回延迟和出口 IP。
+// 这会完整走一遍 TLS 握手、Trojan 认证和目标连接，比单纯的 TCP 拨号更能反映真实可用性。
+func (s *TrojanStrategy) CheckHealthAdvanced() (latency int64, exitIP string, err error) {
+	return shared.CheckTraceHealth("trojan", healthCheckURL, s.GetSocksConnection)
+}
@@ -0,0 +1,290 @@
+package trojan
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"liuproxy_nexus/internal/shared"
+	"liuproxy_nexus/internal/shared/testutil"
+	"liuproxy_nexus/internal/shared/types"
+)
+
+const (
+	testPassword   = "liuproxy-trojan-password"
+	testServerName = "trojan.test"
+)
+
+// testCertificate 生成一个 trojan.test 的自签名证书。
+func testCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: testServerName},
+		DNSNames:     []string{testServerName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// serveTrojan 是测试用 Trojan 服务器的协议处理：校验密码，CONNECT 转发到目标，UDP ASSOCIATE 原样回显数据报。
+func serveTrojan(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	hash := make([]byte, 56+2)
+	if _, err := io.ReadFull(reader, hash); err != nil {
+		return
+	}
+	if !bytes.Equal(hash[:56], passwordHash(testPassword)) {
+		t.Errorf("trojan server: wrong password hash %q", hash[:56])
+		return
+	}
+	cmd, err := reader.ReadByte()
+	if err != nil {
+		return
+	}
+	target, err := readAddress(reader)
+	if err != nil {
+		t.Errorf("trojan server: bad address: %v", err)
+		return
+	}
+	if _, err := io.ReadFull(reader, make([]byte, 2)); err != nil {
+		return
+	}
+
+	switch cmd {
+	case commandConnect:
+		remote, err := net.Dial("tcp", target)
+		if err != nil {
+			return
+		}
+		defer remote.Close()
+		shared.Relay(conn, reader, remote)
+	case commandAssociate:
+		buf := make([]byte, maxUDPPayload)
+		for {
+			addr, n, err := readPacket(reader, buf)
+			if err != nil {
+				return
+			}
+			frame, err := encodePacket(addr, buf[:n])
+			if err != nil {
+				t.Errorf("trojan server: %v", err)
+				return
+			}
+			if _, err := conn.Write(frame); err != nil {
+				return
+			}
+		}
+	default:
+		t.Errorf("trojan server: unexpected command %d", cmd)
+	}
+}
+
+// startTLSServer 启动一个直接监听 TLS 的 Trojan 服务器，返回其端口。
+func startTLSServer(t *testing.T) int {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveTrojan(t, conn)
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// startWSServer 启动一个 WebSocket over TLS 的 Trojan 服务器，返回其端口。
+func startWSServer(t *testing.T, path string) int {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			http.NotFound(w, r)
+			return
+		}
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		serveTrojan(t, shared.NewWebSocketConnAdapter(ws))
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server.Listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestTrojanStrategy(t *testing.T) {
+	tests := []struct {
+		name        string
+		network     string
+		fingerprint string
+	}{
+		{name: "tls", network: "tcp"},
+		{name: "utls", network: "tcp", fingerprint: "chrome"},
+		{name: "ws", network: "ws"},
+		{name: "ws utls", network: "ws", fingerprint: "chrome"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var port int
+			if tt.network == "ws" {
+				port = startWSServer(t, "/trojan")
+			} else {
+				port = startTLSServer(t)
+			}
+			echoAddr := testutil.StartEchoServer(t)
+
+			profile := &types.ServerProfile{
+				ID: "trojan-test", Remarks: "trojan-test", Type: "trojan", Active: true,
+				Address: "127.0.0.1", Port: port, Password: testPassword,
+				Network: tt.network, Path: "/trojan", Host: testServerName,
+				SNI: testServerName, Fingerprint: tt.fingerprint, AllowInsecure: true,
+			}
+			strategy, err := NewTrojanStrategy(&types.Config{}, profile, testutil.NopStateManager{}, nil)
+			if err != nil {
+				t.Fatalf("NewTrojanStrategy() error = %v", err)
+			}
+			defer strategy.CloseTunnel()
+
+			t.Run("socks", func(t *testing.T) {
+				conn := testutil.DialThroughStrategy(t, strategy, echoAddr)
+				defer conn.Close()
+				testutil.ExpectEcho(t, conn, "hello over socks")
+			})
+
+			t.Run("raw tcp", func(t *testing.T) {
+				client, inbound := net.Pipe()
+				defer client.Close()
+				go strategy.HandleRawTCP(inbound, echoAddr)
+				testutil.ExpectEcho(t, client, "hello over transparent tcp")
+			})
+
+			t.Run("udp", func(t *testing.T) {
+				testutil.ExpectUDPEcho(t, strategy, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 53},
+					"hello over udp #0", "hello over udp #1", "hello over udp #2")
+			})
+
+			if stats := strategy.GetTrafficStats(); stats.Uplink == 0 || stats.Downlink == 0 {
+				t.Errorf("traffic stats not counted: %+v", stats)
+			}
+		})
+	}
+}
+
+func TestTrojanStrategyCheckHealthAdvanced(t *testing.T) {
+	trace := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "fl=1f1\nip=203.0.113.7\nloc=ZZ\n")
+	}))
+	defer trace.Close()
+	original := healthCheckURL
+	healthCheckURL = trace.URL
+	defer func() { healthCheckURL = original }()
+
+	profile := &types.ServerProfile{
+		ID: "trojan-health", Type: "trojan", Address: "127.0.0.1", Port: startTLSServer(t),
+		Password: testPassword, SNI: testServerName, AllowInsecure: true,
+	}
+	strategy, err := NewTrojanStrategy(&types.Config{}, profile, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer strategy.CloseTunnel()
+
+	latency, exitIP, err := strategy.(*TrojanStrategy).CheckHealthAdvanced()
+	if err != nil {
+		t.Fatalf("CheckHealthAdvanced() error = %v", err)
+	}
+	if exitIP != "203.0.113.7" {
+		t.Errorf("exitIP = %q, want 203.0.113.7", exitIP)
+	}
+	if latency < 0 {
+		t.Errorf("latency = %d, want >= 0", latency)
+	}
+}
+
+func TestTrojanStrategyVerifiesCertificate(t *testing.T) {
+	port := startTLSServer(t)
+	down := make(chan string, 1)
+	profile := &types.ServerProfile{
+		ID: "trojan-verify", Type: "trojan", Address: "127.0.0.1", Port: port,
+		Password: testPassword, SNI: testServerName,
+	}
+	strategy, err := NewTrojanStrategy(&types.Config{}, profile, &downRecorder{down: down}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer strategy.CloseTunnel()
+
+	if conn, err := strategy.(*TrojanStrategy).dialTarget(net.JoinHostPort("127.0.0.1", strconv.Itoa(port))); err == nil {
+		conn.Close()
+		t.Fatal("expected the self-signed certificate to be rejected without allowInsecure")
+	}
+	select {
+	case id := <-down:
+		if id != "trojan-verify" {
+			t.Errorf("server marked down = %q, want trojan-verify", id)
+		}
+	default:
+		t.Error("expected the server to be marked down")
+	}
+}
+
+type downRecorder struct {
+	down chan string
+}
+
+func (r *downRecorder) SetServerStatusDown(serverID, reason string) {
+	select {
+	case r.down <- serverID:
+	default:
+	}
+}
+
+func TestNewTrojanStrategyValidatesProfile(t *testing.T) {
+	for _, profile := range []*types.ServerProfile{
+		{ID: "no-password", Address: "127.0.0.1", Port: 443},
+		{ID: "bad-network", Address: "127.0.0.1", Port: 443, Password: "x", Network: "quic"},
+	} {
+		if _, err := NewTrojanStrategy(&types.Config{}, profile, nil, nil); err == nil {
+			t.Errorf("%s: expected an error", profile.ID)
+		}
+	}
+}
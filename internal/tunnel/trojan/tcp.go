@@ -0,0 +1,46 @@
+package trojan
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Initialize 启动一个本地 SOCKS5 监听器。
+// 这个方法主要用于移动端等传统的转发代理场景。
+func (s *TrojanStrategy) Initialize() error { return s.relay.Listen(s.profile.LocalPort) }
+
+// GetSocksConnection 为 Gateway 提供一个内存中的 SOCKS5 连接。
+func (s *TrojanStrategy) GetSocksConnection() (net.Conn, error) {
+	return s.relay.GetSocksConnection()
+}
+
+// HandleRawTCP 是处理透明代理 TCP 流量的入口。
+func (s *TrojanStrategy) HandleRawTCP(inboundConn net.Conn, targetDest string) {
+	s.relay.HandleRawTCP(inboundConn, targetDest)
+}
+
+// dialTarget 连接 Trojan 服务器并发送 CONNECT 请求头，返回到 targetAddr 的隧道连接。
+func (s *TrojanStrategy) dialTarget(targetAddr string) (net.Conn, error) {
+	header, err := encodeRequest(s.passwordHash, commandConnect, targetAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, err := s.dialServer(ctx)
+	if err != nil {
+		if s.stateManager != nil {
+			s.stateManager.SetServerStatusDown(s.profile.ID, err.Error())
+		}
+		return nil, err
+	}
+
+	if _, err := conn.Write(header); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to write trojan request: %w", err)
+	}
+	return conn, nil
+}
@@ -0,0 +1,78 @@
+package trojan
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"liuproxy_nexus/internal/shared/types"
+)
+
+// HandleUDPPacket 处理透明代理的 UDP 包：按 sessionKey 复用到服务器的 UDP ASSOCIATE 隧道，
+// 所有目标的数据报按帧复用同一条 Trojan 连接。
+func (s *TrojanStrategy) HandleUDPPacket(packet *types.UDPPacket, sessionKey string) error {
+	return s.relay.SendUDP(sessionKey, packet, nil, func() (net.PacketConn, error) {
+		return s.dialAssociate(packet.Destination.String())
+	})
+}
+
+// dialAssociate 连接 Trojan 服务器并发送 UDP ASSOCIATE 请求头。
+// 请求头中的地址服务器不会使用，这里填第一个目标。
+func (s *TrojanStrategy) dialAssociate(firstTarget string) (net.PacketConn, error) {
+	header, err := encodeRequest(s.passwordHash, commandAssociate, firstTarget)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, err := s.dialServer(ctx)
+	if err != nil {
+		if s.stateManager != nil {
+			s.stateManager.SetServerStatusDown(s.profile.ID, err.Error())
+		}
+		return nil, err
+	}
+	if _, err := conn.Write(header); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to write trojan udp request: %w", err)
+	}
+	return &packetConn{Conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// packetConn 把 UDP ASSOCIATE 连接包装为 net.PacketConn，每个数据报是一个带目标地址的帧。
+type packetConn struct {
+	net.Conn
+	reader  *bufio.Reader
+	writeMu sync.Mutex // 保证并发写入的数据报帧不会交错
+}
+
+func (c *packetConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	frame, err := encodePacket(addr.String(), p)
+	if err != nil {
+		return 0, err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if _, err := c.Conn.Write(frame); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *packetConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	addr, n, err := readPacket(c.reader, p)
+	if err != nil {
+		return 0, nil, err
+	}
+	return n, packetAddr(addr), nil
+}
+
+// packetAddr 是帧中的来源地址，可能是域名。
+type packetAddr string
+
+func (a packetAddr) Network() string { return "udp" }
+func (a packetAddr) String() string  { return string(a) }
@@ -1,8 +1,11 @@
 package vless
 
 import (
+	"encoding/binary"
 	"fmt"
 	"io"
+	"net"
+	"strconv"
 )
 
 // DecodeResponseHeader 解码VLESS响应头，目前只做验证
@@ -29,3 +32,38 @@ func DecodeResponseHeader(reader io.Reader) error {
 
 	return nil
 }
+
+// readAddressPort 读取 writeAddressPort 格式的目标，返回 host:port。
+func readAddressPort(reader io.Reader) (string, error) {
+	var header [3]byte // port(2) + address type(1)
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return "", err
+	}
+	port := binary.BigEndian.Uint16(header[:2])
+
+	var host string
+	switch header[2] {
+	case AddressTypeIPv4, AddressTypeIPv6:
+		ip := make([]byte, net.IPv4len)
+		if header[2] == AddressTypeIPv6 {
+			ip = make([]byte, net.IPv6len)
+		}
+		if _, err := io.ReadFull(reader, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case AddressTypeDomain:
+		var length [1]byte
+		if _, err := io.ReadFull(reader, length[:]); err != nil {
+			return "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(reader, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		return "", fmt.Errorf("unknown address type: %d", header[2])
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), nil
+}
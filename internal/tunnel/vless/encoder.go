@@ -23,11 +23,14 @@ const (
 
 const (
 	RequestCommandTCP = byte(0x01)
+	RequestCommandUDP = byte(0x02)
+	RequestCommandMux = byte(0x03) // mux.cool，用于 XUDP
 )
 
 // EncodeRequestHeader 编码VLESS请求头，但使用标准库类型。
-// 这是新的、解耦后的版本。
-func EncodeRequestHeader(writer io.Writer, command byte, host string, port int, userUUID string) error {
+// 这是新的、解耦后的版本。flow 非空时写入 addons (如 xtls-rprx-vision)。
+// command 为 RequestCommandMux 时不写目标地址，host 和 port 被忽略。
+func EncodeRequestHeader(writer io.Writer, command byte, host string, port int, userUUID string, flow string) error {
 	uid, err := uuid.Parse(userUUID)
 	if err != nil {
 		return fmt.Errorf("invalid vless uuid: %w", err)
@@ -39,14 +42,36 @@ func EncodeRequestHeader(writer io.Writer, command byte, host string, port int,
 	buf.WriteByte(Version)
 	// 2. UUID
 	buf.Write(uid[:])
-	// 3. Addons (currently none)
-	buf.WriteByte(0x00)
+	// 3. Addons: protobuf 编码，目前只有 field 1 (flow)
+	if flow == "" {
+		buf.WriteByte(0x00)
+	} else {
+		if len(flow) > 127 {
+			return fmt.Errorf("vless flow too long: %s", flow)
+		}
+		buf.WriteByte(byte(2 + len(flow)))
+		buf.WriteByte(0x0a) // field 1, wire type 2
+		buf.WriteByte(byte(len(flow)))
+		buf.WriteString(flow)
+	}
 	// 4. Command
 	buf.WriteByte(command)
-	// 5. Port (BigEndian)
+	// 5. Port + Address
+	if command != RequestCommandMux {
+		if err := writeAddressPort(buf, host, port); err != nil {
+			return err
+		}
+	}
+
+	// 6. Write buffer to the underlying writer
+	_, err = writer.Write(buf.Bytes())
+	return err
+}
+
+// writeAddressPort 按 VLESS (以及 mux.cool) 的格式写入目标：端口 (BigEndian) 在前，然后是地址类型和地址。
+func writeAddressPort(buf *bytes.Buffer, host string, port int) error {
 	binary.Write(buf, binary.BigEndian, uint16(port))
 
-	// 6. Address
 	ip := net.ParseIP(host)
 	if ip != nil {
 		if ipv4 := ip.To4(); ipv4 != nil {
@@ -56,16 +81,13 @@ func EncodeRequestHeader(writer io.Writer, command byte, host string, port int,
 			buf.WriteByte(AddressTypeIPv6)
 			buf.Write(ip.To16())
 		}
-	} else {
-		if len(host) > 255 {
-			return fmt.Errorf("domain length > 255: %s", host)
-		}
-		buf.WriteByte(AddressTypeDomain)
-		buf.WriteByte(byte(len(host)))
-		buf.WriteString(host)
+		return nil
 	}
-
-	// 7. Write buffer to the underlying writer
-	_, err = writer.Write(buf.Bytes())
-	return err
+	if len(host) > 255 {
+		return fmt.Errorf("domain length > 255: %s", host)
+	}
+	buf.WriteByte(AddressTypeDomain)
+	buf.WriteByte(byte(len(host)))
+	buf.WriteString(host)
+	return nil
 }
@@ -43,7 +43,7 @@ func (s *VlessStrategyNative) GetSocksConnection() (net.Conn, error) {
 	go func() {
 		countedPipe := shared.NewCountedConn(serverPipe, &s.uplinkBytes, &s.downlinkBytes)
 		defer s.activeConnections.Add(-1)
-		ctx := s.logger.WithContext(context.Background())
+		ctx := s.withDialer(s.logger.WithContext(context.Background()))
 		// HandleConnection 包含了完整的 SOCKS5 握手和后续逻辑。
 		// 它将 serverPipe 视为一个标准的 net.Conn。
 		HandleConnection(ctx, countedPipe, bufio.NewReader(countedPipe), s.profile, s.stateManager)
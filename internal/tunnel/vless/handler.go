@@ -3,6 +3,7 @@ package vless
 import (
 	"bufio"
 	"context"
+	"fmt"
 	"github.com/rs/zerolog/log"
 	"net"
 
@@ -10,7 +11,8 @@ import (
 )
 
 // HandleConnection 是 VLESS 原生策略的统一连接处理器。
-// 它接收一个带有 logger 的 context，并根据 profile 中的网络类型进行分发。
+// 它接收一个带有 logger 的 context，完成 SOCKS5 握手后，CONNECT 根据 profile 中的网络类型进行分发，
+// UDP ASSOCIATE 交给 HandleUDPAssociate。
 func HandleConnection(
 	ctx context.Context,
 	clientConn net.Conn,
@@ -18,6 +20,30 @@ func HandleConnection(
 	profile *types.ServerProfile,
 	stateManager types.StateManager,
 ) {
+	l := log.Ctx(ctx)
+
+	cmd, targetAddr, err := ReadSocks5Request(clientConn, reader)
+	if err != nil {
+		clientConn.Close()
+		return
+	}
+
+	switch cmd {
+	case 0x01: // CONNECT
+	case 0x03: // UDP ASSOCIATE
+		HandleUDPAssociate(ctx, clientConn, reader, profile, stateManager)
+		return
+	default:
+		l.Debug().Int("command", int(cmd)).Msg("VLESS: Unsupported SOCKS5 command, closing.")
+		_, _ = clientConn.Write([]byte{0x05, 0x07, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		clientConn.Close()
+		return
+	}
+	if err := writeSocks5Success(clientConn, nil); err != nil {
+		clientConn.Close()
+		return
+	}
+
 	network := profile.Network
 	if network == "" {
 		network = "ws" // 默认为 ws
@@ -25,11 +51,38 @@ func HandleConnection(
 
 	switch network {
 	case "grpc":
-		HandleGRPCConnection(ctx, clientConn, reader, profile, stateManager)
+		HandleGRPCConnection(ctx, clientConn, reader, targetAddr, profile, stateManager)
 	case "ws":
-		HandleWSConnection(ctx, clientConn, reader, profile, stateManager)
+		HandleWSConnection(ctx, clientConn, reader, targetAddr, profile, stateManager)
+	case "tcp", "h2", "httpupgrade", "xhttp", "splithttp":
+		HandleStreamConnection(ctx, clientConn, reader, targetAddr, profile, stateManager)
 	default:
-		log.Ctx(ctx).Error().Str("network", network).Msg("Unsupported network type for VLESS native strategy")
+		l.Error().Str("network", network).Msg("Unsupported network type for VLESS native strategy")
 		clientConn.Close()
 	}
 }
+
+// DialVless 根据 profile 中的网络类型建立到 VLESS 服务器的连接 (不含 VLESS 请求头)。
+func DialVless(ctx context.Context, profile *types.ServerProfile) (net.Conn, error) {
+	network := profile.Network
+	if network == "" {
+		network = "ws" // 默认为 ws
+	}
+
+	switch network {
+	case "grpc":
+		return DialVlessGRPC(ctx, profile)
+	case "ws":
+		return DialVlessWS(ctx, profile)
+	case "tcp":
+		return DialVlessTCP(ctx, profile)
+	case "h2":
+		return DialVlessH2(ctx, profile)
+	case "httpupgrade":
+		return DialVlessHTTPUpgrade(ctx, profile)
+	case "xhttp", "splithttp":
+		return DialVlessXHTTP(ctx, profile)
+	default:
+		return nil, fmt.Errorf("unsupported network type for VLESS: %s", network)
+	}
+}
@@ -20,35 +20,26 @@ import (
 )
 
 // HandleGRPCConnection encapsulates all logic for handling a VLESS+gRPC connection.
-// It creates a new connection to the remote server for each request.
+// It creates a new connection to the remote server for each CONNECT request
+// (the SOCKS5 handshake has already been done by HandleConnection).
 func HandleGRPCConnection(
 	ctx context.Context,
 	clientConn net.Conn,
 	reader *bufio.Reader,
+	targetAddr string,
 	profile *types.ServerProfile,
 	stateManager types.StateManager,
 ) {
 	defer clientConn.Close()
 
 	l := log.Ctx(ctx)
-	l.Debug().Msg("VLESS-NATIVE-GRPC: New connection received, starting SOCKS5 handshake...")
-
-	cmd, targetAddr, err := HandshakeSocks5AndGetResponse(clientConn, reader)
-	if err != nil {
-		l.Debug().Err(err).Msg("VLESS-NATIVE-GRPC: SOCKS5 handshake failed")
-		return
-	}
-	if cmd != 1 {
-		l.Debug().Int("command", int(cmd)).Msg("VLESS-NATIVE-GRPC: Received non-CONNECT command, closing.")
-		return
-	}
-	l.Debug().Str("target", targetAddr).Msg("VLESS-NATIVE-GRPC: SOCKS5 handshake successful.")
+	l.Debug().Str("target", targetAddr).Msg("VLESS-NATIVE-GRPC: New CONNECT request received.")
 
 	host, portStr, _ := net.SplitHostPort(targetAddr)
 	port, _ := strconv.Atoi(portStr)
 
 	l.Debug().Str("remote", profile.Address).Msg("VLESS-NATIVE-GRPC: Dialing new connection to remote...")
-	remoteConn, err := DialVlessGRPC(context.Background(), profile)
+	remoteConn, err := DialVlessGRPC(ctx, profile)
 	if err != nil {
 		l.Error().Err(err).Str("remote", profile.Address).Msg("VLESS-NATIVE-GRPC: failed to dial remote")
 		// 【新增】调用 StateManager 将服务器状态设置为 Down
@@ -63,7 +54,7 @@ func HandleGRPCConnection(
 
 	headerBuf := new(bytes.Buffer)
 	// Temporarily use xraynet types for header encoding
-	if err := EncodeRequestHeader(headerBuf, RequestCommandTCP, host, port, profile.UUID); err != nil {
+	if err := EncodeRequestHeader(headerBuf, RequestCommandTCP, host, port, profile.UUID, ""); err != nil {
 		l.Error().Err(err).Msg("VLESS-NATIVE-GRPC: failed to encode request header")
 		return
 	}
@@ -0,0 +1,469 @@
+package vless
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	gotls "crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	utls "github.com/refraction-networking/utls"
+	"golang.org/x/net/http2"
+
+	"liuproxy_nexus/internal/shared/types"
+	xraynet "liuproxy_nexus/internal/xray_core/common/net"
+	"liuproxy_nexus/internal/xray_core/transport/internet"
+	"liuproxy_nexus/internal/xray_core/transport/internet/tls"
+)
+
+// 基于 HTTP 的传输：h2 (HTTP/2 流式 POST)、httpupgrade (HTTP/1.1 Upgrade 后的裸流)
+// 和 xhttp (splithttp 的 packet-up 模式：一个 GET 接收下行，按序号的 POST 发送上行)。
+// 都只支持 security 为 tls 或 none，TLS 握手与 raw TCP 一样使用 profile 的 SNI / Fingerprint。
+
+// xhttp 的上行参数，与 xray 的默认值一致。
+const (
+	xhttpMaxPostBytes    = 1000000
+	xhttpMinPostInterval = 30 * time.Millisecond
+	xhttpPaddingMin      = 100
+	xhttpPaddingMax      = 1000
+	xhttpFlushTimeout    = 5 * time.Second
+)
+
+// dialHTTPStream 建立 HTTP 传输的底层连接：tls 时以 alpn 完成 (u)TLS 握手并校验协商结果。
+func dialHTTPStream(ctx context.Context, profile *types.ServerProfile, alpn string) (net.Conn, error) {
+	dest := xraynet.TCPDestination(xraynet.ParseAddress(profile.Address), xraynet.Port(profile.Port))
+	rawConn, err := internet.DialSystem(ctx, dest, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	switch profile.Security {
+	case "tls":
+		conn, err := tlsClient(ctx, rawConn, profile, dest, []string{alpn})
+		if err != nil {
+			rawConn.Close()
+			return nil, err
+		}
+		if negotiated := negotiatedProtocol(conn); negotiated != alpn && (alpn == "h2" || negotiated != "") {
+			conn.Close()
+			return nil, fmt.Errorf("server negotiated ALPN '%s', want '%s'", negotiated, alpn)
+		}
+		return conn, nil
+	case "", "none":
+		return rawConn, nil
+	default:
+		rawConn.Close()
+		return nil, fmt.Errorf("unsupported security '%s' for vless %s", profile.Security, profile.Network)
+	}
+}
+
+func negotiatedProtocol(conn net.Conn) string {
+	switch c := conn.(type) {
+	case *tls.Conn:
+		return c.ConnectionState().NegotiatedProtocol
+	case *tls.UConn:
+		name, _ := c.NegotiatedProtocol()
+		return name
+	case *utls.UConn:
+		return c.ConnectionState().NegotiatedProtocol
+	}
+	return ""
+}
+
+// httpHost 返回 HTTP 请求使用的 Host：优先 profile.Host，其次 SNI，最后服务器地址。
+func httpHost(profile *types.ServerProfile) string {
+	switch {
+	case profile.Host != "":
+		return profile.Host
+	case profile.SNI != "":
+		return profile.SNI
+	default:
+		return net.JoinHostPort(profile.Address, strconv.Itoa(profile.Port))
+	}
+}
+
+func httpScheme(profile *types.ServerProfile) string {
+	if profile.Security == "tls" {
+		return "https"
+	}
+	return "http"
+}
+
+// httpPath 返回以 "/" 开头的路径；trailingSlash 时同时保证以 "/" 结尾 (xhttp 在其后拼接会话 ID)。
+func httpPath(profile *types.ServerProfile, trailingSlash bool) string {
+	path := profile.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	if trailingSlash && !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+	return path
+}
+
+// newH2Transport 创建一个通过 dialHTTPStream 建立连接的 HTTP/2 传输，非 tls 时使用 h2c。
+func newH2Transport(profile *types.ServerProfile) *http2.Transport {
+	return &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *gotls.Config) (net.Conn, error) {
+			return dialHTTPStream(ctx, profile, "h2")
+		},
+		ReadIdleTimeout: 30 * time.Second,
+		IdleConnTimeout: 30 * time.Second,
+	}
+}
+
+// streamContext 返回一个保留 ctx 中的值 (日志、拨号器) 但不随 ctx 取消的 context：
+// 拨号阶段 ctx 取消会中止请求，建立之后连接的生命周期由 Close 控制。
+func streamContext(ctx context.Context) (context.Context, context.CancelFunc, func() bool) {
+	streamCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, cancel)
+	return streamCtx, cancel, stop
+}
+
+// DialVlessHTTPUpgrade 是 httpupgrade 传输的专用拨号器：发送 HTTP/1.1 Upgrade 请求，收到 101 后直接使用这条连接。
+func DialVlessHTTPUpgrade(ctx context.Context, profile *types.ServerProfile) (net.Conn, error) {
+	conn, err := dialHTTPStream(ctx, profile, "http/1.1")
+	if err != nil {
+		return nil, err
+	}
+
+	host := httpHost(profile)
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    &url.URL{Scheme: "http", Host: host, Path: httpPath(profile, false)},
+		Host:   host,
+		Header: http.Header{
+			"Connection": {"Upgrade"},
+			"Upgrade":    {"websocket"},
+		},
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send httpupgrade request: %w", err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read httpupgrade response: %w", err)
+	}
+	resp.Body.Close()
+	conn.SetDeadline(time.Time{})
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		!strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") ||
+		!strings.EqualFold(resp.Header.Get("Connection"), "upgrade") {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected httpupgrade response: %s", resp.Status)
+	}
+
+	if reader.Buffered() == 0 {
+		return conn, nil
+	}
+	return &bufferedConn{Conn: conn, reader: reader}, nil
+}
+
+// bufferedConn 先读出升级响应之后已被缓冲的数据，再读取底层连接。
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) { return c.reader.Read(b) }
+
+func (c *bufferedConn) CloseWrite() error {
+	CloseWriter(c.Conn)
+	return nil
+}
+
+// DialVlessH2 是 h2 传输的专用拨号器：一个 HTTP/2 POST 请求，请求体为上行，响应体为下行。
+func DialVlessH2(ctx context.Context, profile *types.ServerProfile) (net.Conn, error) {
+	transport := newH2Transport(profile)
+	streamCtx, cancel, stop := streamContext(ctx)
+	defer stop()
+
+	uplinkReader, uplinkWriter := io.Pipe()
+	host := httpHost(profile)
+	req, err := http.NewRequestWithContext(streamCtx, http.MethodPost, httpScheme(profile)+"://"+host+httpPath(profile, false), uplinkReader)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Host = host
+	req.ContentLength = -1
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		cancel()
+		transport.CloseIdleConnections()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		transport.CloseIdleConnections()
+		return nil, fmt.Errorf("unexpected h2 response: %s", resp.Status)
+	}
+
+	return &httpStreamConn{
+		reader: resp.Body,
+		writer: uplinkWriter,
+		remote: &net.TCPAddr{IP: net.ParseIP(profile.Address), Port: profile.Port},
+		onClose: func() {
+			cancel()
+			transport.CloseIdleConnections()
+		},
+	}, nil
+}
+
+// DialVlessXHTTP 是 xhttp (packet-up) 传输的专用拨号器。tls 时 GET 和 POST 复用一条 HTTP/2 连接，否则使用 HTTP/1.1。
+func DialVlessXHTTP(ctx context.Context, profile *types.ServerProfile) (net.Conn, error) {
+	var transport http.RoundTripper
+	closeTransport := func() {}
+	if profile.Security == "tls" {
+		h2 := newH2Transport(profile)
+		transport, closeTransport = h2, h2.CloseIdleConnections
+	} else {
+		h1 := &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return dialHTTPStream(ctx, profile, "http/1.1")
+			},
+			MaxIdleConnsPerHost: 4,
+			IdleConnTimeout:     30 * time.Second,
+		}
+		transport, closeTransport = h1, h1.CloseIdleConnections
+	}
+
+	streamCtx, cancel, stop := streamContext(ctx)
+	defer stop()
+	closeAll := func() {
+		cancel()
+		closeTransport()
+	}
+
+	host := httpHost(profile)
+	sessionURL := httpScheme(profile) + "://" + host + httpPath(profile, true) + uuid.NewString()
+	req, err := newXHTTPRequest(streamCtx, http.MethodGet, sessionURL, host, nil)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		closeAll()
+		return nil, fmt.Errorf("unexpected xhttp response: %s", resp.Status)
+	}
+
+	uplink := &xhttpUplink{
+		ctx:        streamCtx,
+		transport:  transport,
+		sessionURL: sessionURL,
+		host:       host,
+		chunks:     make(chan []byte, 16),
+		done:       make(chan struct{}),
+		finished:   make(chan struct{}),
+		onError:    closeAll,
+	}
+	go uplink.run()
+
+	return &xhttpConn{
+		httpStreamConn: &httpStreamConn{
+			reader: resp.Body,
+			writer: uplink,
+			remote: &net.TCPAddr{IP: net.ParseIP(profile.Address), Port: profile.Port},
+			onClose: func() {
+				uplink.Close()
+				closeAll()
+			},
+		},
+		uplink: uplink,
+	}, nil
+}
+
+// xhttpConn 是 packet-up 模式的连接。packet-up 无法只关闭上行，
+// 因此 CloseWrite 在发出已排队的数据后关闭整个连接，避免服务器端会话一直挂起。
+type xhttpConn struct {
+	*httpStreamConn
+	uplink *xhttpUplink
+}
+
+func (c *xhttpConn) CloseWrite() error {
+	c.uplink.Close()
+	select {
+	case <-c.uplink.finished:
+	case <-time.After(xhttpFlushTimeout):
+	}
+	return c.Close()
+}
+
+// newXHTTPRequest 创建 xhttp 请求，并像 xray 客户端一样在 Referer 的 x_padding 参数中携带随机长度的填充。
+func newXHTTPRequest(ctx context.Context, method, rawURL, host string, body []byte) (*http.Request, error) {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, bodyReader)
+	if err != nil {
+		return nil, err
+	}
+	req.Host = host
+	padding := strings.Repeat("X", xhttpPaddingMin+rand.IntN(xhttpPaddingMax-xhttpPaddingMin+1))
+	req.Header.Set("Referer", rawURL+"?x_padding="+padding)
+	return req, nil
+}
+
+// xhttpUplink 把写入的数据合并后按序号逐个 POST 到 <session>/<seq>。
+type xhttpUplink struct {
+	ctx        context.Context
+	transport  http.RoundTripper
+	sessionURL string
+	host       string
+	chunks     chan []byte
+	done       chan struct{}
+	finished   chan struct{} // run 退出时关闭
+	closeOnce  sync.Once
+	onError    func()
+
+	mu  sync.Mutex
+	err error
+}
+
+func (u *xhttpUplink) Write(b []byte) (int, error) {
+	if err := u.failure(); err != nil {
+		return 0, err
+	}
+	select {
+	case u.chunks <- append([]byte(nil), b...):
+		return len(b), nil
+	case <-u.done:
+		return 0, net.ErrClosed
+	case <-u.ctx.Done():
+		return 0, net.ErrClosed
+	}
+}
+
+// Close 停止上行。packet-up 没有上行结束的信号，已排队的数据仍会发出。
+func (u *xhttpUplink) Close() error {
+	u.closeOnce.Do(func() { close(u.done) })
+	return nil
+}
+
+func (u *xhttpUplink) failure() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.err
+}
+
+func (u *xhttpUplink) run() {
+	defer close(u.finished)
+	for seq := 0; ; seq++ {
+		var payload []byte
+		select {
+		case payload = <-u.chunks:
+		case <-u.done:
+			// 关闭后仍发出已排队的数据
+			select {
+			case payload = <-u.chunks:
+			default:
+				return
+			}
+		case <-u.ctx.Done():
+			return
+		}
+		// 合并已排队的数据，减少请求数
+	merge:
+		for len(payload) < xhttpMaxPostBytes {
+			select {
+			case more := <-u.chunks:
+				payload = append(payload, more...)
+			default:
+				break merge
+			}
+		}
+
+		start := time.Now()
+		if err := u.post(seq, payload); err != nil {
+			u.mu.Lock()
+			u.err = err
+			u.mu.Unlock()
+			u.onError()
+			return
+		}
+		if wait := xhttpMinPostInterval - time.Since(start); wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+}
+
+func (u *xhttpUplink) post(seq int, payload []byte) error {
+	req, err := newXHTTPRequest(u.ctx, http.MethodPost, u.sessionURL+"/"+strconv.Itoa(seq), u.host, payload)
+	if err != nil {
+		return err
+	}
+	resp, err := u.transport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected xhttp upload response: %s", resp.Status)
+	}
+	return nil
+}
+
+// httpStreamConn 把 HTTP 请求体 (上行) 和响应体 (下行) 组合成 net.Conn。
+type httpStreamConn struct {
+	reader    io.ReadCloser
+	writer    io.WriteCloser
+	remote    net.Addr
+	onClose   func()
+	closeOnce sync.Once
+}
+
+func (c *httpStreamConn) Read(b []byte) (int, error) {
+	n, err := c.reader.Read(b)
+	if errors.Is(err, context.Canceled) {
+		err = net.ErrClosed
+	}
+	return n, err
+}
+
+func (c *httpStreamConn) Write(b []byte) (int, error) { return c.writer.Write(b) }
+
+// CloseWrite 结束上行 (h2 会发送 END_STREAM)，下行不受影响。
+func (c *httpStreamConn) CloseWrite() error { return c.writer.Close() }
+
+func (c *httpStreamConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.writer.Close()
+		c.reader.Close()
+		c.onClose()
+	})
+	return nil
+}
+
+func (c *httpStreamConn) LocalAddr() net.Addr                { return &net.TCPAddr{} }
+func (c *httpStreamConn) RemoteAddr() net.Addr               { return c.remote }
+func (c *httpStreamConn) SetDeadline(t time.Time) error      { return nil }
+func (c *httpStreamConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *httpStreamConn) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,269 @@
+package vless
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"liuproxy_nexus/internal/shared/testutil"
+	"liuproxy_nexus/internal/shared/types"
+)
+
+const testHTTPPath = "/vless-http"
+
+// httpTestConn 把 HTTP 请求体和响应写入端包装成 net.Conn，交给 serveVless 处理。
+type httpTestConn struct {
+	net.Conn // 只用于满足接口，不会被调用
+	reader   io.Reader
+	writer   io.Writer
+	closeFn  func()
+}
+
+func (c *httpTestConn) Read(b []byte) (int, error) { return c.reader.Read(b) }
+
+func (c *httpTestConn) Write(b []byte) (int, error) {
+	n, err := c.writer.Write(b)
+	if flusher, ok := c.writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, err
+}
+
+func (c *httpTestConn) Close() error {
+	c.closeFn()
+	return nil
+}
+
+// hijackedConn 先读出 Hijack 时 bufio 中已缓冲的数据。
+type hijackedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *hijackedConn) Read(b []byte) (int, error) { return c.reader.Read(b) }
+
+// xhttpTestSession 把按序号到达的 POST 数据按顺序写入上行管道。
+type xhttpTestSession struct {
+	uplinkReader *io.PipeReader
+	uplinkWriter *io.PipeWriter
+
+	mu      sync.Mutex
+	next    int
+	pending map[int][]byte
+}
+
+func (s *xhttpTestSession) upload(seq int, payload []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending[seq] = payload
+	for {
+		data, ok := s.pending[s.next]
+		if !ok {
+			return nil
+		}
+		delete(s.pending, s.next)
+		s.next++
+		if _, err := s.uplinkWriter.Write(data); err != nil {
+			return err
+		}
+	}
+}
+
+// newHTTPTransportHandler 返回测试用的 VLESS HTTP 传输服务端，network 为 httpupgrade、h2 或 xhttp。
+func newHTTPTransportHandler(t *testing.T, network string) http.Handler {
+	var sessionsMu sync.Mutex
+	sessions := make(map[string]*xhttpTestSession)
+	getSession := func(id string) *xhttpTestSession {
+		sessionsMu.Lock()
+		defer sessionsMu.Unlock()
+		session, ok := sessions[id]
+		if !ok {
+			reader, writer := io.Pipe()
+			session = &xhttpTestSession{uplinkReader: reader, uplinkWriter: writer, pending: make(map[int][]byte)}
+			sessions[id] = session
+		}
+		return session
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch network {
+		case "httpupgrade":
+			if r.URL.Path != testHTTPPath || !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+				http.Error(w, "bad upgrade request", http.StatusBadRequest)
+				return
+			}
+			conn, bufrw, err := http.NewResponseController(w).Hijack()
+			if err != nil {
+				t.Errorf("httpupgrade server: %v", err)
+				return
+			}
+			bufrw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+			bufrw.Flush()
+			serveVless(t, &hijackedConn{Conn: conn, reader: bufrw.Reader})
+
+		case "h2":
+			if r.Method != http.MethodPost || r.URL.Path != testHTTPPath || r.ProtoMajor != 2 {
+				http.Error(w, "bad h2 request", http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			http.NewResponseController(w).Flush()
+			serveVless(t, &httpTestConn{reader: r.Body, writer: w, closeFn: func() { r.Body.Close() }})
+
+		case "xhttp":
+			referer, err := url.Parse(r.Header.Get("Referer"))
+			if padding := len(referer.Query().Get("x_padding")); err != nil || padding < xhttpPaddingMin || padding > xhttpPaddingMax {
+				http.Error(w, "invalid padding", http.StatusBadRequest)
+				return
+			}
+			parts := strings.Split(strings.TrimPrefix(r.URL.Path, testHTTPPath+"/"), "/")
+			switch {
+			case r.Method == http.MethodGet && len(parts) == 1:
+				session := getSession(parts[0])
+				w.Header().Set("X-Accel-Buffering", "no")
+				w.WriteHeader(http.StatusOK)
+				http.NewResponseController(w).Flush()
+				go func() {
+					<-r.Context().Done()
+					session.uplinkReader.CloseWithError(io.EOF)
+				}()
+				serveVless(t, &httpTestConn{reader: session.uplinkReader, writer: w, closeFn: func() {
+					session.uplinkReader.CloseWithError(io.EOF)
+				}})
+			case r.Method == http.MethodPost && len(parts) == 2:
+				seq, err := strconv.Atoi(parts[1])
+				if err != nil {
+					http.Error(w, "bad seq", http.StatusBadRequest)
+					return
+				}
+				payload, err := io.ReadAll(r.Body)
+				if err != nil {
+					return
+				}
+				if err := getSession(parts[0]).upload(seq, payload); err != nil {
+					http.Error(w, err.Error(), http.StatusGone)
+				}
+			default:
+				http.Error(w, "bad xhttp request", http.StatusBadRequest)
+			}
+		}
+	})
+}
+
+// startHTTPTransportServer 启动测试用 HTTP 传输服务器，tlsEnabled 时同时支持 h2 和 http/1.1 (ALPN 协商)。
+func startHTTPTransportServer(t *testing.T, network string, tlsEnabled bool) int {
+	t.Helper()
+	handler := newHTTPTransportHandler(t, network)
+	var server *httptest.Server
+	if tlsEnabled {
+		server = httptest.NewUnstartedServer(handler)
+		server.EnableHTTP2 = true
+		server.StartTLS()
+	} else {
+		server = httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	}
+	t.Cleanup(server.Close)
+	return server.Listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestVlessHTTPTransports(t *testing.T) {
+	echoAddr := testutil.StartEchoServer(t)
+
+	securities := []struct {
+		name        string
+		tls         bool
+		fingerprint string
+	}{
+		{name: "none"},
+		{name: "tls", tls: true},
+		{name: "utls", tls: true, fingerprint: "chrome"},
+	}
+
+	for _, network := range []string{"httpupgrade", "h2", "xhttp"} {
+		for _, security := range securities {
+			t.Run(network+"/"+security.name, func(t *testing.T) {
+				port := startHTTPTransportServer(t, network, security.tls)
+				profile := &types.ServerProfile{
+					ID: "vless-" + network, Remarks: "vless-" + network, Type: "vless", Active: true,
+					Address: "127.0.0.1", Port: port, UUID: testUUID,
+					Network: network, Path: testHTTPPath, Host: "cdn.example.com",
+				}
+				if security.tls {
+					profile.Security, profile.SNI, profile.AllowInsecure = "tls", "example.com", true
+					profile.Fingerprint = security.fingerprint
+				}
+
+				strategy, err := NewVlessStrategy(&types.Config{}, profile, nil, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer strategy.CloseTunnel()
+
+				t.Run("socks", func(t *testing.T) {
+					conn := testutil.DialThroughStrategy(t, strategy, echoAddr)
+					defer conn.Close()
+					testutil.ExpectEcho(t, conn, "hello over "+network)
+					testutil.ExpectEcho(t, conn, strings.Repeat("large payload ", 20000))
+				})
+
+				t.Run("raw tcp", func(t *testing.T) {
+					client, inbound := net.Pipe()
+					defer client.Close()
+					go strategy.HandleRawTCP(inbound, echoAddr)
+					testutil.ExpectEcho(t, client, "hello over transparent "+network)
+				})
+
+				t.Run("health", func(t *testing.T) {
+					if err := strategy.CheckHealth(); err != nil {
+						t.Fatalf("CheckHealth() error = %v", err)
+					}
+				})
+			})
+		}
+	}
+}
+
+func TestVlessHTTPUpgradeRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not found", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	profile := &types.ServerProfile{
+		Address: "127.0.0.1", Port: server.Listener.Addr().(*net.TCPAddr).Port,
+		UUID: testUUID, Network: "httpupgrade", Path: testHTTPPath,
+	}
+	for _, dial := range []func() (net.Conn, error){
+		func() (net.Conn, error) { return DialVlessHTTPUpgrade(t.Context(), profile) },
+		func() (net.Conn, error) { return DialVlessXHTTP(t.Context(), profile) },
+	} {
+		conn, err := dial()
+		if err == nil {
+			conn.Close()
+			t.Fatal("expected a non-success HTTP response to fail the dial")
+		}
+	}
+}
+
+func TestHTTPPath(t *testing.T) {
+	profile := &types.ServerProfile{Path: "xhttp"}
+	if got := httpPath(profile, false); got != "/xhttp" {
+		t.Errorf("httpPath() = %q, want /xhttp", got)
+	}
+	if got := httpPath(profile, true); got != "/xhttp/" {
+		t.Errorf("httpPath(trailingSlash) = %q, want /xhttp/", got)
+	}
+	if got := httpPath(&types.ServerProfile{}, true); got != "/" {
+		t.Errorf("httpPath(empty) = %q, want /", got)
+	}
+}
@@ -0,0 +1,224 @@
+package vless
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"liuproxy_nexus/internal/shared/tlsclient"
+	"liuproxy_nexus/internal/shared/types"
+	xraynet "liuproxy_nexus/internal/xray_core/common/net"
+	"liuproxy_nexus/internal/xray_core/transport/internet"
+	"liuproxy_nexus/internal/xray_core/transport/internet/reality"
+	"liuproxy_nexus/internal/xray_core/transport/internet/tls"
+)
+
+// defaultRealityFingerprint REALITY 必须使用 uTLS 指纹，profile 未指定时使用 chrome。
+const defaultRealityFingerprint = "chrome"
+
+// HandleStreamConnection 封装了处理基于字节流传输 (raw TCP、h2、httpupgrade、xhttp) 的 VLESS 连接的所有逻辑。
+// 它为每一个 CONNECT 请求 (SOCKS5 握手已由 HandleConnection 完成) 创建一个新的到远程服务器的连接。
+func HandleStreamConnection(
+	ctx context.Context,
+	clientConn net.Conn,
+	reader *bufio.Reader,
+	targetAddr string,
+	profile *types.ServerProfile,
+	stateManager types.StateManager,
+) {
+	defer clientConn.Close()
+
+	l := log.Ctx(ctx)
+
+	host, portStr, _ := net.SplitHostPort(targetAddr)
+	port, _ := strconv.Atoi(portStr)
+
+	remoteConn, err := DialVless(ctx, profile)
+	if err != nil {
+		l.Error().Err(err).Str("remote", profile.Address).Str("network", profile.Network).Msg("VLESS-NATIVE: failed to dial remote")
+		if stateManager != nil {
+			stateManager.SetServerStatusDown(profile.ID, err.Error())
+		}
+		_, _ = clientConn.Write([]byte{0x05, 0x04, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer remoteConn.Close()
+
+	headerBuf := new(bytes.Buffer)
+	if err := EncodeRequestHeader(headerBuf, RequestCommandTCP, host, port, profile.UUID, profile.Flow); err != nil {
+		l.Error().Err(err).Msg("VLESS-NATIVE: failed to encode request header")
+		return
+	}
+
+	relayVless(ctx, clientConn, reader, remoteConn, headerBuf.Bytes(), profile)
+}
+
+// firstPayloadTimeout 发送请求头前等待客户端第一个数据块的时间 (与 xray 一致)，
+// 超时后单独发送请求头，避免服务器先发言的协议 (SSH、SMTP 等) 卡住。
+const firstPayloadTimeout = 100 * time.Millisecond
+
+// relayVless 发送 VLESS 请求头并在客户端与远程连接之间双向转发数据。
+// clientReader 是客户端一侧可能已缓冲数据的读取端；profile.Flow 为 xtls-rprx-vision 时上下行都经过 Vision 处理。
+func relayVless(ctx context.Context, clientConn net.Conn, clientReader io.Reader, remoteConn net.Conn, header []byte, profile *types.ServerProfile) {
+	l := log.Ctx(ctx)
+
+	var uplink io.Writer = remoteConn
+	var downlink io.Reader = remoteConn
+	closeUplink := func() { CloseWriter(remoteConn) }
+	if profile.Flow == FlowVision {
+		userUUID, err := uuid.Parse(profile.UUID)
+		if err != nil {
+			l.Error().Err(err).Msg("VLESS: invalid uuid for vision flow")
+			return
+		}
+		writer, reader, err := newVision(remoteConn, userUUID[:], header)
+		if err != nil {
+			l.Error().Err(err).Msg("VLESS: failed to set up vision flow")
+			return
+		}
+		uplink, downlink = writer, reader
+		closeUplink = func() { writer.CloseWrite() }
+		header = nil // 由 visionWriter 与第一个帧一起发送
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	// UPLINK: Client -> Remote，请求头尽量与第一个数据块合并发送
+	go func() {
+		defer wg.Done()
+		defer closeUplink()
+
+		first := make([]byte, visionBufferSize)
+		clientConn.SetReadDeadline(time.Now().Add(firstPayloadTimeout))
+		n, readErr := clientReader.Read(first)
+		clientConn.SetReadDeadline(time.Time{})
+		if readErr != nil {
+			var netErr net.Error
+			if errors.As(readErr, &netErr) && netErr.Timeout() {
+				readErr = nil
+			}
+		}
+
+		if _, err := uplink.Write(append(header, first[:n]...)); err != nil {
+			l.Error().Err(err).Msg("VLESS: [UPLINK] Failed to write combined initial payload.")
+			return
+		}
+		if readErr != nil {
+			return
+		}
+		_, _ = io.CopyBuffer(uplink, clientReader, make([]byte, visionBufferSize))
+	}()
+
+	// DOWNLINK: Remote -> Client
+	go func() {
+		defer wg.Done()
+		if err := DecodeResponseHeader(remoteConn); err != nil {
+			l.Error().Err(err).Msg("VLESS: [DOWNLINK] Failed to decode VLESS response header.")
+			CloseWriter(clientConn)
+			return
+		}
+		_, _ = io.CopyBuffer(clientConn, downlink, make([]byte, visionBufferSize))
+		CloseWriter(clientConn)
+	}()
+
+	wg.Wait()
+}
+
+// DialVlessTCP 是 raw TCP 传输的专用拨号器，根据 Security 在 TCP 之上建立 reality、tls 或明文连接。
+// 底层 TCP 经 internet.DialSystem 建立，因此会使用 ctx 中注入的拨号器 (via 链)。
+func DialVlessTCP(ctx context.Context, profile *types.ServerProfile) (net.Conn, error) {
+	dest := xraynet.TCPDestination(xraynet.ParseAddress(profile.Address), xraynet.Port(profile.Port))
+
+	var realityConfig *reality.Config
+	if profile.Security == "reality" {
+		var err error
+		if realityConfig, err = buildRealityConfig(profile); err != nil {
+			return nil, err
+		}
+	}
+
+	rawConn, err := internet.DialSystem(ctx, dest, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	switch profile.Security {
+	case "reality":
+		// 与 xray 一致：认证失败时 UClient 会在后台继续模拟浏览器访问 SNI，因此不在这里关闭 rawConn
+		return reality.UClient(rawConn, realityConfig, ctx, dest)
+	case "tls":
+		conn, err := tlsClient(ctx, rawConn, profile, dest, nil)
+		if err != nil {
+			rawConn.Close()
+			return nil, err
+		}
+		return conn, nil
+	case "", "none":
+		return rawConn, nil
+	default:
+		rawConn.Close()
+		return nil, fmt.Errorf("unsupported security '%s' for vless tcp", profile.Security)
+	}
+}
+
+// buildRealityConfig 从 profile 构建 REALITY 客户端配置。
+// PublicKey 为 base64 (RawURL) 编码的 X25519 公钥，ShortID 为最多 16 个字符的十六进制串。
+func buildRealityConfig(profile *types.ServerProfile) (*reality.Config, error) {
+	publicKey, err := base64.RawURLEncoding.DecodeString(profile.PublicKey)
+	if err != nil || len(publicKey) != 32 {
+		return nil, fmt.Errorf("invalid reality public key '%s'", profile.PublicKey)
+	}
+	if len(profile.ShortID) > 16 {
+		return nil, fmt.Errorf("reality short id '%s' is longer than 16 hex characters", profile.ShortID)
+	}
+	shortID := make([]byte, 8)
+	if _, err := hex.Decode(shortID, []byte(profile.ShortID)); err != nil {
+		return nil, fmt.Errorf("invalid reality short id '%s': %w", profile.ShortID, err)
+	}
+
+	fingerprint := profile.Fingerprint
+	if fingerprint == "" {
+		fingerprint = defaultRealityFingerprint
+	}
+	if tls.GetFingerprint(fingerprint) == nil {
+		return nil, fmt.Errorf("unknown tls fingerprint '%s'", fingerprint)
+	}
+
+	return &reality.Config{
+		ServerName:  profile.SNI,
+		Fingerprint: fingerprint,
+		PublicKey:   publicKey,
+		ShortId:     shortID,
+		SpiderX:     "/",
+		SpiderY:     make([]int64, 10),
+	}, nil
+}
+
+// tlsClient 在 rawConn 上完成 TLS 握手 (经 tlsclient，支持指纹和 ECH)。
+// alpn 是传输层要求的 ALPN，优先于 profile.ALPN；两者都为空时使用默认的 h2, http/1.1。
+func tlsClient(ctx context.Context, rawConn net.Conn, profile *types.ServerProfile, dest xraynet.Destination, alpn []string) (net.Conn, error) {
+	config, err := tlsclient.FromProfile(profile, dest.Address.String(), "h2", "http/1.1")
+	if err != nil {
+		return nil, err
+	}
+	if len(alpn) > 0 {
+		config.ALPN = alpn
+	}
+	conn, err := tlsclient.Client(ctx, rawConn, config)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
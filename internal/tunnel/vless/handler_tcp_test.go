@@ -0,0 +1,284 @@
+package vless
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	gotls "crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	xreality "github.com/xtls/reality"
+
+	"liuproxy_nexus/internal/shared/testutil"
+	"liuproxy_nexus/internal/shared/types"
+)
+
+const (
+	testUUID        = "b831381d-6324-4d53-ad4f-8cda48b30811"
+	testRealitySNI  = "reality.test"
+	testRealityShID = "0123456789abcdef"
+)
+
+// newTestCertificate 生成一个 name 的自签名证书。
+func newTestCertificate(t *testing.T, name string) gotls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gotls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// startTLSDest 启动 REALITY 服务器借用握手的目标站点：一个普通的 TLS 1.3 服务器。
+func startTLSDest(t *testing.T) string {
+	t.Helper()
+	ln, err := gotls.Listen("tcp", "127.0.0.1:0", &gotls.Config{
+		Certificates: []gotls.Certificate{newTestCertificate(t, testRealitySNI)},
+		MinVersion:   gotls.VersionTLS13,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// startRealityServer 启动一个 REALITY 服务器，握手后的连接交给 serve 处理，返回端口和 base64 编码的公钥。
+func startRealityServer(t *testing.T, serve func(*testing.T, net.Conn)) (int, string) {
+	t.Helper()
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var shortID [8]byte
+	if _, err := hex.Decode(shortID[:], []byte(testRealityShID)); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &xreality.Config{
+		DialContext:            (&net.Dialer{}).DialContext,
+		Type:                   "tcp",
+		Dest:                   startTLSDest(t),
+		ServerNames:            map[string]bool{testRealitySNI: true},
+		PrivateKey:             privateKey.Bytes(),
+		ShortIds:               map[[8]byte]bool{shortID: true},
+		SessionTicketsDisabled: true,
+	}
+	// 与 xray 服务端一致：预先探测目标站点握手后的记录长度，探测完成前 Server 会等待
+	xreality.DetectPostHandshakeRecordsLens(config)
+	for alpn := 0; alpn < 3; alpn++ {
+		key := config.Dest + " " + testRealitySNI + " " + strconv.Itoa(alpn)
+		for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+			if val, _ := xreality.GlobalPostHandshakeRecordsLens.Load(key); val != nil {
+				if _, done := val.([]int); done {
+					break
+				}
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("reality record length detection for %s did not finish", key)
+			}
+		}
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				realityConn, err := xreality.Server(context.Background(), conn, config)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				serve(t, realityConn)
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port, base64.RawURLEncoding.EncodeToString(privateKey.PublicKey().Bytes())
+}
+
+// readVlessRequest 读取并校验 VLESS 请求头，返回命令、addons 和目标地址 (mux 命令没有目标地址)。
+// reader 可以直接是 TLS 连接：只读取请求头本身，后续数据留在连接中。
+func readVlessRequest(t *testing.T, reader io.Reader) (cmd byte, addons []byte, target string, ok bool) {
+	header := make([]byte, 1+16+1)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return 0, nil, "", false
+	}
+	if id := uuid.UUID(header[1:17]); id.String() != testUUID {
+		t.Errorf("vless server: unexpected uuid %s", id)
+		return 0, nil, "", false
+	}
+	addons = make([]byte, header[17])
+	if _, err := io.ReadFull(reader, addons); err != nil {
+		return 0, nil, "", false
+	}
+
+	var command [1]byte
+	if _, err := io.ReadFull(reader, command[:]); err != nil {
+		return 0, nil, "", false
+	}
+	if command[0] == RequestCommandMux {
+		return command[0], addons, "", true
+	}
+	target, err := readAddressPort(reader)
+	if err != nil {
+		t.Errorf("vless server: %v", err)
+		return 0, nil, "", false
+	}
+	return command[0], addons, target, true
+}
+
+// serveVless 是测试用 VLESS 服务器的协议处理：校验 UUID，将 TCP 请求转发到目标。
+func serveVless(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	cmd, _, targetAddr, ok := readVlessRequest(t, reader)
+	if !ok {
+		return
+	}
+	if cmd != RequestCommandTCP {
+		t.Errorf("vless server: unexpected command %d", cmd)
+		return
+	}
+	target, err := net.Dial("tcp", targetAddr)
+	if err != nil {
+		return
+	}
+	defer target.Close()
+	if _, err := conn.Write([]byte{Version, 0}); err != nil {
+		return
+	}
+	go func() {
+		io.Copy(target, reader)
+		CloseWriter(target)
+	}()
+	io.Copy(conn, target)
+}
+
+func newRealityProfile(port int, publicKey string) *types.ServerProfile {
+	return &types.ServerProfile{
+		ID: "vless-reality", Remarks: "vless-reality", Type: "vless", Active: true,
+		Address: "127.0.0.1", Port: port, UUID: testUUID,
+		Network: "tcp", Security: "reality", SNI: testRealitySNI,
+		Fingerprint: "chrome", PublicKey: publicKey, ShortID: testRealityShID,
+	}
+}
+
+func TestVlessRealityTCP(t *testing.T) {
+	port, publicKey := startRealityServer(t, serveVless)
+	echoAddr := testutil.StartEchoServer(t)
+
+	strategy, err := NewVlessStrategy(&types.Config{}, newRealityProfile(port, publicKey), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer strategy.CloseTunnel()
+
+	t.Run("socks", func(t *testing.T) {
+		conn := testutil.DialThroughStrategy(t, strategy, echoAddr)
+		defer conn.Close()
+		testutil.ExpectEcho(t, conn, "hello over reality")
+	})
+
+	t.Run("raw tcp", func(t *testing.T) {
+		client, inbound := net.Pipe()
+		defer client.Close()
+		go strategy.HandleRawTCP(inbound, echoAddr)
+		testutil.ExpectEcho(t, client, "hello over transparent reality")
+	})
+
+	t.Run("health", func(t *testing.T) {
+		if err := strategy.CheckHealth(); err != nil {
+			t.Fatalf("CheckHealth() error = %v", err)
+		}
+	})
+}
+
+func TestVlessRealityRejectsWrongPublicKey(t *testing.T) {
+	port, _ := startRealityServer(t, serveVless)
+	otherKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := newRealityProfile(port, base64.RawURLEncoding.EncodeToString(otherKey.PublicKey().Bytes()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if conn, err := DialVlessTCP(ctx, profile); err == nil {
+		conn.Close()
+		t.Fatal("expected the reality handshake to fail with a mismatched public key")
+	}
+}
+
+func TestBuildRealityConfig(t *testing.T) {
+	key := base64.RawURLEncoding.EncodeToString(make([]byte, 32))
+	tests := []struct {
+		name    string
+		profile types.ServerProfile
+		wantErr bool
+	}{
+		{name: "valid", profile: types.ServerProfile{PublicKey: key, ShortID: "ab12"}},
+		{name: "empty short id", profile: types.ServerProfile{PublicKey: key}},
+		{name: "bad public key", profile: types.ServerProfile{PublicKey: "not-a-key"}, wantErr: true},
+		{name: "short id too long", profile: types.ServerProfile{PublicKey: key, ShortID: "0123456789abcdef00"}, wantErr: true},
+		{name: "short id not hex", profile: types.ServerProfile{PublicKey: key, ShortID: "zz"}, wantErr: true},
+		{name: "unknown fingerprint", profile: types.ServerProfile{PublicKey: key, Fingerprint: "netscape"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := buildRealityConfig(&tt.profile)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildRealityConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && config.Fingerprint == "" {
+				t.Error("expected a default fingerprint")
+			}
+		})
+	}
+}
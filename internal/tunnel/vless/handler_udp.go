@@ -0,0 +1,90 @@
+package vless
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog/log"
+
+	"liuproxy_nexus/internal/shared/types"
+)
+
+// HandleUDPAssociate 处理 SOCKS5 UDP ASSOCIATE：在本地开一个 UDP 中继端口，
+// 客户端发来的数据报经 VLESS UDP 隧道转发，TCP 控制连接断开时结束。
+func HandleUDPAssociate(
+	ctx context.Context,
+	clientConn net.Conn,
+	reader *bufio.Reader,
+	profile *types.ServerProfile,
+	stateManager types.StateManager,
+) {
+	defer clientConn.Close()
+	l := log.Ctx(ctx)
+
+	// 中继端口与 SOCKS 监听器在同一地址上；内存管道 (网关模式) 时使用回环地址
+	listenIP := net.IPv4(127, 0, 0, 1)
+	if tcpAddr, ok := clientConn.LocalAddr().(*net.TCPAddr); ok && !tcpAddr.IP.IsUnspecified() {
+		listenIP = tcpAddr.IP
+	}
+	relayConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: listenIP})
+	if err != nil {
+		l.Error().Err(err).Msg("VLESS-UDP: Failed to create local UDP relay.")
+		_, _ = clientConn.Write([]byte{0x05, 0x01, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer relayConn.Close()
+
+	if err := writeSocks5Success(clientConn, relayConn.LocalAddr().(*net.UDPAddr)); err != nil {
+		return
+	}
+	l.Debug().Str("relay_addr", relayConn.LocalAddr().String()).Msg("VLESS-UDP: UDP ASSOCIATE relay started.")
+
+	// 回复发往最近一次发送数据报的客户端地址
+	var clientAddr atomic.Pointer[net.UDPAddr]
+	var wg sync.WaitGroup
+	relay := newUDPRelay(ctx, profile, stateManager, &wg, func(payload []byte, from string) {
+		addr := clientAddr.Load()
+		if addr == nil {
+			return
+		}
+		datagram, err := appendSocks5UDPHeader(make([]byte, 0, 22+len(payload)), from)
+		if err != nil {
+			return
+		}
+		if _, err := relayConn.WriteToUDP(append(datagram, payload...), addr); err != nil {
+			l.Debug().Err(err).Msg("VLESS-UDP: Failed to write back to client.")
+		}
+	})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		buf := make([]byte, maxUDPPayload)
+		for {
+			n, addr, err := relayConn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			target, payload, err := parseSocks5UDPDatagram(buf[:n])
+			if err != nil {
+				l.Debug().Err(err).Msg("VLESS-UDP: Dropping invalid SOCKS5 UDP datagram.")
+				continue
+			}
+			clientAddr.Store(addr)
+			if err := relay.send(payload, target); err != nil {
+				l.Warn().Err(err).Str("target", target).Msg("VLESS-UDP: Failed to forward datagram.")
+			}
+		}
+	}()
+
+	// 阻塞直到 TCP 控制连接断开
+	_, _ = io.Copy(io.Discard, reader)
+	relayConn.Close()
+	relay.close()
+	wg.Wait()
+	l.Debug().Msg("VLESS-UDP: TCP control connection closed, UDP relay stopped.")
+}
@@ -10,7 +10,9 @@ import (
 	"net"
 	"strconv"
 	"sync"
+	"time"
 
+	"liuproxy_nexus/internal/shared"
 	"liuproxy_nexus/internal/shared/types"
 	xraynet "liuproxy_nexus/internal/xray_core/common/net"
 	"liuproxy_nexus/internal/xray_core/common/serial"
@@ -20,35 +22,24 @@ import (
 )
 
 // HandleWSConnection 封装了处理 VLESS+WS 连接的所有逻辑。
-// 它为每一个客户端请求创建一个新的到远程服务器的连接。
+// 它为每一个 CONNECT 请求 (SOCKS5 握手已由 HandleConnection 完成) 创建一个新的到远程服务器的连接。
 func HandleWSConnection(
 	ctx context.Context,
 	clientConn net.Conn,
 	reader *bufio.Reader,
+	targetAddr string,
 	profile *types.ServerProfile,
 	stateManager types.StateManager,
 ) {
 	defer clientConn.Close()
 
 	l := log.Ctx(ctx)
-	//l.Debug().Msg("VLESS-NATIVE-WS: New connection received, starting SOCKS5 handshake...")
-
-	cmd, targetAddr, err := HandshakeSocks5AndGetResponse(clientConn, reader)
-	if err != nil {
-		//l.Debug().Err(err).Msg("VLESS-NATIVE-WS: SOCKS5 handshake failed")
-		return
-	}
-	if cmd != 1 {
-		//l.Debug().Int("command", int(cmd)).Msg("VLESS-NATIVE-WS: Received non-CONNECT command, closing.")
-		return
-	}
-	//l.Debug().Str("target", targetAddr).Msg("VLESS-NATIVE-WS: SOCKS5 handshake successful.")
 
 	host, portStr, _ := net.SplitHostPort(targetAddr)
 	port, _ := strconv.Atoi(portStr)
 
 	// 为本次请求建立一个全新的远程连接
-	remoteConn, err := DialVlessWS(context.Background(), profile)
+	remoteConn, err := DialVlessWS(ctx, profile)
 	if err != nil {
 		l.Error().Err(err).Str("remote", profile.Address).Msg("VLESS-NATIVE-WS: failed to dial remote")
 		// 【新增】调用 StateManager 将服务器状态设置为 Down
@@ -63,7 +54,7 @@ func HandleWSConnection(
 
 	headerBuf := new(bytes.Buffer)
 	// Temporarily use xraynet types for header encoding
-	if err := EncodeRequestHeader(headerBuf, RequestCommandTCP, host, port, profile.UUID); err != nil {
+	if err := EncodeRequestHeader(headerBuf, RequestCommandTCP, host, port, profile.UUID, ""); err != nil {
 		l.Error().Err(err).Msg("VLESS-NATIVE-WS: failed to encode request header")
 		return
 	}
@@ -112,19 +103,38 @@ func HandleWSConnection(
 	//l.Debug().Msg("VLESS-NATIVE-WS: Connection handling finished.")
 }
 
-// dialVlessWS 是 WebSocket 传输的专用拨号器。
+// DialVlessWS 是 WebSocket 传输的专用拨号器。
+// 路径带有 ed 参数 (如 "/?ed=2560") 时使用早期数据: VLESS 请求头和第一段数据随升级请求发出，
+// 返回的连接在第一次写入时才拨号。
 func DialVlessWS(ctx context.Context, profile *types.ServerProfile) (net.Conn, error) {
+	path, maxEarlyData := shared.ParseEarlyData(profile.Path)
+	dial := func(earlyData []byte) (net.Conn, error) {
+		return dialVlessWS(ctx, profile, path, earlyData)
+	}
+	if maxEarlyData > 0 {
+		delay := time.Duration(profile.EarlyDataDelay) * time.Millisecond
+		return shared.NewEarlyDataConn(maxEarlyData, delay, dial), nil
+	}
+	return dial(nil)
+}
+
+// dialVlessWS 建立 WebSocket 连接，earlyData 非空时放入升级请求。
+func dialVlessWS(ctx context.Context, profile *types.ServerProfile, path string, earlyData []byte) (net.Conn, error) {
 	// Use xraynet types as internet.Dial still requires them.
 	dest := xraynet.TCPDestination(xraynet.ParseAddress(profile.Address), xraynet.Port(profile.Port))
 
+	header := []*websocket.Header{{Key: "Host", Value: profile.Host}}
+	if len(earlyData) > 0 {
+		header = append(header, &websocket.Header{Key: shared.EarlyDataHeader, Value: shared.EncodeEarlyData(earlyData)})
+	}
 	streamSettings := &internet.StreamConfig{
 		ProtocolName: "websocket",
 		TransportSettings: []*internet.TransportConfig{
 			{
 				ProtocolName: "websocket",
 				Settings: serial.ToTypedMessage(&websocket.Config{
-					Path:   profile.Path,
-					Header: []*websocket.Header{{Key: "Host", Value: profile.Host}},
+					Path:   path,
+					Header: header,
 				}),
 			},
 		},
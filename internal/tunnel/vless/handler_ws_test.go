@@ -0,0 +1,119 @@
+package vless
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"liuproxy_nexus/internal/shared"
+	"liuproxy_nexus/internal/shared/testutil"
+	"liuproxy_nexus/internal/shared/types"
+)
+
+// startEarlyDataWSServer 启动接受早期数据的 WebSocket VLESS 服务器，返回端口和带有早期数据的升级请求计数。
+// 路径中不应再出现 ed 参数。
+func startEarlyDataWSServer(t *testing.T, serve func(t *testing.T, conn net.Conn)) (int, *atomic.Int32) {
+	t.Helper()
+	earlyRequests := new(atomic.Int32)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != testHTTPPath || r.URL.Query().Has("ed") {
+			http.Error(w, "bad path", http.StatusNotFound)
+			return
+		}
+		earlyData, responseHeader := shared.AcceptEarlyData(r)
+		ws, err := upgrader.Upgrade(w, r, responseHeader)
+		if err != nil {
+			return
+		}
+		if len(earlyData) > 0 {
+			earlyRequests.Add(1)
+		}
+		serve(t, shared.NewPrefixedConn(shared.NewWebSocketConnAdapter(ws), earlyData))
+	}))
+	t.Cleanup(server.Close)
+	return server.Listener.Addr().(*net.TCPAddr).Port, earlyRequests
+}
+
+func TestVlessWSEarlyData(t *testing.T) {
+	echoAddr := testutil.StartEchoServer(t)
+	port, earlyRequests := startEarlyDataWSServer(t, serveVless)
+
+	profile := &types.ServerProfile{
+		ID: "vless-ws", Remarks: "vless-ws", Type: "vless", Active: true,
+		Address: "127.0.0.1", Port: port, UUID: testUUID,
+		Network: "ws", Path: testHTTPPath + "?ed=2560", Host: "cdn.example.com",
+	}
+	strategy, err := NewVlessStrategy(&types.Config{}, profile, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer strategy.CloseTunnel()
+
+	t.Run("socks", func(t *testing.T) {
+		conn := testutil.DialThroughStrategy(t, strategy, echoAddr)
+		defer conn.Close()
+		testutil.ExpectEcho(t, conn, "hello over ws")
+		// 超出 ed 的部分在连接建立后正常发送
+		testutil.ExpectEcho(t, conn, strings.Repeat("large payload ", 20000))
+	})
+
+	t.Run("raw tcp", func(t *testing.T) {
+		client, inbound := net.Pipe()
+		defer client.Close()
+		go strategy.HandleRawTCP(inbound, echoAddr)
+		testutil.ExpectEcho(t, client, "hello over transparent ws")
+	})
+
+	t.Run("health", func(t *testing.T) {
+		if err := strategy.CheckHealth(); err != nil {
+			t.Fatalf("CheckHealth() error = %v", err)
+		}
+	})
+
+	if n := earlyRequests.Load(); n != 2 {
+		t.Errorf("server saw %d upgrade requests with early data, want 2", n)
+	}
+}
+
+// TestVlessWSEarlyDataUDP 检查 UDP 隧道的请求头和第一个数据报随升级请求发出。
+// 早期数据的拨号发生在 getOrDial 返回之后，不能使用已经取消的 ctx。
+func TestVlessWSEarlyDataUDP(t *testing.T) {
+	echo := testutil.StartUDPEchoServer(t)
+	port, earlyRequests := startEarlyDataWSServer(t, serveVlessUDP)
+	clientAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}
+
+	for _, packetEncoding := range []string{"", PacketEncodingXUDP} {
+		t.Run("encoding="+packetEncoding, func(t *testing.T) {
+			profile := &types.ServerProfile{
+				ID: "vless-ws-udp", Remarks: "vless-ws-udp", Type: "vless", Active: true,
+				Address: "127.0.0.1", Port: port, UUID: testUUID, PacketEncoding: packetEncoding,
+				Network: "ws", Path: testHTTPPath + "?ed=2560", Host: "cdn.example.com",
+			}
+			strategy, err := NewVlessStrategy(&types.Config{}, profile, testutil.NopStateManager{}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer strategy.CloseTunnel()
+
+			before := earlyRequests.Load()
+			payload := []byte("hello ws udp " + packetEncoding)
+			reply := testutil.NewUDPReply()
+			packet := &types.UDPPacket{Source: clientAddr, Destination: echo, Payload: payload, Reply: reply.Reply}
+			if err := strategy.HandleUDPPacket(packet, clientAddr.String()); err != nil {
+				t.Fatalf("HandleUDPPacket() error = %v", err)
+			}
+			if d := reply.Next(t); string(d.Payload) != string(payload) {
+				t.Errorf("UDP reply = %q, want %q", d.Payload, payload)
+			}
+			if got := earlyRequests.Load() - before; got != 1 {
+				t.Errorf("server saw %d upgrade requests with early data, want 1", got)
+			}
+		})
+	}
+}
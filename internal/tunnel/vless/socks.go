@@ -9,10 +9,9 @@ import (
 	"strconv"
 )
 
-// HandshakeSocks5AndGetResponse performs a SOCKS5 handshake for a client connection.
-// It reads the handshake and request, sends back appropriate responses,
-// and returns the command and target address requested by the client.
-func HandshakeSocks5AndGetResponse(conn net.Conn, reader *bufio.Reader) (byte, string, error) {
+// ReadSocks5Request performs the SOCKS5 auth phase and reads the request,
+// but leaves the reply to the caller (UDP ASSOCIATE needs to report its relay address).
+func ReadSocks5Request(conn net.Conn, reader *bufio.Reader) (byte, string, error) {
 	// 1. Auth Phase
 	authHeader := make([]byte, 2)
 	if _, err := io.ReadFull(reader, authHeader); err != nil {
@@ -68,12 +67,83 @@ func HandshakeSocks5AndGetResponse(conn net.Conn, reader *bufio.Reader) (byte, s
 	}
 	port := binary.BigEndian.Uint16(portBuf)
 
-	// 3. Send Response
-	if _, err := conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0}); err != nil {
-		return 0, "", err
+	return cmd, net.JoinHostPort(host, strconv.Itoa(int(port))), nil
+}
+
+// parseSocks5UDPDatagram parses a SOCKS5 UDP request (RSV, FRAG, ATYP, DST.ADDR, DST.PORT, DATA).
+// Fragmented datagrams are not supported.
+func parseSocks5UDPDatagram(b []byte) (string, []byte, error) {
+	if len(b) < 4 {
+		return "", nil, io.ErrShortBuffer
 	}
+	if b[2] != 0 {
+		return "", nil, fmt.Errorf("fragmented socks5 udp datagram is not supported")
+	}
+	var host string
+	offset := 4
+	switch b[3] {
+	case 0x01: // IPv4
+		if len(b) < offset+net.IPv4len+2 {
+			return "", nil, io.ErrShortBuffer
+		}
+		host = net.IP(b[offset : offset+net.IPv4len]).String()
+		offset += net.IPv4len
+	case 0x03: // Domain
+		if len(b) < offset+1 || len(b) < offset+1+int(b[offset])+2 {
+			return "", nil, io.ErrShortBuffer
+		}
+		host = string(b[offset+1 : offset+1+int(b[offset])])
+		offset += 1 + int(b[offset])
+	case 0x04: // IPv6
+		if len(b) < offset+net.IPv6len+2 {
+			return "", nil, io.ErrShortBuffer
+		}
+		host = net.IP(b[offset : offset+net.IPv6len]).String()
+		offset += net.IPv6len
+	default:
+		return "", nil, fmt.Errorf("unsupported address type: %d", b[3])
+	}
+	port := binary.BigEndian.Uint16(b[offset : offset+2])
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), b[offset+2:], nil
+}
 
-	return cmd, net.JoinHostPort(host, strconv.Itoa(int(port))), nil
+// appendSocks5UDPHeader appends the SOCKS5 UDP reply header for a datagram coming from addr (host:port).
+func appendSocks5UDPHeader(b []byte, addr string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, _ := strconv.Atoi(portStr)
+	b = append(b, 0, 0, 0)
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			b = append(append(b, 0x01), ip4...)
+		} else {
+			b = append(append(b, 0x04), ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("domain length > 255: %s", host)
+		}
+		b = append(append(b, 0x03, byte(len(host))), host...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(port)), nil
+}
+
+// writeSocks5Success sends a success reply. bindAddr may be nil, in which case 0.0.0.0:0 is reported.
+func writeSocks5Success(conn net.Conn, bindAddr *net.UDPAddr) error {
+	reply := []byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0}
+	if bindAddr != nil {
+		if ip4 := bindAddr.IP.To4(); ip4 != nil {
+			copy(reply[4:8], ip4)
+		} else {
+			reply = append([]byte{0x05, 0x00, 0x00, 0x04}, bindAddr.IP.To16()...)
+			reply = append(reply, 0, 0)
+		}
+		binary.BigEndian.PutUint16(reply[len(reply)-2:], uint16(bindAddr.Port))
+	}
+	_, err := conn.Write(reply)
+	return err
 }
 
 // CloseWriter safely closes a connection's write side.
@@ -2,22 +2,20 @@ package vless
 
 import (
 	"bufio"
-	"bytes"
 	"context"
 	"fmt"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"golang.org/x/net/proxy"
-	"io"
+	"liuproxy_nexus/internal/shared"
 	"liuproxy_nexus/internal/shared/logger"
+	"liuproxy_nexus/internal/shared/outbound"
 	"net"
-	"net/http"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"liuproxy_nexus/internal/shared/types"
+	"liuproxy_nexus/internal/xray_core/transport/internet"
 )
 
 // VlessStrategyNative 现在是一个无状态的监听器和分发器。
@@ -34,26 +32,65 @@ type VlessStrategyNative struct {
 	uplinkBytes       atomic.Uint64
 	downlinkBytes     atomic.Uint64
 	stateManager      types.StateManager
+	dialer            types.Dialer // 非 nil 时经由前置策略 (via) 建立底层连接
+
+	// --- 透明代理 UDP 会话管理 ---
+	udpSessions sync.Map // key: sessionKey, value: *udpRelay
+	done        chan struct{}
 }
 
 // NewVlessStrategyNative 创建一个新的 VLESS 原生策略实例。
-func NewVlessStrategy(cfg *types.Config, profile *types.ServerProfile, stateManager types.StateManager) (types.TunnelStrategy, error) {
+func NewVlessStrategy(cfg *types.Config, profile *types.ServerProfile, stateManager types.StateManager, dialer types.Dialer) (types.TunnelStrategy, error) {
 	logger.Info().Str("implementation", "native").Msg("Creating VLESS strategy")
 
 	// 检查 profile 是否有效
 	if profile == nil {
 		return nil, fmt.Errorf("vless strategy requires a non-nil profile")
 	}
+	if err := validateFlow(profile); err != nil {
+		return nil, err
+	}
 
-	return &VlessStrategyNative{
+	s := &VlessStrategyNative{
 		config:       cfg,
 		profile:      profile,
 		stateManager: stateManager,
+		dialer:       dialer,
+		done:         make(chan struct{}),
 		logger: log.With().
 			Str("strategy_type", "vless-native").
 			Str("server_id", profile.ID).
 			Str("remarks", profile.Remarks).Logger(),
-	}, nil
+	}
+	s.waitGroup.Add(1)
+	go s.cleanupLoop()
+	return s, nil
+}
+
+// validateFlow 检查 flow 与传输层的组合：Vision 需要 raw TCP 上的 tls 或 reality。
+func validateFlow(profile *types.ServerProfile) error {
+	switch profile.Flow {
+	case "":
+		return nil
+	case FlowVision:
+		if profile.Network != "tcp" {
+			return fmt.Errorf("vless flow '%s' requires network 'tcp', got '%s'", profile.Flow, profile.Network)
+		}
+		if profile.Security != "tls" && profile.Security != "reality" {
+			return fmt.Errorf("vless flow '%s' requires security 'tls' or 'reality', got '%s'", profile.Flow, profile.Security)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported vless flow '%s'", profile.Flow)
+	}
+}
+
+// withDialer 让 ctx 下由 xray 传输层发起的连接使用策略的拨号器。
+func (s *VlessStrategyNative) withDialer(ctx context.Context) context.Context {
+	if s.dialer == nil {
+		return ctx
+	}
+	return internet.ContextWithDialer(ctx, s.dialer.DialContext)
 }
 
 // InitializeForGateway 在网关模式下被调用。对于vless这种无状态策略，这是一个空操作。
@@ -84,7 +121,7 @@ func (s *VlessStrategyNative) handleClientConnection(clientConn net.Conn) {
 	defer s.activeConnections.Add(-1)
 
 	// 将子 logger 注入到 vless 包的处理函数中
-	ctx := s.logger.WithContext(context.Background())
+	ctx := s.withDialer(s.logger.WithContext(context.Background()))
 	HandleConnection(ctx, clientConn, bufio.NewReader(clientConn), s.profile, s.stateManager)
 }
 
@@ -102,6 +139,8 @@ func (s *VlessStrategyNative) CloseTunnel() {
 		if s.listener != nil {
 			s.listener.Close()
 		}
+		close(s.done)
+		outbound.CloseDialer(s.dialer)
 		// 在等待 WaitGroup 之前，强制关闭所有活动的连接
 		s.activeConns.Range(func(key, value interface{}) bool {
 			if conn, ok := key.(net.Conn); ok {
@@ -109,6 +148,11 @@ func (s *VlessStrategyNative) CloseTunnel() {
 			}
 			return true
 		})
+		s.udpSessions.Range(func(key, value interface{}) bool {
+			value.(*udpRelay).close()
+			s.udpSessions.Delete(key)
+			return true
+		})
 		s.waitGroup.Wait()
 	})
 }
@@ -125,6 +169,9 @@ func (s *VlessStrategyNative) GetMetrics() *types.Metrics {
 }
 
 func (s *VlessStrategyNative) UpdateServer(profile *types.ServerProfile) error {
+	if err := validateFlow(profile); err != nil {
+		return err
+	}
 	s.profile = profile
 	s.logger = log.With().
 		Str("strategy_type", "vless-native").
@@ -136,89 +183,23 @@ func (s *VlessStrategyNative) UpdateServer(profile *types.ServerProfile) error {
 
 // CheckHealth (旧接口实现): 轻量级TCP拨号，用于移动端。
 func (s *VlessStrategyNative) CheckHealth() error {
-	var conn net.Conn
-	var err error
-	network := s.profile.Network
-	if network == "" {
-		network = "ws"
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	ctx, cancel := context.WithTimeout(s.withDialer(context.Background()), time.Second*5)
 	defer cancel()
-	switch network {
-	case "grpc":
-		conn, err = DialVlessGRPC(ctx, s.profile)
-	case "ws":
-		conn, err = DialVlessWS(ctx, s.profile)
-	default:
-		err = fmt.Errorf("unsupported network type for health check: %s", network)
-	}
+	conn, err := DialVless(ctx, s.profile)
 	if err != nil {
 		return err
 	}
-	conn.Close()
+	defer conn.Close()
+	// 使用早期数据时拨号推迟到第一次写入，这里立即完成握手
+	if ec, ok := conn.(*shared.EarlyDataConn); ok {
+		return ec.Handshake()
+	}
 	return nil
 }
 
-// pipeDialer 实现了 proxy.Dialer 接口，但它的 Dial 方法总是返回预设的 net.Conn
-type pipeDialer struct {
-	conn net.Conn
-}
-
-func (d *pipeDialer) Dial(network, addr string) (net.Conn, error) {
-	return d.conn, nil
-}
-
+// CheckHealthAdvanced 通过隧道请求 Cloudflare trace，返回延迟和出口 IP。
 func (s *VlessStrategyNative) CheckHealthAdvanced() (latency int64, exitIP string, err error) {
-	pipeConn, err := s.GetSocksConnection()
-	if err != nil {
-		return -1, "", fmt.Errorf("vless CheckHealth: failed to get pipe connection: %w", err)
-	}
-	defer pipeConn.Close()
-
-	dialer, err := proxy.SOCKS5("tcp", "placeholder-unused:1080", nil, &pipeDialer{conn: pipeConn})
-	if err != nil {
-		pipeConn.Close()
-		return -1, "", fmt.Errorf("vless CheckHealth: failed to create SOCKS5 dialer: %w", err)
-	}
-
-	transport := &http.Transport{
-		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-			return dialer.Dial(network, addr)
-		},
-	}
-	client := &http.Client{
-		Transport: transport,
-		Timeout:   10 * time.Second,
-	}
-
-	const healthCheckURL = "https://www.cloudflare.com/cdn-cgi/trace"
-	start := time.Now()
-	resp, err := client.Get(healthCheckURL)
-	if err != nil {
-		return -1, "", fmt.Errorf("vless CheckHealth: http get failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	latencyMs := time.Since(start).Milliseconds()
-
-	if resp.StatusCode != http.StatusOK {
-		return latencyMs, "", fmt.Errorf("vless CheckHealth: unexpected status code: %d", resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return latencyMs, "", fmt.Errorf("vless CheckHealth: failed to read response body: %w", err)
-	}
-
-	scanner := bufio.NewScanner(bytes.NewReader(body))
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "ip=") {
-			exitIP = strings.TrimPrefix(line, "ip=")
-			break
-		}
-	}
-	return latencyMs, exitIP, nil
+	return shared.CheckTraceHealth("vless", shared.TraceURL, s.GetSocksConnection)
 }
 
 func (s *VlessStrategyNative) GetTrafficStats() types.TrafficStats {
@@ -3,15 +3,13 @@ package vless
 import (
 	"bytes"
 	"context"
-	"io"
+	"fmt"
 	"liuproxy_nexus/internal/shared"
 	"liuproxy_nexus/internal/shared/types"
 	"net"
+	"net/netip"
 	"strconv"
-	"sync"
 	"time"
-
-	"github.com/rs/zerolog/log"
 )
 
 // HandleRawTCP 是处理透明代理 TCP 流量的入口。
@@ -32,25 +30,10 @@ func (s *VlessStrategyNative) HandleRawTCP(inboundConn net.Conn, targetDest stri
 	// 用 CountedConn 包装 inboundConn
 	countedInbound := shared.NewCountedConn(inboundConn, &s.uplinkBytes, &s.downlinkBytes)
 
-	// 1. Dial a new remote connection (gRPC or WS)
-	var remoteConn net.Conn
-	network := s.profile.Network
-	if network == "" {
-		network = "ws" // Default to ws
-	}
-	dialCtx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	// 1. Dial a new remote connection (gRPC, WS or TCP)
+	dialCtx, cancel := context.WithTimeout(s.withDialer(context.Background()), time.Second*10)
 	defer cancel()
-
-	switch network {
-	case "grpc":
-		remoteConn, err = DialVlessGRPC(dialCtx, s.profile)
-	case "ws":
-		remoteConn, err = DialVlessWS(dialCtx, s.profile)
-	default:
-		l.Error().Str("network", network).Msg("VLESS-RAW: Unsupported network type")
-		return
-	}
-
+	remoteConn, err := DialVless(dialCtx, s.profile)
 	if err != nil {
 		l.Error().Err(err).Msg("VLESS-RAW: Failed to dial remote")
 		if s.stateManager != nil {
@@ -62,43 +45,82 @@ func (s *VlessStrategyNative) HandleRawTCP(inboundConn net.Conn, targetDest stri
 
 	// 2. Encode VLESS header with targetDest
 	headerBuf := new(bytes.Buffer)
-	if err := EncodeRequestHeader(headerBuf, RequestCommandTCP, host, port, s.profile.UUID); err != nil {
+	if err := EncodeRequestHeader(headerBuf, RequestCommandTCP, host, port, s.profile.UUID, s.profile.Flow); err != nil {
 		l.Error().Err(err).Msg("VLESS-RAW: Failed to encode request header")
 		return
 	}
 
 	// 3. Pipe data between inboundConn and remoteConn
-	var wg sync.WaitGroup
-	wg.Add(2)
+	relayVless(ctx, countedInbound, countedInbound, remoteConn, headerBuf.Bytes(), s.profile)
+}
 
-	// Uplink
-	go func() {
-		defer wg.Done()
-		// Create a reader that first reads the VLESS header, then the rest of the inbound connection.
-		reader := io.MultiReader(headerBuf, countedInbound) // 使用 countedInbound
-		bytesCopied, copyErr := io.Copy(remoteConn, reader)
-		log.Ctx(ctx).Debug().Int64("bytes", bytesCopied).Err(copyErr).Msg("VLESS-RAW: [UPLINK] Piping finished.")
-		CloseWriter(remoteConn)
-	}()
+// udpSessionTimeout 透明代理 UDP 会话的空闲超时。
+const udpSessionTimeout = 60 * time.Second
 
-	// Downlink
-	go func() {
-		defer wg.Done()
-		if err := DecodeResponseHeader(remoteConn); err != nil {
-			log.Ctx(ctx).Error().Err(err).Msg("VLESS-RAW: [DOWNLINK] Failed to decode VLESS response header.")
-			CloseWriter(countedInbound)
-			return
+// HandleUDPPacket 是处理透明代理 UDP 流量的入口：按 sessionKey 复用 VLESS UDP 隧道，回复经 packet.Reply 写回客户端。
+func (s *VlessStrategyNative) HandleUDPPacket(packet *types.UDPPacket, sessionKey string) error {
+	if packet.Reply == nil {
+		return fmt.Errorf("UDP packet from %s has no reply path", packet.Source)
+	}
+	relay := s.getOrCreateUDPRelay(sessionKey, packet.Source, packet.Reply)
+	if err := relay.send(packet.Payload, packet.Destination.String()); err != nil {
+		s.logger.Warn().Err(err).Str("client_ip", sessionKey).Msg("[VLESS-UDP] Failed to forward packet.")
+		return err
+	}
+	s.uplinkBytes.Add(uint64(len(packet.Payload)))
+	return nil
+}
+
+func (s *VlessStrategyNative) getOrCreateUDPRelay(sessionKey string, clientAddr net.Addr, reply types.UDPReplyFunc) *udpRelay {
+	if v, ok := s.udpSessions.Load(sessionKey); ok {
+		relay := v.(*udpRelay)
+		relay.expiry.Store(time.Now().Add(udpSessionTimeout).UnixNano())
+		return relay
+	}
+
+	s.logger.Debug().Str("client_ip", sessionKey).Msg("[VLESS-UDP] Creating new session.")
+	ctx := s.withDialer(s.logger.WithContext(context.Background()))
+	relay := newUDPRelay(ctx, s.profile, s.stateManager, &s.waitGroup, func(payload []byte, from string) {
+		s.downlinkBytes.Add(uint64(len(payload)))
+		if err := reply(payload, replySource(from)); err != nil {
+			s.logger.Warn().Err(err).Str("client_ip", clientAddr.String()).Msg("[VLESS-UDP] Failed to write back to client.")
 		}
-		bytesCopied, copyErr := io.Copy(countedInbound, remoteConn)
-		log.Ctx(ctx).Debug().Int64("bytes", bytesCopied).Err(copyErr).Msg("VLESS-RAW: [DOWNLINK] Piping finished.")
-		CloseWriter(countedInbound)
-	}()
+	})
+	relay.expiry.Store(time.Now().Add(udpSessionTimeout).UnixNano())
+	if existing, loaded := s.udpSessions.LoadOrStore(sessionKey, relay); loaded {
+		return existing.(*udpRelay)
+	}
+	return relay
+}
 
-	wg.Wait()
+// replySource 把回复来源 host:port 转换为 UDP 地址，host 不是 IP 时返回 nil (入站使用原始目标)。
+func replySource(from string) net.Addr {
+	addrPort, err := netip.ParseAddrPort(from)
+	if err != nil {
+		return nil
+	}
+	return net.UDPAddrFromAddrPort(addrPort)
 }
 
-// HandleUDPPacket 是处理透明代理 UDP 流量的入口。
-func (s *VlessStrategyNative) HandleUDPPacket(packet *types.UDPPacket, sessionKey string) error {
-	s.logger.Warn().Str("session_key", sessionKey).Msg("VLESS strategy does not support UDP. Packet dropped.")
-	return nil // 返回 nil 表示我们已经“处理”了这个包（即丢弃）
+func (s *VlessStrategyNative) cleanupLoop() {
+	defer s.waitGroup.Done()
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			s.udpSessions.Range(func(key, value interface{}) bool {
+				relay := value.(*udpRelay)
+				if now.UnixNano() > relay.expiry.Load() {
+					s.logger.Debug().Str("client_ip", key.(string)).Msg("[VLESS-UDP] Cleaning up expired session.")
+					relay.close()
+					s.udpSessions.Delete(key)
+				}
+				return true
+			})
+		case <-s.done:
+			return
+		}
+	}
 }
@@ -0,0 +1,404 @@
+package vless
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"liuproxy_nexus/internal/shared/types"
+)
+
+// PacketEncodingXUDP 是 ServerProfile.PacketEncoding 中表示 XUDP 的取值。
+const PacketEncodingXUDP = "xudp"
+
+// maxUDPPayload 数据报长度字段只有 2 字节。
+const maxUDPPayload = 65535
+
+// udpDialTimeout 是建立 VLESS UDP 隧道的超时。
+const udpDialTimeout = 10 * time.Second
+
+// mux.cool 帧中的状态、选项和网络类型，XUDP 只使用其中 UDP 相关的部分。
+const (
+	muxStatusNew       byte = 0x01
+	muxStatusKeep      byte = 0x02
+	muxStatusEnd       byte = 0x03
+	muxStatusKeepAlive byte = 0x04
+
+	muxOptionData byte = 0x01
+
+	muxNetworkUDP byte = 0x02
+)
+
+// packetConn 是经由 VLESS 服务器收发 UDP 数据报的隧道。
+type packetConn interface {
+	// WritePacket 发送一个发往 target (host:port) 的数据报。
+	WritePacket(payload []byte, target string) error
+	// ReadPacket 读取一个数据报到 buf，返回长度和来源地址 (host:port)。
+	ReadPacket(buf []byte) (int, string, error)
+	Close() error
+}
+
+// packetStream 是 packetConn 共用的 VLESS 连接：请求头与第一个帧一起发送，读取第一个帧前先解析响应头。
+type packetStream struct {
+	conn        net.Conn
+	writer      io.Writer     // conn 或 Vision 发送端
+	reader      *bufio.Reader // conn 或 Vision 接收端，响应头之后的数据
+	header      []byte        // 尚未发送的请求头
+	cancel      context.CancelFunc
+	writeMu     sync.Mutex
+	gotResponse bool // 只在读取方 goroutine 中访问
+}
+
+func (p *packetStream) writeFrame(frame []byte) error {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	return p.writeLocked(frame)
+}
+
+// writeLocked 写入一个帧，调用方需持有 writeMu。
+func (p *packetStream) writeLocked(frame []byte) error {
+	if p.header != nil {
+		frame = append(p.header, frame...)
+		p.header = nil
+	}
+	_, err := p.writer.Write(frame)
+	return err
+}
+
+func (p *packetStream) readResponse() error {
+	if p.gotResponse {
+		return nil
+	}
+	// 响应头不经过 Vision 填充，直接从 conn 读取且不预读后面的数据
+	if err := DecodeResponseHeader(p.conn); err != nil {
+		return err
+	}
+	p.gotResponse = true
+	return nil
+}
+
+func (p *packetStream) Close() error {
+	p.cancel()
+	return p.conn.Close()
+}
+
+// lengthPacketConn 是命令 0x02 的 UDP 连接：只能发往请求头中的目标，每个数据报带 2 字节长度前缀。
+type lengthPacketConn struct {
+	*packetStream
+	target string
+}
+
+func (c *lengthPacketConn) WritePacket(payload []byte, target string) error {
+	if len(payload) > maxUDPPayload {
+		return fmt.Errorf("udp payload too large: %d", len(payload))
+	}
+	frame := make([]byte, 2, 2+len(payload))
+	binary.BigEndian.PutUint16(frame, uint16(len(payload)))
+	return c.writeFrame(append(frame, payload...))
+}
+
+func (c *lengthPacketConn) ReadPacket(buf []byte) (int, string, error) {
+	if err := c.readResponse(); err != nil {
+		return 0, "", err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(c.reader, length[:]); err != nil {
+		return 0, "", err
+	}
+	n := int(binary.BigEndian.Uint16(length[:]))
+	if n > len(buf) {
+		return 0, "", io.ErrShortBuffer
+	}
+	if _, err := io.ReadFull(c.reader, buf[:n]); err != nil {
+		return 0, "", err
+	}
+	return n, c.target, nil
+}
+
+// xudpPacketConn 把所有目标的数据报复用到一条 mux.cool 会话上 (XUDP)。
+// 第一帧为 New 并携带 GlobalID，之后的 Keep 帧逐包携带目标地址；服务器的 Keep 帧携带来源地址。
+type xudpPacketConn struct {
+	*packetStream
+	globalID [8]byte
+	started  bool // 由 writeMu 保护
+	first    string
+}
+
+func (c *xudpPacketConn) WritePacket(payload []byte, target string) error {
+	if len(payload) > maxUDPPayload {
+		return fmt.Errorf("udp payload too large: %d", len(payload))
+	}
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return err
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	// New 帧必须是第一个帧，整个构造和写入过程都持有锁
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	status := muxStatusKeep
+	if !c.started {
+		status = muxStatusNew
+	}
+
+	// metadata: 会话 ID (XUDP 固定为 0) + 状态 + 选项 + 网络 + 目标 [+ GlobalID]
+	meta := bytes.NewBuffer([]byte{0, 0, status, muxOptionData, muxNetworkUDP})
+	if err := writeAddressPort(meta, host, port); err != nil {
+		return err
+	}
+	if status == muxStatusNew {
+		meta.Write(c.globalID[:])
+	}
+
+	frame := make([]byte, 0, 2+meta.Len()+2+len(payload))
+	frame = binary.BigEndian.AppendUint16(frame, uint16(meta.Len()))
+	frame = append(frame, meta.Bytes()...)
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	if err := c.writeLocked(append(frame, payload...)); err != nil {
+		return err
+	}
+	c.started = true
+	return nil
+}
+
+func (c *xudpPacketConn) ReadPacket(buf []byte) (int, string, error) {
+	if err := c.readResponse(); err != nil {
+		return 0, "", err
+	}
+	for {
+		var length [2]byte
+		if _, err := io.ReadFull(c.reader, length[:]); err != nil {
+			return 0, "", err
+		}
+		meta := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(c.reader, meta); err != nil {
+			return 0, "", err
+		}
+		if len(meta) < 4 {
+			return 0, "", fmt.Errorf("xudp: short frame metadata")
+		}
+
+		from := c.first
+		switch meta[2] {
+		case muxStatusKeep:
+			if len(meta) > 5 && meta[4] == muxNetworkUDP {
+				addr, err := readAddressPort(bytes.NewReader(meta[5:]))
+				if err != nil {
+					return 0, "", fmt.Errorf("xudp: invalid source address: %w", err)
+				}
+				from = addr
+			}
+		case muxStatusKeepAlive:
+			from = ""
+		case muxStatusEnd:
+			return 0, "", io.EOF
+		default:
+			return 0, "", fmt.Errorf("xudp: unexpected frame status %d", meta[2])
+		}
+
+		if meta[3]&muxOptionData == 0 {
+			continue
+		}
+		if _, err := io.ReadFull(c.reader, length[:]); err != nil {
+			return 0, "", err
+		}
+		n := int(binary.BigEndian.Uint16(length[:]))
+		if n > len(buf) {
+			return 0, "", io.ErrShortBuffer
+		}
+		if _, err := io.ReadFull(c.reader, buf[:n]); err != nil {
+			return 0, "", err
+		}
+		if n == 0 || from == "" {
+			continue
+		}
+		return n, from, nil
+	}
+}
+
+// dialPacketConn 建立一条 VLESS UDP 隧道。PacketEncoding 为 xudp 时 target 只是第一个目标，之后可以发往任意地址。
+// 使用早期数据的 WebSocket 传输在第一次写入时才拨号，因此拨号使用的 ctx 随隧道一起保留到 Close。
+func dialPacketConn(ctx context.Context, profile *types.ServerProfile, target string) (packetConn, error) {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, err
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	command := RequestCommandUDP
+	switch profile.PacketEncoding {
+	case "", "none":
+	case PacketEncodingXUDP:
+		command = RequestCommandMux
+	default:
+		return nil, fmt.Errorf("unsupported vless packet encoding '%s'", profile.PacketEncoding)
+	}
+	headerBuf := new(bytes.Buffer)
+	if err := EncodeRequestHeader(headerBuf, command, host, port, profile.UUID, profile.Flow); err != nil {
+		return nil, err
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, udpDialTimeout)
+	conn, err := DialVless(dialCtx, profile)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	stream := &packetStream{conn: conn, writer: conn, header: headerBuf.Bytes(), cancel: cancel}
+	var downlink io.Reader = conn
+	if profile.Flow == FlowVision {
+		// 与 xray 一致，Vision 流控下 UDP 帧同样经过填充
+		userUUID, err := uuid.Parse(profile.UUID)
+		if err != nil {
+			stream.Close()
+			return nil, err
+		}
+		writer, reader, err := newVision(conn, userUUID[:], stream.header)
+		if err != nil {
+			stream.Close()
+			return nil, err
+		}
+		stream.writer, stream.header = writer, nil
+		downlink = reader
+	}
+	stream.reader = bufio.NewReader(downlink)
+
+	if command == RequestCommandMux {
+		c := &xudpPacketConn{packetStream: stream, first: target}
+		if _, err := rand.Read(c.globalID[:]); err != nil {
+			stream.Close()
+			return nil, err
+		}
+		return c, nil
+	}
+	return &lengthPacketConn{packetStream: stream, target: target}, nil
+}
+
+// udpRelay 管理一个客户端的 VLESS UDP 隧道：PacketEncoding 为 xudp 时所有目标共用一条，否则每个目标一条。
+// 隧道按需建立，服务器返回的数据报交给 reply。
+type udpRelay struct {
+	ctx          context.Context
+	profile      *types.ServerProfile
+	stateManager types.StateManager
+	reply        func(payload []byte, from string)
+	wg           *sync.WaitGroup // 跟踪回复 goroutine
+
+	mu     sync.Mutex
+	conns  map[string]packetConn // key: 目标地址，xudp 时为 ""
+	closed bool
+	expiry atomic.Int64 // UnixNano，供空闲清理使用
+}
+
+func newUDPRelay(ctx context.Context, profile *types.ServerProfile, stateManager types.StateManager, wg *sync.WaitGroup, reply func(payload []byte, from string)) *udpRelay {
+	return &udpRelay{ctx: ctx, profile: profile, stateManager: stateManager, reply: reply, wg: wg, conns: make(map[string]packetConn)}
+}
+
+// send 把 payload 发往 target，必要时先建立隧道。写入失败的隧道会被丢弃，下一个数据报将重建。
+func (r *udpRelay) send(payload []byte, target string) error {
+	conn, err := r.getOrDial(target)
+	if err != nil {
+		return err
+	}
+	if err := conn.WritePacket(payload, target); err != nil {
+		r.remove(r.key(target), conn)
+		return err
+	}
+	return nil
+}
+
+func (r *udpRelay) key(target string) string {
+	if r.profile.PacketEncoding == PacketEncodingXUDP {
+		return ""
+	}
+	return target
+}
+
+// getOrDial 返回 target 使用的隧道，不存在时建立。拨号时不持有 r.mu，以免阻塞其他目标的数据报；
+// 拨号完成后重新检查，若期间已有其他 goroutine 建立了隧道则使用它。
+func (r *udpRelay) getOrDial(target string) (packetConn, error) {
+	key := r.key(target)
+	r.mu.Lock()
+	closed, conn := r.closed, r.conns[key]
+	r.mu.Unlock()
+	if closed {
+		return nil, net.ErrClosed
+	}
+	if conn != nil {
+		return conn, nil
+	}
+
+	conn, err := dialPacketConn(r.ctx, r.profile, target)
+	if err != nil {
+		if r.stateManager != nil {
+			r.stateManager.SetServerStatusDown(r.profile.ID, err.Error())
+		}
+		return nil, err
+	}
+
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		conn.Close()
+		return nil, net.ErrClosed
+	}
+	if existing, ok := r.conns[key]; ok {
+		r.mu.Unlock()
+		conn.Close()
+		return existing, nil
+	}
+	r.conns[key] = conn
+	r.wg.Add(1)
+	r.mu.Unlock()
+
+	go r.replyLoop(key, conn)
+	return conn, nil
+}
+
+func (r *udpRelay) replyLoop(key string, conn packetConn) {
+	defer r.wg.Done()
+	defer r.remove(key, conn)
+
+	buf := make([]byte, maxUDPPayload)
+	for {
+		n, from, err := conn.ReadPacket(buf)
+		if err != nil {
+			log.Ctx(r.ctx).Debug().Err(err).Str("key", key).Msg("VLESS-UDP: Reply loop terminating.")
+			return
+		}
+		r.reply(buf[:n], from)
+	}
+}
+
+func (r *udpRelay) remove(key string, conn packetConn) {
+	conn.Close()
+	r.mu.Lock()
+	if r.conns[key] == conn {
+		delete(r.conns, key)
+	}
+	r.mu.Unlock()
+}
+
+func (r *udpRelay) close() {
+	r.mu.Lock()
+	r.closed = true
+	conns := r.conns
+	r.conns = make(map[string]packetConn)
+	r.mu.Unlock()
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
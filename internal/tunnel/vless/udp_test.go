@@ -0,0 +1,294 @@
+package vless
+
+import (
+	"bufio"
+	"bytes"
+	gotls "crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"liuproxy_nexus/internal/shared/testutil"
+	"liuproxy_nexus/internal/shared/types"
+)
+
+// serveVlessUDP 是测试用 VLESS 服务器的 UDP 处理：命令 0x02 和 XUDP 的数据报经本地 UDP socket 转发到目标。
+// addons 带 Vision 流控时与 xray 一样用 Vision 处理请求头之后的数据。
+func serveVlessUDP(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+
+	cmd, addons, target, ok := readVlessRequest(t, conn)
+	if !ok {
+		return
+	}
+	var writer io.Writer = conn
+	var reader io.Reader = conn
+	if bytes.Contains(addons, []byte(FlowVision)) {
+		userUUID := uuid.MustParse(testUUID)
+		visionWriter, visionReader, err := newVision(conn, userUUID[:], []byte{Version, 0})
+		if err != nil {
+			t.Errorf("vless udp server: %v", err)
+			return
+		}
+		writer, reader = visionWriter, visionReader
+	} else if _, err := conn.Write([]byte{Version, 0}); err != nil {
+		return
+	}
+	br := bufio.NewReader(reader)
+
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer udpConn.Close()
+
+	switch cmd {
+	case RequestCommandUDP:
+		dest, err := net.ResolveUDPAddr("udp", target)
+		if err != nil {
+			t.Errorf("vless udp server: %v", err)
+			return
+		}
+		go func() {
+			buf := make([]byte, maxUDPPayload)
+			for {
+				n, _, err := udpConn.ReadFromUDP(buf)
+				if err != nil {
+					return
+				}
+				frame := binary.BigEndian.AppendUint16(nil, uint16(n))
+				if _, err := writer.Write(append(frame, buf[:n]...)); err != nil {
+					return
+				}
+			}
+		}()
+		for {
+			var length [2]byte
+			if _, err := io.ReadFull(br, length[:]); err != nil {
+				return
+			}
+			payload := make([]byte, binary.BigEndian.Uint16(length[:]))
+			if _, err := io.ReadFull(br, payload); err != nil {
+				return
+			}
+			udpConn.WriteToUDP(payload, dest)
+		}
+
+	case RequestCommandMux:
+		go func() {
+			buf := make([]byte, maxUDPPayload)
+			for {
+				n, from, err := udpConn.ReadFromUDP(buf)
+				if err != nil {
+					return
+				}
+				meta := bytes.NewBuffer([]byte{0, 0, muxStatusKeep, muxOptionData, muxNetworkUDP})
+				writeAddressPort(meta, from.IP.String(), from.Port)
+				frame := binary.BigEndian.AppendUint16(nil, uint16(meta.Len()))
+				frame = append(frame, meta.Bytes()...)
+				frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+				if _, err := writer.Write(append(frame, buf[:n]...)); err != nil {
+					return
+				}
+			}
+		}()
+		for first := true; ; first = false {
+			var length [2]byte
+			if _, err := io.ReadFull(br, length[:]); err != nil {
+				return
+			}
+			meta := make([]byte, binary.BigEndian.Uint16(length[:]))
+			if _, err := io.ReadFull(br, meta); err != nil {
+				return
+			}
+			wantStatus := muxStatusKeep
+			if first {
+				wantStatus = muxStatusNew
+			}
+			if meta[2] != wantStatus || meta[4] != muxNetworkUDP {
+				t.Errorf("xudp server: unexpected frame metadata %x", meta)
+				return
+			}
+			metaReader := bytes.NewReader(meta[5:])
+			addr, err := readAddressPort(metaReader)
+			if err != nil {
+				t.Errorf("xudp server: %v", err)
+				return
+			}
+			if first && metaReader.Len() != 8 {
+				t.Errorf("xudp server: New frame carries %d bytes of GlobalID, want 8", metaReader.Len())
+			}
+			if _, err := io.ReadFull(br, length[:]); err != nil {
+				return
+			}
+			payload := make([]byte, binary.BigEndian.Uint16(length[:]))
+			if _, err := io.ReadFull(br, payload); err != nil {
+				return
+			}
+			dest, err := net.ResolveUDPAddr("udp", addr)
+			if err != nil {
+				t.Errorf("xudp server: %v", err)
+				return
+			}
+			udpConn.WriteToUDP(payload, dest)
+		}
+
+	default:
+		t.Errorf("vless udp server: unexpected command %d", cmd)
+	}
+}
+
+// startVlessUDPServer 启动测试用 VLESS UDP 服务器；tlsEnabled 时外层为 TLS (Vision 需要)。
+func startVlessUDPServer(t *testing.T, tlsEnabled bool) int {
+	t.Helper()
+	var ln net.Listener
+	var err error
+	if tlsEnabled {
+		ln, err = gotls.Listen("tcp", "127.0.0.1:0", &gotls.Config{
+			Certificates: []gotls.Certificate{newTestCertificate(t, testVisionSNI)},
+		})
+	} else {
+		ln, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveVlessUDP(t, conn)
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func expectSocksUDPEcho(t *testing.T, conn *net.UDPConn, relay *net.UDPAddr, target *net.UDPAddr, payload string) {
+	t.Helper()
+	datagram, err := appendSocks5UDPHeader(nil, target.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.WriteToUDP(append(datagram, payload...), relay); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, maxUDPPayload)
+	n, _, err := conn.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("no UDP reply: %v", err)
+	}
+	from, got, err := parseSocks5UDPDatagram(buf[:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != payload {
+		t.Errorf("UDP reply = %q, want %q", got, payload)
+	}
+	if from != target.String() {
+		t.Errorf("UDP reply from %s, want %s", from, target)
+	}
+}
+
+func TestVlessUDP(t *testing.T) {
+	plainPort := startVlessUDPServer(t, false)
+	tlsPort := startVlessUDPServer(t, true)
+	echoA, echoB := testutil.StartUDPEchoServer(t), testutil.StartUDPEchoServer(t)
+
+	newProfile := func(port int, packetEncoding, flow string) *types.ServerProfile {
+		profile := &types.ServerProfile{
+			ID: "vless-udp", Remarks: "vless-udp", Type: "vless", Active: true,
+			Address: "127.0.0.1", Port: port, UUID: testUUID, Network: "tcp",
+			PacketEncoding: packetEncoding, Flow: flow,
+		}
+		if flow != "" {
+			profile.Security, profile.SNI, profile.AllowInsecure = "tls", testVisionSNI, true
+		}
+		return profile
+	}
+	tests := []struct {
+		name    string
+		profile *types.ServerProfile
+	}{
+		{name: "packet", profile: newProfile(plainPort, "", "")},
+		{name: "xudp", profile: newProfile(plainPort, PacketEncodingXUDP, "")},
+		{name: "vision packet", profile: newProfile(tlsPort, "", FlowVision)},
+		{name: "vision xudp", profile: newProfile(tlsPort, PacketEncodingXUDP, FlowVision)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy, err := NewVlessStrategy(&types.Config{}, tt.profile, testutil.NopStateManager{}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer strategy.CloseTunnel()
+
+			t.Run("socks udp associate", func(t *testing.T) {
+				control, err := strategy.GetSocksConnection()
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer control.Close()
+				relay := testutil.SOCKS5UDPAssociate(t, control)
+
+				clientConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer clientConn.Close()
+				expectSocksUDPEcho(t, clientConn, relay, echoA, "hello A")
+				expectSocksUDPEcho(t, clientConn, relay, echoB, "hello B")
+				expectSocksUDPEcho(t, clientConn, relay, echoA, "hello A again")
+			})
+
+			t.Run("transparent", func(t *testing.T) {
+				// 同一客户端的会话复用第一个数据报的回复路径，回复来源随目标变化
+				client := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}
+				reply := testutil.NewUDPReply()
+				for i, target := range []*net.UDPAddr{echoA, echoB} {
+					payload := []byte("hello transparent " + strconv.Itoa(i))
+					packet := &types.UDPPacket{Source: client, Destination: target, Payload: payload, Reply: reply.Reply}
+					if err := strategy.HandleUDPPacket(packet, client.String()); err != nil {
+						t.Fatalf("HandleUDPPacket() error = %v", err)
+					}
+					d := reply.Next(t)
+					if !bytes.Equal(d.Payload, payload) {
+						t.Errorf("UDP reply = %q, want %q", d.Payload, payload)
+					}
+					if d.From == nil || !testutil.SameUDPAddr(d.From, target) {
+						t.Errorf("UDP reply came from %v, want %s", d.From, target)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestSocks5UDPDatagram(t *testing.T) {
+	for _, addr := range []string{"192.0.2.1:53", "[2001:db8::1]:443", "example.com:8080"} {
+		datagram, err := appendSocks5UDPHeader(nil, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		target, payload, err := parseSocks5UDPDatagram(append(datagram, "data"...))
+		if err != nil {
+			t.Fatalf("parseSocks5UDPDatagram(%s) error = %v", addr, err)
+		}
+		if target != addr || string(payload) != "data" {
+			t.Errorf("parseSocks5UDPDatagram(%s) = %s, %q", addr, target, payload)
+		}
+	}
+	if _, _, err := parseSocks5UDPDatagram([]byte{0, 0, 1, 0x01, 127, 0, 0, 1, 0, 53}); err == nil {
+		t.Error("expected fragmented datagrams to be rejected")
+	}
+}
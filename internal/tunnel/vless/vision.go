@@ -0,0 +1,434 @@
+package vless
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"reflect"
+	"sync"
+	"unsafe"
+
+	utls "github.com/refraction-networking/utls"
+
+	"liuproxy_nexus/internal/xray_core/transport/internet/reality"
+	"liuproxy_nexus/internal/xray_core/transport/internet/tls"
+)
+
+// XTLS Vision 流控 (xtls-rprx-vision)：内层 TLS 握手阶段对前几个包做随机填充以隐藏长度特征，
+// 内层进入 TLS 1.3 应用数据后双方改为直接在底层 TCP 上收发，省去外层 TLS 的二次加密。
+// 帧格式和状态机与 xray-core 的 proxy/proxy.go 保持一致，以便与上游服务端互通。
+
+// FlowVision 是 ServerProfile.Flow 中表示 Vision 流控的取值。
+const FlowVision = "xtls-rprx-vision"
+
+const (
+	visionCommandPaddingContinue byte = 0x00
+	visionCommandPaddingEnd      byte = 0x01
+	visionCommandPaddingDirect   byte = 0x02
+)
+
+// visionBufferSize 对应 xray 的 buf.Size，填充后的帧不会超过这个长度。
+const visionBufferSize = 8192
+
+var (
+	tls13SupportedVersions  = []byte{0x00, 0x2b, 0x00, 0x02, 0x03, 0x04}
+	tlsClientHandShakeStart = []byte{0x16, 0x03}
+	tlsServerHandShakeStart = []byte{0x16, 0x03, 0x03}
+	tlsApplicationDataStart = []byte{0x17, 0x03, 0x03}
+)
+
+const (
+	tlsHandshakeTypeClientHello byte = 0x01
+	tlsHandshakeTypeServerHello byte = 0x02
+
+	tlsAES128GCMSHA256    uint16 = 0x1301
+	tlsAES128CCM8SHA256   uint16 = 0x1305
+	visionPacketsToFilter        = 8
+)
+
+// visionState 是一条连接上行和下行共享的内层流量识别状态 (对应 xray 的 TrafficState)。
+type visionState struct {
+	mu                     sync.Mutex
+	userUUID               []byte
+	numberOfPacketToFilter int
+	enableXtls             bool
+	isTLS12orAbove         bool
+	isTLS                  bool
+	cipher                 uint16
+	remainingServerHello   int
+}
+
+// filterTLS 检查前几个包，识别内层 TLS 的 ClientHello / ServerHello，判断能否切换为直接拷贝。调用方需持有 mu。
+func (s *visionState) filterTLS(b []byte) {
+	s.numberOfPacketToFilter--
+	if len(b) >= 6 {
+		if bytes.Equal(tlsServerHandShakeStart, b[:3]) && b[5] == tlsHandshakeTypeServerHello {
+			s.remainingServerHello = (int(b[3])<<8 | int(b[4])) + 5
+			s.isTLS12orAbove = true
+			s.isTLS = true
+			if len(b) >= 79 && s.remainingServerHello >= 79 {
+				if suite := 43 + int(b[43]) + 1; suite+2 <= len(b) {
+					s.cipher = uint16(b[suite])<<8 | uint16(b[suite+1])
+				}
+			}
+		} else if bytes.Equal(tlsClientHandShakeStart, b[:2]) && b[5] == tlsHandshakeTypeClientHello {
+			s.isTLS = true
+		}
+	}
+	if s.remainingServerHello > 0 {
+		end := min(s.remainingServerHello, len(b))
+		s.remainingServerHello -= len(b)
+		if bytes.Contains(b[:end], tls13SupportedVersions) {
+			// 内层是 TLS 1.3：除 CCM_8 外的套件记录格式都可以直接透传
+			if s.cipher >= tlsAES128GCMSHA256 && s.cipher < tlsAES128CCM8SHA256 {
+				s.enableXtls = true
+			}
+			s.numberOfPacketToFilter = 0
+		} else if s.remainingServerHello <= 0 {
+			s.numberOfPacketToFilter = 0
+		}
+	}
+}
+
+// visionPad 构造一个填充帧：[UUID (每个方向仅第一帧)] command contentLen(2) paddingLen(2) content padding。
+func visionPad(content []byte, command byte, userUUID *[]byte, longPadding bool) []byte {
+	contentLen := len(content)
+	var paddingLen int
+	if contentLen < 900 && longPadding {
+		paddingLen = rand.IntN(500) + 900 - contentLen
+	} else {
+		paddingLen = rand.IntN(256)
+	}
+	if paddingLen > visionBufferSize-21-contentLen {
+		paddingLen = visionBufferSize - 21 - contentLen
+	}
+
+	frame := make([]byte, 0, 16+5+contentLen+paddingLen)
+	if *userUUID != nil {
+		frame = append(frame, *userUUID...)
+		*userUUID = nil
+	}
+	frame = append(frame, command, byte(contentLen>>8), byte(contentLen), byte(paddingLen>>8), byte(paddingLen))
+	frame = append(frame, content...)
+	return append(frame, make([]byte, paddingLen)...)
+}
+
+// visionReshape 把接近缓冲区大小的数据块拆成两段，保证加上填充头后仍不超过 visionBufferSize。
+func visionReshape(b []byte) [][]byte {
+	if len(b) < visionBufferSize-21 {
+		return [][]byte{b}
+	}
+	index := bytes.LastIndex(b, tlsApplicationDataStart)
+	if index < 21 || index > visionBufferSize-21 {
+		index = visionBufferSize / 2
+	}
+	return [][]byte{b[:index], b[index:]}
+}
+
+// visionWriter 是 Vision 的发送端：填充阶段按帧写入外层 TLS 连接，发出 PaddingDirect 后直接写底层 TCP。
+type visionWriter struct {
+	conn       net.Conn // 外层 TLS / REALITY 连接
+	rawConn    net.Conn // 外层之下的 TCP 连接
+	state      *visionState
+	userUUID   []byte // 只在第一个填充帧前写一次
+	prefix     []byte // 与第一个帧合并发送的数据 (VLESS 请求头)
+	isPadding  bool
+	directCopy bool
+}
+
+// Write 把 b 按 visionBufferSize 切块处理。b 为空时发送一个只有填充的帧 (用于尽快送出请求头)。
+func (w *visionWriter) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, w.writeChunk(nil)
+	}
+	for written := 0; written < len(b); {
+		end := min(written+visionBufferSize, len(b))
+		if err := w.writeChunk(b[written:end]); err != nil {
+			return written, err
+		}
+		written = end
+	}
+	return len(b), nil
+}
+
+func (w *visionWriter) writeChunk(b []byte) error {
+	if w.directCopy {
+		_, err := w.rawConn.Write(b)
+		return err
+	}
+
+	out := w.prefix
+	w.prefix = nil
+	if b == nil {
+		if w.isPadding {
+			out = append(out, visionPad(nil, visionCommandPaddingContinue, &w.userUUID, true)...)
+		}
+		_, err := w.conn.Write(out)
+		return err
+	}
+
+	w.state.mu.Lock()
+	if w.state.numberOfPacketToFilter > 0 {
+		w.state.filterTLS(b)
+	}
+	isTLS, isTLS12orAbove, enableXtls := w.state.isTLS, w.state.isTLS12orAbove, w.state.enableXtls
+	packetsToFilter := w.state.numberOfPacketToFilter
+	w.state.mu.Unlock()
+
+	switchToDirectCopy := false
+	if w.isPadding {
+		chunks := visionReshape(b)
+		longPadding := isTLS
+		for i, chunk := range chunks {
+			last := i == len(chunks)-1
+			if isTLS && len(chunk) >= 6 && bytes.Equal(tlsApplicationDataStart, chunk[:3]) {
+				// 内层进入应用数据阶段，结束填充；可以直接拷贝时通知对端切换
+				if enableXtls {
+					switchToDirectCopy = true
+				}
+				command := visionCommandPaddingContinue
+				if last {
+					command = visionCommandPaddingEnd
+					if enableXtls {
+						command = visionCommandPaddingDirect
+					}
+				}
+				out = append(out, visionPad(chunk, command, &w.userUUID, true)...)
+				w.isPadding = false
+				longPadding = false
+				continue
+			} else if !isTLS12orAbove && packetsToFilter <= 1 {
+				// 非 TLS 1.2+ 流量，提前一个包结束填充以兼容旧版 Vision 接收端
+				w.isPadding = false
+				out = append(out, visionPad(chunk, visionCommandPaddingEnd, &w.userUUID, longPadding)...)
+				for _, rest := range chunks[i+1:] {
+					out = append(out, rest...)
+				}
+				break
+			}
+			command := visionCommandPaddingContinue
+			if last && !w.isPadding {
+				command = visionCommandPaddingEnd
+				if enableXtls {
+					command = visionCommandPaddingDirect
+				}
+			}
+			out = append(out, visionPad(chunk, command, &w.userUUID, longPadding)...)
+		}
+	} else {
+		out = append(out, b...)
+	}
+
+	if _, err := w.conn.Write(out); err != nil {
+		return err
+	}
+	if switchToDirectCopy {
+		w.directCopy = true
+	}
+	return nil
+}
+
+// CloseWrite 关闭发送方向。切换为直接拷贝后外层 TLS 不再使用，只关闭底层 TCP 的写方向。
+func (w *visionWriter) CloseWrite() error {
+	if w.directCopy {
+		CloseWriter(w.rawConn)
+	} else {
+		CloseWriter(w.conn)
+	}
+	return nil
+}
+
+// visionReader 是 Vision 的接收端：去掉对端的填充，收到 PaddingDirect 后改为直接读取底层 TCP。
+type visionReader struct {
+	conn     net.Conn
+	rawConn  net.Conn
+	input    *bytes.Reader // 外层 TLS 已解密但未读取的数据
+	rawInput *bytes.Buffer // 外层 TLS 已从 TCP 读取但未解密的数据
+	state    *visionState
+
+	withinPaddingBuffers bool
+	remainingCommand     int
+	remainingContent     int
+	remainingPadding     int
+	currentCommand       int
+	directCopy           bool
+
+	buf     []byte
+	pending []byte
+}
+
+func (r *visionReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.directCopy {
+			return r.rawConn.Read(p)
+		}
+		n, err := r.conn.Read(r.buf)
+		if n > 0 {
+			r.pending = r.process(r.buf[:n])
+		}
+		if err != nil && len(r.pending) == 0 {
+			return 0, err
+		}
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func (r *visionReader) process(b []byte) []byte {
+	r.state.mu.Lock()
+	filtering := r.state.numberOfPacketToFilter > 0
+	r.state.mu.Unlock()
+
+	switchToDirectCopy := false
+	if r.withinPaddingBuffers || filtering {
+		b = r.unpad(b)
+		if r.remainingContent > 0 || r.remainingPadding > 0 || r.currentCommand == 0 {
+			r.withinPaddingBuffers = true
+		} else if r.currentCommand == int(visionCommandPaddingEnd) {
+			r.withinPaddingBuffers = false
+		} else if r.currentCommand == int(visionCommandPaddingDirect) {
+			r.withinPaddingBuffers = false
+			switchToDirectCopy = true
+		}
+	}
+
+	if len(b) > 0 {
+		r.state.mu.Lock()
+		if r.state.numberOfPacketToFilter > 0 {
+			r.state.filterTLS(b)
+		}
+		r.state.mu.Unlock()
+	}
+
+	if switchToDirectCopy {
+		// 对端此后直接在 TCP 上发送：外层 TLS 里残留的明文和尚未解密的原始字节都属于直接拷贝的数据
+		if r.input.Len() > 0 {
+			rest, _ := io.ReadAll(r.input)
+			b = append(b, rest...)
+		}
+		if r.rawInput.Len() > 0 {
+			b = append(b, r.rawInput.Bytes()...)
+			r.rawInput.Reset()
+		}
+		r.directCopy = true
+	}
+	return b
+}
+
+// unpad 解析填充帧，返回其中的有效数据 (对应 xray 的 XtlsUnpadding)。
+func (r *visionReader) unpad(b []byte) []byte {
+	if r.remainingCommand == -1 && r.remainingContent == -1 && r.remainingPadding == -1 {
+		if len(b) >= 21 && bytes.Equal(r.state.userUUID, b[:16]) {
+			b = b[16:]
+			r.remainingCommand = 5
+		} else {
+			return b
+		}
+	}
+
+	var out []byte
+	for len(b) > 0 {
+		if r.remainingCommand > 0 {
+			data := int(b[0])
+			b = b[1:]
+			switch r.remainingCommand {
+			case 5:
+				r.currentCommand = data
+			case 4:
+				r.remainingContent = data << 8
+			case 3:
+				r.remainingContent |= data
+			case 2:
+				r.remainingPadding = data << 8
+			case 1:
+				r.remainingPadding |= data
+			}
+			r.remainingCommand--
+		} else if r.remainingContent > 0 {
+			n := min(r.remainingContent, len(b))
+			out = append(out, b[:n]...)
+			b = b[n:]
+			r.remainingContent -= n
+		} else {
+			n := min(r.remainingPadding, len(b))
+			b = b[n:]
+			r.remainingPadding -= n
+		}
+		if r.remainingCommand <= 0 && r.remainingContent <= 0 && r.remainingPadding <= 0 {
+			if r.currentCommand == int(visionCommandPaddingContinue) {
+				r.remainingCommand = 5
+			} else {
+				// 填充结束，本块剩余的数据是未填充的原始数据
+				r.remainingCommand, r.remainingContent, r.remainingPadding = -1, -1, -1
+				out = append(out, b...)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// newVision 为已完成 TLS / REALITY 握手的连接创建共享状态的 Vision 发送端和接收端。
+// prefix 会与第一个帧一起发送。
+func newVision(conn net.Conn, userUUID []byte, prefix []byte) (*visionWriter, *visionReader, error) {
+	input, rawInput, rawConn, err := tlsBuffers(conn)
+	if err != nil {
+		return nil, nil, err
+	}
+	state := &visionState{userUUID: userUUID, numberOfPacketToFilter: visionPacketsToFilter}
+	writer := &visionWriter{
+		conn:      conn,
+		rawConn:   rawConn,
+		state:     state,
+		userUUID:  append([]byte(nil), userUUID...),
+		prefix:    prefix,
+		isPadding: true,
+	}
+	reader := &visionReader{
+		conn:                 conn,
+		rawConn:              rawConn,
+		input:                input,
+		rawInput:             rawInput,
+		state:                state,
+		withinPaddingBuffers: true,
+		remainingCommand:     -1,
+		remainingContent:     -1,
+		remainingPadding:     -1,
+		buf:                  make([]byte, visionBufferSize),
+	}
+	return writer, reader, nil
+}
+
+// tlsBuffers 通过反射取出 TLS 连接内部的 input / rawInput 缓冲区以及其下的原始连接。
+// crypto/tls、uTLS 和 REALITY 的 Conn 结构相同，切换直接拷贝时需要接管这两个缓冲区中的数据。
+func tlsBuffers(conn net.Conn) (*bytes.Reader, *bytes.Buffer, net.Conn, error) {
+	var inner interface{} = conn
+	switch c := conn.(type) {
+	case *tls.Conn:
+		inner = c.Conn
+	case *tls.UConn:
+		inner = c.UConn.Conn
+	case *utls.UConn:
+		inner = c.Conn
+	case *reality.UConn:
+		inner = c.UConn.Conn
+	}
+
+	netConner, ok := inner.(interface{ NetConn() net.Conn })
+	v := reflect.ValueOf(inner)
+	if !ok || v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return nil, nil, nil, fmt.Errorf("%s requires a tls or reality connection, got %T", FlowVision, conn)
+	}
+	inputField := v.Elem().FieldByName("input")
+	rawInputField := v.Elem().FieldByName("rawInput")
+	if !inputField.IsValid() || inputField.Type() != reflect.TypeOf(bytes.Reader{}) ||
+		!rawInputField.IsValid() || rawInputField.Type() != reflect.TypeOf(bytes.Buffer{}) {
+		return nil, nil, nil, fmt.Errorf("%s: unexpected tls connection layout in %T", FlowVision, inner)
+	}
+	input := (*bytes.Reader)(unsafe.Pointer(inputField.UnsafeAddr()))
+	rawInput := (*bytes.Buffer)(unsafe.Pointer(rawInputField.UnsafeAddr()))
+	return input, rawInput, netConner.NetConn(), nil
+}
@@ -0,0 +1,421 @@
+package vless
+
+import (
+	"bytes"
+	gotls "crypto/tls"
+	"encoding/hex"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"liuproxy_nexus/internal/shared/testutil"
+	"liuproxy_nexus/internal/shared/types"
+)
+
+const testVisionSNI = "vision.test"
+
+// visionResult 记录服务端一条连接结束时 Vision 两个方向是否切换到了直接拷贝。
+type visionResult struct {
+	readerDirect bool
+	writerDirect bool
+}
+
+// newVisionServe 返回一个 VLESS + Vision 服务端处理函数，每条连接结束后把结果发到 results。
+func newVisionServe(results chan<- visionResult) func(*testing.T, net.Conn) {
+	return func(t *testing.T, conn net.Conn) {
+		defer conn.Close()
+
+		// 直接从 TLS 连接读取请求头，之后的 Vision 帧留给 visionReader
+		cmd, addons, targetAddr, ok := readVlessRequest(t, conn)
+		if !ok {
+			return
+		}
+		if cmd != RequestCommandTCP {
+			t.Errorf("vision server: unexpected command %d", cmd)
+			return
+		}
+		if !bytes.Contains(addons, []byte(FlowVision)) {
+			t.Errorf("vision server: addons %x do not carry the flow", addons)
+			return
+		}
+		target, err := net.Dial("tcp", targetAddr)
+		if err != nil {
+			return
+		}
+		defer target.Close()
+
+		userUUID := uuid.MustParse(testUUID)
+		writer, reader, err := newVision(conn, userUUID[:], []byte{Version, 0})
+		if err != nil {
+			t.Errorf("vision server: %v", err)
+			return
+		}
+		uplinkDone := make(chan struct{})
+		go func() {
+			defer close(uplinkDone)
+			io.Copy(target, reader)
+			CloseWriter(target)
+		}()
+		io.Copy(writer, target)
+		writer.CloseWrite()
+		<-uplinkDone
+		results <- visionResult{readerDirect: reader.directCopy, writerDirect: writer.directCopy}
+	}
+}
+
+// startVisionTLSServer 启动一个外层为普通 TLS 的 VLESS + Vision 服务器，返回端口。
+func startVisionTLSServer(t *testing.T, results chan<- visionResult) int {
+	t.Helper()
+	ln, err := gotls.Listen("tcp", "127.0.0.1:0", &gotls.Config{
+		Certificates: []gotls.Certificate{newTestCertificate(t, testVisionSNI)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	serve := newVisionServe(results)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serve(t, conn)
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// startTLSEchoServer 启动一个 TLS 1.3 回显服务器，作为经过 Vision 的内层 TLS 目标。
+func startTLSEchoServer(t *testing.T) string {
+	t.Helper()
+	ln, err := gotls.Listen("tcp", "127.0.0.1:0", &gotls.Config{
+		Certificates: []gotls.Certificate{newTestCertificate(t, "inner.test")},
+		MinVersion:   gotls.VersionTLS13,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func waitVisionResult(t *testing.T, results <-chan visionResult) visionResult {
+	t.Helper()
+	select {
+	case result := <-results:
+		return result
+	case <-time.After(5 * time.Second):
+		t.Fatal("vision server did not finish the connection")
+		return visionResult{}
+	}
+}
+
+func TestVlessVision(t *testing.T) {
+	echoAddr := testutil.StartEchoServer(t)
+	tlsEchoAddr := startTLSEchoServer(t)
+
+	tlsResults := make(chan visionResult, 4)
+	tlsPort := startVisionTLSServer(t, tlsResults)
+	realityResults := make(chan visionResult, 4)
+	realityPort, publicKey := startRealityServer(t, newVisionServe(realityResults))
+
+	realityProfile := newRealityProfile(realityPort, publicKey)
+	realityProfile.Flow = FlowVision
+	servers := []struct {
+		name    string
+		profile *types.ServerProfile
+		results chan visionResult
+	}{
+		{
+			name: "tls",
+			profile: &types.ServerProfile{
+				ID: "vless-vision", Remarks: "vless-vision", Type: "vless", Active: true,
+				Address: "127.0.0.1", Port: tlsPort, UUID: testUUID, Flow: FlowVision,
+				Network: "tcp", Security: "tls", SNI: testVisionSNI, AllowInsecure: true,
+			},
+			results: tlsResults,
+		},
+		{name: "reality", profile: realityProfile, results: realityResults},
+	}
+
+	for _, server := range servers {
+		t.Run(server.name, func(t *testing.T) {
+			strategy, err := NewVlessStrategy(&types.Config{}, server.profile, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer strategy.CloseTunnel()
+
+			t.Run("plain", func(t *testing.T) {
+				conn := testutil.DialThroughStrategy(t, strategy, echoAddr)
+				testutil.ExpectEcho(t, conn, "hello over vision")
+				testutil.ExpectEcho(t, conn, strings.Repeat("large payload ", 2000))
+				conn.Close()
+				// 非 TLS 流量只做填充，不会切换为直接拷贝
+				if result := waitVisionResult(t, server.results); result.readerDirect || result.writerDirect {
+					t.Errorf("plain traffic switched to direct copy: %+v", result)
+				}
+			})
+
+			t.Run("inner tls", func(t *testing.T) {
+				conn := testutil.DialThroughStrategy(t, strategy, tlsEchoAddr)
+				innerConn := gotls.Client(conn, &gotls.Config{ServerName: "inner.test", InsecureSkipVerify: true})
+				testutil.ExpectEcho(t, innerConn, "hello over inner tls")
+				testutil.ExpectEcho(t, innerConn, strings.Repeat("x", 3*visionBufferSize))
+				innerConn.Close()
+				// 内层 TLS 1.3 进入应用数据后两个方向都应切换为直接拷贝
+				if result := waitVisionResult(t, server.results); !result.readerDirect || !result.writerDirect {
+					t.Errorf("inner tls 1.3 did not switch to direct copy: %+v", result)
+				}
+			})
+
+			t.Run("raw tcp", func(t *testing.T) {
+				client, inbound := net.Pipe()
+				go strategy.HandleRawTCP(inbound, echoAddr)
+				testutil.ExpectEcho(t, client, "hello over transparent vision")
+				client.Close()
+				waitVisionResult(t, server.results)
+			})
+		})
+	}
+}
+
+func TestEncodeRequestHeaderFlow(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := EncodeRequestHeader(buf, RequestCommandTCP, "127.0.0.1", 443, testUUID, FlowVision); err != nil {
+		t.Fatal(err)
+	}
+	addons := buf.Bytes()[17:]
+	want := append([]byte{byte(2 + len(FlowVision)), 0x0a, byte(len(FlowVision))}, FlowVision...)
+	if !bytes.HasPrefix(addons, want) {
+		t.Fatalf("addons = %x, want prefix %x", addons, want)
+	}
+
+	buf.Reset()
+	if err := EncodeRequestHeader(buf, RequestCommandTCP, "127.0.0.1", 443, testUUID, ""); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Bytes()[17] != 0 {
+		t.Fatalf("addons length without flow = %d, want 0", buf.Bytes()[17])
+	}
+}
+
+func TestValidateFlow(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile types.ServerProfile
+		wantErr bool
+	}{
+		{name: "no flow", profile: types.ServerProfile{Network: "ws"}},
+		{name: "vision tls", profile: types.ServerProfile{Flow: FlowVision, Network: "tcp", Security: "tls"}},
+		{name: "vision reality", profile: types.ServerProfile{Flow: FlowVision, Network: "tcp", Security: "reality"}},
+		{name: "vision over ws", profile: types.ServerProfile{Flow: FlowVision, Network: "ws", Security: "tls"}, wantErr: true},
+		{name: "vision without tls", profile: types.ServerProfile{Flow: FlowVision, Network: "tcp", Security: "none"}, wantErr: true},
+		{name: "unknown flow", profile: types.ServerProfile{Flow: "xtls-rprx-direct", Network: "tcp", Security: "tls"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateFlow(&tt.profile); (err != nil) != tt.wantErr {
+				t.Fatalf("validateFlow() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// 以下固定帧按 xray-core proxy/proxy.go 的 XtlsPadding 布局写出 (填充字节为零，xray 的接收端忽略其内容)：
+// [UUID] command contentLen(2) paddingLen(2) content padding。
+const testVisionUUIDHex = "b831381d63244d53ad4f8cda48b30811"
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// newTestVisionReader 创建一个处于初始状态的接收端，不需要真实的 TLS 连接。
+func newTestVisionReader(rawInput []byte) *visionReader {
+	userUUID := uuid.MustParse(testUUID)
+	return &visionReader{
+		input:                bytes.NewReader(nil),
+		rawInput:             bytes.NewBuffer(rawInput),
+		state:                &visionState{userUUID: userUUID[:], numberOfPacketToFilter: visionPacketsToFilter},
+		withinPaddingBuffers: true,
+		remainingCommand:     -1,
+		remainingContent:     -1,
+		remainingPadding:     -1,
+	}
+}
+
+func TestVisionReaderVectors(t *testing.T) {
+	tests := []struct {
+		name       string
+		stream     string
+		rawInput   string
+		want       string // 为空时期望 stream 原样传递
+		wantDirect bool
+	}{
+		{
+			// PaddingContinue 之后是 PaddingEnd，结束填充后的数据原样传递
+			name: "continue then end",
+			stream: testVisionUUIDHex + " 00 0005 0003 68656c6c6f 000000" +
+				" 01 0005 0000 776f726c64" + " 726177",
+			want: "helloworldraw",
+		},
+		{
+			// PaddingDirect 之后外层 TLS 中未解密的原始字节属于直接拷贝的数据
+			name:       "direct",
+			stream:     testVisionUUIDHex + " 02 0003 0004 616263 00000000" + " 726177",
+			rawInput:   "74637062797465",
+			want:       "abcrawtcpbyte",
+			wantDirect: true,
+		},
+		{
+			// 只有填充的帧 (客户端尽快送出请求头时发送) 不产生数据
+			name:   "padding only",
+			stream: testVisionUUIDHex + " 00 0000 0004 00000000" + " 01 0002 0000 6f6b",
+			want:   "ok",
+		},
+		{
+			// 第一帧不以 UUID 开头说明对端没有使用 Vision，数据原样传递
+			name:   "not padded",
+			stream: "00112233445566778899aabbccddeeff 00 0005 0000 68656c6c6f",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := mustDecodeHex(t, tt.stream)
+			want := []byte(tt.want)
+			if tt.want == "" {
+				want = stream
+			}
+
+			// 一次收到全部数据
+			r := newTestVisionReader(mustDecodeHex(t, tt.rawInput))
+			if got := r.process(stream); !bytes.Equal(got, want) {
+				t.Errorf("process() = %q, want %q", got, want)
+			}
+			if r.directCopy != tt.wantDirect {
+				t.Errorf("directCopy = %v, want %v", r.directCopy, tt.wantDirect)
+			}
+
+			// 帧被拆散到多次读取中 (UUID 必须在第一次读取中完整出现)，尚未读取的数据留在外层 TLS 的缓冲区
+			r = newTestVisionReader(mustDecodeHex(t, tt.rawInput))
+			r.input.Reset(stream[21:])
+			got := r.process(stream[:21])
+			for i := 21; i < len(stream) && !r.directCopy; i++ {
+				r.input.Reset(stream[i+1:])
+				got = append(got, r.process(stream[i:i+1])...)
+			}
+			if !bytes.Equal(got, want) || r.directCopy != tt.wantDirect {
+				t.Errorf("byte-wise process() = %q (direct %v), want %q (direct %v)", got, r.directCopy, want, tt.wantDirect)
+			}
+		})
+	}
+}
+
+// recordConn 记录每次 Write 的数据。
+type recordConn struct {
+	net.Conn
+	writes [][]byte
+}
+
+func (c *recordConn) Write(b []byte) (int, error) {
+	c.writes = append(c.writes, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+// checkVisionFrame 检查 frame 是一个完整的填充帧。填充长度是随机的，只检查它与帧长度一致、在 xray 的取值范围内且内容为零。
+func checkVisionFrame(t *testing.T, frame []byte, header string, content []byte, longPadding bool) {
+	t.Helper()
+	want := mustDecodeHex(t, header)
+	if !bytes.HasPrefix(frame, want) || len(frame) < len(want)+2+len(content) {
+		t.Fatalf("frame = %x, want prefix %x", frame, want)
+	}
+	paddingLen := int(frame[len(want)])<<8 | int(frame[len(want)+1])
+	body := frame[len(want)+2:]
+	if len(body) != len(content)+paddingLen {
+		t.Fatalf("frame length %d does not match content %d + padding %d", len(body), len(content), paddingLen)
+	}
+	if !bytes.Equal(body[:len(content)], content) {
+		t.Errorf("frame content = %x, want %x", body[:len(content)], content)
+	}
+	if !bytes.Equal(body[len(content):], make([]byte, paddingLen)) {
+		t.Errorf("padding is not zero: %x", body[len(content):])
+	}
+	minPadding, maxPadding := 0, 255
+	if longPadding && len(content) < 900 {
+		minPadding, maxPadding = 900-len(content), 1399-len(content)
+	}
+	if paddingLen < minPadding || paddingLen > maxPadding {
+		t.Errorf("padding length %d out of range [%d, %d]", paddingLen, minPadding, maxPadding)
+	}
+}
+
+func TestVisionWriterFrames(t *testing.T) {
+	userUUID := uuid.MustParse(testUUID)
+	appData := mustDecodeHex(t, "1703030005 0102030405")
+	newWriter := func(state *visionState) (*visionWriter, *recordConn, *recordConn) {
+		conn, rawConn := &recordConn{}, &recordConn{}
+		return &visionWriter{
+			conn: conn, rawConn: rawConn, state: state,
+			userUUID: append([]byte(nil), userUUID[:]...), prefix: []byte{Version, 0}, isPadding: true,
+		}, conn, rawConn
+	}
+
+	t.Run("continue", func(t *testing.T) {
+		w, conn, _ := newWriter(&visionState{numberOfPacketToFilter: visionPacketsToFilter})
+		w.Write([]byte("hello"))
+		w.Write([]byte("world"))
+		// 第一帧前是请求头的剩余部分和 UUID，之后的帧不再带 UUID
+		checkVisionFrame(t, conn.writes[0], "0000"+testVisionUUIDHex+"00 0005", []byte("hello"), false)
+		checkVisionFrame(t, conn.writes[1], "00 0005", []byte("world"), false)
+	})
+
+	t.Run("padding only", func(t *testing.T) {
+		w, conn, _ := newWriter(&visionState{numberOfPacketToFilter: visionPacketsToFilter})
+		w.Write(nil)
+		checkVisionFrame(t, conn.writes[0], "0000"+testVisionUUIDHex+"00 0000", nil, true)
+	})
+
+	t.Run("end", func(t *testing.T) {
+		// 内层 TLS 不能直接拷贝 (例如 TLS 1.2)：第一个应用数据记录以 PaddingEnd 结束填充
+		w, conn, rawConn := newWriter(&visionState{isTLS: true, isTLS12orAbove: true})
+		w.Write(appData)
+		w.Write([]byte("after"))
+		checkVisionFrame(t, conn.writes[0], "0000"+testVisionUUIDHex+"01 000a", appData, true)
+		if !bytes.Equal(conn.writes[1], []byte("after")) || len(rawConn.writes) != 0 {
+			t.Errorf("data after PaddingEnd = %q on the tls conn, %d raw writes", conn.writes[1], len(rawConn.writes))
+		}
+	})
+
+	t.Run("direct", func(t *testing.T) {
+		// 内层 TLS 1.3：第一个应用数据记录以 PaddingDirect 结束填充，之后直接写底层连接
+		w, conn, rawConn := newWriter(&visionState{isTLS: true, isTLS12orAbove: true, enableXtls: true})
+		w.Write(appData)
+		w.Write([]byte("after"))
+		checkVisionFrame(t, conn.writes[0], "0000"+testVisionUUIDHex+"02 000a", appData, true)
+		if len(conn.writes) != 1 || len(rawConn.writes) != 1 || !bytes.Equal(rawConn.writes[0], []byte("after")) {
+			t.Errorf("data after PaddingDirect was not written to the raw conn: tls %q, raw %q", conn.writes, rawConn.writes)
+		}
+	})
+}
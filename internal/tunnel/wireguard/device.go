@@ -0,0 +1,322 @@
+package wireguard
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+
+	"liuproxy_nexus/internal/shared/types"
+)
+
+const (
+	defaultMTU = 1420
+	// persistentKeepalive 让设备启动后立即握手，并在 NAT 后保持映射
+	persistentKeepalive = 25
+)
+
+// deviceConfig 是从 ServerProfile 解析出的 WireGuard 设备配置。
+type deviceConfig struct {
+	localAddresses []netip.Addr
+	dnsServers     []netip.Addr
+	mtu            int
+	ipc            string // wireguard UAPI 格式的设备和对端配置
+}
+
+// parseConfig 校验 profile 并生成设备配置。密钥均为 base64 编码的 32 字节 Curve25519 密钥。
+func parseConfig(profile *types.ServerProfile) (*deviceConfig, error) {
+	privateKey, err := decodeKey("privateKey", profile.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := decodeKey("publicKey", profile.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if profile.Address == "" || profile.Port <= 0 || profile.Port > 65535 {
+		return nil, fmt.Errorf("wireguard: invalid endpoint %s:%d", profile.Address, profile.Port)
+	}
+
+	cfg := &deviceConfig{mtu: profile.MTU}
+	if cfg.mtu == 0 {
+		cfg.mtu = defaultMTU
+	}
+	if cfg.mtu < 576 || cfg.mtu > 65535 {
+		return nil, fmt.Errorf("wireguard: invalid mtu %d", profile.MTU)
+	}
+
+	if len(profile.LocalAddress) == 0 {
+		return nil, fmt.Errorf("wireguard: localAddress is required")
+	}
+	for _, s := range profile.LocalAddress {
+		addr, err := parseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("wireguard: invalid localAddress '%s': %w", s, err)
+		}
+		cfg.localAddresses = append(cfg.localAddresses, addr)
+	}
+	for _, s := range profile.DNS {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("wireguard: invalid dns server '%s': %w", s, err)
+		}
+		cfg.dnsServers = append(cfg.dnsServers, addr)
+	}
+
+	var ipc strings.Builder
+	fmt.Fprintf(&ipc, "private_key=%s\n", privateKey)
+	fmt.Fprintf(&ipc, "public_key=%s\n", publicKey)
+	if profile.PreSharedKey != "" {
+		presharedKey, err := decodeKey("preSharedKey", profile.PreSharedKey)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&ipc, "preshared_key=%s\n", presharedKey)
+	}
+	fmt.Fprintf(&ipc, "endpoint=%s\n", endpointAddr(profile))
+	fmt.Fprintf(&ipc, "persistent_keepalive_interval=%d\n", persistentKeepalive)
+	allowedIPs := profile.AllowedIPs
+	if len(allowedIPs) == 0 {
+		allowedIPs = []string{"0.0.0.0/0", "::/0"}
+	}
+	for _, s := range allowedIPs {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("wireguard: invalid allowedIPs entry '%s': %w", s, err)
+		}
+		fmt.Fprintf(&ipc, "allowed_ip=%s\n", prefix.Masked())
+	}
+	cfg.ipc = ipc.String()
+	return cfg, nil
+}
+
+// decodeKey 把 base64 密钥转换为 UAPI 使用的十六进制格式。
+func decodeKey(name, key string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(raw) != device.NoisePublicKeySize {
+		return "", fmt.Errorf("wireguard: %s must be a base64-encoded 32-byte key", name)
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+// parseAddr 接受 "10.0.0.2" 或 "10.0.0.2/32" 形式的地址。
+func parseAddr(s string) (netip.Addr, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Addr{}, err
+		}
+		return prefix.Addr(), nil
+	}
+	return netip.ParseAddr(s)
+}
+
+func endpointAddr(profile *types.ServerProfile) string {
+	return net.JoinHostPort(profile.Address, strconv.Itoa(profile.Port))
+}
+
+// tunnel 是一个运行中的用户态 WireGuard 设备及其网络栈。
+type tunnel struct {
+	dev  *device.Device
+	tnet *netstack.Net
+	cfg  *deviceConfig
+}
+
+// newTunnel 创建 netstack 设备并启动，对端的 UDP 报文经 dialer 收发。
+func newTunnel(cfg *deviceConfig, endpoint string, dialer types.Dialer, logger *device.Logger) (*tunnel, error) {
+	tunDev, tnet, err := netstack.CreateNetTUN(cfg.localAddresses, cfg.dnsServers, cfg.mtu)
+	if err != nil {
+		return nil, fmt.Errorf("wireguard: failed to create netstack: %w", err)
+	}
+	dev := device.NewDevice(tunDev, &dialerBind{dialer: dialer, endpoint: endpoint}, logger)
+	if err := dev.IpcSet(cfg.ipc); err != nil {
+		dev.Close()
+		return nil, fmt.Errorf("wireguard: failed to configure device: %w", err)
+	}
+	if err := dev.Up(); err != nil {
+		dev.Close()
+		return nil, fmt.Errorf("wireguard: failed to bring device up: %w", err)
+	}
+	return &tunnel{dev: dev, tnet: tnet, cfg: cfg}, nil
+}
+
+// lastHandshake 返回与对端最近一次握手成功的时间，尚未握手时为零值。
+func (t *tunnel) lastHandshake() time.Time {
+	status, err := t.dev.IpcGet()
+	if err != nil {
+		return time.Time{}
+	}
+	for _, line := range strings.Split(status, "\n") {
+		if value, ok := strings.CutPrefix(line, "last_handshake_time_sec="); ok {
+			if sec, err := strconv.ParseInt(value, 10, 64); err == nil && sec > 0 {
+				return time.Unix(sec, 0)
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// resolve 把 host:port 解析为隧道内可用的地址。配置了隧道 DNS 时在隧道内解析，否则使用本地解析器；
+// 优先选择与本端地址同一协议族的结果。
+func (t *tunnel) resolve(ctx context.Context, target string) (netip.AddrPort, error) {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid port in %s", target)
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return netip.AddrPortFrom(addr.Unmap(), uint16(port)), nil
+	}
+
+	var addrs []netip.Addr
+	if len(t.cfg.dnsServers) > 0 {
+		results, err := t.tnet.LookupContextHost(ctx, host)
+		if err != nil {
+			return netip.AddrPort{}, err
+		}
+		for _, r := range results {
+			if addr, err := netip.ParseAddr(r); err == nil {
+				addrs = append(addrs, addr)
+			}
+		}
+	} else {
+		addrs, err = net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		if err != nil {
+			return netip.AddrPort{}, err
+		}
+	}
+	for _, addr := range addrs {
+		if t.localAddr(addr.Unmap()).IsValid() {
+			return netip.AddrPortFrom(addr.Unmap(), uint16(port)), nil
+		}
+	}
+	return netip.AddrPort{}, fmt.Errorf("no usable address found for %s", host)
+}
+
+// localAddr 返回与 addr 同一协议族的本端地址，没有时返回零值。
+func (t *tunnel) localAddr(addr netip.Addr) netip.Addr {
+	for _, local := range t.cfg.localAddresses {
+		if local.Is4() == addr.Is4() {
+			return local
+		}
+	}
+	return netip.Addr{}
+}
+
+func (t *tunnel) close() {
+	t.dev.Close()
+}
+
+// dialerBind 是只与一个对端通信的 conn.Bind：Open 时经 dialer 拨号 UDP 到对端 Endpoint，
+// 因此 WireGuard 报文同样遵循出站套接字选项和 via 链。
+type dialerBind struct {
+	dialer   types.Dialer
+	endpoint string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+var _ conn.Bind = (*dialerBind)(nil)
+
+func (b *dialerBind) Open(port uint16) ([]conn.ReceiveFunc, uint16, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn != nil {
+		return nil, 0, conn.ErrBindAlreadyOpen
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c, err := b.dialer.DialContext(ctx, "udp", b.endpoint)
+	if err != nil {
+		return nil, 0, err
+	}
+	b.conn = c
+
+	ep := &endpoint{addr: b.endpoint}
+	receive := func(packets [][]byte, sizes []int, eps []conn.Endpoint) (int, error) {
+		for {
+			n, err := c.Read(packets[0])
+			if err == nil {
+				sizes[0], eps[0] = n, ep
+				return 1, nil
+			}
+			if errors.Is(err, net.ErrClosed) || errors.Is(err, io.EOF) {
+				return 0, net.ErrClosed
+			}
+			// 已连接的 UDP 套接字会收到 ICMP 不可达等错误，对端恢复后即可继续通信
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	return []conn.ReceiveFunc{receive}, 0, nil
+}
+
+func (b *dialerBind) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn == nil {
+		return nil
+	}
+	err := b.conn.Close()
+	b.conn = nil
+	return err
+}
+
+func (b *dialerBind) SetMark(mark uint32) error { return nil }
+
+func (b *dialerBind) Send(bufs [][]byte, _ conn.Endpoint) error {
+	b.mu.Lock()
+	c := b.conn
+	b.mu.Unlock()
+	if c == nil {
+		return net.ErrClosed
+	}
+	for _, buf := range bufs {
+		if _, err := c.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *dialerBind) ParseEndpoint(s string) (conn.Endpoint, error) {
+	if _, _, err := net.SplitHostPort(s); err != nil {
+		return nil, err
+	}
+	return &endpoint{addr: s}, nil
+}
+
+func (b *dialerBind) BatchSize() int { return 1 }
+
+// endpoint 是对端地址，可以是域名，由 dialer 负责解析。
+type endpoint struct {
+	addr string
+}
+
+func (e *endpoint) ClearSrc()           {}
+func (e *endpoint) SrcToString() string { return "" }
+func (e *endpoint) DstToString() string { return e.addr }
+func (e *endpoint) DstToBytes() []byte  { return []byte(e.addr) }
+func (e *endpoint) SrcIP() netip.Addr   { return netip.Addr{} }
+
+func (e *endpoint) DstIP() netip.Addr {
+	if addrPort, err := netip.ParseAddrPort(e.addr); err == nil {
+		return addrPort.Addr()
+	}
+	return netip.Addr{}
+}
@@ -0,0 +1,173 @@
+package wireguard
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"golang.zx2c4.com/wireguard/device"
+
+	"liuproxy_nexus/internal/shared"
+	"liuproxy_nexus/internal/shared/outbound"
+	"liuproxy_nexus/internal/shared/types"
+)
+
+// handshakeTimeout 是 CheckHealth 等待与对端完成握手的时间。
+const handshakeTimeout = 5 * time.Second
+
+// WireGuardStrategy 实现了 TunnelStrategy 接口，在进程内运行一个用户态 WireGuard 对端 (gVisor netstack)，
+// 不创建内核网卡。TCP 和 UDP 流量从隧道内的网络栈发出。
+type WireGuardStrategy struct {
+	config       *types.Config
+	profile      *types.ServerProfile
+	logger       zerolog.Logger
+	stateManager types.StateManager
+	dialer       types.Dialer
+	relay        *shared.SocksRelay // 本地 SOCKS5 入口、透明代理 TCP 和 UDP 会话
+
+	// --- WireGuard 设备，首次使用时启动 ---
+	mu        sync.Mutex
+	deviceCfg *deviceConfig
+	tun       *tunnel
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+var _ types.TunnelStrategy = (*WireGuardStrategy)(nil)
+
+// NewWireGuardStrategy 创建一个新的 WireGuard 策略实例。设备在第一次需要时才启动。
+func NewWireGuardStrategy(cfg *types.Config, profile *types.ServerProfile, stateManager types.StateManager, dialer types.Dialer) (types.TunnelStrategy, error) {
+	if profile == nil {
+		return nil, fmt.Errorf("wireguard strategy requires a non-nil profile")
+	}
+	deviceCfg, err := parseConfig(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &WireGuardStrategy{
+		config:       cfg,
+		profile:      profile,
+		deviceCfg:    deviceCfg,
+		stateManager: stateManager,
+		dialer:       outbound.Or(dialer),
+		done:         make(chan struct{}),
+		logger: log.With().
+			Str("strategy_type", "wireguard").
+			Str("server_id", profile.ID).
+			Str("remarks", profile.Remarks).Logger(),
+	}
+	s.relay = shared.NewSocksRelay("wireguard", &s.logger, s.dialTarget)
+	return s, nil
+}
+
+// getTunnel 返回运行中的设备，尚未启动时先启动它。
+func (s *WireGuardStrategy) getTunnel() (*tunnel, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.done:
+		return nil, net.ErrClosed
+	default:
+	}
+	if s.tun != nil {
+		return s.tun, nil
+	}
+
+	deviceLogger := &device.Logger{
+		Verbosef: func(format string, args ...any) { s.logger.Trace().Msgf("[WG] "+format, args...) },
+		Errorf:   func(format string, args ...any) { s.logger.Warn().Msgf("[WG] "+format, args...) },
+	}
+	tun, err := newTunnel(s.deviceCfg, endpointAddr(s.profile), s.dialer, deviceLogger)
+	if err != nil {
+		if s.stateManager != nil {
+			s.stateManager.SetServerStatusDown(s.profile.ID, err.Error())
+		}
+		return nil, err
+	}
+	s.logger.Info().Str("endpoint", endpointAddr(s.profile)).Msg("WireGuard device started.")
+	s.tun = tun
+	return tun, nil
+}
+
+// resetTunnel 关闭当前设备，下次使用时按最新配置重建。
+func (s *WireGuardStrategy) resetTunnel() {
+	s.mu.Lock()
+	tun := s.tun
+	s.tun = nil
+	s.mu.Unlock()
+	if tun != nil {
+		tun.close()
+	}
+}
+
+// InitializeForGateway 在网关模式下被调用。设备按需启动，这是一个空操作。
+func (s *WireGuardStrategy) InitializeForGateway() error {
+	s.logger.Debug().Msg("WireGuard: Initializing for Gateway (no-op).")
+	return nil
+}
+
+func (s *WireGuardStrategy) GetType() string { return "wireguard" }
+
+func (s *WireGuardStrategy) CloseTunnel() {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		close(s.done)
+		s.mu.Unlock()
+		s.relay.Close()
+		s.resetTunnel()
+		outbound.CloseDialer(s.dialer)
+		s.logger.Info().Msg("wireguard strategy closed.")
+	})
+}
+
+func (s *WireGuardStrategy) GetListenerInfo() *types.ListenerInfo { return s.relay.GetListenerInfo() }
+func (s *WireGuardStrategy) GetMetrics() *types.Metrics           { return s.relay.GetMetrics() }
+func (s *WireGuardStrategy) GetTrafficStats() types.TrafficStats  { return s.relay.GetTrafficStats() }
+
+// UpdateServer 应用新配置。已有连接随旧设备一起关闭，之后的连接使用新设备。
+func (s *WireGuardStrategy) UpdateServer(profile *types.ServerProfile) error {
+	deviceCfg, err := parseConfig(profile)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.profile = profile
+	s.deviceCfg = deviceCfg
+	s.logger = log.With().
+		Str("strategy_type", "wireguard").
+		Str("server_id", profile.ID).
+		Str("remarks", profile.Remarks).Logger()
+	s.mu.Unlock()
+	s.resetTunnel()
+	s.logger.Info().Msg("WireGuard profile updated. The device will be restarted on next use.")
+	return nil
+}
+
+// CheckHealth 启动设备并等待与对端完成握手 (设备启动时会立即发送 keepalive 触发握手)。
+func (s *WireGuardStrategy) CheckHealth() error {
+	tun, err := s.getTunnel()
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(handshakeTimeout)
+	for {
+		// 会话密钥每 2 分钟轮换一次，超过 3 分钟没有握手说明对端已不可达
+		if last := tun.lastHandshake(); !last.IsZero() && time.Since(last) < 3*time.Minute {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("wireguard: no handshake with %s", endpointAddr(s.profile))
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// CheckHealthAdvanced 通过隧道请求 Cloudflare trace，返回延迟和出口 IP。
+func (s *WireGuardStrategy) CheckHealthAdvanced() (latency int64, exitIP string, err error) {
+	return shared.CheckTraceHealth("wireguard", shared.TraceURL, s.GetSocksConnection)
+}
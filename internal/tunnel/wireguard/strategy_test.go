@@ -0,0 +1,252 @@
+package wireguard
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+
+	"liuproxy_nexus/internal/shared/outbound"
+	"liuproxy_nexus/internal/shared/testutil"
+	"liuproxy_nexus/internal/shared/types"
+)
+
+var (
+	testServerAddr = netip.MustParseAddr("10.99.0.1")
+	testClientAddr = netip.MustParseAddr("10.99.0.2")
+)
+
+// newKeyPair 生成 base64 编码的 WireGuard 私钥和公钥。
+func newKeyPair(t *testing.T) (privateKey, publicKey string) {
+	t.Helper()
+	var priv [32]byte
+	if _, err := rand.Read(priv[:]); err != nil {
+		t.Fatal(err)
+	}
+	priv[0] &= 248
+	priv[31] = (priv[31] & 127) | 64
+	pub, err := curve25519.X25519(priv[:], curve25519.Basepoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(priv[:]), base64.StdEncoding.EncodeToString(pub)
+}
+
+func hexKey(t *testing.T, key string) string {
+	t.Helper()
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(raw)
+}
+
+// startTestPeer 启动作为服务器的进程内 WireGuard 对端，监听回环 UDP 端口。
+// 它的网络栈中运行 TCP (端口 7) 和 UDP (端口 53) 回显服务。返回对端公钥和 UDP 端口。
+func startTestPeer(t *testing.T, clientPublicKey string) (publicKey string, port int) {
+	t.Helper()
+	privateKey, publicKey := newKeyPair(t)
+	tunDev, tnet, err := netstack.CreateNetTUN([]netip.Addr{testServerAddr}, nil, defaultMTU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev := device.NewDevice(tunDev, conn.NewDefaultBind(), device.NewLogger(device.LogLevelSilent, ""))
+	ipc := fmt.Sprintf("private_key=%s\nlisten_port=0\npublic_key=%s\nallowed_ip=%s/32\n",
+		hexKey(t, privateKey), hexKey(t, clientPublicKey), testClientAddr)
+	if err := dev.IpcSet(ipc); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.Up(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(dev.Close)
+
+	status, err := dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fmt.Sscanf(status[strings.Index(status, "listen_port="):], "listen_port=%d", &port); err != nil {
+		t.Fatalf("failed to read listen port: %v", err)
+	}
+
+	tcpListener, err := tnet.ListenTCPAddrPort(netip.AddrPortFrom(testServerAddr, 7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := tcpListener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+
+	udpConn, err := tnet.ListenUDPAddrPort(netip.AddrPortFrom(testServerAddr, 53))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := udpConn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			udpConn.WriteTo(buf[:n], addr)
+		}
+	}()
+	t.Cleanup(func() {
+		tcpListener.Close()
+		udpConn.Close()
+	})
+	return publicKey, port
+}
+
+// countingDialer 记录经它建立的连接数，用于确认对端报文走策略的 dialer。
+type countingDialer struct {
+	dials atomic.Int32
+}
+
+func (d *countingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d.dials.Add(1)
+	return outbound.DialContext(ctx, network, address)
+}
+
+func TestWireGuardStrategy(t *testing.T) {
+	clientPrivateKey, clientPublicKey := newKeyPair(t)
+	serverPublicKey, port := startTestPeer(t, clientPublicKey)
+	echoAddr := netip.AddrPortFrom(testServerAddr, 7).String()
+
+	profile := &types.ServerProfile{
+		ID: "wg-test", Remarks: "wg-test", Type: "wireguard", Active: true,
+		Address: "127.0.0.1", Port: port,
+		PrivateKey: clientPrivateKey, PublicKey: serverPublicKey,
+		LocalAddress: []string{testClientAddr.String() + "/32"},
+		AllowedIPs:   []string{"10.99.0.0/24"},
+		MTU:          1380,
+	}
+	dialer := &countingDialer{}
+	strategy, err := NewWireGuardStrategy(&types.Config{}, profile, testutil.NopStateManager{}, dialer)
+	if err != nil {
+		t.Fatalf("NewWireGuardStrategy() error = %v", err)
+	}
+	defer strategy.CloseTunnel()
+
+	t.Run("health", func(t *testing.T) {
+		if err := strategy.CheckHealth(); err != nil {
+			t.Fatalf("CheckHealth() error = %v", err)
+		}
+	})
+
+	t.Run("socks", func(t *testing.T) {
+		conn := testutil.DialThroughStrategy(t, strategy, echoAddr)
+		defer conn.Close()
+		testutil.ExpectEcho(t, conn, "hello over socks")
+		testutil.ExpectEcho(t, conn, strings.Repeat("large payload ", 20000))
+	})
+
+	t.Run("raw tcp", func(t *testing.T) {
+		client, inbound := net.Pipe()
+		defer client.Close()
+		go strategy.HandleRawTCP(inbound, echoAddr)
+		testutil.ExpectEcho(t, client, "hello over transparent tcp")
+	})
+
+	t.Run("udp", func(t *testing.T) {
+		testutil.ExpectUDPEcho(t, strategy, net.UDPAddrFromAddrPort(netip.AddrPortFrom(testServerAddr, 53)),
+			"hello over udp", "second datagram")
+	})
+
+	if stats := strategy.GetTrafficStats(); stats.Uplink == 0 || stats.Downlink == 0 {
+		t.Errorf("traffic stats not counted: %+v", stats)
+	}
+	if n := dialer.dials.Load(); n != 1 {
+		t.Errorf("peer dialed %d times through the strategy dialer, want 1", n)
+	}
+}
+
+func TestWireGuardStrategyUnreachablePeer(t *testing.T) {
+	clientPrivateKey, _ := newKeyPair(t)
+	_, serverPublicKey := newKeyPair(t)
+
+	// 占用一个端口后关闭，保证没有对端在监听
+	udpConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := udpConn.LocalAddr().(*net.UDPAddr).Port
+	udpConn.Close()
+
+	profile := &types.ServerProfile{
+		ID: "wg-down", Address: "127.0.0.1", Port: port,
+		PrivateKey: clientPrivateKey, PublicKey: serverPublicKey,
+		LocalAddress: []string{testClientAddr.String()},
+	}
+	strategy, err := NewWireGuardStrategy(&types.Config{}, profile, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer strategy.CloseTunnel()
+	if err := strategy.CheckHealth(); err == nil {
+		t.Fatal("expected CheckHealth to fail without a handshake")
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	privateKey, publicKey := newKeyPair(t)
+	valid := func() *types.ServerProfile {
+		return &types.ServerProfile{
+			Address: "wg.example.com", Port: 51820,
+			PrivateKey: privateKey, PublicKey: publicKey,
+			LocalAddress: []string{"10.0.0.2/32", "fd00::2"},
+		}
+	}
+
+	cfg, err := parseConfig(valid())
+	if err != nil {
+		t.Fatalf("parseConfig() error = %v", err)
+	}
+	if cfg.mtu != defaultMTU || len(cfg.localAddresses) != 2 {
+		t.Errorf("unexpected defaults: mtu=%d addresses=%v", cfg.mtu, cfg.localAddresses)
+	}
+	for _, want := range []string{"endpoint=wg.example.com:51820\n", "allowed_ip=0.0.0.0/0\n", "allowed_ip=::/0\n"} {
+		if !strings.Contains(cfg.ipc, want) {
+			t.Errorf("ipc config missing %q:\n%s", want, cfg.ipc)
+		}
+	}
+
+	tests := map[string]func(p *types.ServerProfile){
+		"bad private key":   func(p *types.ServerProfile) { p.PrivateKey = "not-a-key" },
+		"short public key":  func(p *types.ServerProfile) { p.PublicKey = base64.StdEncoding.EncodeToString([]byte("short")) },
+		"no local address":  func(p *types.ServerProfile) { p.LocalAddress = nil },
+		"bad local address": func(p *types.ServerProfile) { p.LocalAddress = []string{"10.0.0.300"} },
+		"bad allowed ip":    func(p *types.ServerProfile) { p.AllowedIPs = []string{"10.0.0.0"} },
+		"bad mtu":           func(p *types.ServerProfile) { p.MTU = 100 },
+		"bad dns":           func(p *types.ServerProfile) { p.DNS = []string{"dns.example.com"} },
+		"no endpoint":       func(p *types.ServerProfile) { p.Port = 0 },
+	}
+	for name, mutate := range tests {
+		profile := valid()
+		mutate(profile)
+		if _, err := parseConfig(profile); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
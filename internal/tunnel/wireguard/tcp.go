@@ -0,0 +1,37 @@
+package wireguard
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// Initialize 启动一个本地 SOCKS5 监听器。
+// 这个方法主要用于移动端等传统的转发代理场景。
+func (s *WireGuardStrategy) Initialize() error { return s.relay.Listen(s.profile.LocalPort) }
+
+// GetSocksConnection 为 Gateway 提供一个内存中的 SOCKS5 连接。
+func (s *WireGuardStrategy) GetSocksConnection() (net.Conn, error) {
+	return s.relay.GetSocksConnection()
+}
+
+// HandleRawTCP 是处理透明代理 TCP 流量的入口。
+func (s *WireGuardStrategy) HandleRawTCP(inboundConn net.Conn, targetDest string) {
+	s.relay.HandleRawTCP(inboundConn, targetDest)
+}
+
+// dialTarget 经隧道内的网络栈连接 targetAddr。
+func (s *WireGuardStrategy) dialTarget(targetAddr string) (net.Conn, error) {
+	tun, err := s.getTunnel()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	addrPort, err := tun.resolve(ctx, targetAddr)
+	if err != nil {
+		return nil, err
+	}
+	return tun.tnet.DialContextTCPAddrPort(ctx, addrPort)
+}
@@ -0,0 +1,40 @@
+package wireguard
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"liuproxy_nexus/internal/shared/types"
+)
+
+// HandleUDPPacket 处理透明代理的 UDP 包：按 sessionKey 复用隧道网络栈内的 UDP 套接字，
+// 同一协议族的所有目标共用它。
+func (s *WireGuardStrategy) HandleUDPPacket(packet *types.UDPPacket, sessionKey string) error {
+	tun, err := s.getTunnel()
+	if err != nil {
+		s.logger.Error().Err(err).Msg("[WG-UDP] Failed to start wireguard device.")
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	dest, err := tun.resolve(ctx, packet.Destination.String())
+	if err != nil {
+		return err
+	}
+
+	// 隧道内的 UDP 套接字只能发往同一协议族的地址，IPv6 目标使用单独的会话
+	key := sessionKey
+	if dest.Addr().Is6() {
+		key += "/v6"
+	}
+	return s.relay.SendUDP(key, packet, net.UDPAddrFromAddrPort(dest), func() (net.PacketConn, error) {
+		local := tun.localAddr(dest.Addr())
+		if !local.IsValid() {
+			return nil, fmt.Errorf("no local address for %s", dest.Addr())
+		}
+		return tun.tnet.ListenUDPAddrPort(netip.AddrPortFrom(local, 0))
+	})
+}
@@ -3,8 +3,6 @@ package worker
 
 import (
 	"context"
-	"crypto/tls"
-	"crypto/x509"
 	"fmt"
 	"net"
 	"net/http"
@@ -13,11 +11,20 @@ import (
 
 	"github.com/gorilla/websocket"
 	"liuproxy_nexus/internal/shared"
+	"liuproxy_nexus/internal/shared/tlsclient"
+	"liuproxy_nexus/internal/shared/types"
 )
 
+// workerUserAgent 与默认的 chrome 指纹 (uTLS HelloChrome_Auto) 版本一致。
+const workerUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/133.0.0.0 Safari/537.36"
+
 // Dial 负责为 Worker 策略建立 WebSocket 连接。
-// 这个版本回退到了简单的Dialer逻辑，并增加了详细的证书诊断日志。
-func Dial(urlStr string, edgeIP string) (net.Conn, error) {
+// Edge IP 优选由 netDialer 实现 (见 edgeRaceDialer)，Host 始终使用 URL 中的主机名。
+// wss 的 TLS 握手由 tlsclient 按 tlsConfig 完成 (指纹、ALPN、ECH)；tlsConfig 为 nil 时以 URL 主机名为 SNI。
+// URL 带有 ed 参数时使用早期数据: 返回的连接在第一次写入时才拨号，见 shared.EarlyDataConn。
+// onDial 非 nil 时以握手结果调用，使用早期数据时在实际拨号之后 (第一次写入时) 调用。
+func Dial(netDialer types.Dialer, urlStr string, tlsConfig *tlsclient.Config, earlyDataDelay time.Duration, onDial func(err error)) (net.Conn, error) {
+	urlStr, maxEarlyData := shared.ParseEarlyData(urlStr)
 
 	parsedURL, err := url.Parse(urlStr)
 	if err != nil {
@@ -26,55 +33,52 @@ func Dial(urlStr string, edgeIP string) (net.Conn, error) {
 
 	requestHeader := http.Header{}
 	requestHeader.Set("Host", parsedURL.Hostname())
-	requestHeader.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/107.0.0.0 Safari/537.36")
+	requestHeader.Set("User-Agent", workerUserAgent)
 
 	dialer := *websocket.DefaultDialer
 	dialer.HandshakeTimeout = 15 * time.Second
 
-	// 我们仍然需要自定义NetDialContext来实现Edge IP
 	dialer.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
-		dialAddr := addr
-		if edgeIP != "" {
-			_, port, err := net.SplitHostPort(addr)
+		dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		return netDialer.DialContext(dialCtx, network, addr)
+	}
+
+	if parsedURL.Scheme == "wss" {
+		if tlsConfig == nil {
+			tlsConfig = &tlsclient.Config{ServerName: parsedURL.Hostname(), ALPN: []string{"http/1.1"}}
+		}
+		dialer.NetDialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dialer.NetDialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			tlsConn, err := tlsclient.Client(ctx, conn, tlsConfig)
 			if err != nil {
-				port = "443" // Default to 443 for wss if port is missing
+				conn.Close()
+				return nil, err
 			}
-			dialAddr = net.JoinHostPort(edgeIP, port)
+			return tlsConn, nil
 		}
-
-		d := &net.Dialer{Timeout: 10 * time.Second}
-		return d.DialContext(ctx, network, dialAddr)
 	}
 
-	// 我们使用TLSClientConfig来控制TLS握手
-	if parsedURL.Scheme == "wss" {
-		dialer.TLSClientConfig = &tls.Config{
-			ServerName: parsedURL.Hostname(), // 设置SNI
-			// --- 日志增强核心 ---
-			// 这个函数会在TLS握手成功后，证书验证之前被调用
-			// 让我们在这里打印出服务器到底给了我们什么证书
-			VerifyConnection: func(cs tls.ConnectionState) error {
-				// 在这里执行Go标准库默认的验证逻辑
-				opts := x509.VerifyOptions{
-					DNSName:       cs.ServerName,
-					Intermediates: x509.NewCertPool(),
-				}
-				for _, cert := range cs.PeerCertificates[1:] {
-					opts.Intermediates.AddCert(cert)
-				}
-				_, err := cs.PeerCertificates[0].Verify(opts)
-				if err != nil {
-					return err
-				}
-				return nil
-			},
+	dial := func(earlyData []byte) (net.Conn, error) {
+		header := requestHeader
+		if len(earlyData) > 0 {
+			header = requestHeader.Clone()
+			header.Set(shared.EarlyDataHeader, shared.EncodeEarlyData(earlyData))
+		}
+		ws, _, err := dialer.Dial(urlStr, header)
+		if onDial != nil {
+			onDial(err)
+		}
+		if err != nil {
+			return nil, err
 		}
+		return shared.NewWebSocketConnAdapter(ws), nil
 	}
-
-	ws, _, err := dialer.Dial(urlStr, requestHeader)
-	if err != nil {
-		return nil, err
+	if maxEarlyData > 0 {
+		return shared.NewEarlyDataConn(maxEarlyData, earlyDataDelay, dial), nil
 	}
-
-	return shared.NewWebSocketConnAdapter(ws), nil
+	return dial(nil)
 }
@@ -0,0 +1,387 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/rs/zerolog"
+
+	"liuproxy_nexus/internal/shared/tlsclient"
+	"liuproxy_nexus/internal/shared/types"
+)
+
+// Edge IP 优选: profile.EdgeIP 可以是单个 IP (或域名)，也可以是逗号/空白分隔的 IP、CIDR 列表，
+// 例如 "104.16.0.0/24, 172.64.32.1"。有多个候选时，后台扫描器定期测量到候选 IP 的 TCP+TLS
+// 握手延迟并维护排序列表；拨号时对排名最前的几个候选进行 happy-eyeballs 式竞速，
+// 某个 IP 变差时自动换用其他 IP，而不是把整个服务器标记为不可用。
+const (
+	edgeRaceCandidates  = 3                      // 每次拨号参与竞速的候选数
+	edgeRaceDelay       = 300 * time.Millisecond // 前一个候选未完成时启动下一个的间隔
+	edgeScanInterval    = 10 * time.Minute
+	edgeScanSamples     = 32 // 每轮从 CIDR 中随机抽取的地址数
+	edgeScanConcurrency = 8
+	edgeScanTimeout     = 3 * time.Second
+	edgeRankedSize      = 16 // 保留的排序列表长度
+)
+
+// parseEdgeIPs 解析 EdgeIP 配置，返回单独列出的主机和 CIDR 网段。无法解析为 IP 的项按域名处理。
+func parseEdgeIPs(spec string) ([]string, []netip.Prefix, error) {
+	var hosts []string
+	var prefixes []netip.Prefix
+	fields := strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == ';' || unicode.IsSpace(r) })
+	for _, field := range fields {
+		if !strings.Contains(field, "/") {
+			if addr, err := netip.ParseAddr(field); err == nil {
+				field = addr.Unmap().String()
+			}
+			if !slices.Contains(hosts, field) {
+				hosts = append(hosts, field)
+			}
+			continue
+		}
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, nil, fmt.Errorf("worker: invalid edge IP range %q: %w", field, err)
+		}
+		prefix = prefix.Masked()
+		if prefix.IsSingleIP() {
+			hosts = append(hosts, prefix.Addr().String())
+		} else {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return hosts, prefixes, nil
+}
+
+// randomAddr 返回网段内的一个随机地址。
+func randomAddr(prefix netip.Prefix) netip.Addr {
+	b := prefix.Addr().AsSlice()
+	bits := prefix.Bits()
+	for i := range b {
+		switch {
+		case bits >= 8:
+			bits -= 8
+		case bits > 0:
+			mask := byte(0xFF) >> bits
+			b[i] = b[i]&^mask | byte(rand.UintN(256))&mask
+			bits = 0
+		default:
+			b[i] = byte(rand.UintN(256))
+		}
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+// edgeScanner 维护一个 profile 的候选 Edge IP 及其延迟排序。
+type edgeScanner struct {
+	hosts    []string
+	prefixes []netip.Prefix
+	probe    func(ctx context.Context, ip string) error
+	logger   zerolog.Logger
+
+	mu     sync.Mutex
+	ranked []types.EdgeIPStat // 成功的按延迟升序在前，失败的 (Latency = -1) 在后
+
+	rescan chan struct{}
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newEdgeScanner(spec string, probe func(ctx context.Context, ip string) error, logger zerolog.Logger) (*edgeScanner, error) {
+	hosts, prefixes, err := parseEdgeIPs(spec)
+	if err != nil {
+		return nil, err
+	}
+	return &edgeScanner{
+		hosts:    hosts,
+		prefixes: prefixes,
+		probe:    probe,
+		logger:   logger,
+		rescan:   make(chan struct{}, 1),
+	}, nil
+}
+
+// scanning 表示是否有多个候选需要扫描。只配置一个 IP 时保持原来的直接拨号行为。
+func (e *edgeScanner) scanning() bool {
+	return len(e.prefixes) > 0 || len(e.hosts) > 1
+}
+
+// start 在有多个候选时启动后台扫描，立即进行第一轮。
+func (e *edgeScanner) start() {
+	if !e.scanning() {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	e.cancel = cancel
+	e.wg.Add(1)
+	go e.scanLoop(ctx)
+}
+
+func (e *edgeScanner) stop() {
+	if e.cancel != nil {
+		e.cancel()
+	}
+	e.wg.Wait()
+}
+
+func (e *edgeScanner) scanLoop(ctx context.Context) {
+	defer e.wg.Done()
+	ticker := time.NewTicker(edgeScanInterval)
+	defer ticker.Stop()
+	for {
+		e.scan(ctx)
+		select {
+		case <-ticker.C:
+		case <-e.rescan:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// scan 测量配置的主机、当前排名中的 IP 和从网段中新抽取的地址，重新排序。
+func (e *edgeScanner) scan(ctx context.Context) {
+	e.mu.Lock()
+	candidates := slices.Clone(e.hosts)
+	for _, stat := range e.ranked {
+		if !slices.Contains(candidates, stat.IP) {
+			candidates = append(candidates, stat.IP)
+		}
+	}
+	e.mu.Unlock()
+	for i := 0; i < edgeScanSamples && len(e.prefixes) > 0; i++ {
+		ip := randomAddr(e.prefixes[rand.IntN(len(e.prefixes))]).String()
+		if !slices.Contains(candidates, ip) {
+			candidates = append(candidates, ip)
+		}
+	}
+
+	results := make([]types.EdgeIPStat, len(candidates))
+	sem := make(chan struct{}, edgeScanConcurrency)
+	var wg sync.WaitGroup
+	for i, ip := range candidates {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			probeCtx, cancel := context.WithTimeout(ctx, edgeScanTimeout)
+			defer cancel()
+			start := time.Now()
+			results[i] = types.EdgeIPStat{IP: ip, Latency: -1}
+			if err := e.probe(probeCtx, ip); err == nil {
+				results[i].Latency = time.Since(start).Milliseconds()
+			}
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	sortEdgeStats(results)
+	if len(results) > edgeRankedSize {
+		results = results[:edgeRankedSize]
+	}
+	e.mu.Lock()
+	e.ranked = results
+	e.mu.Unlock()
+	if len(results) > 0 {
+		e.logger.Debug().Str("best_ip", results[0].IP).Int64("latency_ms", results[0].Latency).
+			Int("candidates", len(candidates)).Msg("[Edge] Scan finished.")
+	}
+}
+
+func sortEdgeStats(stats []types.EdgeIPStat) {
+	slices.SortStableFunc(stats, func(a, b types.EdgeIPStat) int {
+		switch {
+		case a.Latency < 0 && b.Latency < 0:
+			return 0
+		case a.Latency < 0:
+			return 1
+		case b.Latency < 0:
+			return -1
+		}
+		return int(a.Latency - b.Latency)
+	})
+}
+
+// candidates 返回最多 n 个拨号候选：优先使用扫描结果中可用的 IP，
+// 尚无结果 (或全部失败) 时依次使用配置的主机和网段中的随机地址。
+func (e *edgeScanner) candidates(n int) []string {
+	var out []string
+	e.mu.Lock()
+	for _, stat := range e.ranked {
+		if len(out) < n && stat.Latency >= 0 {
+			out = append(out, stat.IP)
+		}
+	}
+	e.mu.Unlock()
+	for _, host := range e.hosts {
+		if len(out) < n && !slices.Contains(out, host) {
+			out = append(out, host)
+		}
+	}
+	for i := 0; len(out) < n && len(e.prefixes) > 0 && i < n; i++ {
+		ip := randomAddr(e.prefixes[rand.IntN(len(e.prefixes))]).String()
+		if !slices.Contains(out, ip) {
+			out = append(out, ip)
+		}
+	}
+	return out
+}
+
+// report 记录一次拨号失败：该 IP 排到最后，没有可用 IP 时立即重新扫描。
+func (e *edgeScanner) report(ip string, err error) {
+	if !e.scanning() {
+		return
+	}
+	e.mu.Lock()
+	usable := 0
+	for i := range e.ranked {
+		if e.ranked[i].IP == ip {
+			e.ranked[i].Latency = -1
+		}
+		if e.ranked[i].Latency >= 0 {
+			usable++
+		}
+	}
+	sortEdgeStats(e.ranked)
+	e.mu.Unlock()
+	e.logger.Debug().Err(err).Str("edge_ip", ip).Msg("[Edge] Dial failed, demoting edge IP.")
+	if usable == 0 {
+		select {
+		case e.rescan <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// snapshot 返回当前排序列表的副本，只有多个候选时才有内容。
+func (e *edgeScanner) snapshot() []types.EdgeIPStat {
+	if !e.scanning() {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return slices.Clone(e.ranked)
+}
+
+// newEdgeProbe 返回测量单个 Edge IP 的函数：TCP 连接，wss 时再按 tlsConfig 完成 TLS 握手 (与建立隧道时的指纹相同)。
+func newEdgeProbe(dialer types.Dialer, port string, tlsConfig *tlsclient.Config) func(ctx context.Context, ip string) error {
+	return func(ctx context.Context, ip string) error {
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip, port))
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if tlsConfig == nil {
+			return nil
+		}
+		_, err = tlsclient.Client(ctx, conn, tlsConfig)
+		return err
+	}
+}
+
+// edgeRaceDialer 把到 Worker 主机的拨号替换为对候选 Edge IP 的竞速拨号。每次建立隧道使用一个新实例，
+// chosen 记录获胜的 IP，以便之后的 TLS / WebSocket 握手失败时降低它的排名。
+type edgeRaceDialer struct {
+	dialer  types.Dialer
+	scanner *edgeScanner
+	chosen  string
+}
+
+func (d *edgeRaceDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		port = "443" // Default to 443 for wss if port is missing
+	}
+	ips := d.scanner.candidates(edgeRaceCandidates)
+	if len(ips) == 0 {
+		return d.dialer.DialContext(ctx, network, addr)
+	}
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = net.JoinHostPort(ip, port)
+	}
+	conn, winner, err := raceDial(ctx, d.dialer, network, addrs, func(addr string, err error) {
+		host, _, _ := net.SplitHostPort(addr)
+		d.scanner.report(host, err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	d.chosen, _, _ = net.SplitHostPort(winner)
+	return conn, nil
+}
+
+// raceDial 依次启动对 addrs 的拨号：前一个在 edgeRaceDelay 内未完成或已失败时启动下一个，
+// 返回第一个成功的连接和地址，其余连接被取消或关闭。失败的地址通过 report 报告。
+func raceDial(ctx context.Context, dialer types.Dialer, network string, addrs []string, report func(addr string, err error)) (net.Conn, string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn net.Conn
+		addr string
+		err  error
+	}
+	results := make(chan result, len(addrs))
+	next, pending := 0, 0
+	startNext := func() {
+		addr := addrs[next]
+		next++
+		pending++
+		go func() {
+			conn, err := dialer.DialContext(ctx, network, addr)
+			results <- result{conn: conn, addr: addr, err: err}
+		}()
+	}
+	startNext()
+	timer := time.NewTimer(edgeRaceDelay)
+	defer timer.Stop()
+
+	var firstErr error
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				// 关闭晚到的连接
+				go func(n int) {
+					for ; n > 0; n-- {
+						if late := <-results; late.conn != nil {
+							late.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, r.addr, nil
+			}
+			if ctx.Err() == nil {
+				report(r.addr, r.err)
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if next < len(addrs) {
+				startNext()
+				timer.Reset(edgeRaceDelay)
+			}
+		case <-timer.C:
+			if next < len(addrs) {
+				startNext()
+				timer.Reset(edgeRaceDelay)
+			}
+		}
+	}
+	return nil, "", firstErr
+}
@@ -0,0 +1,172 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestParseEdgeIPs(t *testing.T) {
+	hosts, prefixes, err := parseEdgeIPs("104.16.1.1, 104.16.0.0/24;172.64.1.1/32 edge.example.com\n2606:4700::/120 104.16.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"104.16.1.1", "172.64.1.1", "edge.example.com"}; !slices.Equal(hosts, want) {
+		t.Errorf("hosts = %v, want %v", hosts, want)
+	}
+	want := []netip.Prefix{netip.MustParsePrefix("104.16.0.0/24"), netip.MustParsePrefix("2606:4700::/120")}
+	if !slices.Equal(prefixes, want) {
+		t.Errorf("prefixes = %v, want %v", prefixes, want)
+	}
+
+	if _, _, err := parseEdgeIPs("104.16.0.0/33"); err == nil {
+		t.Error("invalid CIDR accepted")
+	}
+}
+
+func TestRandomAddr(t *testing.T) {
+	for _, s := range []string{"104.16.0.0/20", "10.0.0.0/8", "2606:4700::/100"} {
+		prefix := netip.MustParsePrefix(s)
+		for i := 0; i < 100; i++ {
+			if addr := randomAddr(prefix); !prefix.Contains(addr) {
+				t.Fatalf("randomAddr(%s) = %s, outside the prefix", prefix, addr)
+			}
+		}
+	}
+}
+
+// fakeDialer 按地址返回预设的延迟和错误。
+type fakeDialer struct {
+	delays map[string]time.Duration
+	errs   map[string]error
+}
+
+func (d *fakeDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	select {
+	case <-time.After(d.delays[addr]):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if err := d.errs[addr]; err != nil {
+		return nil, err
+	}
+	client, server := net.Pipe()
+	server.Close()
+	return client, nil
+}
+
+func TestRaceDial(t *testing.T) {
+	addrs := []string{"1.1.1.1:443", "2.2.2.2:443", "3.3.3.3:443"}
+	tests := []struct {
+		name     string
+		dialer   *fakeDialer
+		winner   string
+		reported []string
+	}{
+		{"first wins", &fakeDialer{}, "1.1.1.1:443", nil},
+		{"failure starts the next at once", &fakeDialer{
+			errs: map[string]error{"1.1.1.1:443": errors.New("refused")},
+		}, "2.2.2.2:443", []string{"1.1.1.1:443"}},
+		{"slow first is overtaken", &fakeDialer{
+			delays: map[string]time.Duration{"1.1.1.1:443": 5 * time.Second},
+		}, "2.2.2.2:443", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var reported []string
+			start := time.Now()
+			conn, winner, err := raceDial(context.Background(), tt.dialer, "tcp", addrs, func(addr string, err error) {
+				mu.Lock()
+				reported = append(reported, addr)
+				mu.Unlock()
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn.Close()
+			if winner != tt.winner {
+				t.Errorf("winner = %s, want %s", winner, tt.winner)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("race took %v", elapsed)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if !slices.Equal(reported, tt.reported) {
+				t.Errorf("reported = %v, want %v", reported, tt.reported)
+			}
+		})
+	}
+
+	t.Run("all fail", func(t *testing.T) {
+		d := &fakeDialer{errs: map[string]error{}}
+		for _, addr := range addrs {
+			d.errs[addr] = errors.New("refused " + addr)
+		}
+		if _, _, err := raceDial(context.Background(), d, "tcp", addrs, func(string, error) {}); err == nil {
+			t.Fatal("raceDial() succeeded with no reachable address")
+		}
+	})
+}
+
+func TestEdgeScannerRanking(t *testing.T) {
+	latency := map[string]time.Duration{"1.1.1.1": 30 * time.Millisecond, "2.2.2.2": 5 * time.Millisecond}
+	probe := func(ctx context.Context, ip string) error {
+		d, ok := latency[ip]
+		if !ok {
+			return errors.New("unreachable")
+		}
+		time.Sleep(d)
+		return nil
+	}
+	e, err := newEdgeScanner("1.1.1.1, 2.2.2.2, 3.3.3.3", probe, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 扫描之前按配置顺序
+	if got := e.candidates(2); !slices.Equal(got, []string{"1.1.1.1", "2.2.2.2"}) {
+		t.Errorf("candidates before scan = %v", got)
+	}
+
+	e.scan(context.Background())
+	ranked := e.snapshot()
+	if len(ranked) != 3 || ranked[0].IP != "2.2.2.2" || ranked[1].IP != "1.1.1.1" || ranked[2].Latency != -1 {
+		t.Fatalf("ranking = %+v", ranked)
+	}
+	if got := e.candidates(3); !slices.Equal(got, []string{"2.2.2.2", "1.1.1.1", "3.3.3.3"}) {
+		t.Errorf("candidates after scan = %v", got)
+	}
+
+	// 拨号失败的 IP 排到最后
+	e.report("2.2.2.2", errors.New("handshake failed"))
+	if got := e.candidates(1); !slices.Equal(got, []string{"1.1.1.1"}) {
+		t.Errorf("candidates after report = %v", got)
+	}
+}
+
+func TestEdgeScannerSingleIP(t *testing.T) {
+	e, err := newEdgeScanner("104.16.1.1", nil, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.scanning() {
+		t.Error("a single edge IP should not be scanned")
+	}
+	e.start()
+	defer e.stop()
+	if got := e.candidates(edgeRaceCandidates); !slices.Equal(got, []string{"104.16.1.1"}) {
+		t.Errorf("candidates = %v", got)
+	}
+	if e.snapshot() != nil {
+		t.Error("snapshot should be empty for a single edge IP")
+	}
+}
@@ -3,6 +3,7 @@ package worker
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"liuproxy_nexus/internal/shared"
 	"liuproxy_nexus/internal/shared/globalstate"
 	protocol2 "liuproxy_nexus/internal/shared/protocol"
@@ -17,7 +18,7 @@ func (s *WorkerStrategy) Initialize() error {
 	addr := fmt.Sprintf("127.0.0.1:%d", s.profile.LocalPort)
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
-		return fmt.Errorf("worker strategy failed to listen on %s: %w", err)
+		return fmt.Errorf("worker strategy failed to listen on %s: %w", addr, err)
 	}
 	s.listener = listener
 
@@ -77,6 +78,11 @@ func (s *WorkerStrategy) handleClientConnection(plainConn net.Conn) {
 		return
 	}
 
+	if s.profile.Multiplex {
+		s.relayMux(plainConn, targetAddr, true)
+		return
+	}
+
 	//l.Debug().Msg("Creating tunnel...")
 	tunnelConn, cipher, err := s.createTunnel()
 	if err != nil {
@@ -176,3 +182,44 @@ func (s *WorkerStrategy) handleClientConnection(plainConn net.Conn) {
 	}()
 	wg.Wait()
 }
+
+// relayMux 在多路复用会话上打开一个流并双向转发。NewStream 请求和数据一起发出，不等待 Worker 应答；
+// socksReply 为 true 时先向 SOCKS5 客户端回复成功。
+func (s *WorkerStrategy) relayMux(inbound net.Conn, targetAddr string, socksReply bool) {
+	l := s.logger.With().Str("func", "relayMux").Logger()
+	stream, err := s.openStream(targetAddr)
+	if err != nil {
+		l.Error().Err(err).Msg("Failed to open mux stream.")
+		if s.stateManager != nil {
+			s.stateManager.SetServerStatusDown(s.profile.ID, err.Error())
+		}
+		return
+	}
+	defer stream.Close()
+
+	if socksReply {
+		// 与单流模式相同，在 goroutine 中回复，避免管道对端尚未读取时阻塞
+		go func() {
+			if _, err := inbound.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0}); err != nil {
+				l.Error().Err(err).Msg("Failed to write SOCKS5 success reply.")
+				inbound.Close()
+			}
+		}()
+	}
+	globalstate.GlobalStatus.Set(fmt.Sprintf("Connected (Worker via %s)", s.profile.Address))
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		// 协议没有半关闭，客户端关闭写方向时关闭整个流 (与单流模式相同)
+		defer stream.Close()
+		io.Copy(stream, inbound)
+	}()
+	go func() {
+		defer wg.Done()
+		defer inbound.Close()
+		io.Copy(inbound, stream)
+	}()
+	wg.Wait()
+}
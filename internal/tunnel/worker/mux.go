@@ -0,0 +1,426 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog"
+
+	"liuproxy_nexus/internal/shared"
+	"liuproxy_nexus/internal/shared/muxpool"
+	protocol2 "liuproxy_nexus/internal/shared/protocol"
+	"liuproxy_nexus/internal/shared/securecrypt"
+)
+
+// Worker 多路复用: 一个 WebSocket 隧道上承载多个逻辑流，使用 v2.2 协议的 StreamID 区分。
+// 上行包由会话的 Cipher 加密，下行包为明文，与单流模式相同:
+//
+//	FlagControlNewStreamTCP   新建流，负载为目标地址。不等待应答即可发送数据
+//	FlagControlNewStreamTCPSuccess  Worker 已连接目标
+//	FlagTCPData               流数据
+//	FlagControlCloseStream    任一方向关闭流
+//	FlagControlWindowUpdate   本端归还下行窗口，负载为 4 字节大端序的增量
+//
+// 下行方向按流做信用流控: 每个流打开时 Worker 有 muxStreamWindow 字节的发送窗口，
+// 本地读取方每读出半个窗口就通过 WindowUpdate 归还，Worker 窗口用完时暂停该流的发送。
+// 这样读取慢的流只会让自己停下，会话的读协程从不阻塞，同一会话上的其他流不受影响。
+// 不遵守窗口的 Worker (例如不认识 WindowUpdate 的旧脚本) 超出窗口时该流被重置。
+const (
+	muxStreamWindow      = 1 << 20   // 每个流的初始接收窗口，也是接收缓冲的上限
+	muxMaxPayload        = 16 * 1024 // 上行数据包的最大负载
+	muxKeepAliveInterval = 30 * time.Second
+	muxKeepAliveTimeout  = 90 * time.Second
+)
+
+// Worker 的 WebSocket 在部署或实例回收时会被断开，会话存活时间比 goremote 短
+var muxPoolConfig = muxpool.Config{
+	MaxAge:     10 * time.Minute,
+	MaxStreams: 1024, // 累计流数，远小于 StreamID 空间，ID 不会很快被复用
+	MaxActive:  128,  // 单个会话的并发流上限，超出时临时建立额外的会话
+}
+
+var (
+	errSessionClosed = errors.New("worker: mux session closed")
+	errStreamReset   = errors.New("worker: stream reset")
+	errNoStreamID    = errors.New("worker: no free stream ID")
+)
+
+// muxSession 是一个承载多个流的 Worker 隧道，实现 muxpool.Session。
+type muxSession struct {
+	conn   net.Conn
+	cipher *securecrypt.Cipher
+	logger zerolog.Logger
+
+	writeMu sync.Mutex // 上行包按顺序加密写出
+
+	mu      sync.Mutex
+	streams map[uint16]*muxStream
+	nextID  uint16
+
+	lastRead atomic.Int64
+	die      chan struct{}
+	dieOnce  sync.Once
+}
+
+func newMuxSession(conn net.Conn, cipher *securecrypt.Cipher, logger zerolog.Logger) *muxSession {
+	s := &muxSession{
+		conn:    conn,
+		cipher:  cipher,
+		logger:  logger,
+		streams: make(map[uint16]*muxStream),
+		die:     make(chan struct{}),
+	}
+	s.lastRead.Store(time.Now().UnixNano())
+	go s.readLoop()
+	return s
+}
+
+// newMuxPool 创建 Worker 隧道的会话池。
+func (s *WorkerStrategy) newMuxPool(size int) *muxpool.Pool[*muxSession] {
+	config := muxPoolConfig
+	config.Size = size
+	return muxpool.New(config, func() (*muxSession, error) {
+		conn, cipher, err := s.createTunnel()
+		if err != nil {
+			return nil, err
+		}
+		return newMuxSession(conn, cipher, s.logger), nil
+	}, s.logger)
+}
+
+// openStream 在会话池中流数最少的会话上打开一个到 targetAddr 的流。
+func (s *WorkerStrategy) openStream(targetAddr string) (*muxStream, error) {
+	var stream *muxStream
+	err := s.mux.Open(func(session *muxSession) (err error) {
+		stream, err = session.openStream(targetAddr)
+		return err
+	})
+	return stream, err
+}
+
+// webSocketOf 返回隧道底层的 WebSocket 连接，用于发送 ping。
+// 使用早期数据的隧道在第一次写入时才建立，此时会等待拨号完成。
+func webSocketOf(conn net.Conn) *websocket.Conn {
+	if pc, ok := conn.(*prefixConn); ok {
+		conn = pc.Conn
+	}
+	if ec, ok := conn.(*shared.EarlyDataConn); ok {
+		var err error
+		if conn, err = ec.Conn(); err != nil {
+			return nil
+		}
+	}
+	if adapter, ok := conn.(*shared.WebSocketConnAdapter); ok {
+		return adapter.Conn
+	}
+	return nil
+}
+
+// keepAlive 定期发送 WebSocket ping，长时间没有收到任何数据或 pong 时关闭会话。
+// Cloudflare 会关闭长时间空闲的 WebSocket，ping 同时也避免这种情况。
+func (s *muxSession) keepAlive(ws *websocket.Conn) {
+	ticker := time.NewTicker(muxKeepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.die:
+			return
+		}
+		if time.Since(time.Unix(0, s.lastRead.Load())) > muxKeepAliveTimeout {
+			s.logger.Warn().Msg("[Mux] Keepalive timeout, closing session.")
+			s.Close()
+			return
+		}
+		if err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+			s.Close()
+			return
+		}
+	}
+}
+
+// openStream 分配 StreamID 并发送 NewStream 请求。不等待 Worker 应答，数据可以立即写入。
+func (s *muxSession) openStream(targetAddr string) (*muxStream, error) {
+	s.mu.Lock()
+	if s.IsClosed() {
+		s.mu.Unlock()
+		return nil, errSessionClosed
+	}
+	id, ok := s.allocateIDLocked()
+	if !ok {
+		s.mu.Unlock()
+		return nil, errNoStreamID
+	}
+	stream := &muxStream{
+		id:       id,
+		session:  s,
+		readable: make(chan struct{}, 1),
+	}
+	s.streams[id] = stream
+	s.mu.Unlock()
+
+	packet := protocol2.Packet{StreamID: id, Flag: protocol2.FlagControlNewStreamTCP, Payload: buildMetadataForWorker(1, targetAddr)}
+	if err := s.writePacket(&packet); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return stream, nil
+}
+
+func (s *muxSession) allocateIDLocked() (uint16, bool) {
+	for i := 0; i < 0xFFFF; i++ {
+		s.nextID++
+		if s.nextID == 0 {
+			continue
+		}
+		if _, used := s.streams[s.nextID]; !used {
+			return s.nextID, true
+		}
+	}
+	return 0, false
+}
+
+func (s *muxSession) writePacket(p *protocol2.Packet) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return protocol2.WriteSecurePacket(s.conn, p, s.cipher)
+}
+
+// readLoop 把下行包分发到各个流。
+func (s *muxSession) readLoop() {
+	defer s.Close()
+	if ws := webSocketOf(s.conn); ws != nil {
+		// pong 在读协程中处理，同样算作会话存活。在开始读取之前设置，避免与读协程竞争
+		ws.SetPongHandler(func(string) error {
+			s.lastRead.Store(time.Now().UnixNano())
+			return nil
+		})
+		go s.keepAlive(ws)
+	}
+	for {
+		packet, err := protocol2.ReadUnsecurePacket(s.conn)
+		if err != nil {
+			if !s.IsClosed() {
+				s.logger.Debug().Err(err).Msg("[Mux] Session read failed.")
+			}
+			return
+		}
+		s.lastRead.Store(time.Now().UnixNano())
+
+		s.mu.Lock()
+		stream := s.streams[packet.StreamID]
+		s.mu.Unlock()
+		if stream == nil {
+			// 本端已关闭的流，Worker 收到 CloseStream 前发出的数据
+			continue
+		}
+		switch packet.Flag {
+		case protocol2.FlagControlNewStreamTCPSuccess:
+		case protocol2.FlagTCPData:
+			stream.push(packet.Payload)
+		case protocol2.FlagControlCloseStream:
+			s.removeStream(stream)
+			stream.remoteClose(io.EOF)
+		}
+	}
+}
+
+func (s *muxSession) removeStream(stream *muxStream) {
+	s.mu.Lock()
+	if s.streams[stream.id] == stream {
+		delete(s.streams, stream.id)
+	}
+	s.mu.Unlock()
+}
+
+// NumStreams 返回当前打开的流数。
+func (s *muxSession) NumStreams() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.streams)
+}
+
+func (s *muxSession) IsClosed() bool {
+	select {
+	case <-s.die:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *muxSession) CloseChan() <-chan struct{} {
+	return s.die
+}
+
+// Close 关闭隧道，会话上的流读完已缓冲的数据后返回 errSessionClosed。
+func (s *muxSession) Close() error {
+	s.dieOnce.Do(func() {
+		close(s.die)
+		s.conn.Close()
+		s.mu.Lock()
+		streams := s.streams
+		s.streams = make(map[uint16]*muxStream)
+		s.mu.Unlock()
+		for _, stream := range streams {
+			stream.remoteClose(errSessionClosed)
+		}
+	})
+	return nil
+}
+
+// muxStream 是会话上的一个逻辑流，实现 io.ReadWriteCloser。
+type muxStream struct {
+	id      uint16
+	session *muxSession
+
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	consumed int   // 已读出、尚未通过 WindowUpdate 归还的字节数
+	rerr     error // 远端关闭 (io.EOF) 或会话关闭
+	closed   bool
+	readable chan struct{}
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func (st *muxStream) Read(b []byte) (int, error) {
+	for {
+		st.mu.Lock()
+		switch {
+		case st.closed:
+			st.mu.Unlock()
+			return 0, io.ErrClosedPipe
+		case st.buf.Len() > 0:
+			n, _ := st.buf.Read(b)
+			st.consumed += n
+			var increment int
+			if st.consumed >= muxStreamWindow/2 && st.rerr == nil {
+				increment, st.consumed = st.consumed, 0
+			}
+			st.mu.Unlock()
+			if increment > 0 {
+				st.updateWindow(increment)
+			}
+			return n, nil
+		case st.rerr != nil:
+			err := st.rerr
+			st.mu.Unlock()
+			return 0, err
+		}
+		st.mu.Unlock()
+		<-st.readable
+	}
+}
+
+// updateWindow 把读出的 increment 字节归还给 Worker。
+func (st *muxStream) updateWindow(increment int) {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(increment))
+	packet := protocol2.Packet{StreamID: st.id, Flag: protocol2.FlagControlWindowUpdate, Payload: payload}
+	if err := st.session.writePacket(&packet); err != nil {
+		st.session.Close()
+	}
+}
+
+// push 由会话读协程调用，不阻塞。Worker 超出窗口发送时重置该流。
+func (st *muxStream) push(payload []byte) {
+	st.mu.Lock()
+	if st.closed || st.rerr != nil {
+		st.mu.Unlock()
+		return
+	}
+	if st.buf.Len()+st.consumed+len(payload) > muxStreamWindow {
+		st.mu.Unlock()
+		st.session.logger.Warn().Uint16("stream_id", st.id).Msg("[Mux] Worker exceeded the stream window, resetting.")
+		st.reset()
+		return
+	}
+	st.buf.Write(payload)
+	st.mu.Unlock()
+	notify(st.readable)
+}
+
+func (st *muxStream) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		st.mu.Lock()
+		closed, rerr := st.closed, st.rerr
+		st.mu.Unlock()
+		if closed || rerr != nil {
+			return written, io.ErrClosedPipe
+		}
+
+		chunk := b
+		if len(chunk) > muxMaxPayload {
+			chunk = chunk[:muxMaxPayload]
+		}
+		packet := protocol2.Packet{StreamID: st.id, Flag: protocol2.FlagTCPData, Payload: chunk}
+		if err := st.session.writePacket(&packet); err != nil {
+			st.session.Close()
+			return written, err
+		}
+		written += len(chunk)
+		b = b[len(chunk):]
+	}
+	return written, nil
+}
+
+// Close 关闭流，远端尚未关闭时发送 CloseStream。
+func (st *muxStream) Close() error {
+	st.mu.Lock()
+	if st.closed {
+		st.mu.Unlock()
+		return nil
+	}
+	st.closed = true
+	remoteClosed := st.rerr != nil
+	st.buf.Reset()
+	st.mu.Unlock()
+	notify(st.readable)
+
+	st.session.removeStream(st)
+	if !remoteClosed && !st.session.IsClosed() {
+		packet := protocol2.Packet{StreamID: st.id, Flag: protocol2.FlagControlCloseStream}
+		if err := st.session.writePacket(&packet); err != nil {
+			st.session.Close()
+		}
+	}
+	return nil
+}
+
+// reset 关闭超出窗口的流，本端的读写立即失败。CloseStream 在另一个协程中发送，不阻塞读协程。
+func (st *muxStream) reset() {
+	st.remoteClose(errStreamReset)
+	st.session.removeStream(st)
+	packet := protocol2.Packet{StreamID: st.id, Flag: protocol2.FlagControlCloseStream}
+	go func() {
+		if err := st.session.writePacket(&packet); err != nil {
+			st.session.Close()
+		}
+	}()
+}
+
+func (st *muxStream) remoteClose(err error) {
+	st.mu.Lock()
+	if st.rerr == nil {
+		st.rerr = err
+	}
+	if err == errStreamReset {
+		st.buf.Reset()
+	}
+	st.mu.Unlock()
+	notify(st.readable)
+}
@@ -0,0 +1,425 @@
+package worker
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog"
+
+	"liuproxy_nexus/internal/shared"
+	protocol2 "liuproxy_nexus/internal/shared/protocol"
+	"liuproxy_nexus/internal/shared/securecrypt"
+	"liuproxy_nexus/internal/shared/testutil"
+	"liuproxy_nexus/internal/shared/types"
+)
+
+// fakeWorker 模拟 Worker 的多路复用端: 目标 "echo" 回显数据，"flood" 连接后按窗口发送
+// floodSize 字节再关闭流，"overrun" 不理会窗口持续发送，"refuse" 连接后立即关闭流。
+// 收到的 CloseStream 记录在 closed 中。
+type fakeWorker struct {
+	mu     sync.Mutex
+	conns  []net.Conn
+	closed chan uint16
+}
+
+// floodSize 是 "flood" 目标发送的数据量，远大于流的窗口。
+const floodSize = 4 * muxStreamWindow
+
+// floodPayload 返回 "flood" 目标发送的内容。
+func floodPayload() []byte {
+	data := make([]byte, floodSize)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	return data
+}
+
+// sendWindow 是 Worker 一侧某个流的剩余发送窗口。
+type sendWindow struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	avail  int
+	closed bool
+}
+
+func newSendWindow() *sendWindow {
+	w := &sendWindow{avail: muxStreamWindow}
+	w.cond = sync.NewCond(&w.mu)
+	return w
+}
+
+// take 等待窗口中有 n 字节可用并扣除，流关闭时返回 false。
+func (w *sendWindow) take(n int) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for w.avail < n && !w.closed {
+		w.cond.Wait()
+	}
+	w.avail -= n
+	return !w.closed
+}
+
+func (w *sendWindow) update(increment int, closed bool) {
+	w.mu.Lock()
+	w.avail += increment
+	w.closed = w.closed || closed
+	w.mu.Unlock()
+	w.cond.Broadcast()
+}
+
+func newFakeWorker() *fakeWorker {
+	return &fakeWorker{closed: make(chan uint16, 64)}
+}
+
+func (w *fakeWorker) dial() (net.Conn, *securecrypt.Cipher, error) {
+	cipher, err := securecrypt.NewCipher(125)
+	if err != nil {
+		return nil, nil, err
+	}
+	client, server := net.Pipe()
+	w.mu.Lock()
+	w.conns = append(w.conns, server)
+	w.mu.Unlock()
+	go w.serve(server, cipher)
+	return client, cipher, nil
+}
+
+func (w *fakeWorker) conn(i int) net.Conn {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.conns[i]
+}
+
+func (w *fakeWorker) serve(conn net.Conn, cipher *securecrypt.Cipher) {
+	var writeMu sync.Mutex
+	write := func(p protocol2.Packet) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		protocol2.WritePacket(conn, &p)
+	}
+	windows := make(map[uint16]*sendWindow) // 只在读协程中访问
+	defer func() {
+		conn.Close()
+		for _, window := range windows {
+			window.update(0, true)
+		}
+	}()
+	for {
+		packet, err := protocol2.ReadSecurePacket(conn, cipher)
+		if err != nil {
+			return
+		}
+		window := windows[packet.StreamID]
+		switch packet.Flag {
+		case protocol2.FlagControlNewStreamTCP:
+			window = newSendWindow()
+			windows[packet.StreamID] = window
+			write(protocol2.Packet{StreamID: packet.StreamID, Flag: protocol2.FlagControlNewStreamTCPSuccess})
+			switch {
+			case bytes.Contains(packet.Payload, []byte("flood")):
+				go func(id uint16) {
+					data := floodPayload()
+					for len(data) > 0 {
+						chunk := data[:min(len(data), 16*1024)]
+						if !window.take(len(chunk)) {
+							return
+						}
+						write(protocol2.Packet{StreamID: id, Flag: protocol2.FlagTCPData, Payload: chunk})
+						data = data[len(chunk):]
+					}
+					write(protocol2.Packet{StreamID: id, Flag: protocol2.FlagControlCloseStream})
+				}(packet.StreamID)
+			case bytes.Contains(packet.Payload, []byte("overrun")):
+				go func(id uint16) {
+					chunk := make([]byte, 16*1024)
+					for i := 0; i < 3*muxStreamWindow/len(chunk); i++ {
+						write(protocol2.Packet{StreamID: id, Flag: protocol2.FlagTCPData, Payload: chunk})
+					}
+				}(packet.StreamID)
+			case bytes.Contains(packet.Payload, []byte("refuse")):
+				write(protocol2.Packet{StreamID: packet.StreamID, Flag: protocol2.FlagControlCloseStream})
+			}
+		case protocol2.FlagTCPData:
+			write(protocol2.Packet{StreamID: packet.StreamID, Flag: protocol2.FlagTCPData, Payload: packet.Payload})
+		case protocol2.FlagControlWindowUpdate:
+			if window != nil && len(packet.Payload) == 4 {
+				window.update(int(binary.BigEndian.Uint32(packet.Payload)), false)
+			}
+		case protocol2.FlagControlCloseStream:
+			if window != nil {
+				window.update(0, true)
+				delete(windows, packet.StreamID)
+			}
+			w.closed <- packet.StreamID
+		}
+	}
+}
+
+// newTestSession 建立一个到 w 的多路复用会话。
+func newTestSession(t *testing.T, w *fakeWorker) *muxSession {
+	t.Helper()
+	conn, cipher, err := w.dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := newMuxSession(conn, cipher, zerolog.Nop())
+	t.Cleanup(func() { session.Close() })
+	return session
+}
+
+func TestMuxConcurrentStreams(t *testing.T) {
+	session := newTestSession(t, newFakeWorker())
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stream, err := session.openStream("echo.test:80")
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer stream.Close()
+			data := make([]byte, 100*1024)
+			rand.Read(data)
+			go stream.Write(data)
+			got := make([]byte, len(data))
+			if _, err := io.ReadFull(stream, got); err != nil {
+				errs <- err
+				return
+			}
+			if !bytes.Equal(got, data) {
+				errs <- errors.New("echo mismatch")
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if n := session.NumStreams(); n != 0 {
+		t.Errorf("session has %d streams after all were closed", n)
+	}
+}
+
+func TestMuxStreamClose(t *testing.T) {
+	w := newFakeWorker()
+	session := newTestSession(t, w)
+
+	// Worker 关闭流: 本端读到 EOF，不再回发 CloseStream
+	refused, err := session.openStream("refuse.test:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := refused.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Read() after remote close = %v, want EOF", err)
+	}
+	refused.Close()
+
+	// 本端关闭流: Worker 收到 CloseStream
+	stream, err := session.openStream("echo.test:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Close()
+	select {
+	case id := <-w.closed:
+		if id != stream.id {
+			t.Errorf("CloseStream for stream %d, want %d", id, stream.id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("worker did not receive CloseStream")
+	}
+	if _, err := stream.Write([]byte("x")); err == nil {
+		t.Error("Write() succeeded on a closed stream")
+	}
+}
+
+func TestMuxSlowReaderReceivesFullPayload(t *testing.T) {
+	w := newFakeWorker()
+	session := newTestSession(t, w)
+	slow, err := session.openStream("flood.test:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slow.Close()
+
+	// 暂不读取 slow: Worker 用完窗口后停止发送，同一会话上的其他流照常工作
+	time.Sleep(100 * time.Millisecond)
+	stream, err := session.openStream("echo.test:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go stream.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(stream, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("echo while another stream is stalled = %q, %v", buf, err)
+	}
+	stream.Close()
+
+	// 慢慢读取，窗口随读取归还，最终收到全部数据且流没有被重置
+	var got bytes.Buffer
+	chunk := make([]byte, 32*1024)
+	for {
+		n, err := slow.Read(chunk)
+		got.Write(chunk[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read() after %d bytes: %v", got.Len(), err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if !bytes.Equal(got.Bytes(), floodPayload()) {
+		t.Errorf("received %d bytes, want the whole %d byte payload", got.Len(), floodSize)
+	}
+}
+
+func TestMuxWindowOverrunIsReset(t *testing.T) {
+	w := newFakeWorker()
+	session := newTestSession(t, w)
+	stalled, err := session.openStream("overrun.test:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stalled.Close()
+
+	// 不遵守窗口的 Worker 超出窗口时该流立即被重置，会话的读协程不等待
+	select {
+	case id := <-w.closed:
+		if id != stalled.id {
+			t.Fatalf("CloseStream for stream %d, want %d", id, stalled.id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("stalled stream was not reset")
+	}
+	if _, err := io.Copy(io.Discard, stalled); !errors.Is(err, errStreamReset) {
+		t.Errorf("reading a reset stream: %v, want errStreamReset", err)
+	}
+
+	// 同一会话上的其他流不受影响
+	stream, err := session.openStream("echo.test:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	go stream.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(stream, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("echo after reset = %q, %v", buf, err)
+	}
+}
+
+func TestMuxSessionFailure(t *testing.T) {
+	w := newFakeWorker()
+	session := newTestSession(t, w)
+	stream, err := session.openStream("echo.test:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 隧道断开: 会话关闭，流读取返回 errSessionClosed
+	w.conn(0).Close()
+	if _, err := io.Copy(io.Discard, stream); !errors.Is(err, errSessionClosed) {
+		t.Errorf("reading from a failed session: %v, want errSessionClosed", err)
+	}
+	select {
+	case <-session.CloseChan():
+	case <-time.After(5 * time.Second):
+		t.Fatal("session still open after the tunnel failed")
+	}
+	if _, err := session.openStream("echo.test:80"); !errors.Is(err, errSessionClosed) {
+		t.Errorf("openStream() on a closed session = %v, want errSessionClosed", err)
+	}
+}
+
+func TestWorkerStrategyMultiplex(t *testing.T) {
+	t.Run("plain", func(t *testing.T) { testWorkerStrategyMultiplex(t, "/tunnel") })
+	// salt 和第一个 NewStream 请求随升级请求发出
+	t.Run("early data", func(t *testing.T) { testWorkerStrategyMultiplex(t, "/tunnel?ed=2048") })
+}
+
+func testWorkerStrategyMultiplex(t *testing.T, path string) {
+	psk := make([]byte, 32)
+	rand.Read(psk)
+	profile := &types.ServerProfile{
+		ID: "worker-mux", Type: "worker", Scheme: "ws", Address: "127.0.0.1", Path: path,
+		PSK: base64.StdEncoding.EncodeToString(psk), Multiplex: true, MuxSessions: 1,
+	}
+	keys, err := newKeySource(&types.Config{}, profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟 Worker: 每个 WebSocket 先读取 salt 派生会话密钥，之后按多路复用协议处理
+	var upgrades, earlyData atomic.Int32
+	w := newFakeWorker()
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		data, responseHeader := shared.AcceptEarlyData(r)
+		ws, err := upgrader.Upgrade(rw, r, responseHeader)
+		if err != nil {
+			return
+		}
+		upgrades.Add(1)
+		if len(data) > 0 {
+			earlyData.Add(1)
+		}
+		conn := shared.NewPrefixedConn(shared.NewWebSocketConnAdapter(ws), data)
+		cipher, err := keys.ReadServerCipher(conn)
+		if err != nil {
+			conn.Close()
+			return
+		}
+		w.serve(conn, cipher)
+	}))
+	defer server.Close()
+	profile.Port = server.Listener.Addr().(*net.TCPAddr).Port
+
+	strategy, err := NewWorkerStrategy(&types.Config{CommonConf: types.CommonConf{BufferSize: 4096}}, profile, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer strategy.CloseTunnel()
+
+	for i := 0; i < 2; i++ {
+		conn := testutil.DialThroughStrategy(t, strategy, "echo.test:"+strconv.Itoa(80+i))
+		payload := bytes.Repeat([]byte("hello over worker mux "), 5000)
+		go conn.Write(payload)
+		got := make([]byte, len(payload))
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := io.ReadFull(conn, got); err != nil || !bytes.Equal(got, payload) {
+			t.Fatalf("echo failed: %v", err)
+		}
+		conn.Close()
+	}
+
+	// 两个连接复用同一个 WebSocket
+	if n := upgrades.Load(); n != 1 {
+		t.Errorf("worker saw %d WebSocket connections, want 1", n)
+	}
+	if _, maxEarlyData := shared.ParseEarlyData(path); maxEarlyData > 0 && earlyData.Load() != 1 {
+		t.Error("worker did not receive early data")
+	}
+	if mux := strategy.GetMetrics().Mux; mux == nil || mux.TotalStreams != 2 || mux.Sessions != 1 {
+		t.Errorf("unexpected mux metrics: %+v", mux)
+	}
+}
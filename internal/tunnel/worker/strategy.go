@@ -10,8 +10,12 @@ import (
 	"github.com/rs/zerolog/log"
 	"golang.org/x/net/proxy"
 	"io"
+	"liuproxy_nexus/internal/shared"
+	"liuproxy_nexus/internal/shared/muxpool"
+	"liuproxy_nexus/internal/shared/outbound"
 	protocol2 "liuproxy_nexus/internal/shared/protocol"
 	"liuproxy_nexus/internal/shared/securecrypt"
+	"liuproxy_nexus/internal/shared/tlsclient"
 	"net"
 	"net/http"
 	"net/url"
@@ -37,21 +41,80 @@ type WorkerStrategy struct {
 	uplinkBytes       atomic.Uint64
 	downlinkBytes     atomic.Uint64
 	stateManager      types.StateManager
+	dialer            types.Dialer
+	keys              *securecrypt.KeySource
+	edges             atomic.Pointer[edgeScanner]
+	mux               *muxpool.Pool[*muxSession]
 }
 
 // Ensure WorkerStrategy implements TunnelStrategy interface
 var _ types.TunnelStrategy = (*WorkerStrategy)(nil)
 
-func NewWorkerStrategy(cfg *types.Config, profile *types.ServerProfile, stateManager types.StateManager) (types.TunnelStrategy, error) {
-	return &WorkerStrategy{
+func NewWorkerStrategy(cfg *types.Config, profile *types.ServerProfile, stateManager types.StateManager, dialer types.Dialer) (types.TunnelStrategy, error) {
+	keys, err := newKeySource(cfg, profile)
+	if err != nil {
+		return nil, err
+	}
+	s := &WorkerStrategy{
 		config:       cfg,
 		profile:      profile,
 		stateManager: stateManager,
-		logger: log.With().
-			Str("strategy_type", "worker").
-			Str("server_id", profile.ID).
-			Str("remarks", profile.Remarks).Logger(),
-	}, nil
+		dialer:       outbound.Or(dialer),
+		keys:         keys,
+		logger:       newLogger(profile),
+	}
+	if keys.IsLegacy() {
+		s.logger.Warn().Msg("Using the legacy integer crypt key. Configure a psk once the worker supports it.")
+	}
+	edges, err := s.newEdgeScanner(profile, s.logger)
+	if err != nil {
+		return nil, err
+	}
+	s.edges.Store(edges)
+	edges.start()
+	s.mux = s.newMuxPool(profile.MuxSessions)
+	return s, nil
+}
+
+func newLogger(profile *types.ServerProfile) zerolog.Logger {
+	return log.With().
+		Str("strategy_type", "worker").
+		Str("server_id", profile.ID).
+		Str("remarks", profile.Remarks).Logger()
+}
+
+// newEdgeScanner 根据 profile 的 EdgeIP 创建优选扫描器，测速使用与隧道相同的端口和 SNI。
+func (s *WorkerStrategy) newEdgeScanner(profile *types.ServerProfile, logger zerolog.Logger) (*edgeScanner, error) {
+	var tlsConfig *tlsclient.Config
+	if profile.Scheme == "wss" {
+		var err error
+		if tlsConfig, err = newTLSConfig(profile); err != nil {
+			return nil, err
+		}
+	}
+	probe := newEdgeProbe(s.dialer, strconv.Itoa(profile.Port), tlsConfig)
+	return newEdgeScanner(profile.EdgeIP, probe, logger)
+}
+
+// newTLSConfig 返回 wss 使用的 TLS 配置。SNI 默认为 Worker 主机名；未设置指纹时模拟 Chrome，与请求头中的 User-Agent 一致。
+func newTLSConfig(profile *types.ServerProfile) (*tlsclient.Config, error) {
+	config, err := tlsclient.FromProfile(profile, profile.Address, "http/1.1")
+	if err != nil {
+		return nil, fmt.Errorf("worker: %w", err)
+	}
+	if config.Fingerprint == "" {
+		config.Fingerprint = "chrome"
+	}
+	return config, nil
+}
+
+// newKeySource 根据 profile 的 psk / legacyCrypt 创建密钥来源。Worker 使用 AES-256-GCM (与 WebCrypto 兼容)。
+func newKeySource(cfg *types.Config, profile *types.ServerProfile) (*securecrypt.KeySource, error) {
+	keys, err := securecrypt.NewKeySource(profile.PSK, profile.LegacyCrypt, cfg.CommonConf.Crypt, securecrypt.AES_256_GCM)
+	if err != nil {
+		return nil, fmt.Errorf("worker: %w", err)
+	}
+	return keys, nil
 }
 
 // InitializeForGateway 在网关模式下被调用。对于worker这种无状态策略，这是一个空操作。
@@ -101,9 +164,14 @@ func (s *WorkerStrategy) GetListenerInfo() *types.ListenerInfo {
 }
 
 func (s *WorkerStrategy) GetMetrics() *types.Metrics {
-	return &types.Metrics{
+	metrics := &types.Metrics{
 		ActiveConnections: s.activeConnections.Load(),
+		EdgeIPs:           s.edges.Load().snapshot(),
+	}
+	if s.profile.Multiplex {
+		metrics.Mux = s.mux.Metrics()
 	}
+	return metrics
 }
 
 func (s *WorkerStrategy) GetType() string {
@@ -122,17 +190,32 @@ func (s *WorkerStrategy) CloseTunnel() {
 			}
 			return true
 		})
+		s.mux.Close()
 		s.waitGroup.Wait()
+		s.edges.Load().stop()
+		outbound.CloseDialer(s.dialer)
 		s.logger.Info().Msg("[WorkerStrategy] Listener and all connections closed.")
 	})
 }
 
+// UpdateServer 先创建并校验新配置所需的一切，全部成功后才替换，失败时保留原配置。
 func (s *WorkerStrategy) UpdateServer(profile *types.ServerProfile) error {
+	keys, err := newKeySource(s.config, profile)
+	if err != nil {
+		return err
+	}
+	logger := newLogger(profile)
+	edges, err := s.newEdgeScanner(profile, logger)
+	if err != nil {
+		return err
+	}
 	s.profile = profile
-	s.logger = log.With().
-		Str("strategy_type", "worker").
-		Str("server_id", profile.ID).
-		Str("remarks", profile.Remarks).Logger()
+	s.keys = keys
+	s.logger = logger
+	edges.start()
+	s.edges.Swap(edges).stop()
+	// 关闭旧的多路复用会话，之后的流按新配置重新建立
+	s.mux.Reset(profile.MuxSessions)
 	s.logger.Info().Msg("Worker profile updated. New settings will apply to subsequent connections.")
 	return nil
 }
@@ -141,28 +224,82 @@ func (s *WorkerStrategy) createTunnel() (net.Conn, *securecrypt.Cipher, error) {
 	l := s.logger.With().Str("func", "createTunnel").Logger()
 	l.Debug().Msg("Attempting to create tunnel...")
 
+	// 路径可以带查询参数，例如早期数据的 "/tunnel?ed=2048"
+	path, query, _ := strings.Cut(s.profile.Path, "?")
 	u := url.URL{
-		Scheme: s.profile.Scheme,
-		Host:   net.JoinHostPort(s.profile.Address, strconv.Itoa(s.profile.Port)),
-		Path:   s.profile.Path,
+		Scheme:   s.profile.Scheme,
+		Host:     net.JoinHostPort(s.profile.Address, strconv.Itoa(s.profile.Port)),
+		Path:     path,
+		RawQuery: query,
 	}
 	l.Debug().Str("url", u.String()).Str("edge_ip", s.profile.EdgeIP).Msg("Dialing worker...")
-	tunnelConn, err := Dial(u.String(), s.profile.EdgeIP)
-	if err != nil {
+	var tlsConfig *tlsclient.Config
+	if u.Scheme == "wss" {
+		var err error
+		if tlsConfig, err = newTLSConfig(s.profile); err != nil {
+			return nil, nil, err
+		}
+	}
+	edgeDialer := &edgeRaceDialer{dialer: s.dialer, scanner: s.edges.Load()}
+	// 使用早期数据时握手在第一次写入时才进行，因此结果在拨号回调中处理
+	onDial := func(err error) {
+		if err == nil {
+			l.Debug().Str("edge_ip", edgeDialer.chosen).Msg("Dial successful.")
+			return
+		}
+		if edgeDialer.chosen != "" {
+			// TCP 已连通但 TLS / WebSocket 握手失败，下次换用其他 IP
+			edgeDialer.scanner.report(edgeDialer.chosen, err)
+		}
 		l.Error().Err(err).Msg("Dial failed.")
+	}
+	tunnelConn, err := Dial(edgeDialer, u.String(), tlsConfig, time.Duration(s.profile.EarlyDataDelay)*time.Millisecond, onDial)
+	if err != nil {
 		return nil, nil, err
 	}
-	l.Debug().Msg("Dial successful.")
-	cipher, err := securecrypt.NewCipherWithAlgo(s.config.CommonConf.Crypt, securecrypt.AES_256_GCM)
+	cipher, salt, err := s.keys.NewClientCipher()
 	if err != nil {
 		l.Error().Err(err).Msg("Failed to create cipher.")
 		_ = tunnelConn.Close()
 		return nil, nil, err
 	}
 	l.Debug().Msg("Tunnel created successfully.")
+	if salt != nil {
+		tunnelConn = &prefixConn{Conn: tunnelConn, prefix: salt}
+	}
 	return tunnelConn, cipher, nil
 }
 
+// prefixConn 把 prefix (会话 salt) 与第一次写入合并发送，使 salt 与 NewStream 请求位于同一个 WebSocket 消息中。
+// 第一次写入总是在启动转发 goroutine 之前完成。
+type prefixConn struct {
+	net.Conn
+	prefix []byte
+}
+
+func (c *prefixConn) Write(b []byte) (int, error) {
+	if c.prefix == nil {
+		return c.Conn.Write(b)
+	}
+	buf := append(c.prefix, b...)
+	c.prefix = nil
+	if _, err := c.Conn.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// handshake 完成使用早期数据的隧道的拨号 (否则推迟到第一次写入)。
+func handshake(conn net.Conn) error {
+	if pc, ok := conn.(*prefixConn); ok {
+		conn = pc.Conn
+	}
+	if ec, ok := conn.(*shared.EarlyDataConn); ok {
+		return ec.Handshake()
+	}
+	return nil
+}
+
 // waitForSuccess expects an UNENCRYPTED success packet from the worker.
 func (s *WorkerStrategy) waitForSuccess(conn net.Conn) error {
 	l := s.logger.With().Str("func", "waitForSuccess").Logger()
@@ -219,11 +356,14 @@ func (s *WorkerStrategy) GetTrafficStats() types.TrafficStats {
 func (s *WorkerStrategy) CheckHealth() error {
 	s.logger.Debug().Msg("WorkerStrategy.CheckHealth: Attempting to create tunnel for health check...")
 	conn, _, err := s.createTunnel()
+	if err == nil {
+		err = handshake(conn)
+		conn.Close()
+	}
 	if err != nil {
 		s.logger.Warn().Err(err).Msg("WorkerStrategy.CheckHealth: Failed.")
 		return err
 	}
-	conn.Close()
 	s.logger.Debug().Msg("WorkerStrategy.CheckHealth: Passed.")
 	return nil
 }
@@ -239,7 +379,7 @@ func (s *WorkerStrategy) CheckHealthAdvanced() (latency int64, exitIP string, er
 	}
 	defer pipeConn.Close()
 
-	dialer, err := proxy.SOCKS5("tcp", "placeholder-unused:1080", nil, &pipeDialer{conn: pipeConn})
+	dialer, err := proxy.SOCKS5("tcp", "placeholder-unused:1080", nil, &shared.PipeDialer{Conn: pipeConn})
 	if err != nil {
 		l.Error().Err(err).Msg("Failed to create SOCKS5 dialer.")
 		return -1, "", fmt.Errorf("worker CheckHealth: failed to create SOCKS5 dialer: %w", err)
@@ -285,12 +425,3 @@ func (s *WorkerStrategy) CheckHealthAdvanced() (latency int64, exitIP string, er
 	l.Debug().Msg("Advanced health check finished.")
 	return latencyMs, exitIP, nil
 }
-
-// pipeDialer 实现了 proxy.Dialer 接口，但它的 Dial 方法总是返回预设的 net.Conn
-type pipeDialer struct {
-	conn net.Conn
-}
-
-func (d *pipeDialer) Dial(network, addr string) (net.Conn, error) {
-	return d.conn, nil
-}
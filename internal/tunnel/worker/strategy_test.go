@@ -0,0 +1,63 @@
+package worker
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"liuproxy_nexus/internal/shared/outbound"
+	"liuproxy_nexus/internal/shared/types"
+)
+
+// TestDialReportsEarlyDataHandshake 检查使用早期数据时，推迟到第一次写入的握手结果同样交给 onDial。
+func TestDialReportsEarlyDataHandshake(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	var results []error
+	conn, err := Dial(outbound.System, "ws"+server.URL[len("http"):]+"/tunnel?ed=2048", nil, 0, func(err error) {
+		results = append(results, err)
+	})
+	if err != nil {
+		t.Fatalf("Dial() error = %v, want the dial deferred to the first write", err)
+	}
+	defer conn.Close()
+	if len(results) != 0 {
+		t.Fatalf("onDial called %d times before the first write", len(results))
+	}
+	if _, err := conn.Write([]byte("hello")); err == nil {
+		t.Fatal("Write() succeeded against a server that rejects the upgrade")
+	}
+	if len(results) != 1 || results[0] == nil {
+		t.Fatalf("onDial results = %v, want one handshake failure", results)
+	}
+}
+
+// TestWorkerStrategyUpdateServerKeepsProfileOnError 检查新配置无效时保留原配置。
+func TestWorkerStrategyUpdateServerKeepsProfileOnError(t *testing.T) {
+	psk := make([]byte, 32)
+	rand.Read(psk)
+	profile := &types.ServerProfile{
+		ID: "worker", Type: "worker", Scheme: "ws", Address: "127.0.0.1", Port: 80, Path: "/tunnel",
+		PSK: base64.StdEncoding.EncodeToString(psk),
+	}
+	strategy, err := NewWorkerStrategy(&types.Config{}, profile, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer strategy.CloseTunnel()
+	s := strategy.(*WorkerStrategy)
+	keys := s.keys
+
+	invalid := *profile
+	invalid.Path = "/other"
+	invalid.EdgeIP = "104.16.0.0/33"
+	if err := s.UpdateServer(&invalid); err == nil {
+		t.Fatal("UpdateServer() accepted an invalid edge IP")
+	}
+	if s.profile != profile || s.keys != keys {
+		t.Error("UpdateServer() replaced the profile or keys although it failed")
+	}
+}
@@ -20,6 +20,10 @@ func (s *WorkerStrategy) HandleRawTCP(inboundConn net.Conn, targetDest string) {
 	countedInbound := shared.NewCountedConn(inboundConn, &s.uplinkBytes, &s.downlinkBytes)
 
 	// 这个逻辑与 handleClientConnection 非常相似，但完全跳过了 SOCKS5 握手。
+	if s.profile.Multiplex {
+		s.relayMux(countedInbound, targetDest, false)
+		return
+	}
 
 	tunnelConn, cipher, err := s.createTunnel()
 	if err != nil {
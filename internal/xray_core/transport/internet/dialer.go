@@ -47,8 +47,31 @@ func Dial(ctx context.Context, dest net.Destination, streamSettings *MemoryStrea
 	return nil, errors.NewError("unknown network ", dest.Network)
 }
 
+// ContextDialFunc replaces the system dialer for connections created under a context.
+type ContextDialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+type contextDialerKey struct{}
+
+// ContextWithDialer makes DialSystem use dial instead of the system dialer, so that transports
+// can be layered on top of another outbound (proxy chaining).
+func ContextWithDialer(ctx context.Context, dial ContextDialFunc) context.Context {
+	if dial == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, contextDialerKey{}, dial)
+}
+
+// DialerFromContext returns the dial function set by ContextWithDialer, or nil.
+func DialerFromContext(ctx context.Context) ContextDialFunc {
+	dial, _ := ctx.Value(contextDialerKey{}).(ContextDialFunc)
+	return dial
+}
+
 // DialSystem calls system dialer to create a network connection.
 func DialSystem(ctx context.Context, dest net.Destination, sockopt *SocketConfig) (net.Conn, error) {
+	if dial := DialerFromContext(ctx); dial != nil {
+		return dial(ctx, dest.Network.SystemString(), dest.NetAddr())
+	}
 	var src net.Address
 	if outbound := session.OutboundFromContext(ctx); outbound != nil {
 		src = outbound.Gateway
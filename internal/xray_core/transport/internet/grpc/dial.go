@@ -76,6 +76,7 @@ func dialgRPC(ctx context.Context, dest net.Destination, streamSettings *interne
 			gctx = session.ContextWithID(gctx, session.IDFromContext(ctx))
 			gctx = session.ContextWithOutbound(gctx, session.OutboundFromContext(ctx))
 			gctx = session.ContextWithTimeoutOnly(gctx, true)
+			gctx = internet.ContextWithDialer(gctx, internet.DialerFromContext(ctx))
 
 			c, err := internet.DialSystem(gctx, net.TCPDestination(address, port), sockopt)
 			if err == nil {
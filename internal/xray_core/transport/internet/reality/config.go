@@ -3,7 +3,7 @@ package reality
 import (
 	"io"
 	"liuproxy_nexus/internal/shared/logger"
-	"net"
+	"liuproxy_nexus/internal/shared/outbound"
 	"os"
 	"time"
 
@@ -12,9 +12,8 @@ import (
 )
 
 func (c *Config) GetREALITYConfig() *reality.Config {
-	var dialer net.Dialer
 	config := &reality.Config{
-		DialContext: dialer.DialContext,
+		DialContext: outbound.DialContext,
 
 		Show: c.Show,
 		Type: c.Type,
@@ -27,7 +27,6 @@ import (
 
 	utls "github.com/refraction-networking/utls"
 	"github.com/xtls/reality"
-	"golang.org/x/crypto/chacha20poly1305"
 	"golang.org/x/crypto/hkdf"
 	"golang.org/x/net/http2"
 	"liuproxy_nexus/internal/xray_core/common/errors"
@@ -35,9 +34,6 @@ import (
 	"liuproxy_nexus/internal/xray_core/transport/internet/tls"
 )
 
-//go:linkname aesgcmPreferred github.com/refraction-networking/utls.aesgcmPreferred
-func aesgcmPreferred(ciphers []uint16) bool
-
 type Conn struct {
 	*reality.Conn
 }
@@ -132,20 +128,29 @@ func UClient(c net.Conn, config *Config, ctx context.Context, dest net.Destinati
 		if err != nil {
 			return nil, errors.NewError("REALITY: publicKey == nil")
 		}
-		uConn.AuthKey, _ = uConn.HandshakeState.State13.EcdheKey.ECDH(publicKey)
+		var ecdhe *ecdh.PrivateKey
+		if keys := uConn.HandshakeState.State13.KeyShareKeys; keys != nil {
+			ecdhe = keys.Ecdhe
+			if ecdhe == nil {
+				ecdhe = keys.MlkemEcdhe
+			}
+		}
+		if ecdhe == nil {
+			ecdhe = uConn.HandshakeState.State13.EcdheKey
+		}
+		if ecdhe == nil {
+			return nil, errors.NewError("REALITY: current fingerprint does not support TLS 1.3")
+		}
+		uConn.AuthKey, _ = ecdhe.ECDH(publicKey)
 		if uConn.AuthKey == nil {
 			return nil, errors.NewError("REALITY: SharedKey == nil")
 		}
 		if _, err := hkdf.New(sha256.New, uConn.AuthKey, hello.Random[:20], []byte("REALITY")).Read(uConn.AuthKey); err != nil {
 			return nil, err
 		}
-		var aead cipher.AEAD
-		if aesgcmPreferred(hello.CipherSuites) {
-			block, _ := aes.NewCipher(uConn.AuthKey)
-			aead, _ = cipher.NewGCM(block)
-		} else {
-			aead, _ = chacha20poly1305.New(uConn.AuthKey)
-		}
+		// 服务端固定使用 AES-GCM 解密 SessionId，不再随 cipher suite 偏好切换
+		block, _ := aes.NewCipher(uConn.AuthKey)
+		aead, _ := cipher.NewGCM(block)
 		if config.Show {
 			logger.Error().Msgf("REALITY localAddr: %v\tuConn.AuthKey[:16]: %v\tAEAD: %T\n", localAddr, uConn.AuthKey[:16], aead)
 		}
@@ -3,6 +3,7 @@ package internet
 import (
 	"context"
 	"liuproxy_nexus/internal/shared/logger"
+	"liuproxy_nexus/internal/shared/outbound"
 	"syscall"
 	"time"
 
@@ -10,7 +11,8 @@ import (
 	"liuproxy_nexus/internal/xray_core/common/net"
 )
 
-var effectiveSystemDialer SystemDialer = &DefaultSystemDialer{}
+// outbound.Control 为所有出站 socket 设置全局的 fwmark / 网卡 / 源地址
+var effectiveSystemDialer SystemDialer = &DefaultSystemDialer{controllers: []control.Func{outbound.Control}}
 
 type SystemDialer interface {
 	Dial(ctx context.Context, source net.Address, destination net.Destination, sockopt *SocketConfig) (net.Conn, error)
@@ -10,13 +10,18 @@ TRANSPARENT_PROXY_TCP_ENABLED=${TRANSPARENT_PROXY_TCP_ENABLED:-"true"}
 # UDP透明代理开关
 TRANSPARENT_PROXY_UDP_ENABLED=${TRANSPARENT_PROXY_UDP_ENABLED:-"true"}
 
+# 规则是否交给程序自身管理 (settings.json 中的 netfilter 模块)，为 true 时本脚本不再安装任何规则
+NETFILTER_IN_PROCESS=${NETFILTER_IN_PROCESS:-"false"}
+
 # 其他配置项
 TPROXY_PORT=${TPROXY_PORT:-12345}
 MSS_CLAMPING_ENABLED=${MSS_CLAMPING_ENABLED:-"true"}
 EXCLUDED_IPS=${EXCLUDED_IPS:-"0.0.0.0/8,10.0.0.0/8,127.0.0.0/8,169.254.0.0/16,172.16.0.0/12,192.168.0.0/16,224.0.0.0/4,240.0.0.0/4"}
 
 # 检查是否需要配置任何透明代理规则
-if [ "$TRANSPARENT_PROXY_TCP_ENABLED" = "true" ] || [ "$TRANSPARENT_PROXY_UDP_ENABLED" = "true" ]; then
+if [ "$NETFILTER_IN_PROCESS" = "true" ]; then
+    echo "Netfilter rules are managed by LiuProxy itself (settings.json: netfilter). Skipping script rule configuration."
+elif [ "$TRANSPARENT_PROXY_TCP_ENABLED" = "true" ] || [ "$TRANSPARENT_PROXY_UDP_ENABLED" = "true" ]; then
     echo "Transparent proxy is enabled for at least one protocol. Configuring network..."
 
     # 1. 启用内核IP转发 (只要有一个代理开启，就需要)